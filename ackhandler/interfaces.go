@@ -17,14 +17,20 @@ type SentPacketHandler interface {
 
 	ProbablyHasPacketForRetransmission() bool
 	DequeuePacketForRetransmission() (packet *ackhandlerlegacy.Packet)
+	// DequeueAckedPacket returns the next packet that was acknowledged by the peer, or nil if there is none
+	DequeueAckedPacket() (packet *ackhandlerlegacy.Packet)
 
 	BytesInFlight() protocol.ByteCount
 	GetLargestAcked() protocol.PacketNumber
 
 	CongestionAllowsSending() bool
 	CheckForError() error
+	// OnConnectionMigration resets the congestion controller and the RTT measurements when the peer's IP address changed
+	OnConnectionMigration()
 
 	TimeOfFirstRTO() time.Time
+	// GetStats returns statistics about the sent packets and the state of the congestion controller
+	GetStats() ackhandlerlegacy.SentPacketStats
 }
 
 // ReceivedPacketHandler handles ACKs needed to send for incoming packets
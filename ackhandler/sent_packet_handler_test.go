@@ -7,6 +7,7 @@ import (
 	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qlog"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -16,6 +17,7 @@ type mockCongestion struct {
 	argsOnPacketSent        []interface{}
 	argsOnCongestionEvent   []interface{}
 	onRetransmissionTimeout bool
+	onConnectionMigration   bool
 }
 
 func (m *mockCongestion) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration {
@@ -33,6 +35,10 @@ func (m *mockCongestion) GetCongestionWindow() protocol.ByteCount {
 	return protocol.DefaultTCPMSS
 }
 
+func (m *mockCongestion) GetSlowStartThreshold() protocol.ByteCount {
+	return 2 * protocol.DefaultTCPMSS
+}
+
 func (m *mockCongestion) OnCongestionEvent(rttUpdated bool, bytesInFlight protocol.ByteCount, ackedPackets congestion.PacketVector, lostPackets congestion.PacketVector) {
 	m.nCalls++
 	m.argsOnCongestionEvent = []interface{}{rttUpdated, bytesInFlight, ackedPackets, lostPackets}
@@ -47,10 +53,43 @@ func (m *mockCongestion) RetransmissionDelay() time.Duration {
 	return protocol.DefaultRetransmissionTime
 }
 
+func (m *mockCongestion) OnConnectionMigration() {
+	m.nCalls++
+	m.onConnectionMigration = true
+}
+
 func (m *mockCongestion) SetNumEmulatedConnections(n int)         { panic("not implemented") }
-func (m *mockCongestion) OnConnectionMigration()                  { panic("not implemented") }
 func (m *mockCongestion) SetSlowStartLargeReduction(enabled bool) { panic("not implemented") }
 
+type lostPacket struct {
+	packetNumber protocol.PacketNumber
+	reason       qlog.LossReason
+}
+
+type mockTracer struct {
+	lostPackets       []lostPacket
+	rttUpdates        int
+	congestionWindows []protocol.ByteCount
+}
+
+var _ qlog.ConnectionTracer = &mockTracer{}
+
+func (t *mockTracer) SentPacket(*qlog.PacketHeader, protocol.ByteCount, []frames.Frame)     {}
+func (t *mockTracer) ReceivedPacket(*qlog.PacketHeader, protocol.ByteCount, []frames.Frame) {}
+func (t *mockTracer) LostPacket(packetNumber protocol.PacketNumber, reason qlog.LossReason) {
+	t.lostPackets = append(t.lostPackets, lostPacket{packetNumber, reason})
+}
+func (t *mockTracer) UpdatedRTT(smoothedRTT, minRTT, latestRTT time.Duration) { t.rttUpdates++ }
+func (t *mockTracer) UpdatedCongestionWindow(congestionWindow, bytesInFlight protocol.ByteCount) {
+	t.congestionWindows = append(t.congestionWindows, congestionWindow)
+}
+func (t *mockTracer) OpenedStream(protocol.StreamID)            {}
+func (t *mockTracer) ClosedStream(protocol.StreamID)            {}
+func (t *mockTracer) SentHandshakeMessage(string, []string)     {}
+func (t *mockTracer) ReceivedHandshakeMessage(string, []string) {}
+func (t *mockTracer) ClosedConnection(err error, remote bool)   {}
+func (t *mockTracer) Close()                                    {}
+
 var _ = Describe("SentPacketHandler", func() {
 	var (
 		handler     *sentPacketHandler
@@ -58,13 +97,18 @@ var _ = Describe("SentPacketHandler", func() {
 	)
 
 	BeforeEach(func() {
-		handler = NewSentPacketHandler().(*sentPacketHandler)
+		handler = NewSentPacketHandler(protocol.InitialCongestionWindow, protocol.DefaultMaxCongestionWindow, nil).(*sentPacketHandler)
 		streamFrame = frames.StreamFrame{
 			StreamID: 5,
 			Data:     []byte{0x13, 0x37},
 		}
 	})
 
+	It("uses the congestion window passed to the constructor", func() {
+		handler = NewSentPacketHandler(10, 20, nil).(*sentPacketHandler)
+		Expect(handler.congestion.GetCongestionWindow()).To(Equal(10 * protocol.DefaultTCPMSS))
+	})
+
 	It("gets the LargestAcked packet number", func() {
 		handler.LargestAcked = 0x1337
 		Expect(handler.GetLargestAcked()).To(Equal(protocol.PacketNumber(0x1337)))
@@ -279,6 +323,24 @@ var _ = Describe("SentPacketHandler", func() {
 				Expect(handler.packetHistory[10].MissingReports).To(BeZero())
 			})
 
+			It("queues the acknowledged packets", func() {
+				ack := frames.AckFrame{
+					LargestAcked: 9,
+					LowestAcked:  2,
+					AckRanges: []frames.AckRange{ // packets 4 and 5 were lost
+						{FirstPacketNumber: 6, LastPacketNumber: 9},
+						{FirstPacketNumber: 2, LastPacketNumber: 3},
+					},
+				}
+				err := handler.ReceivedAck(&ack, 1)
+				Expect(err).ToNot(HaveOccurred())
+				var acked []protocol.PacketNumber
+				for p := handler.DequeueAckedPacket(); p != nil; p = handler.DequeueAckedPacket() {
+					acked = append(acked, p.PacketNumber)
+				}
+				Expect(acked).To(ConsistOf([]protocol.PacketNumber{2, 3, 6, 7, 8, 9}))
+			})
+
 			It("NACKs packets below the LowestAcked", func() {
 				ack := frames.AckFrame{
 					LargestAcked: 8,
@@ -431,6 +493,16 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.retransmissionQueue[0].PacketNumber).To(Equal(protocol.PacketNumber(2)))
 		})
 
+		It("counts packets queued for retransmission as lost", func() {
+			for i := uint8(0); i < protocol.RetransmissionThreshold+1; i++ {
+				_, err := handler.nackPacket(2)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			stats := handler.GetStats()
+			Expect(stats.PacketsLost).To(Equal(uint64(1)))
+			Expect(stats.BytesLost).To(Equal(protocol.ByteCount(1)))
+		})
+
 		It("dequeues a packet for retransmission", func() {
 			for i := uint8(0); i < protocol.RetransmissionThreshold+1; i++ {
 				_, err := handler.nackPacket(3)
@@ -556,6 +628,9 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.LargestInOrderAcked).To(Equal(protocol.PacketNumber(0)))
 			Expect(handler.LargestAcked).To(Equal(protocol.PacketNumber(3)))
 			Expect(err).ToNot(HaveOccurred())
+			// the retransmission will be acknowledged, so the packet is not reported as acknowledged
+			Expect(handler.DequeueAckedPacket().PacketNumber).To(Equal(protocol.PacketNumber(3)))
+			Expect(handler.DequeueAckedPacket()).To(BeNil())
 		})
 	})
 
@@ -696,6 +771,27 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(cong.argsOnCongestionEvent[3]).To(Equal(congestion.PacketVector{{1, 1}}))
 			Expect(cong.onRetransmissionTimeout).To(BeTrue())
 		})
+
+		It("returns the stats", func() {
+			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			err := handler.SentPacket(&ackhandlerlegacy.Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 42})
+			Expect(err).NotTo(HaveOccurred())
+			stats := handler.GetStats()
+			Expect(stats.SmoothedRTT).To(Equal(time.Second))
+			Expect(stats.MinRTT).To(Equal(time.Second))
+			Expect(stats.LatestRTT).To(Equal(time.Second))
+			Expect(stats.CongestionWindow).To(Equal(protocol.DefaultTCPMSS))
+			Expect(stats.SlowStartThreshold).To(Equal(2 * protocol.DefaultTCPMSS))
+			Expect(stats.BytesInFlight).To(Equal(protocol.ByteCount(42)))
+		})
+
+		It("resets the congestion controller and the RTT stats on connection migration", func() {
+			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			Expect(handler.rttStats.SmoothedRTT()).ToNot(BeZero())
+			handler.OnConnectionMigration()
+			Expect(cong.onConnectionMigration).To(BeTrue())
+			Expect(handler.rttStats.SmoothedRTT()).To(BeZero())
+		})
 	})
 
 	Context("calculating RTO", func() {
@@ -786,4 +882,49 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.DequeuePacketForRetransmission()).To(Equal(p))
 		})
 	})
+
+	Context("tracing", func() {
+		var tracer *mockTracer
+
+		BeforeEach(func() {
+			tracer = &mockTracer{}
+			handler.tracer = tracer
+		})
+
+		It("traces packets lost because they were NACKed", func() {
+			for i := protocol.PacketNumber(1); i <= 3; i++ {
+				err := handler.SentPacket(&ackhandlerlegacy.Packet{PacketNumber: i, Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			for i := uint8(0); i < protocol.RetransmissionThreshold+1; i++ {
+				_, err := handler.nackPacket(2)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(tracer.lostPackets).To(Equal([]lostPacket{{2, qlog.LossReasonNack}}))
+		})
+
+		It("traces packets lost because of a retransmission timeout, and the new congestion window", func() {
+			err := handler.SentPacket(&ackhandlerlegacy.Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 1})
+			Expect(err).NotTo(HaveOccurred())
+			handler.lastSentPacketTime = time.Now().Add(-time.Second)
+			handler.maybeQueuePacketsRTO()
+			Expect(tracer.lostPackets).To(Equal([]lostPacket{{1, qlog.LossReasonRetransmissionTimeout}}))
+			Expect(tracer.congestionWindows).To(Equal([]protocol.ByteCount{handler.congestion.GetCongestionWindow()}))
+		})
+
+		It("traces RTT updates and congestion window changes when receiving an ACK", func() {
+			err := handler.SentPacket(&ackhandlerlegacy.Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 1})
+			Expect(err).NotTo(HaveOccurred())
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, LowestAcked: 1}, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tracer.rttUpdates).To(Equal(1))
+			Expect(tracer.congestionWindows).To(HaveLen(1))
+		})
+
+		It("only traces the congestion window when it changed", func() {
+			handler.maybeTraceCongestionWindow()
+			handler.maybeTraceCongestionWindow()
+			Expect(tracer.congestionWindows).To(HaveLen(1))
+		})
+	})
 })
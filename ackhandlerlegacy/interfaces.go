@@ -16,14 +16,35 @@ type SentPacketHandler interface {
 
 	ProbablyHasPacketForRetransmission() bool
 	DequeuePacketForRetransmission() (packet *Packet)
+	// DequeueAckedPacket returns the next packet that was acknowledged by the peer, or nil if there is none
+	DequeueAckedPacket() (packet *Packet)
 
 	BytesInFlight() protocol.ByteCount
 	GetLargestAcked() protocol.PacketNumber
 
 	CongestionAllowsSending() bool
 	CheckForError() error
+	// OnConnectionMigration resets the congestion controller and the RTT measurements when the peer's IP address changed
+	OnConnectionMigration()
 
 	TimeOfFirstRTO() time.Time
+	// GetStats returns statistics about the sent packets and the state of the congestion controller
+	GetStats() SentPacketStats
+}
+
+// SentPacketStats are statistics about the sent packets and the state of the congestion controller
+type SentPacketStats struct {
+	SmoothedRTT time.Duration
+	MinRTT      time.Duration
+	LatestRTT   time.Duration
+
+	CongestionWindow   protocol.ByteCount
+	SlowStartThreshold protocol.ByteCount
+	BytesInFlight      protocol.ByteCount
+
+	// PacketsLost and BytesLost count the packets that were queued for retransmission, either because they were NACKed or because of a retransmission timeout
+	PacketsLost uint64
+	BytesLost   protocol.ByteCount
 }
 
 // ReceivedPacketHandler handles ACKs needed to send for incoming packets
@@ -12,7 +12,10 @@ import (
 // A Packet is a packet
 type Packet struct {
 	PacketNumber protocol.PacketNumber
-	Frames       []frames.Frame
+	// Frames contains the frames sent in the packet, except for STREAM frames
+	Frames []frames.Frame
+	// StreamRanges contains the ranges of stream data sent in the packet
+	StreamRanges []StreamRange
 	EntropyBit   bool
 	Entropy      EntropyAccumulator
 	Length       protocol.ByteCount
@@ -23,15 +26,37 @@ type Packet struct {
 	SendTime time.Time
 }
 
-// GetStreamFramesForRetransmission gets all the streamframes for retransmission
-func (p *Packet) GetStreamFramesForRetransmission() []*frames.StreamFrame {
-	var streamFrames []*frames.StreamFrame
-	for _, frame := range p.Frames {
+// A StreamRange is the range of stream data sent in a STREAM frame
+// The data itself is not stored, it is kept in the send buffer of the stream until it is acknowledged.
+type StreamRange struct {
+	StreamID protocol.StreamID
+	Offset   protocol.ByteCount
+	Length   protocol.ByteCount
+	FinBit   bool
+}
+
+// NewStreamRange returns the range of stream data sent in a STREAM frame
+func NewStreamRange(frame *frames.StreamFrame) StreamRange {
+	return StreamRange{
+		StreamID: frame.StreamID,
+		Offset:   frame.Offset,
+		Length:   frame.DataLen(),
+		FinBit:   frame.FinBit,
+	}
+}
+
+// SetFrames sets the frames sent in the packet
+// For STREAM frames, only the range of the data is stored.
+func (p *Packet) SetFrames(fs []frames.Frame) {
+	p.Frames = p.Frames[:0]
+	p.StreamRanges = p.StreamRanges[:0]
+	for _, frame := range fs {
 		if streamFrame, isStreamFrame := frame.(*frames.StreamFrame); isStreamFrame {
-			streamFrames = append(streamFrames, streamFrame)
+			p.StreamRanges = append(p.StreamRanges, NewStreamRange(streamFrame))
+		} else {
+			p.Frames = append(p.Frames, frame)
 		}
 	}
-	return streamFrames
 }
 
 // GetControlFramesForRetransmission gets all the control frames for retransmission
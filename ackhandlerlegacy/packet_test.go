@@ -53,11 +53,15 @@ var _ = Describe("Packet", func() {
 			}
 		})
 
-		It("gets all StreamFrames", func() {
-			streamFrames := packet.GetStreamFramesForRetransmission()
-			Expect(streamFrames).To(HaveLen(2))
-			Expect(streamFrames).To(ContainElement(streamFrame1))
-			Expect(streamFrames).To(ContainElement(streamFrame2))
+		It("stores only the ranges of StreamFrames", func() {
+			streamFrame2.Offset = 0x1000
+			streamFrame2.FinBit = true
+			packet.SetFrames([]frames.Frame{windowUpdateFrame, streamFrame1, ackFrame1, streamFrame2, rstStreamFrame, ackFrame2, stopWaitingFrame})
+			Expect(packet.Frames).To(Equal([]frames.Frame{windowUpdateFrame, ackFrame1, rstStreamFrame, ackFrame2, stopWaitingFrame}))
+			Expect(packet.StreamRanges).To(Equal([]StreamRange{
+				{StreamID: 5, Offset: 0, Length: 2},
+				{StreamID: 6, Offset: 0x1000, Length: 4, FinBit: true},
+			}))
 		})
 
 		It("gets all control frames", func() {
@@ -78,14 +82,10 @@ var _ = Describe("Packet", func() {
 			Expect(controlFrames).ToNot(ContainElement(stopWaitingFrame))
 		})
 
-		It("returns an empty slice of StreamFrames if no StreamFrames are queued", func() {
-			// overwrite the globally defined packet here
-			packet := Packet{
-				PacketNumber: 1337,
-				Frames:       []frames.Frame{ackFrame1, rstStreamFrame},
-			}
-			streamFrames := packet.GetStreamFramesForRetransmission()
-			Expect(streamFrames).To(BeEmpty())
+		It("doesn't store any StreamRanges if there are no StreamFrames", func() {
+			packet.SetFrames([]frames.Frame{ackFrame1, rstStreamFrame})
+			Expect(packet.Frames).To(Equal([]frames.Frame{ackFrame1, rstStreamFrame}))
+			Expect(packet.StreamRanges).To(BeEmpty())
 		})
 
 		It("returns an empty slice of control frames if no applicable control frames are queued", func() {
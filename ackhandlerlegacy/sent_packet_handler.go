@@ -8,6 +8,7 @@ import (
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/qlog"
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
@@ -42,24 +43,34 @@ type sentPacketHandler struct {
 	packetHistory map[protocol.PacketNumber]*Packet
 
 	retransmissionQueue []*Packet
-	stopWaitingManager  StopWaitingManager
+	// ackedPacketQueue contains the packets that were acknowledged, until they are dequeued
+	ackedPacketQueue   []*Packet
+	stopWaitingManager StopWaitingManager
 
 	bytesInFlight protocol.ByteCount
+	// packetsLost and bytesLost count the packets queued for retransmission
+	packetsLost uint64
+	bytesLost   protocol.ByteCount
 
 	rttStats   *congestion.RTTStats
 	congestion congestion.SendAlgorithm
+
+	// tracer is nil if the connection is not traced
+	tracer qlog.ConnectionTracer
+	// tracedCongestionWindow is the congestion window that was traced last
+	tracedCongestionWindow protocol.ByteCount
 }
 
 // NewSentPacketHandler creates a new sentPacketHandler
-func NewSentPacketHandler(stopWaitingManager StopWaitingManager) SentPacketHandler {
+func NewSentPacketHandler(stopWaitingManager StopWaitingManager, initialCongestionWindow, maxCongestionWindow protocol.PacketNumber, tracer qlog.ConnectionTracer) SentPacketHandler {
 	rttStats := &congestion.RTTStats{}
 
 	congestion := congestion.NewCubicSender(
 		congestion.DefaultClock{},
 		rttStats,
 		false, /* don't use reno since chromium doesn't (why?) */
-		protocol.InitialCongestionWindow,
-		protocol.DefaultMaxCongestionWindow,
+		initialCongestionWindow,
+		maxCongestionWindow,
 	)
 
 	return &sentPacketHandler{
@@ -67,13 +78,17 @@ func NewSentPacketHandler(stopWaitingManager StopWaitingManager) SentPacketHandl
 		stopWaitingManager: stopWaitingManager,
 		rttStats:           rttStats,
 		congestion:         congestion,
+		tracer:             tracer,
 	}
 }
 
 func (h *sentPacketHandler) ackPacket(packetNumber protocol.PacketNumber) *Packet {
 	packet, ok := h.packetHistory[packetNumber]
-	if ok && !packet.Retransmitted {
-		h.bytesInFlight -= packet.Length
+	if ok {
+		if !packet.Retransmitted {
+			h.bytesInFlight -= packet.Length
+		}
+		h.ackedPacketQueue = append(h.ackedPacketQueue, packet)
 	}
 	delete(h.packetHistory, packetNumber)
 
@@ -99,6 +114,9 @@ func (h *sentPacketHandler) nackPacket(packetNumber protocol.PacketNumber) (*Pac
 
 	if packet.MissingReports > protocol.RetransmissionThreshold {
 		h.queuePacketForRetransmission(packet)
+		if h.tracer != nil {
+			h.tracer.LostPacket(packet.PacketNumber, qlog.LossReasonNack)
+		}
 		return packet, nil
 	}
 	return nil, nil
@@ -108,6 +126,8 @@ func (h *sentPacketHandler) queuePacketForRetransmission(packet *Packet) {
 	h.bytesInFlight -= packet.Length
 	h.retransmissionQueue = append(h.retransmissionQueue, packet)
 	packet.Retransmitted = true
+	h.packetsLost++
+	h.bytesLost += packet.Length
 }
 
 func (h *sentPacketHandler) SentPacket(packet *Packet) error {
@@ -217,6 +237,9 @@ func (h *sentPacketHandler) ReceivedAck(ackFrameOrig *frames.AckFrame, withPacke
 		timeDelta := time.Now().Sub(packet.SendTime)
 		// TODO: Don't always update RTT
 		h.rttStats.UpdateRTT(timeDelta, ackFrame.DelayTime, time.Now())
+		if h.tracer != nil {
+			h.tracer.UpdatedRTT(h.rttStats.SmoothedRTT(), h.rttStats.MinRTT(), h.rttStats.LatestRTT())
+		}
 		if utils.Debug() {
 			utils.Debugf("\tEstimated RTT: %dms", h.rttStats.SmoothedRTT()/time.Millisecond)
 		}
@@ -268,6 +291,7 @@ func (h *sentPacketHandler) ReceivedAck(ackFrameOrig *frames.AckFrame, withPacke
 		ackedPackets,
 		lostPackets,
 	)
+	h.maybeTraceCongestionWindow()
 
 	return nil
 }
@@ -304,6 +328,16 @@ func (h *sentPacketHandler) DequeuePacketForRetransmission() (packet *Packet) {
 	return nil
 }
 
+// DequeueAckedPacket returns the next packet that was acknowledged by the peer
+func (h *sentPacketHandler) DequeueAckedPacket() (packet *Packet) {
+	if len(h.ackedPacketQueue) == 0 {
+		return nil
+	}
+	packet = h.ackedPacketQueue[0]
+	h.ackedPacketQueue = h.ackedPacketQueue[1:]
+	return packet
+}
+
 func (h *sentPacketHandler) BytesInFlight() protocol.ByteCount {
 	return h.bytesInFlight
 }
@@ -320,6 +354,25 @@ func (h *sentPacketHandler) CongestionAllowsSending() bool {
 	return h.BytesInFlight() <= h.congestion.GetCongestionWindow()
 }
 
+func (h *sentPacketHandler) OnConnectionMigration() {
+	h.rttStats.OnConnectionMigration()
+	h.congestion.OnConnectionMigration()
+	h.maybeTraceCongestionWindow()
+}
+
+func (h *sentPacketHandler) GetStats() SentPacketStats {
+	return SentPacketStats{
+		SmoothedRTT:        h.rttStats.SmoothedRTT(),
+		MinRTT:             h.rttStats.MinRTT(),
+		LatestRTT:          h.rttStats.LatestRTT(),
+		CongestionWindow:   h.congestion.GetCongestionWindow(),
+		SlowStartThreshold: h.congestion.GetSlowStartThreshold(),
+		BytesInFlight:      h.bytesInFlight,
+		PacketsLost:        h.packetsLost,
+		BytesLost:          h.bytesLost,
+	}
+}
+
 func (h *sentPacketHandler) CheckForError() error {
 	length := len(h.retransmissionQueue) + len(h.packetHistory)
 	if uint32(length) > protocol.MaxTrackedSentPackets {
@@ -342,11 +395,28 @@ func (h *sentPacketHandler) maybeQueuePacketsRTO() {
 			h.congestion.OnCongestionEvent(false, h.BytesInFlight(), nil, packetsLost)
 			h.congestion.OnRetransmissionTimeout(true)
 			h.queuePacketForRetransmission(packet)
+			if h.tracer != nil {
+				h.tracer.LostPacket(packet.PacketNumber, qlog.LossReasonRetransmissionTimeout)
+			}
+			h.maybeTraceCongestionWindow()
 			return
 		}
 	}
 }
 
+// maybeTraceCongestionWindow traces the congestion window, if it changed since it was traced last
+func (h *sentPacketHandler) maybeTraceCongestionWindow() {
+	if h.tracer == nil {
+		return
+	}
+	congestionWindow := h.congestion.GetCongestionWindow()
+	if congestionWindow == h.tracedCongestionWindow {
+		return
+	}
+	h.tracedCongestionWindow = congestionWindow
+	h.tracer.UpdatedCongestionWindow(congestionWindow, h.bytesInFlight)
+}
+
 func (h *sentPacketHandler) getRTO() time.Duration {
 	rto := h.congestion.RetransmissionDelay()
 	if rto == 0 {
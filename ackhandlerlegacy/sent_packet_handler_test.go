@@ -6,6 +6,7 @@ import (
 	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qlog"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -15,6 +16,7 @@ type mockCongestion struct {
 	argsOnPacketSent        []interface{}
 	argsOnCongestionEvent   []interface{}
 	onRetransmissionTimeout bool
+	onConnectionMigration   bool
 }
 
 func (m *mockCongestion) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration {
@@ -32,6 +34,10 @@ func (m *mockCongestion) GetCongestionWindow() protocol.ByteCount {
 	return protocol.DefaultTCPMSS
 }
 
+func (m *mockCongestion) GetSlowStartThreshold() protocol.ByteCount {
+	return 2 * protocol.DefaultTCPMSS
+}
+
 func (m *mockCongestion) OnCongestionEvent(rttUpdated bool, bytesInFlight protocol.ByteCount, ackedPackets congestion.PacketVector, lostPackets congestion.PacketVector) {
 	m.nCalls++
 	m.argsOnCongestionEvent = []interface{}{rttUpdated, bytesInFlight, ackedPackets, lostPackets}
@@ -46,8 +52,12 @@ func (m *mockCongestion) RetransmissionDelay() time.Duration {
 	return protocol.DefaultRetransmissionTime
 }
 
+func (m *mockCongestion) OnConnectionMigration() {
+	m.nCalls++
+	m.onConnectionMigration = true
+}
+
 func (m *mockCongestion) SetNumEmulatedConnections(n int)         { panic("not implemented") }
-func (m *mockCongestion) OnConnectionMigration()                  { panic("not implemented") }
 func (m *mockCongestion) SetSlowStartLargeReduction(enabled bool) { panic("not implemented") }
 
 type mockStopWaiting struct {
@@ -63,6 +73,35 @@ func (m *mockStopWaiting) ReceivedAckForPacketNumber(packetNumber protocol.Packe
 	m.receivedAckForPacketNumber = packetNumber
 }
 
+type lostPacket struct {
+	packetNumber protocol.PacketNumber
+	reason       qlog.LossReason
+}
+
+type mockTracer struct {
+	lostPackets       []lostPacket
+	rttUpdates        int
+	congestionWindows []protocol.ByteCount
+}
+
+var _ qlog.ConnectionTracer = &mockTracer{}
+
+func (t *mockTracer) SentPacket(*qlog.PacketHeader, protocol.ByteCount, []frames.Frame)     {}
+func (t *mockTracer) ReceivedPacket(*qlog.PacketHeader, protocol.ByteCount, []frames.Frame) {}
+func (t *mockTracer) LostPacket(packetNumber protocol.PacketNumber, reason qlog.LossReason) {
+	t.lostPackets = append(t.lostPackets, lostPacket{packetNumber, reason})
+}
+func (t *mockTracer) UpdatedRTT(smoothedRTT, minRTT, latestRTT time.Duration) { t.rttUpdates++ }
+func (t *mockTracer) UpdatedCongestionWindow(congestionWindow, bytesInFlight protocol.ByteCount) {
+	t.congestionWindows = append(t.congestionWindows, congestionWindow)
+}
+func (t *mockTracer) OpenedStream(protocol.StreamID)            {}
+func (t *mockTracer) ClosedStream(protocol.StreamID)            {}
+func (t *mockTracer) SentHandshakeMessage(string, []string)     {}
+func (t *mockTracer) ReceivedHandshakeMessage(string, []string) {}
+func (t *mockTracer) ClosedConnection(err error, remote bool)   {}
+func (t *mockTracer) Close()                                    {}
+
 var _ = Describe("SentPacketHandler", func() {
 	var (
 		handler     *sentPacketHandler
@@ -71,7 +110,7 @@ var _ = Describe("SentPacketHandler", func() {
 
 	BeforeEach(func() {
 		stopWaitingManager := &mockStopWaiting{}
-		handler = NewSentPacketHandler(stopWaitingManager).(*sentPacketHandler)
+		handler = NewSentPacketHandler(stopWaitingManager, protocol.InitialCongestionWindow, protocol.DefaultMaxCongestionWindow, nil).(*sentPacketHandler)
 		streamFrame = frames.StreamFrame{
 			StreamID: 5,
 			Data:     []byte{0x13, 0x37},
@@ -334,6 +373,29 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.packetHistory).ToNot(HaveKey(protocol.PacketNumber(6)))
 		})
 
+		It("queues the acknowledged packets", func() {
+			var entropy EntropyAccumulator
+			largestObserved := 4
+			for i := 0; i < largestObserved; i++ {
+				if i == 2 { // Packet Number 3 missing
+					continue
+				}
+				entropy.Add(packets[i].PacketNumber, packets[i].EntropyBit)
+			}
+			ack := frames.AckFrameLegacy{
+				LargestObserved: protocol.PacketNumber(largestObserved),
+				Entropy:         byte(entropy),
+				NackRanges:      []frames.NackRange{{FirstPacketNumber: 3, LastPacketNumber: 3}},
+			}
+			err := handler.ReceivedAck(&frames.AckFrame{AckFrameLegacy: &ack}, 1)
+			Expect(err).ToNot(HaveOccurred())
+			var acked []protocol.PacketNumber
+			for p := handler.DequeueAckedPacket(); p != nil; p = handler.DequeueAckedPacket() {
+				acked = append(acked, p.PacketNumber)
+			}
+			Expect(acked).To(ConsistOf([]protocol.PacketNumber{1, 2, 4}))
+		})
+
 		It("processes an ACK frame that would be sent after a late arrival of a packet", func() {
 			entropy := EntropyAccumulator(0)
 			largestObserved := 6
@@ -527,6 +589,16 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.retransmissionQueue[0].PacketNumber).To(Equal(protocol.PacketNumber(2)))
 		})
 
+		It("counts packets queued for retransmission as lost", func() {
+			for i := uint8(0); i < protocol.RetransmissionThreshold+1; i++ {
+				_, err := handler.nackPacket(2)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			stats := handler.GetStats()
+			Expect(stats.PacketsLost).To(Equal(uint64(1)))
+			Expect(stats.BytesLost).To(Equal(protocol.ByteCount(1)))
+		})
+
 		It("dequeues a packet for retransmission", func() {
 			for i := uint8(0); i < protocol.RetransmissionThreshold+1; i++ {
 				_, err := handler.nackPacket(3)
@@ -722,6 +794,27 @@ var _ = Describe("SentPacketHandler", func() {
 
 			Expect(cong.onRetransmissionTimeout).To(BeTrue())
 		})
+
+		It("returns the stats", func() {
+			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 42})
+			Expect(err).NotTo(HaveOccurred())
+			stats := handler.GetStats()
+			Expect(stats.SmoothedRTT).To(Equal(time.Second))
+			Expect(stats.MinRTT).To(Equal(time.Second))
+			Expect(stats.LatestRTT).To(Equal(time.Second))
+			Expect(stats.CongestionWindow).To(Equal(protocol.DefaultTCPMSS))
+			Expect(stats.SlowStartThreshold).To(Equal(2 * protocol.DefaultTCPMSS))
+			Expect(stats.BytesInFlight).To(Equal(protocol.ByteCount(42)))
+		})
+
+		It("resets the congestion controller and the RTT stats on connection migration", func() {
+			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			Expect(handler.rttStats.SmoothedRTT()).ToNot(BeZero())
+			handler.OnConnectionMigration()
+			Expect(cong.onConnectionMigration).To(BeTrue())
+			Expect(handler.rttStats.SmoothedRTT()).To(BeZero())
+		})
 	})
 
 	Context("calculating RTO", func() {
@@ -812,4 +905,49 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.DequeuePacketForRetransmission()).To(Equal(p))
 		})
 	})
+
+	Context("tracing", func() {
+		var tracer *mockTracer
+
+		BeforeEach(func() {
+			tracer = &mockTracer{}
+			handler.tracer = tracer
+		})
+
+		It("traces packets lost because they were NACKed", func() {
+			for i := protocol.PacketNumber(1); i <= 3; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: i, Frames: []frames.Frame{&streamFrame}, Length: 1})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			for i := uint8(0); i < protocol.RetransmissionThreshold+1; i++ {
+				_, err := handler.nackPacket(2)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(tracer.lostPackets).To(Equal([]lostPacket{{2, qlog.LossReasonNack}}))
+		})
+
+		It("traces packets lost because of a retransmission timeout, and the new congestion window", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 1})
+			Expect(err).NotTo(HaveOccurred())
+			handler.lastSentPacketTime = time.Now().Add(-time.Second)
+			handler.maybeQueuePacketsRTO()
+			Expect(tracer.lostPackets).To(Equal([]lostPacket{{1, qlog.LossReasonRetransmissionTimeout}}))
+			Expect(tracer.congestionWindows).To(Equal([]protocol.ByteCount{handler.congestion.GetCongestionWindow()}))
+		})
+
+		It("traces RTT updates and congestion window changes when receiving an ACK", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 1})
+			Expect(err).NotTo(HaveOccurred())
+			err = handler.ReceivedAck(&frames.AckFrame{AckFrameLegacy: &frames.AckFrameLegacy{LargestObserved: 1}}, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tracer.rttUpdates).To(Equal(1))
+			Expect(tracer.congestionWindows).To(HaveLen(1))
+		})
+
+		It("only traces the congestion window when it changed", func() {
+			handler.maybeTraceCongestionWindow()
+			handler.maybeTraceCongestionWindow()
+			Expect(tracer.congestionWindows).To(HaveLen(1))
+		})
+	})
 })
@@ -0,0 +1,10 @@
+package quic
+
+import "golang.org/x/net/ipv4"
+
+// A batchConn reads and writes multiple packets with a single syscall
+// ipv4.Message is the same type as ipv6.Message, so this is implemented by both ipv4.PacketConn and ipv6.PacketConn
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
@@ -0,0 +1,21 @@
+package quic
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// newBatchConn returns a batchConn using recvmmsg and sendmmsg for UDP connections
+// It returns nil for all other connections.
+func newBatchConn(pconn net.PacketConn) batchConn {
+	udpConn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	if addr, ok := udpConn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil && addr.IP != nil {
+		return ipv6.NewPacketConn(udpConn)
+	}
+	return ipv4.NewPacketConn(udpConn)
+}
@@ -0,0 +1,69 @@
+package quic
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batched I/O", func() {
+	It("doesn't use batched I/O for connections other than UDP", func() {
+		Expect(newBatchConn(newMockPacketConn())).To(BeNil())
+	})
+
+	It("sends and receives multiple packets using batched I/O", func() {
+		serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer serverConn.Close()
+		clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+
+		c := newConn(clientConn, serverConn.LocalAddr())
+		Expect(c.bconn).ToNot(BeNil())
+		err = c.writeBatch([][]byte{[]byte("foo"), []byte("bar"), []byte("baz")})
+		Expect(err).ToNot(HaveOccurred())
+
+		bconn := newBatchConn(serverConn)
+		Expect(bconn).ToNot(BeNil())
+		var received []string
+		for len(received) < 3 {
+			msgs := make([]ipv4.Message, 10)
+			for i := range msgs {
+				msgs[i].Buffers = [][]byte{make([]byte, 100)}
+			}
+			n, err := bconn.ReadBatch(msgs, 0)
+			Expect(err).ToNot(HaveOccurred())
+			for _, msg := range msgs[:n] {
+				Expect(msg.Addr).To(Equal(clientConn.LocalAddr()))
+				received = append(received, string(msg.Buffers[0][:msg.N]))
+			}
+		}
+		Expect(received).To(Equal([]string{"foo", "bar", "baz"}))
+	})
+
+	It("reuses the messages for sending multiple batches", func() {
+		serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer serverConn.Close()
+		clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+
+		c := newConn(clientConn, serverConn.LocalAddr())
+		c.gsoConn = nil
+		err = c.writeBatch([][]byte{[]byte("foo"), []byte("bar"), []byte("baz")})
+		Expect(err).ToNot(HaveOccurred())
+		msg := &c.msgs[0]
+		err = c.writeBatch([][]byte{[]byte("foo"), []byte("bar")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(&c.msgs[0]).To(BeIdenticalTo(msg))
+		Expect(c.buffers).To(HaveLen(3))
+		for _, b := range c.buffers {
+			Expect(b).To(BeNil())
+		}
+	})
+})
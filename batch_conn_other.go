@@ -0,0 +1,10 @@
+// +build !linux
+
+package quic
+
+import "net"
+
+// newBatchConn returns nil, batched reads and writes are only supported on Linux
+func newBatchConn(pconn net.PacketConn) batchConn {
+	return nil
+}
@@ -2,22 +2,50 @@ package quic
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 )
 
+// A packetBuffer holds a single packet
+// It is reference counted: everybody who keeps a reference to the packet data beyond handling the packet,
+// e.g. a stream that queues a StreamFrame referencing the data, has to Retain the buffer, and Release it when done.
+// The buffer is put back into the pool once the last reference was released.
+type packetBuffer struct {
+	Slice []byte
+
+	refCount int32
+}
+
 var bufferPool sync.Pool
 
-func getPacketBuffer() []byte {
-	return bufferPool.Get().([]byte)
+// getPacketBuffer gets a packet buffer from the pool
+// The caller holds the only reference to it, and has to Release it.
+func getPacketBuffer() *packetBuffer {
+	buf := bufferPool.Get().(*packetBuffer)
+	buf.refCount = 1
+	buf.Slice = buf.Slice[:0]
+	return buf
 }
 
-func putPacketBuffer(buf []byte) {
-	bufferPool.Put(buf[:0])
+// Retain adds a reference to the buffer
+func (b *packetBuffer) Retain() {
+	atomic.AddInt32(&b.refCount, 1)
+}
+
+// Release removes a reference to the buffer, and puts it back into the pool if it was the last one
+func (b *packetBuffer) Release() {
+	refCount := atomic.AddInt32(&b.refCount, -1)
+	if refCount < 0 {
+		panic("packetBuffer BUG: released too often")
+	}
+	if refCount == 0 {
+		bufferPool.Put(b)
+	}
 }
 
 func init() {
 	bufferPool.New = func() interface{} {
-		return make([]byte, 0, protocol.MaxPacketSize)
+		return &packetBuffer{Slice: make([]byte, 0, protocol.MaxPacketSize)}
 	}
 }
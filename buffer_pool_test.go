@@ -7,20 +7,47 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// getPacketBufferWithData gets a packet buffer from the pool, containing a copy of data
+func getPacketBufferWithData(data []byte) *packetBuffer {
+	buf := getPacketBuffer()
+	buf.Slice = append(buf.Slice, data...)
+	return buf
+}
+
 var _ = Describe("Buffer Pool", func() {
 	It("returns buffers of correct len and cap", func() {
 		buf := getPacketBuffer()
-		Expect(buf).To(HaveLen(0))
-		Expect(buf).To(HaveCap(int(protocol.MaxPacketSize)))
+		Expect(buf.Slice).To(HaveLen(0))
+		Expect(buf.Slice).To(HaveCap(int(protocol.MaxPacketSize)))
+		Expect(buf.refCount).To(BeEquivalentTo(1))
 	})
 
 	It("zeroes put buffers' length", func() {
 		for i := 0; i < 1000; i++ {
 			buf := getPacketBuffer()
-			putPacketBuffer(buf[0:10])
+			buf.Slice = buf.Slice[0:10]
+			buf.Release()
 			buf = getPacketBuffer()
-			Expect(buf).To(HaveLen(0))
-			Expect(buf).To(HaveCap(int(protocol.MaxPacketSize)))
+			Expect(buf.Slice).To(HaveLen(0))
+			Expect(buf.Slice).To(HaveCap(int(protocol.MaxPacketSize)))
 		}
 	})
+
+	It("counts references", func() {
+		buf := getPacketBuffer()
+		buf.Retain()
+		buf.Retain()
+		Expect(buf.refCount).To(BeEquivalentTo(3))
+		buf.Release()
+		buf.Release()
+		Expect(buf.refCount).To(BeEquivalentTo(1))
+		buf.Release()
+		Expect(buf.refCount).To(BeZero())
+	})
+
+	It("panics when released too often", func() {
+		buf := getPacketBuffer()
+		buf.Release()
+		Expect(func() { buf.Release() }).To(Panic())
+	})
 })
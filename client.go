@@ -0,0 +1,259 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+)
+
+type client struct {
+	mutex sync.Mutex
+
+	conn     net.PacketConn
+	addr     net.Addr
+	hostname string
+
+	config *Config
+
+	connectionID protocol.ConnectionID
+	version      protocol.VersionNumber
+
+	versionNegotiated bool // has version negotiation completed yet
+
+	handshakeChan chan error
+
+	session *session
+}
+
+var errCloseSessionForNewVersion = errors.New("closing session in order to recreate it with a new version")
+
+var errNoClientVersion = errors.New("quic: the client only supports QUIC 33 and newer")
+
+// Dial establishes a new QUIC connection to a server
+// It blocks until the handshake has completed, or an error occurred.
+// The TLSConfig of the config is used to verify the server's certificate chain.
+// The client only supports QUIC 33 and newer, so the configured versions must contain at least one of them.
+func Dial(addr string, config *Config) (Session, error) {
+	config = populateConfig(config)
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	// the versions are sorted in ascending order, see chooseClientVersion for why older versions can't be used
+	if config.Versions[len(config.Versions)-1] < protocol.Version33 {
+		return nil, errNoClientVersion
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	connID, err := generateConnectionID()
+	if err != nil {
+		return nil, err
+	}
+
+	// don't set an IP, such that both IPv4 and IPv6 addresses can be dialed
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		conn:          conn,
+		addr:          udpAddr,
+		hostname:      hostname,
+		config:        config,
+		connectionID:  connID,
+		version:       config.Versions[len(config.Versions)-1], // use the highest configured version by default
+		handshakeChan: make(chan error, 1),
+	}
+
+	c.config.Logger.Infof("Starting new connection to %s (%s), connectionID %x, version %d", hostname, udpAddr.String(), c.connectionID, c.version)
+
+	c.mutex.Lock()
+	err = c.createNewSession()
+	c.mutex.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.listen()
+
+	if err := <-c.handshakeChan; err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.session, nil
+}
+
+// listen listens on the underlying connection and passes packets on for handling
+func (c *client) listen() {
+	for {
+		buffer := getPacketBuffer()
+
+		n, addr, err := c.conn.ReadFrom(buffer.Slice[:protocol.MaxPacketSize])
+		if err != nil {
+			buffer.Release()
+			if !strings.HasSuffix(err.Error(), "use of closed network connection") {
+				c.mutex.Lock()
+				c.session.Close(err)
+				c.mutex.Unlock()
+			}
+			return
+		}
+		buffer.Slice = buffer.Slice[:n]
+
+		if err := c.handlePacket(addr, buffer); err != nil {
+			c.config.Logger.Errorf("error handling packet: %s", err.Error())
+		}
+		buffer.Release()
+	}
+}
+
+// handlePacket handles a packet received from the server
+// The caller keeps its reference to the packet buffer, the session retains the buffer if it queues the packet.
+func (c *client) handlePacket(remoteAddr net.Addr, buffer *packetBuffer) error {
+	packet := buffer.Slice
+	if protocol.ByteCount(len(packet)) > protocol.MaxPacketSize {
+		return qerr.PacketTooLarge
+	}
+
+	r := bytes.NewReader(packet)
+
+	hdr, err := parsePublicHeader(r, protocol.PerspectiveServer)
+	if err != nil {
+		return qerr.Error(qerr.InvalidPacketHeader, err.Error())
+	}
+	hdr.Raw = packet[:len(packet)-r.Len()]
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// ignore packets sent for a different connection
+	if hdr.ConnectionID != c.connectionID {
+		return nil
+	}
+
+	if hdr.ResetFlag {
+		pr, err := parsePublicReset(r)
+		if err != nil {
+			c.config.Logger.Infof("Received a Public Reset for connection %x. An error occurred parsing the packet.", hdr.ConnectionID)
+			return nil
+		}
+		c.config.Logger.Infof("Received Public Reset, rejected packet number: %#x.", pr.rejectedPacketNumber)
+		c.session.closeImpl(qerr.Error(qerr.PublicReset, fmt.Sprintf("Received a Public Reset for packet number %#x", pr.rejectedPacketNumber)), true)
+		return nil
+	}
+
+	if hdr.VersionFlag {
+		// ignore delayed Version Negotiation Packets
+		if c.versionNegotiated {
+			return nil
+		}
+		return c.handleVersionNegotiationPacket(hdr)
+	}
+
+	// this is the first packet after the client sent a packet with the VersionFlag set
+	// if the server doesn't send a Version Negotiation Packet, it supports the suggested version
+	c.versionNegotiated = true
+
+	c.session.handlePacket(&receivedPacket{
+		remoteAddr:   remoteAddr,
+		publicHeader: hdr,
+		data:         packet[len(packet)-r.Len():],
+		buffer:       buffer,
+	})
+	return nil
+}
+
+// handleVersionNegotiationPacket recreates the session with a version supported by the server
+// the mutex has to be held when calling this function
+func (c *client) handleVersionNegotiationPacket(hdr *publicHeader) error {
+	// if the server supports the version we're using, this is a delayed or forged packet
+	for _, v := range hdr.SupportedVersions {
+		if v == c.version {
+			return nil
+		}
+	}
+
+	newVersion, ok := chooseClientVersion(c.config.Versions, hdr.SupportedVersions)
+	if !ok {
+		c.session.Close(qerr.InvalidVersion)
+		return nil
+	}
+
+	c.config.Logger.Infof("Switching to QUIC version %d", newVersion)
+	c.version = newVersion
+	c.versionNegotiated = true
+	c.session.Close(errCloseSessionForNewVersion)
+
+	return c.createNewSession()
+}
+
+// createNewSession creates a new session and starts its run loop
+// the mutex has to be held when calling this function
+func (c *client) createNewSession() error {
+	session, err := newClientSession(
+		newConn(c.conn, c.addr),
+		c.hostname,
+		c.version,
+		c.connectionID,
+		c.config,
+		c.closeCallback,
+		c.handshakeChan,
+	)
+	if err != nil {
+		return err
+	}
+
+	c.session = session
+	go session.run()
+	return nil
+}
+
+func (c *client) closeCallback(id protocol.ConnectionID, _ []byte) {
+	c.config.Logger.Infof("Connection %x closed.", id)
+	c.conn.Close()
+}
+
+// chooseClientVersion chooses the highest version that is supported by both the client and the server
+// the client doesn't support QUIC 32, since the diversification nonce flag isn't unambiguous before QUIC 33
+func chooseClientVersion(ourVersions, serverVersions []protocol.VersionNumber) (protocol.VersionNumber, bool) {
+	for i := len(ourVersions) - 1; i >= 0; i-- {
+		v := ourVersions[i]
+		if v < protocol.Version33 {
+			break
+		}
+		for _, sv := range serverVersions {
+			if sv == v {
+				return v, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func generateConnectionID() (protocol.ConnectionID, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	return protocol.ConnectionID(binary.LittleEndian.Uint64(b)), nil
+}
@@ -0,0 +1,178 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		cl           *client
+		serverConn   *net.UDPConn
+		connectionID protocol.ConnectionID
+	)
+
+	BeforeEach(func() {
+		var err error
+		serverConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		connectionID = 0x1337
+		cl = &client{
+			conn:          conn,
+			addr:          serverConn.LocalAddr().(*net.UDPAddr),
+			hostname:      "quic.clemente.io",
+			config:        populateConfig(nil),
+			connectionID:  connectionID,
+			version:       protocol.Version34,
+			handshakeChan: make(chan error, 1),
+		}
+		cl.mutex.Lock()
+		err = cl.createNewSession()
+		cl.mutex.Unlock()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cl.session.Close(nil)
+		serverConn.Close()
+	})
+
+	isClosed := func(s *session) bool {
+		return atomic.LoadUint32(&s.closed) == 1
+	}
+
+	It("errors when dialing with only versions older than QUIC 33", func() {
+		_, err := Dial("localhost:4242", &Config{Versions: []protocol.VersionNumber{protocol.Version32}})
+		Expect(err).To(MatchError(errNoClientVersion))
+	})
+
+	It("generates random connection IDs", func() {
+		id1, err := generateConnectionID()
+		Expect(err).ToNot(HaveOccurred())
+		id2, err := generateConnectionID()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id1).ToNot(Equal(id2))
+	})
+
+	It("opens streams with odd stream IDs", func() {
+		str, err := cl.session.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+		str, err = cl.session.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.StreamID()).To(Equal(protocol.StreamID(5)))
+	})
+
+	It("errors on packets that are too large", func() {
+		err := cl.handlePacket(nil, getPacketBufferWithData(bytes.Repeat([]byte{'f'}, int(protocol.MaxPacketSize+1))))
+		Expect(err).To(MatchError(qerr.PacketTooLarge))
+	})
+
+	It("errors on invalid public headers", func() {
+		err := cl.handlePacket(nil, getPacketBufferWithData([]byte{0x08}))
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidPacketHeader))
+	})
+
+	It("ignores packets with a different connection ID", func() {
+		b := &bytes.Buffer{}
+		hdr := &publicHeader{
+			ConnectionID:    connectionID + 1,
+			PacketNumber:    1,
+			PacketNumberLen: protocol.PacketNumberLen1,
+		}
+		err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version34)
+		Expect(err).ToNot(HaveOccurred())
+		err = cl.handlePacket(nil, getPacketBufferWithData(b.Bytes()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cl.versionNegotiated).To(BeFalse())
+	})
+
+	It("closes the session when receiving a public reset", func() {
+		b := &bytes.Buffer{}
+		b.Write(writePublicReset(connectionID, 1, 0))
+		err := cl.handlePacket(nil, getPacketBufferWithData(b.Bytes()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(isClosed(cl.session)).To(BeTrue())
+		Eventually(cl.handshakeChan).Should(Receive(MatchError(qerr.Error(qerr.PublicReset, "Received a Public Reset for packet number 0x1"))))
+	})
+
+	Context("version negotiation", func() {
+		composeVersionNegotiationPacket := func(connID protocol.ConnectionID, versions []protocol.VersionNumber) []byte {
+			b := &bytes.Buffer{}
+			b.WriteByte(0x01 | 0x0c)
+			binary.Write(b, binary.LittleEndian, uint64(connID))
+			for _, v := range versions {
+				binary.Write(b, binary.LittleEndian, protocol.VersionNumberToTag(v))
+			}
+			return b.Bytes()
+		}
+
+		It("chooses the highest version supported by both sides", func() {
+			v, ok := chooseClientVersion(protocol.SupportedVersions, []protocol.VersionNumber{protocol.Version33, protocol.Version34})
+			Expect(ok).To(BeTrue())
+			Expect(v).To(Equal(protocol.Version34))
+			v, ok = chooseClientVersion(protocol.SupportedVersions, []protocol.VersionNumber{1, protocol.Version33})
+			Expect(ok).To(BeTrue())
+			Expect(v).To(Equal(protocol.Version33))
+		})
+
+		It("only chooses versions that are configured", func() {
+			v, ok := chooseClientVersion([]protocol.VersionNumber{protocol.Version33}, []protocol.VersionNumber{protocol.Version33, protocol.Version34})
+			Expect(ok).To(BeTrue())
+			Expect(v).To(Equal(protocol.Version33))
+		})
+
+		It("doesn't choose QUIC 32", func() {
+			_, ok := chooseClientVersion(protocol.SupportedVersions, []protocol.VersionNumber{protocol.Version32})
+			Expect(ok).To(BeFalse())
+		})
+
+		It("recreates the session with a new version", func() {
+			cl.version = 1 // pretend we're using an unsupported version
+			oldSession := cl.session
+			err := cl.handlePacket(nil, getPacketBufferWithData(composeVersionNegotiationPacket(connectionID, []protocol.VersionNumber{protocol.Version33})))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.version).To(Equal(protocol.Version33))
+			Expect(cl.versionNegotiated).To(BeTrue())
+			Expect(cl.session).ToNot(BeIdenticalTo(oldSession))
+			Expect(isClosed(oldSession)).To(BeTrue())
+			Expect(cl.handshakeChan).ToNot(Receive())
+		})
+
+		It("ignores version negotiation packets that contain the current version", func() {
+			oldSession := cl.session
+			err := cl.handlePacket(nil, getPacketBufferWithData(composeVersionNegotiationPacket(connectionID, []protocol.VersionNumber{protocol.Version33, protocol.Version34})))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.version).To(Equal(protocol.Version34))
+			Expect(cl.session).To(BeIdenticalTo(oldSession))
+		})
+
+		It("ignores delayed version negotiation packets", func() {
+			cl.version = 1
+			cl.versionNegotiated = true
+			oldSession := cl.session
+			err := cl.handlePacket(nil, getPacketBufferWithData(composeVersionNegotiationPacket(connectionID, []protocol.VersionNumber{protocol.Version33})))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.session).To(BeIdenticalTo(oldSession))
+			Expect(cl.version).To(Equal(protocol.VersionNumber(1)))
+		})
+
+		It("closes the session if no common version is found", func() {
+			cl.version = 1
+			err := cl.handlePacket(nil, getPacketBufferWithData(composeVersionNegotiationPacket(connectionID, []protocol.VersionNumber{protocol.Version32})))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(isClosed(cl.session)).To(BeTrue())
+			Eventually(cl.handshakeChan).Should(Receive(Equal(qerr.Error(qerr.InvalidVersion, ""))))
+		})
+	})
+})
@@ -0,0 +1,120 @@
+package quic
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qlog"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// Config contains all configuration data needed for a QUIC server or client.
+// All fields except the TLSConfig are optional, zero values are replaced by the defaults from the protocol package.
+type Config struct {
+	// TLSConfig is used by the server to sign the server config, and by the client to verify the certificate chain.
+	TLSConfig *tls.Config
+	// Versions are the QUIC versions that may be negotiated, in ascending order.
+	// If not set, all versions in protocol.SupportedVersions are used.
+	Versions []protocol.VersionNumber
+	// ReceiveStreamFlowControlWindow is the stream-level flow control window for receiving data.
+	ReceiveStreamFlowControlWindow protocol.ByteCount
+	// ReceiveConnectionFlowControlWindow is the connection-level flow control window for receiving data.
+	ReceiveConnectionFlowControlWindow protocol.ByteCount
+	// StreamSendBufferSize is the number of bytes a stream buffers until they are acknowledged by the peer.
+	// Write returns as soon as the data was copied into the buffer, and only blocks while the buffer is full.
+	StreamSendBufferSize protocol.ByteCount
+	// MaxStreamsPerConnection is the maximum number of streams the peer may open.
+	MaxStreamsPerConnection uint32
+	// MaxIdleConnectionStateLifetime is the maximum value accepted for the idle connection state lifetime.
+	MaxIdleConnectionStateLifetime time.Duration
+	// InitialCongestionWindow is the initial congestion window in QUIC packets.
+	InitialCongestionWindow protocol.PacketNumber
+	// MaxCongestionWindow is the maximum congestion window in QUIC packets.
+	MaxCongestionWindow protocol.PacketNumber
+	// MaxSessionUnprocessedPackets is the maximum number of packets queued in a session that are not yet processed.
+	MaxSessionUnprocessedPackets int
+	// ResetSecret is used by the server to derive the nonce proof sent in public resets.
+	// All servers that may receive packets for the same connections should use the same secret, and it should persist across restarts.
+	// If not set, a random secret is generated.
+	ResetSecret []byte
+	// ConnectionMigrated is called by the server when a client's address changed.
+	// It is only called once a packet from the new address was authenticated.
+	// It is called from the session's run loop, and must not block.
+	ConnectionMigrated func(Session, ConnectionMigration)
+	// ReusePortSockets is the number of sockets ListenAddr opens on the same address using SO_REUSEPORT.
+	// Every socket gets its own receive loop, all of them share the same sessions. A good value is runtime.NumCPU().
+	// This is only supported on Linux. If not set, a single socket is used.
+	ReusePortSockets int
+	// EventLoopWorkers is the number of event loop workers the server multiplexes its sessions onto.
+	// Sessions then don't run their own go routine and timer, which makes idle sessions a lot cheaper.
+	// If not set, every session runs its own go routine.
+	EventLoopWorkers int
+	// Tracer is used to trace the events of connections, e.g. packets sent and received, packet loss and RTT updates.
+	// qlog.NewFileTracer writes a trace file for every connection.
+	Tracer qlog.Tracer
+	// KeyLogWriter optionally specifies a destination for the keys and IVs of all connections, e.g. to decrypt packet captures in Wireshark.
+	// Every time keys are derived, a line containing the connection ID and the client's and server's keys and IVs is written.
+	// Using a KeyLogWriter compromises security and should only be used for debugging.
+	KeyLogWriter io.Writer
+	// Logger is used for the log messages of the server or client, and of all its sessions.
+	// Sessions attach their connection ID and the remote address to every message.
+	// Use utils.NewStdLogger to log to a log.Logger, and utils.NewRateLimitedLogger to limit floods of error messages.
+	// If not set, utils.DefaultLogger is used, which is configured by utils.SetLogLevel and utils.SetLogWriter.
+	Logger utils.Logger
+}
+
+// populateConfig returns a copy of the config, with all unset values set to their defaults
+func populateConfig(config *Config) *Config {
+	if config == nil {
+		config = &Config{}
+	}
+	c := *config
+
+	if len(c.Versions) == 0 {
+		c.Versions = protocol.SupportedVersions
+	}
+	if c.ReceiveStreamFlowControlWindow == 0 {
+		c.ReceiveStreamFlowControlWindow = protocol.ReceiveStreamFlowControlWindow
+	}
+	if c.ReceiveConnectionFlowControlWindow == 0 {
+		c.ReceiveConnectionFlowControlWindow = protocol.ReceiveConnectionFlowControlWindow
+	}
+	if c.StreamSendBufferSize == 0 {
+		c.StreamSendBufferSize = protocol.DefaultStreamSendBufferSize
+	}
+	if c.MaxStreamsPerConnection == 0 {
+		c.MaxStreamsPerConnection = protocol.MaxStreamsPerConnection
+	}
+	if c.MaxIdleConnectionStateLifetime == 0 {
+		c.MaxIdleConnectionStateLifetime = protocol.MaxIdleConnectionStateLifetime
+	}
+	if c.InitialCongestionWindow == 0 {
+		c.InitialCongestionWindow = protocol.InitialCongestionWindow
+	}
+	if c.MaxCongestionWindow == 0 {
+		c.MaxCongestionWindow = protocol.DefaultMaxCongestionWindow
+	}
+	if c.MaxSessionUnprocessedPackets == 0 {
+		c.MaxSessionUnprocessedPackets = protocol.MaxSessionUnprocessedPackets
+	}
+	if c.Logger == nil {
+		c.Logger = utils.DefaultLogger
+	}
+	return &c
+}
+
+// validateConfig checks that a populated config only contains values we can handle
+func validateConfig(config *Config) error {
+	for _, v := range config.Versions {
+		if !protocol.IsSupportedVersion(protocol.SupportedVersions, v) {
+			return fmt.Errorf("quic: unsupported version %d", v)
+		}
+	}
+	if config.InitialCongestionWindow > config.MaxCongestionWindow {
+		return fmt.Errorf("quic: initial congestion window (%d) larger than the max congestion window (%d)", config.InitialCongestionWindow, config.MaxCongestionWindow)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package quic
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	It("uses the default values if no config is given", func() {
+		c := populateConfig(nil)
+		Expect(c.Versions).To(Equal(protocol.SupportedVersions))
+		Expect(c.ReceiveStreamFlowControlWindow).To(Equal(protocol.ReceiveStreamFlowControlWindow))
+		Expect(c.ReceiveConnectionFlowControlWindow).To(Equal(protocol.ReceiveConnectionFlowControlWindow))
+		Expect(c.StreamSendBufferSize).To(Equal(protocol.DefaultStreamSendBufferSize))
+		Expect(c.MaxStreamsPerConnection).To(Equal(protocol.MaxStreamsPerConnection))
+		Expect(c.MaxIdleConnectionStateLifetime).To(Equal(protocol.MaxIdleConnectionStateLifetime))
+		Expect(c.InitialCongestionWindow).To(Equal(protocol.InitialCongestionWindow))
+		Expect(c.MaxCongestionWindow).To(Equal(protocol.DefaultMaxCongestionWindow))
+		Expect(c.MaxSessionUnprocessedPackets).To(Equal(protocol.MaxSessionUnprocessedPackets))
+		Expect(c.Logger).To(Equal(utils.DefaultLogger))
+	})
+
+	It("keeps values that are set", func() {
+		config := &Config{
+			Versions:                           []protocol.VersionNumber{protocol.Version34},
+			ReceiveStreamFlowControlWindow:     1000,
+			ReceiveConnectionFlowControlWindow: 2000,
+			StreamSendBufferSize:               3000,
+			MaxStreamsPerConnection:            10,
+			MaxIdleConnectionStateLifetime:     time.Minute,
+			InitialCongestionWindow:            5,
+			MaxCongestionWindow:                50,
+			MaxSessionUnprocessedPackets:       20,
+			Logger:                             utils.NewLogger(&bytes.Buffer{}, utils.LogLevelInfo),
+		}
+		c := populateConfig(config)
+		Expect(*c).To(Equal(*config))
+	})
+
+	It("doesn't modify the config passed in", func() {
+		config := &Config{MaxStreamsPerConnection: 10}
+		c := populateConfig(config)
+		Expect(c).ToNot(BeIdenticalTo(config))
+		Expect(config.ReceiveStreamFlowControlWindow).To(BeZero())
+	})
+
+	Context("validating", func() {
+		It("accepts the default config", func() {
+			Expect(validateConfig(populateConfig(nil))).To(Succeed())
+		})
+
+		It("errors on unsupported versions", func() {
+			c := populateConfig(&Config{Versions: []protocol.VersionNumber{protocol.Version34, 1}})
+			Expect(validateConfig(c)).To(MatchError("quic: unsupported version 1"))
+		})
+
+		It("errors if the initial congestion window is larger than the max congestion window", func() {
+			c := populateConfig(&Config{InitialCongestionWindow: 100, MaxCongestionWindow: 10})
+			Expect(validateConfig(c)).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,181 @@
+package quic
+
+import (
+	"net"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/ipv4"
+)
+
+type connection interface {
+	write([]byte) error
+	writeBatch([][]byte) error
+	setCurrentRemoteAddr(net.Addr)
+	// setLogger sets the logger of the session that uses the connection
+	setLogger(utils.Logger)
+	IP() net.IP
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// conn sends packets to the peer of a session over a net.PacketConn
+type conn struct {
+	pconn net.PacketConn
+	// bconn is used to send multiple packets with a single syscall, it is nil if the platform doesn't support this
+	bconn batchConn
+	// gsoConn is used to send multiple packets of the same size with a single sendmsg, using UDP generic segmentation offload
+	// it is nil if GSO isn't supported, it is only accessed by writeBatch
+	gsoConn *net.UDPConn
+	gsoBuf  []byte
+	gsoOOB  []byte
+	// msgs and buffers are reused when sending a batch of packets, they are only accessed by writeBatch
+	// The buffers of every message are a slice of buffers of length 1.
+	msgs    []ipv4.Message
+	buffers [][]byte
+
+	logger utils.Logger
+
+	// currentAddr is changed when the connection is migrated, it is protected by the mutex
+	currentAddr net.Addr
+	mutex       sync.RWMutex
+}
+
+var _ connection = &conn{}
+
+func newConn(pconn net.PacketConn, remoteAddr net.Addr) *conn {
+	c := &conn{
+		pconn:       pconn,
+		bconn:       newBatchConn(pconn),
+		currentAddr: remoteAddr,
+		logger:      utils.DefaultLogger,
+	}
+	if udpConn, ok := pconn.(*net.UDPConn); ok && gsoSupported(udpConn) {
+		c.gsoConn = udpConn
+	}
+	return c
+}
+
+func (c *conn) write(p []byte) error {
+	c.mutex.RLock()
+	addr := c.currentAddr
+	c.mutex.RUnlock()
+	_, err := c.pconn.WriteTo(p, addr)
+	return err
+}
+
+// writeBatch sends multiple packets to the current address
+// If supported, the packets are sent using as few syscalls as possible.
+func (c *conn) writeBatch(packets [][]byte) error {
+	addr := c.RemoteAddr()
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && c.gsoConn != nil {
+		n, err := c.writeGSO(packets, udpAddr)
+		if err == nil {
+			return nil
+		}
+		if !isGSOError(err) {
+			return err
+		}
+		c.logger.Infof("Sending packets using UDP GSO failed, falling back to sending them separately: %s", err.Error())
+		c.gsoConn = nil
+		packets = packets[n:]
+	}
+	if c.bconn == nil {
+		for _, p := range packets {
+			if _, err := c.pconn.WriteTo(p, addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if cap(c.msgs) < len(packets) {
+		c.msgs = make([]ipv4.Message, len(packets))
+		c.buffers = make([][]byte, len(packets))
+	}
+	msgs := c.msgs[:len(packets)]
+	for i, p := range packets {
+		c.buffers[i] = p
+		msgs[i].Buffers = c.buffers[i : i+1]
+		msgs[i].Addr = addr
+	}
+	// don't keep references to the packets, their buffers are reused once they were sent
+	defer func() {
+		for i := range packets {
+			c.buffers[i] = nil
+		}
+	}()
+	for len(msgs) > 0 {
+		n, err := c.bconn.WriteBatch(msgs, 0)
+		if err != nil {
+			return err
+		}
+		msgs = msgs[n:]
+	}
+	return nil
+}
+
+// writeGSO sends runs of packets of the same size with a single syscall each
+// Only the last packet of a run may be smaller than the others, the kernel splits the run into separate UDP datagrams.
+// It returns the number of packets that were sent.
+func (c *conn) writeGSO(packets [][]byte, addr *net.UDPAddr) (int, error) {
+	var sent int
+	for sent < len(packets) {
+		segmentSize := len(packets[sent])
+		c.gsoBuf = append(c.gsoBuf[:0], packets[sent]...)
+		n := 1
+		for sent+n < len(packets) {
+			p := packets[sent+n]
+			if len(p) > segmentSize || len(c.gsoBuf)+len(p) > protocol.MaxCoalescedPacketSize {
+				break
+			}
+			c.gsoBuf = append(c.gsoBuf, p...)
+			n++
+			if len(p) < segmentSize {
+				break
+			}
+		}
+		var err error
+		if n == 1 {
+			_, err = c.gsoConn.WriteToUDP(c.gsoBuf, addr)
+		} else {
+			c.gsoOOB = appendUDPSegmentSizeMsg(c.gsoOOB[:0], segmentSize)
+			_, _, err = c.gsoConn.WriteMsgUDP(c.gsoBuf, c.gsoOOB, addr)
+		}
+		if err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+func (c *conn) setLogger(logger utils.Logger) {
+	c.logger = logger
+}
+
+func (c *conn) setCurrentRemoteAddr(addr net.Addr) {
+	c.mutex.Lock()
+	c.currentAddr = addr
+	c.mutex.Unlock()
+}
+
+// IP returns the IP address of the peer
+// It returns nil if the net.PacketConn doesn't use UDP addresses.
+func (c *conn) IP() net.IP {
+	if addr, ok := c.RemoteAddr().(*net.UDPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.pconn.LocalAddr()
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.currentAddr
+}
@@ -0,0 +1,123 @@
+package quic
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockAddr struct {
+	network, address string
+}
+
+func (a *mockAddr) Network() string { return a.network }
+func (a *mockAddr) String() string  { return a.address }
+
+type packetWithAddr struct {
+	data []byte
+	addr net.Addr
+}
+
+// mockPacketConn is an in-memory net.PacketConn
+type mockPacketConn struct {
+	localAddr   net.Addr
+	dataToRead  chan packetWithAddr
+	dataWritten chan packetWithAddr
+	closed      chan struct{}
+}
+
+var _ net.PacketConn = &mockPacketConn{}
+
+func newMockPacketConn() *mockPacketConn {
+	return &mockPacketConn{
+		localAddr:   &mockAddr{network: "mock", address: "local"},
+		dataToRead:  make(chan packetWithAddr, 10),
+		dataWritten: make(chan packetWithAddr, 10),
+		closed:      make(chan struct{}),
+	}
+}
+
+func (c *mockPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.dataToRead:
+		return copy(b, p.data), p.addr, nil
+	case <-c.closed:
+		return 0, nil, errors.New("use of closed network connection")
+	}
+}
+
+func (c *mockPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	data := make([]byte, len(b))
+	copy(data, b)
+	c.dataWritten <- packetWithAddr{data: data, addr: addr}
+	return len(b), nil
+}
+
+func (c *mockPacketConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *mockPacketConn) LocalAddr() net.Addr                { return c.localAddr }
+func (c *mockPacketConn) SetDeadline(t time.Time) error      { panic("not implemented") }
+func (c *mockPacketConn) SetReadDeadline(t time.Time) error  { panic("not implemented") }
+func (c *mockPacketConn) SetWriteDeadline(t time.Time) error { panic("not implemented") }
+
+var _ = Describe("Connection", func() {
+	var (
+		c     *conn
+		pconn *mockPacketConn
+	)
+
+	BeforeEach(func() {
+		pconn = newMockPacketConn()
+		c = newConn(pconn, &net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1337})
+	})
+
+	It("writes to the current address", func() {
+		err := c.write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		var p packetWithAddr
+		Expect(pconn.dataWritten).To(Receive(&p))
+		Expect(p.data).To(Equal([]byte("foobar")))
+		Expect(p.addr).To(Equal(&net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1337}))
+	})
+
+	It("writes multiple packets to the current address", func() {
+		err := c.writeBatch([][]byte{[]byte("foo"), []byte("bar")})
+		Expect(err).ToNot(HaveOccurred())
+		var p packetWithAddr
+		Expect(pconn.dataWritten).To(Receive(&p))
+		Expect(p.data).To(Equal([]byte("foo")))
+		Expect(p.addr).To(Equal(&net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1337}))
+		Expect(pconn.dataWritten).To(Receive(&p))
+		Expect(p.data).To(Equal([]byte("bar")))
+	})
+
+	It("changes the current address", func() {
+		addr := &mockAddr{network: "mock", address: "remote"}
+		c.setCurrentRemoteAddr(addr)
+		Expect(c.RemoteAddr()).To(Equal(addr))
+		err := c.write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		var p packetWithAddr
+		Expect(pconn.dataWritten).To(Receive(&p))
+		Expect(p.addr).To(Equal(addr))
+	})
+
+	It("gets the local address", func() {
+		Expect(c.LocalAddr()).To(Equal(pconn.localAddr))
+	})
+
+	It("gets the IP of UDP addresses", func() {
+		Expect(c.IP()).To(Equal(net.IPv4(192, 168, 100, 200)))
+	})
+
+	It("doesn't return an IP for other addresses", func() {
+		c.setCurrentRemoteAddr(&mockAddr{network: "mock", address: "remote"})
+		Expect(c.IP()).To(BeNil())
+	})
+})
@@ -14,6 +14,11 @@ type aeadAESGCM struct {
 	myIV      []byte
 	encrypter cipher.AEAD
 	decrypter cipher.AEAD
+
+	// sealNonce and openNonce are reused for every packet
+	// Seal and Open are only called by the session's run loop, so they are never used concurrently.
+	sealNonce []byte
+	openNonce []byte
 }
 
 // NewAEADAESGCM creates a AEAD using AES-GCM with 12 bytes tag size
@@ -46,13 +51,15 @@ func NewAEADAESGCM(otherKey []byte, myKey []byte, otherIV []byte, myIV []byte) (
 		myIV:      myIV,
 		encrypter: encrypter,
 		decrypter: decrypter,
+		sealNonce: make([]byte, nonceSize),
+		openNonce: make([]byte, nonceSize),
 	}, nil
 }
 
 func (aead *aeadAESGCM) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
-	return aead.decrypter.Open(dst, makeNonce(aead.otherIV, packetNumber), src, associatedData)
+	return aead.decrypter.Open(dst, fillNonce(aead.openNonce, aead.otherIV, packetNumber), src, associatedData)
 }
 
 func (aead *aeadAESGCM) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
-	return aead.encrypter.Seal(dst, makeNonce(aead.myIV, packetNumber), src, associatedData)
+	return aead.encrypter.Seal(dst, fillNonce(aead.sealNonce, aead.myIV, packetNumber), src, associatedData)
 }
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
 
 	"github.com/lucas-clemente/quic-go/utils"
 )
@@ -89,6 +90,105 @@ func compressChain(chain [][]byte, pCommonSetHashes, pCachedHashes []byte) ([]by
 	return res.Bytes(), nil
 }
 
+func decompressChain(data []byte) ([][]byte, error) {
+	var chain [][]byte
+	var entries []entry
+	r := bytes.NewReader(data)
+
+	var numCerts int
+	var hasCompressedCerts bool
+	for {
+		entryTypeByte, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if entryTypeByte == 0 {
+			break
+		}
+
+		et := entryType(entryTypeByte)
+		switch et {
+		case entryCompressed:
+			hasCompressedCerts = true
+			entries = append(entries, entry{t: et})
+		case entryCached:
+			return nil, errors.New("unimplemented: cached certificates")
+		case entryCommon:
+			e := entry{t: et}
+			e.h, err = utils.ReadUint64(r)
+			if err != nil {
+				return nil, err
+			}
+			e.i, err = utils.ReadUint32(r)
+			if err != nil {
+				return nil, err
+			}
+			certSet, ok := certSets[e.h]
+			if !ok {
+				return nil, errors.New("unknown certSet")
+			}
+			if e.i >= uint32(len(certSet)) {
+				return nil, errors.New("certificate not found in certSet")
+			}
+			entries = append(entries, e)
+		default:
+			return nil, errors.New("unknown entryType")
+		}
+		numCerts++
+	}
+
+	chain = make([][]byte, numCerts)
+	for i, e := range entries {
+		if e.t == entryCommon {
+			chain[i] = certSets[e.h][e.i]
+		}
+	}
+
+	if hasCompressedCerts {
+		uncompressedLength, err := utils.ReadUint32(r)
+		if err != nil {
+			return nil, err
+		}
+
+		zlibDict := buildZlibDictForEntries(entries, chain)
+		gz, err := zlib.NewReaderDict(r, zlibDict)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		var totalLength uint32
+		var certIndex int
+		for totalLength < uncompressedLength {
+			lenBytes := make([]byte, 4)
+			_, err := io.ReadFull(gz, lenBytes)
+			if err != nil {
+				return nil, err
+			}
+			certLen := binary.LittleEndian.Uint32(lenBytes)
+
+			cert := make([]byte, certLen)
+			n, err := io.ReadFull(gz, cert)
+			if err != nil {
+				return nil, err
+			}
+
+			for entries[certIndex].t != entryCompressed {
+				certIndex++
+				if certIndex >= len(entries) {
+					return nil, errors.New("too many compressed certificates")
+				}
+			}
+			chain[certIndex] = cert
+			certIndex++
+
+			totalLength += 4 + uint32(n)
+		}
+	}
+
+	return chain, nil
+}
+
 func buildEntries(chain [][]byte, chainHashes, cachedHashes, setHashes []uint64) []entry {
 	res := make([]entry, len(chain))
 chainLoop:
@@ -8,6 +8,7 @@ import (
 	"hash/fnv"
 
 	"github.com/lucas-clemente/quic-go-certificates"
+	"github.com/lucas-clemente/quic-go/testdata"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -161,4 +162,69 @@ var _ = Describe("Cert compression", func() {
 		_, err = compressChain(chain, nil, []byte("foo"))
 		Expect(err).To(MatchError("expected a multiple of 8 bytes for CCS / CCRT hashes"))
 	})
+
+	Context("decompression", func() {
+		It("decompresses a single certificate", func() {
+			cert := []byte{0xde, 0xca, 0xfb, 0xad}
+			compressed, err := compressChain([][]byte{cert}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			chain, err := decompressChain(compressed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chain).To(Equal([][]byte{cert}))
+		})
+
+		It("decompresses a certificate chain", func() {
+			cert1 := []byte{0xde, 0xca, 0xfb, 0xad}
+			cert2 := []byte{0xde, 0xad, 0xbe, 0xef}
+			compressed, err := compressChain([][]byte{cert1, cert2}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			chain, err := decompressChain(compressed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chain).To(Equal([][]byte{cert1, cert2}))
+		})
+
+		It("decompresses the chain of the test certificate", func() {
+			cert := testdata.GetCertificate()
+			compressed, err := compressChain(cert.Certificate, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			chain, err := decompressChain(compressed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(chain).To(Equal(cert.Certificate))
+		})
+
+		It("rejects cached certificates", func() {
+			cert := []byte{0xde, 0xca, 0xfb, 0xad}
+			compressed, err := compressChain([][]byte{cert}, nil, byteHash(cert))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = decompressChain(compressed)
+			Expect(err).To(MatchError("unimplemented: cached certificates"))
+		})
+
+		It("rejects unknown entry types", func() {
+			_, err := decompressChain([]byte{0x05, 0x00})
+			Expect(err).To(MatchError("unknown entryType"))
+		})
+
+		It("rejects unknown common certificate sets", func() {
+			data := []byte{0x03}
+			data = append(data, []byte{0xef, 0xbe, 0xad, 0xde, 0, 0, 0, 0}...)
+			data = append(data, []byte{0, 0, 0, 0}...)
+			data = append(data, 0x00)
+			_, err := decompressChain(data)
+			Expect(err).To(MatchError("unknown certSet"))
+		})
+
+		It("errors on empty data", func() {
+			_, err := decompressChain(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("errors if the compressed data is too short", func() {
+			cert := []byte{0xde, 0xca, 0xfb, 0xad}
+			compressed, err := compressChain([][]byte{cert}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = decompressChain(compressed[:8])
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
@@ -0,0 +1,167 @@
+package crypto
+
+import (
+	"crypto/dsa"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cert Manager", func() {
+	var (
+		cm   *certManager
+		cert tls.Certificate
+	)
+
+	BeforeEach(func() {
+		cert = testdata.GetCertificate()
+		cm = NewCertManager(nil).(*certManager)
+	})
+
+	It("errors when given invalid data", func() {
+		err := cm.SetData([]byte("foobar"))
+		Expect(err).To(MatchError(qerr.Error(qerr.InvalidCryptoMessageParameter, "Certificate data invalid")))
+	})
+
+	It("errors when the certificates can't be parsed", func() {
+		compressed, err := compressChain([][]byte{{0xde, 0xca, 0xfb, 0xad}}, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		err = cm.SetData(compressed)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("decompresses a certificate chain", func() {
+		compressed, err := compressChain(cert.Certificate, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		err = cm.SetData(compressed)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cm.chain).To(HaveLen(len(cert.Certificate)))
+		Expect(cm.chain[0].Raw).To(Equal(cert.Certificate[0]))
+	})
+
+	It("gets the common certificate hashes", func() {
+		ccs := cm.GetCommonCertificateHashes()
+		Expect(ccs).ToNot(BeEmpty())
+		Expect(len(ccs) % 8).To(BeZero())
+	})
+
+	Context("getting the leaf cert", func() {
+		It("gets it", func() {
+			compressed, err := compressChain(cert.Certificate, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			err = cm.SetData(compressed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cm.GetLeafCert()).To(Equal(cert.Certificate[0]))
+		})
+
+		It("returns nil if the chain hasn't been set yet", func() {
+			Expect(cm.GetLeafCert()).To(BeNil())
+		})
+	})
+
+	Context("verifying the server proof", func() {
+		BeforeEach(func() {
+			compressed, err := compressChain(cert.Certificate, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			err = cm.SetData(compressed)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("accepts a valid signature", func() {
+			ps, err := NewProofSource(testdata.GetTLSConfig())
+			Expect(err).ToNot(HaveOccurred())
+			proof, err := ps.SignServerProof("", []byte("CHLO"), []byte("SCFG"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cm.VerifyServerProof(proof, []byte("CHLO"), []byte("SCFG"))).To(BeTrue())
+		})
+
+		It("rejects a signature over different data", func() {
+			ps, err := NewProofSource(testdata.GetTLSConfig())
+			Expect(err).ToNot(HaveOccurred())
+			proof, err := ps.SignServerProof("", []byte("CHLO"), []byte("SCFG"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cm.VerifyServerProof(proof, []byte("CHLO"), []byte("foobar"))).To(BeFalse())
+		})
+
+		It("rejects invalid signatures", func() {
+			Expect(cm.VerifyServerProof([]byte("foobar"), []byte("CHLO"), []byte("SCFG"))).To(BeFalse())
+		})
+
+		It("rejects certificates with unsupported key types", func() {
+			cm.chain = []*x509.Certificate{{PublicKeyAlgorithm: x509.DSA, PublicKey: &dsa.PublicKey{}}}
+			Expect(cm.VerifyServerProof([]byte("proof"), []byte("CHLO"), []byte("SCFG"))).To(BeFalse())
+		})
+
+		It("doesn't accept anything before the certificate chain was set", func() {
+			cm.chain = nil
+			Expect(cm.VerifyServerProof([]byte("proof"), []byte("CHLO"), []byte("SCFG"))).To(BeFalse())
+		})
+	})
+
+	Context("verifying the certificate chain", func() {
+		var (
+			leafCert *x509.Certificate
+			now      func() time.Time
+		)
+
+		BeforeEach(func() {
+			var err error
+			leafCert, err = x509.ParseCertificate(cert.Certificate[0])
+			Expect(err).ToNot(HaveOccurred())
+			// make sure the test doesn't fail when the test certificate expires
+			now = func() time.Time { return leafCert.NotBefore.Add(time.Hour) }
+			compressed, err := compressChain(cert.Certificate, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			err = cm.SetData(compressed)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("errors if the chain hasn't been set yet", func() {
+			cm.chain = nil
+			err := cm.Verify("quic.clemente.io")
+			Expect(err).To(MatchError(errNoCertificateChain))
+		})
+
+		It("accepts a certificate signed by a root in the RootCAs", func() {
+			pool := x509.NewCertPool()
+			pool.AddCert(leafCert)
+			cm.config = &tls.Config{RootCAs: pool, Time: now}
+			err := cm.Verify("quic.clemente.io")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects a certificate with a wrong hostname", func() {
+			pool := x509.NewCertPool()
+			pool.AddCert(leafCert)
+			cm.config = &tls.Config{RootCAs: pool, Time: now}
+			err := cm.Verify("google.com")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("uses the ServerName from the tls.Config", func() {
+			pool := x509.NewCertPool()
+			pool.AddCert(leafCert)
+			cm.config = &tls.Config{RootCAs: pool, ServerName: "quic.clemente.io", Time: now}
+			err := cm.Verify("localhost")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects a certificate not signed by a root in the RootCAs", func() {
+			cm.config = &tls.Config{RootCAs: x509.NewCertPool(), Time: now}
+			err := cm.Verify("quic.clemente.io")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("doesn't verify the certificate if InsecureSkipVerify is set", func() {
+			cm.config = &tls.Config{InsecureSkipVerify: true}
+			err := cm.Verify("google.com")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+})
@@ -3,6 +3,8 @@ package crypto
 import (
 	"bytes"
 
+	"github.com/lucas-clemente/quic-go/utils"
+
 	"github.com/lucas-clemente/quic-go-certificates"
 )
 
@@ -13,6 +15,15 @@ var certSets = map[uint64]certSet{
 	certsets.CertSet2Hash: certsets.CertSet2,
 }
 
+// getCommonCertificateHashes gets the hashes of the common certificate sets, in the format sent in the CCS tag
+func getCommonCertificateHashes() []byte {
+	ccs := &bytes.Buffer{}
+	for hash := range certSets {
+		utils.WriteUint64(ccs, hash)
+	}
+	return ccs.Bytes()
+}
+
 // findCertInSet searches for the cert in the set. Negative return value means not found.
 func (s *certSet) findCertInSet(cert []byte) int {
 	for i, c := range *s {
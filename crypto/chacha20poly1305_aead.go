@@ -16,6 +16,11 @@ type aeadChacha20Poly1305 struct {
 	myIV      []byte
 	encrypter cipher.AEAD
 	decrypter cipher.AEAD
+
+	// sealNonce and openNonce are reused for every packet
+	// Seal and Open are only called by the session's run loop, so they are never used concurrently.
+	sealNonce []byte
+	openNonce []byte
 }
 
 // NewAEADChacha20Poly1305 creates a AEAD using chacha20poly1305
@@ -41,13 +46,15 @@ func NewAEADChacha20Poly1305(otherKey []byte, myKey []byte, otherIV []byte, myIV
 		myIV:      myIV,
 		encrypter: encrypter,
 		decrypter: decrypter,
+		sealNonce: make([]byte, nonceSize),
+		openNonce: make([]byte, nonceSize),
 	}, nil
 }
 
 func (aead *aeadChacha20Poly1305) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
-	return aead.decrypter.Open(dst, makeNonce(aead.otherIV, packetNumber), src, associatedData)
+	return aead.decrypter.Open(dst, fillNonce(aead.openNonce, aead.otherIV, packetNumber), src, associatedData)
 }
 
 func (aead *aeadChacha20Poly1305) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
-	return aead.encrypter.Seal(dst, makeNonce(aead.myIV, packetNumber), src, associatedData)
+	return aead.encrypter.Seal(dst, fillNonce(aead.sealNonce, aead.myIV, packetNumber), src, associatedData)
 }
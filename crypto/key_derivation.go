@@ -12,8 +12,8 @@ import (
 )
 
 // DeriveKeysChacha20 derives the client and server keys and creates a matching chacha20poly1305 AEAD instance
-// func DeriveKeysChacha20(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte) (AEAD, error) {
-// 	otherKey, myKey, otherIV, myIV, err := deriveKeys(version, forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 32)
+// func DeriveKeysChacha20(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (AEAD, error) {
+// 	otherKey, myKey, otherIV, myIV, err := deriveKeys(version, forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 32, pers)
 // 	if err != nil {
 // 		return nil, err
 // 	}
@@ -21,15 +21,17 @@ import (
 // }
 
 // DeriveKeysAESGCM derives the client and server keys and creates a matching AES-GCM AEAD instance
-func DeriveKeysAESGCM(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte) (AEAD, error) {
-	otherKey, myKey, otherIV, myIV, err := deriveKeys(version, forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 16)
+func DeriveKeysAESGCM(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (AEAD, error) {
+	otherKey, myKey, otherIV, myIV, err := deriveKeys(version, forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 16, pers)
 	if err != nil {
 		return nil, err
 	}
 	return NewAEADAESGCM(otherKey, myKey, otherIV, myIV)
 }
 
-func deriveKeys(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo, scfg, cert, divNonce []byte, keyLen int) ([]byte, []byte, []byte, []byte, error) {
+// deriveKeys derives the keys and the IVs
+// the keys are returned from the perspective of pers, i.e. the keys used for sending first
+func deriveKeys(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo, scfg, cert, divNonce []byte, keyLen int, pers protocol.Perspective) ([]byte, []byte, []byte, []byte, error) {
 	var info bytes.Buffer
 	if forwardSecure {
 		info.Write([]byte("QUIC forward secure key expansion\x00"))
@@ -43,31 +45,34 @@ func deriveKeys(version protocol.VersionNumber, forwardSecure bool, sharedSecret
 
 	r := hkdf.New(sha256.New, sharedSecret, nonces, info.Bytes())
 
-	otherKey := make([]byte, keyLen)
-	myKey := make([]byte, keyLen)
-	otherIV := make([]byte, 4)
-	myIV := make([]byte, 4)
+	clientKey := make([]byte, keyLen)
+	serverKey := make([]byte, keyLen)
+	clientIV := make([]byte, 4)
+	serverIV := make([]byte, 4)
 
-	if _, err := io.ReadFull(r, otherKey); err != nil {
+	if _, err := io.ReadFull(r, clientKey); err != nil {
 		return nil, nil, nil, nil, err
 	}
-	if _, err := io.ReadFull(r, myKey); err != nil {
+	if _, err := io.ReadFull(r, serverKey); err != nil {
 		return nil, nil, nil, nil, err
 	}
-	if _, err := io.ReadFull(r, otherIV); err != nil {
+	if _, err := io.ReadFull(r, clientIV); err != nil {
 		return nil, nil, nil, nil, err
 	}
-	if _, err := io.ReadFull(r, myIV); err != nil {
+	if _, err := io.ReadFull(r, serverIV); err != nil {
 		return nil, nil, nil, nil, err
 	}
 
 	if !forwardSecure && version >= protocol.Version33 {
-		if err := diversify(myKey, myIV, divNonce); err != nil {
+		if err := diversify(serverKey, serverIV, divNonce); err != nil {
 			return nil, nil, nil, nil, err
 		}
 	}
 
-	return otherKey, myKey, otherIV, myIV, nil
+	if pers == protocol.PerspectiveClient {
+		return serverKey, clientKey, serverIV, clientIV, nil
+	}
+	return clientKey, serverKey, clientIV, serverIV, nil
 }
 
 func diversify(key, iv, divNonce []byte) error {
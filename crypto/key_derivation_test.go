@@ -98,6 +98,7 @@ var _ = Describe("KeyDerivation", func() {
 				[]byte("scfg"),
 				[]byte("cert"),
 				nil,
+				protocol.PerspectiveServer,
 			)
 			Expect(err).ToNot(HaveOccurred())
 			chacha := aead.(*aeadAESGCM)
@@ -117,6 +118,7 @@ var _ = Describe("KeyDerivation", func() {
 				[]byte("scfg"),
 				[]byte("cert"),
 				nil,
+				protocol.PerspectiveServer,
 			)
 			Expect(err).ToNot(HaveOccurred())
 			chacha := aead.(*aeadAESGCM)
@@ -136,6 +138,7 @@ var _ = Describe("KeyDerivation", func() {
 				[]byte("scfg"),
 				[]byte("cert"),
 				[]byte("divnonce"),
+				protocol.PerspectiveServer,
 			)
 			Expect(err).ToNot(HaveOccurred())
 			chacha := aead.(*aeadAESGCM)
@@ -155,6 +158,7 @@ var _ = Describe("KeyDerivation", func() {
 				[]byte("scfg"),
 				[]byte("cert"),
 				[]byte("divnonce"),
+				protocol.PerspectiveServer,
 			)
 			Expect(err).ToNot(HaveOccurred())
 			chacha := aead.(*aeadAESGCM)
@@ -162,5 +166,25 @@ var _ = Describe("KeyDerivation", func() {
 			Expect(chacha.myIV).To(Equal([]byte{0x1c, 0xec, 0xac, 0x9b}))
 			Expect(chacha.otherIV).To(Equal([]byte{0x64, 0xef, 0x3c, 0x9}))
 		})
+
+		It("swaps the keys for the client", func() {
+			aead, err := DeriveKeysAESGCM(
+				protocol.Version33,
+				false,
+				[]byte("0123456789012345678901"),
+				[]byte("nonce"),
+				protocol.ConnectionID(42),
+				[]byte("chlo"),
+				[]byte("scfg"),
+				[]byte("cert"),
+				[]byte("divnonce"),
+				protocol.PerspectiveClient,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			chacha := aead.(*aeadAESGCM)
+			// If the IVs match, the keys will match too, since the keys are read earlier
+			Expect(chacha.myIV).To(Equal([]byte{0x64, 0xef, 0x3c, 0x9}))
+			Expect(chacha.otherIV).To(Equal([]byte{0x1c, 0xec, 0xac, 0x9b}))
+		})
 	})
 })
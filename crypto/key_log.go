@@ -0,0 +1,50 @@
+package crypto
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// keyLogMutex serializes the writes to all key log writers, since the same writer is usually shared by all connections
+var keyLogMutex sync.Mutex
+
+// NewKeyLoggingDeriveKeysAESGCM returns a key derivation function that works like DeriveKeysAESGCM, and additionally writes the derived keys and IVs to w.
+// Every key derivation writes one line, containing the label QUIC_INITIAL_KEYS or QUIC_FORWARD_SECURE_KEYS, the connection ID,
+// and the client key, client IV, server key and server IV, all hex-encoded and separated by spaces.
+// The initial keys of the server are already diversified.
+// The keys allow decrypting all packets of a connection, so this must only be used for debugging.
+// Errors writing to w are logged to the logger, they don't fail the key derivation.
+func NewKeyLoggingDeriveKeysAESGCM(w io.Writer, logger utils.Logger) func(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (AEAD, error) {
+	return func(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (AEAD, error) {
+		otherKey, myKey, otherIV, myIV, err := deriveKeys(version, forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce, 16, pers)
+		if err != nil {
+			return nil, err
+		}
+		if pers == protocol.PerspectiveClient {
+			err = writeKeyLog(w, connID, forwardSecure, myKey, myIV, otherKey, otherIV)
+		} else {
+			err = writeKeyLog(w, connID, forwardSecure, otherKey, otherIV, myKey, myIV)
+		}
+		if err != nil {
+			logger.Errorf("Error writing key log: %s", err.Error())
+		}
+		return NewAEADAESGCM(otherKey, myKey, otherIV, myIV)
+	}
+}
+
+func writeKeyLog(w io.Writer, connID protocol.ConnectionID, forwardSecure bool, clientKey, clientIV, serverKey, serverIV []byte) error {
+	label := "QUIC_INITIAL_KEYS"
+	if forwardSecure {
+		label = "QUIC_FORWARD_SECURE_KEYS"
+	}
+	line := fmt.Sprintf("%s %016x %x %x %x %x\n", label, uint64(connID), clientKey, clientIV, serverKey, serverIV)
+
+	keyLogMutex.Lock()
+	defer keyLogMutex.Unlock()
+	_, err := io.WriteString(w, line)
+	return err
+}
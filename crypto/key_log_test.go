@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type errorWriter struct{}
+
+func (errorWriter) Write([]byte) (int, error) { return 0, errors.New("write error") }
+
+var _ = Describe("Key Log", func() {
+	deriveKeys := func(w *bytes.Buffer, forwardSecure bool, pers protocol.Perspective) AEAD {
+		aead, err := NewKeyLoggingDeriveKeysAESGCM(w, utils.DefaultLogger)(
+			protocol.Version33,
+			forwardSecure,
+			[]byte("0123456789012345678901"),
+			[]byte("nonce"),
+			protocol.ConnectionID(42),
+			[]byte("chlo"),
+			[]byte("scfg"),
+			[]byte("cert"),
+			[]byte("divnonce"),
+			pers,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		return aead
+	}
+
+	It("derives the same keys as DeriveKeysAESGCM", func() {
+		aead := deriveKeys(&bytes.Buffer{}, false, protocol.PerspectiveServer)
+		Expect(aead.(*aeadAESGCM).myIV).To(Equal([]byte{0x1c, 0xec, 0xac, 0x9b}))
+		Expect(aead.(*aeadAESGCM).otherIV).To(Equal([]byte{0x64, 0xef, 0x3c, 0x9}))
+	})
+
+	It("logs the initial keys", func() {
+		b := &bytes.Buffer{}
+		deriveKeys(b, false, protocol.PerspectiveServer)
+		Expect(b.String()).To(HaveSuffix("\n"))
+		fields := strings.Fields(b.String())
+		Expect(fields).To(HaveLen(6))
+		Expect(fields[0]).To(Equal("QUIC_INITIAL_KEYS"))
+		Expect(fields[1]).To(Equal("000000000000002a"))
+		Expect(fields[2]).To(HaveLen(32))
+		Expect(fields[3]).To(Equal("64ef3c09"))
+		Expect(fields[4]).To(HaveLen(32))
+		Expect(fields[5]).To(Equal("1cecac9b"))
+	})
+
+	It("logs the forward-secure keys", func() {
+		b := &bytes.Buffer{}
+		deriveKeys(b, true, protocol.PerspectiveServer)
+		Expect(b.String()).To(HavePrefix("QUIC_FORWARD_SECURE_KEYS 000000000000002a "))
+	})
+
+	It("logs the same line for the client and the server", func() {
+		serverLog := &bytes.Buffer{}
+		deriveKeys(serverLog, false, protocol.PerspectiveServer)
+		clientLog := &bytes.Buffer{}
+		deriveKeys(clientLog, false, protocol.PerspectiveClient)
+		Expect(clientLog.String()).To(Equal(serverLog.String()))
+	})
+
+	It("logs errors when writing the key log fails", func() {
+		logOutput := &bytes.Buffer{}
+		aead, err := NewKeyLoggingDeriveKeysAESGCM(errorWriter{}, utils.NewLogger(logOutput, utils.LogLevelError))(
+			protocol.Version33,
+			true,
+			[]byte("0123456789012345678901"),
+			[]byte("nonce"),
+			protocol.ConnectionID(42),
+			[]byte("chlo"),
+			[]byte("scfg"),
+			[]byte("cert"),
+			nil,
+			protocol.PerspectiveServer,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aead).ToNot(BeNil())
+		Expect(logOutput.String()).To(ContainSubstring("write error"))
+	})
+})
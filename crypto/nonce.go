@@ -6,9 +6,13 @@ import (
 	"github.com/lucas-clemente/quic-go/protocol"
 )
 
-func makeNonce(iv []byte, packetNumber protocol.PacketNumber) []byte {
-	res := make([]byte, 12)
-	copy(res[0:4], iv)
-	binary.LittleEndian.PutUint64(res[4:12], uint64(packetNumber))
-	return res
+// nonceSize is the size of the nonces used by the AEADs
+const nonceSize = 12
+
+// fillNonce writes the nonce for a packet to nonce, which has to be nonceSize bytes long
+// The AEADs preallocate their nonces, so that no allocation is needed per packet.
+func fillNonce(nonce []byte, iv []byte, packetNumber protocol.PacketNumber) []byte {
+	copy(nonce[0:4], iv)
+	binary.LittleEndian.PutUint64(nonce[4:12], uint64(packetNumber))
+	return nonce
 }
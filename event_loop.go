@@ -0,0 +1,186 @@
+package quic
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// An eventLoop multiplexes sessions onto a fixed number of workers, sharded by connection ID
+// This avoids running a go routine, a timer and a packet queue for every session.
+type eventLoop struct {
+	workers   []*eventLoopWorker
+	closeOnce sync.Once
+}
+
+func newEventLoop(numWorkers int) *eventLoop {
+	l := &eventLoop{workers: make([]*eventLoopWorker, numWorkers)}
+	for i := range l.workers {
+		l.workers[i] = newEventLoopWorker()
+		go l.workers[i].run()
+	}
+	return l
+}
+
+// worker returns the worker responsible for a connection ID
+func (l *eventLoop) worker(id protocol.ConnectionID) *eventLoopWorker {
+	return l.workers[uint64(id)%uint64(len(l.workers))]
+}
+
+// close stops all workers, after they handled the events that are already queued
+func (l *eventLoop) close() {
+	l.closeOnce.Do(func() {
+		for _, w := range l.workers {
+			w.close()
+		}
+	})
+}
+
+type sessionPacket struct {
+	session *session
+	packet  receivedPacket
+}
+
+// An eventLoopWorker handles the events of all sessions of one shard
+// It maintains a single timer wheel for the idle, ack-delay and retransmission deadlines of its sessions.
+type eventLoopWorker struct {
+	mutex sync.Mutex
+	// packets are the packets received for the sessions of this worker, they are protected by the mutex
+	packets []sessionPacket
+	// scheduled are the sessions that need to be handled, e.g. because they have data to send or were closed, they are protected by the mutex
+	// a session is only added once, until the worker handled it, see session.loopScheduled
+	scheduled []*session
+	// spare slices are swapped with packets and scheduled when handling the queued events, to avoid allocations
+	sparePackets   []sessionPacket
+	spareScheduled []*session
+
+	// timers is only accessed from the worker's go routine
+	timers *timerWheel
+
+	wakeup    chan struct{}
+	closeChan chan struct{}
+	done      chan struct{}
+}
+
+func newEventLoopWorker() *eventLoopWorker {
+	return &eventLoopWorker{
+		timers:    newTimerWheel(time.Now()),
+		wakeup:    make(chan struct{}, 1),
+		closeChan: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// queuePacket queues a packet for a session
+// Packets are discarded if more than protocol.MaxEventLoopUnprocessedPackets are queued in total,
+// or more than config.MaxSessionUnprocessedPackets for this session, in that case false is returned.
+// The limit per session prevents a single connection from using up the whole queue of the worker.
+func (w *eventLoopWorker) queuePacket(s *session, p receivedPacket) bool {
+	w.mutex.Lock()
+	if len(w.packets) >= protocol.MaxEventLoopUnprocessedPackets || s.loopQueuedPackets >= s.config.MaxSessionUnprocessedPackets {
+		w.mutex.Unlock()
+		return false
+	}
+	s.loopQueuedPackets++
+	w.packets = append(w.packets, sessionPacket{session: s, packet: p})
+	w.mutex.Unlock()
+	w.signal()
+	return true
+}
+
+// schedule makes the worker handle a session, even if no packet was received for it
+func (w *eventLoopWorker) schedule(s *session) {
+	w.mutex.Lock()
+	if !s.loopScheduled {
+		s.loopScheduled = true
+		w.scheduled = append(w.scheduled, s)
+	}
+	w.mutex.Unlock()
+	w.signal()
+}
+
+func (w *eventLoopWorker) signal() {
+	select {
+	case w.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (w *eventLoopWorker) run() {
+	defer close(w.done)
+
+	// The timer is armed for the next deadline of the timer wheel, and stopped while the wheel is empty.
+	// timerChan is nil while the timer is not armed.
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	var timerChan <-chan time.Time
+	var timerDeadline time.Time
+	for {
+		if deadline, ok := w.timers.next(); !ok {
+			if timerChan != nil {
+				timer.Stop()
+				timerChan = nil
+			}
+		} else if timerChan == nil || !deadline.Equal(timerDeadline) {
+			if !timer.Stop() {
+				// drain the channel, in case the timer fired after it was disarmed
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(deadline.Sub(time.Now()))
+			timerChan = timer.C
+			timerDeadline = deadline
+		}
+
+		select {
+		case <-w.closeChan:
+			w.handleQueuedEvents()
+			return
+		case <-w.wakeup:
+			w.handleQueuedEvents()
+		case now := <-timerChan:
+			timerChan = nil
+			for _, s := range w.timers.advance(now) {
+				s.handleEventLoopEvent(nil)
+			}
+		}
+	}
+}
+
+func (w *eventLoopWorker) handleQueuedEvents() {
+	w.mutex.Lock()
+	packets := w.packets
+	w.packets = w.sparePackets[:0]
+	scheduled := w.scheduled
+	w.scheduled = w.spareScheduled[:0]
+	for _, s := range scheduled {
+		s.loopScheduled = false
+	}
+	for i := range packets {
+		packets[i].session.loopQueuedPackets = 0
+	}
+	w.mutex.Unlock()
+
+	for i := range packets {
+		packets[i].session.handleEventLoopEvent(&packets[i].packet)
+		packets[i] = sessionPacket{}
+	}
+	for i, s := range scheduled {
+		s.handleEventLoopEvent(nil)
+		scheduled[i] = nil
+	}
+	// handling the events may have queued new events, so the slices can only be reused now
+	w.mutex.Lock()
+	w.sparePackets = packets[:0]
+	w.spareScheduled = scheduled[:0]
+	w.mutex.Unlock()
+}
+
+func (w *eventLoopWorker) close() {
+	close(w.closeChan)
+	<-w.done
+}
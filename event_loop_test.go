@@ -0,0 +1,79 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Event loop", func() {
+	It("shards sessions by connection ID", func() {
+		loop := newEventLoop(4)
+		defer loop.close()
+		Expect(loop.worker(1)).To(Equal(loop.workers[1]))
+		Expect(loop.worker(6)).To(Equal(loop.workers[2]))
+		Expect(loop.worker(6)).To(Equal(loop.worker(6)))
+	})
+
+	It("can be closed multiple times", func() {
+		loop := newEventLoop(2)
+		loop.close()
+		loop.close()
+	})
+
+	Context("worker", func() {
+		var w *eventLoopWorker
+
+		BeforeEach(func() {
+			w = newEventLoopWorker()
+		})
+
+		It("schedules a session only once", func() {
+			s := &session{}
+			w.schedule(s)
+			w.schedule(s)
+			Expect(w.scheduled).To(Equal([]*session{s}))
+			Expect(s.loopScheduled).To(BeTrue())
+		})
+
+		It("discards packets when too many are queued", func() {
+			config := populateConfig(&Config{MaxSessionUnprocessedPackets: 100})
+			for i := 0; i < protocol.MaxEventLoopUnprocessedPackets/100; i++ {
+				s := &session{config: config}
+				for j := 0; j < 100; j++ {
+					Expect(w.queuePacket(s, receivedPacket{})).To(BeTrue())
+				}
+			}
+			s := &session{config: config}
+			for i := 0; i < protocol.MaxEventLoopUnprocessedPackets%100; i++ {
+				Expect(w.queuePacket(s, receivedPacket{})).To(BeTrue())
+			}
+			Expect(w.queuePacket(s, receivedPacket{})).To(BeFalse())
+			Expect(w.packets).To(HaveLen(protocol.MaxEventLoopUnprocessedPackets))
+		})
+
+		It("discards packets when too many are queued for one session", func() {
+			s := &session{config: populateConfig(&Config{MaxSessionUnprocessedPackets: 10})}
+			for i := 0; i < 10; i++ {
+				Expect(w.queuePacket(s, receivedPacket{})).To(BeTrue())
+			}
+			Expect(w.queuePacket(s, receivedPacket{})).To(BeFalse())
+			Expect(w.queuePacket(&session{config: s.config}, receivedPacket{})).To(BeTrue())
+			Expect(w.packets).To(HaveLen(11))
+		})
+
+		It("handles queued events", func() {
+			s := &session{config: populateConfig(&Config{}), runLoopDone: true}
+			buffer := getPacketBuffer()
+			w.queuePacket(s, receivedPacket{buffer: buffer})
+			w.schedule(s)
+			w.handleQueuedEvents()
+			Expect(buffer.refCount).To(BeZero())
+			Expect(w.packets).To(BeEmpty())
+			Expect(w.scheduled).To(BeEmpty())
+			Expect(s.loopScheduled).To(BeFalse())
+			Expect(s.loopQueuedPackets).To(BeZero())
+		})
+	})
+})
@@ -87,6 +87,53 @@ func (f *flowControlManager) UpdateHighestReceived(streamID protocol.StreamID, b
 	return nil
 }
 
+// ResetStream should be called when a RST_STREAM frame is received
+// byteOffset is the final offset of the stream. All data up to this offset counts towards connection level flow control,
+// and since it will never be read by the application, it is treated as read
+// streamID must not be 0 here
+func (f *flowControlManager) ResetStream(streamID protocol.StreamID, byteOffset protocol.ByteCount) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	streamFlowController, err := f.getFlowController(streamID)
+	if err != nil {
+		return err
+	}
+	increment := streamFlowController.UpdateHighestReceived(byteOffset)
+	if streamFlowController.CheckFlowControlViolation() {
+		return ErrStreamFlowControlViolation
+	}
+
+	if f.contributesToConnectionFlowControl[streamID] {
+		connectionFlowController := f.streamFlowController[0]
+		connectionFlowController.IncrementHighestReceived(increment)
+		if connectionFlowController.CheckFlowControlViolation() {
+			return ErrConnectionFlowControlViolation
+		}
+		if byteOffset > streamFlowController.bytesRead {
+			connectionFlowController.AddBytesRead(byteOffset - streamFlowController.bytesRead)
+		}
+		// data that is still read from this stream was already accounted for
+		f.contributesToConnectionFlowControl[streamID] = false
+	}
+	return nil
+}
+
+// DiscardConnectionData should be called for data received on streams that don't have a flow controller, e.g. because they were rejected
+// The data counts towards connection level flow control, and since it will never be read by the application, it is treated as read
+func (f *flowControlManager) DiscardConnectionData(n protocol.ByteCount) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	connectionFlowController := f.streamFlowController[0]
+	connectionFlowController.IncrementHighestReceived(n)
+	if connectionFlowController.CheckFlowControlViolation() {
+		return ErrConnectionFlowControlViolation
+	}
+	connectionFlowController.AddBytesRead(n)
+	return nil
+}
+
 // streamID must not be 0 here
 func (f *flowControlManager) AddBytesRead(streamID protocol.StreamID, n protocol.ByteCount) error {
 	f.mutex.Lock()
@@ -188,6 +235,18 @@ func (f *flowControlManager) UpdateWindow(streamID protocol.StreamID, offset pro
 	return streamFlowController.UpdateSendWindow(offset), nil
 }
 
+// streamID may be 0 here
+func (f *flowControlManager) GetStats(streamID protocol.StreamID) (Stats, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	streamFlowController, err := f.getFlowController(streamID)
+	if err != nil {
+		return Stats{}, err
+	}
+	return streamFlowController.Stats(), nil
+}
+
 func (f *flowControlManager) getFlowController(streamID protocol.StreamID) (*flowController, error) {
 	streamFlowController, ok := f.streamFlowController[streamID]
 	if !ok {
@@ -89,6 +89,74 @@ var _ = Describe("Flow Control Manager", func() {
 			})
 		})
 
+		Context("resetting streams", func() {
+			It("updates the highest received offset to the final offset", func() {
+				err := fcm.UpdateHighestReceived(4, 0x50)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.ResetStream(4, 0x80)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fcm.streamFlowController[4].highestReceived).To(Equal(protocol.ByteCount(0x80)))
+				Expect(fcm.streamFlowController[0].highestReceived).To(Equal(protocol.ByteCount(0x80)))
+			})
+
+			It("credits the data that wasn't read to the connection level flow controller", func() {
+				err := fcm.UpdateHighestReceived(4, 0x50)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.AddBytesRead(4, 0x20)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.ResetStream(4, 0x80)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fcm.streamFlowController[0].bytesRead).To(Equal(protocol.ByteCount(0x80)))
+			})
+
+			It("doesn't count data read after the reset twice", func() {
+				err := fcm.UpdateHighestReceived(4, 0x50)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.ResetStream(4, 0x50)
+				Expect(err).ToNot(HaveOccurred())
+				err = fcm.AddBytesRead(4, 0x50)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fcm.streamFlowController[0].bytesRead).To(Equal(protocol.ByteCount(0x50)))
+			})
+
+			It("doesn't update the connection level flow controller if the stream does not contribute", func() {
+				err := fcm.ResetStream(1, 0x80)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fcm.streamFlowController[0].highestReceived).To(BeZero())
+				Expect(fcm.streamFlowController[0].bytesRead).To(BeZero())
+			})
+
+			It("errors when the final offset violates stream level flow control", func() {
+				err := fcm.ResetStream(4, 0x101)
+				Expect(err).To(MatchError(ErrStreamFlowControlViolation))
+			})
+
+			It("errors when the final offset violates connection level flow control", func() {
+				fcm.streamFlowController[4].receiveFlowControlWindow = 0x300
+				err := fcm.ResetStream(4, 0x201)
+				Expect(err).To(MatchError(ErrConnectionFlowControlViolation))
+			})
+
+			It("returns an error when called with an unknown stream", func() {
+				err := fcm.ResetStream(1337, 0x1337)
+				Expect(err).To(MatchError(errMapAccess))
+			})
+		})
+
+		Context("discarding data", func() {
+			It("credits the data to the connection level flow controller", func() {
+				err := fcm.DiscardConnectionData(0x80)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fcm.streamFlowController[0].highestReceived).To(Equal(protocol.ByteCount(0x80)))
+				Expect(fcm.streamFlowController[0].bytesRead).To(Equal(protocol.ByteCount(0x80)))
+			})
+
+			It("errors when the data violates connection level flow control", func() {
+				err := fcm.DiscardConnectionData(0x201)
+				Expect(err).To(MatchError(ErrConnectionFlowControlViolation))
+			})
+		})
+
 		Context("window updates", func() {
 			It("gets stream level window updates", func() {
 				err := fcm.UpdateHighestReceived(4, 0x100)
@@ -193,4 +261,42 @@ var _ = Describe("Flow Control Manager", func() {
 			})
 		})
 	})
+
+	Context("stats", func() {
+		It("gets the stats of a stream", func() {
+			fcm.NewStream(5, true)
+			_, err := fcm.UpdateWindow(5, 0x1000)
+			Expect(err).ToNot(HaveOccurred())
+			err = fcm.AddBytesSent(5, 0x400)
+			Expect(err).ToNot(HaveOccurred())
+			err = fcm.UpdateHighestReceived(5, 0x80)
+			Expect(err).ToNot(HaveOccurred())
+			err = fcm.AddBytesRead(5, 0x20)
+			Expect(err).ToNot(HaveOccurred())
+			stats, err := fcm.GetStats(5)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats).To(Equal(Stats{
+				BytesSent:         0x400,
+				SendWindowSize:    0x1000 - 0x400,
+				BytesReceived:     0x80,
+				BytesRead:         0x20,
+				ReceiveWindowSize: 0x100 - 0x80,
+			}))
+		})
+
+		It("gets the stats of the connection", func() {
+			fcm.NewStream(5, true)
+			err := fcm.UpdateHighestReceived(5, 0x80)
+			Expect(err).ToNot(HaveOccurred())
+			stats, err := fcm.GetStats(0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.BytesReceived).To(Equal(protocol.ByteCount(0x80)))
+			Expect(stats.ReceiveWindowSize).To(Equal(protocol.ByteCount(0x200 - 0x80)))
+		})
+
+		It("returns an error when called with an unknown stream", func() {
+			_, err := fcm.GetStats(1337)
+			Expect(err).To(MatchError(errMapAccess))
+		})
+	})
 })
@@ -108,6 +108,20 @@ func (c *flowController) MaybeTriggerWindowUpdate() (bool, protocol.ByteCount) {
 	return false, 0
 }
 
+func (c *flowController) Stats() Stats {
+	var receiveWindowSize protocol.ByteCount
+	if c.receiveFlowControlWindow > c.highestReceived {
+		receiveWindowSize = c.receiveFlowControlWindow - c.highestReceived
+	}
+	return Stats{
+		BytesSent:         c.bytesSent,
+		SendWindowSize:    c.SendWindowSize(),
+		BytesReceived:     c.highestReceived,
+		BytesRead:         c.bytesRead,
+		ReceiveWindowSize: receiveWindowSize,
+	}
+}
+
 func (c *flowController) CheckFlowControlViolation() bool {
 	if c.highestReceived > c.receiveFlowControlWindow {
 		return true
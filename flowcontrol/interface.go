@@ -11,9 +11,23 @@ type FlowControlManager interface {
 	AddBytesRead(streamID protocol.StreamID, n protocol.ByteCount) error
 	MaybeTriggerStreamWindowUpdate(streamID protocol.StreamID) (bool, protocol.ByteCount, error)
 	MaybeTriggerConnectionWindowUpdate() (bool, protocol.ByteCount)
+	ResetStream(streamID protocol.StreamID, byteOffset protocol.ByteCount) error
+	DiscardConnectionData(n protocol.ByteCount) error
 	// methods needed for sending data
 	AddBytesSent(streamID protocol.StreamID, n protocol.ByteCount) error
 	SendWindowSize(streamID protocol.StreamID) (protocol.ByteCount, error)
 	RemainingConnectionWindowSize() protocol.ByteCount
 	UpdateWindow(streamID protocol.StreamID, offset protocol.ByteCount) (bool, error)
+	// GetStats returns the flow control state of a stream, or of the connection for StreamID 0
+	GetStats(streamID protocol.StreamID) (Stats, error)
+}
+
+// Stats is a snapshot of the flow control state of a stream or the connection
+type Stats struct {
+	BytesSent      protocol.ByteCount
+	SendWindowSize protocol.ByteCount
+	// BytesReceived is the highest byte offset received
+	BytesReceived     protocol.ByteCount
+	BytesRead         protocol.ByteCount
+	ReceiveWindowSize protocol.ByteCount
 }
@@ -198,8 +198,13 @@ func ParseAckFrame(r *bytes.Reader, version protocol.VersionNumber) (*AckFrame,
 
 // Write writes an ACK frame.
 func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends an ACK frame to b
+func (f *AckFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
 	if f.AckFrameLegacy != nil {
-		return f.AckFrameLegacy.Write(b, version)
+		return f.AckFrameLegacy.AppendTo(b, version)
 	}
 
 	largestAckedLen := protocol.GetPacketNumberLength(f.LargestAcked)
@@ -219,21 +224,21 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 		typeByte |= 0x20
 	}
 
-	b.WriteByte(typeByte)
+	b = append(b, typeByte)
 
 	switch largestAckedLen {
 	case protocol.PacketNumberLen1:
-		b.WriteByte(uint8(f.LargestAcked))
+		b = append(b, uint8(f.LargestAcked))
 	case protocol.PacketNumberLen2:
-		utils.WriteUint16(b, uint16(f.LargestAcked))
+		b = utils.AppendUint16(b, uint16(f.LargestAcked))
 	case protocol.PacketNumberLen4:
-		utils.WriteUint32(b, uint32(f.LargestAcked))
+		b = utils.AppendUint32(b, uint32(f.LargestAcked))
 	case protocol.PacketNumberLen6:
-		utils.WriteUint48(b, uint64(f.LargestAcked))
+		b = utils.AppendUint48(b, uint64(f.LargestAcked))
 	}
 
 	f.DelayTime = time.Now().Sub(f.PacketReceivedTime)
-	utils.WriteUfloat16(b, uint64(f.DelayTime/time.Microsecond))
+	b = utils.AppendUfloat16(b, uint64(f.DelayTime/time.Microsecond))
 
 	var numRanges uint64
 	var numRangesWritten uint64
@@ -242,7 +247,7 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 		if numRanges > 0xFF {
 			panic("AckFrame: Too many ACK ranges")
 		}
-		b.WriteByte(uint8(numRanges - 1))
+		b = append(b, uint8(numRanges-1))
 	}
 
 	var firstAckBlockLength protocol.PacketNumber
@@ -250,10 +255,10 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 		firstAckBlockLength = f.LargestAcked - f.LowestAcked + 1
 	} else {
 		if f.LargestAcked != f.AckRanges[0].LastPacketNumber {
-			return errInconsistentAckLargestAcked
+			return b, errInconsistentAckLargestAcked
 		}
 		if f.LowestAcked != f.AckRanges[len(f.AckRanges)-1].FirstPacketNumber {
-			return errInconsistentAckLowestAcked
+			return b, errInconsistentAckLowestAcked
 		}
 		firstAckBlockLength = f.LargestAcked - f.AckRanges[0].FirstPacketNumber + 1
 		numRangesWritten++
@@ -261,13 +266,13 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 
 	switch missingSequenceNumberDeltaLen {
 	case protocol.PacketNumberLen1:
-		b.WriteByte(uint8(firstAckBlockLength))
+		b = append(b, uint8(firstAckBlockLength))
 	case protocol.PacketNumberLen2:
-		utils.WriteUint16(b, uint16(firstAckBlockLength))
+		b = utils.AppendUint16(b, uint16(firstAckBlockLength))
 	case protocol.PacketNumberLen4:
-		utils.WriteUint32(b, uint32(firstAckBlockLength))
+		b = utils.AppendUint32(b, uint32(firstAckBlockLength))
 	case protocol.PacketNumberLen6:
-		utils.WriteUint48(b, uint64(firstAckBlockLength))
+		b = utils.AppendUint48(b, uint64(firstAckBlockLength))
 	}
 
 	for i, ackRange := range f.AckRanges {
@@ -284,16 +289,16 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 		}
 
 		if num == 1 {
-			b.WriteByte(uint8(gap))
+			b = append(b, uint8(gap))
 			switch missingSequenceNumberDeltaLen {
 			case protocol.PacketNumberLen1:
-				b.WriteByte(uint8(length))
+				b = append(b, uint8(length))
 			case protocol.PacketNumberLen2:
-				utils.WriteUint16(b, uint16(length))
+				b = utils.AppendUint16(b, uint16(length))
 			case protocol.PacketNumberLen4:
-				utils.WriteUint32(b, uint32(length))
+				b = utils.AppendUint32(b, uint32(length))
 			case protocol.PacketNumberLen6:
-				utils.WriteUint48(b, uint64(length))
+				b = utils.AppendUint48(b, uint64(length))
 			}
 			numRangesWritten++
 		} else {
@@ -309,16 +314,16 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 					gapWritten = 0xFF
 				}
 
-				b.WriteByte(uint8(gapWritten))
+				b = append(b, uint8(gapWritten))
 				switch missingSequenceNumberDeltaLen {
 				case protocol.PacketNumberLen1:
-					b.WriteByte(uint8(lengthWritten))
+					b = append(b, uint8(lengthWritten))
 				case protocol.PacketNumberLen2:
-					utils.WriteUint16(b, uint16(lengthWritten))
+					b = utils.AppendUint16(b, uint16(lengthWritten))
 				case protocol.PacketNumberLen4:
-					utils.WriteUint32(b, uint32(lengthWritten))
+					b = utils.AppendUint32(b, uint32(lengthWritten))
 				case protocol.PacketNumberLen6:
-					utils.WriteUint48(b, uint64(lengthWritten))
+					b = utils.AppendUint48(b, uint64(lengthWritten))
 				}
 
 				numRangesWritten++
@@ -332,12 +337,12 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 	}
 
 	if numRanges != numRangesWritten {
-		return errors.New("BUG: Inconsistent number of ACK ranges written")
+		return b, errors.New("BUG: Inconsistent number of ACK ranges written")
 	}
 
-	b.WriteByte(0) // no timestamps
+	b = append(b, 0) // no timestamps
 
-	return nil
+	return b, nil
 }
 
 // MinLength of a written frame
@@ -27,6 +27,11 @@ type AckFrameLegacy struct {
 
 // Write writes an ACK frame.
 func (f *AckFrameLegacy) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends an ACK frame to b
+func (f *AckFrameLegacy) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
 	largestObservedLen := protocol.GetPacketNumberLength(f.LargestObserved)
 
 	typeByte := uint8(0x40)
@@ -41,38 +46,38 @@ func (f *AckFrameLegacy) Write(b *bytes.Buffer, version protocol.VersionNumber)
 
 	f.DelayTime = time.Now().Sub(f.PacketReceivedTime)
 
-	b.WriteByte(typeByte)
-	b.WriteByte(f.Entropy)
+	b = append(b, typeByte)
+	b = append(b, f.Entropy)
 
 	switch largestObservedLen {
 	case protocol.PacketNumberLen1:
-		b.WriteByte(uint8(f.LargestObserved))
+		b = append(b, uint8(f.LargestObserved))
 	case protocol.PacketNumberLen2:
-		utils.WriteUint16(b, uint16(f.LargestObserved))
+		b = utils.AppendUint16(b, uint16(f.LargestObserved))
 	case protocol.PacketNumberLen4:
-		utils.WriteUint32(b, uint32(f.LargestObserved))
+		b = utils.AppendUint32(b, uint32(f.LargestObserved))
 	case protocol.PacketNumberLen6:
-		utils.WriteUint48(b, uint64(f.LargestObserved))
+		b = utils.AppendUint48(b, uint64(f.LargestObserved))
 	}
 
-	utils.WriteUfloat16(b, uint64(f.DelayTime/time.Microsecond))
-	b.WriteByte(0x01)       // Just one timestamp
-	b.WriteByte(0x00)       // Delta Largest observed
-	utils.WriteUint32(b, 0) // First timestamp
+	b = utils.AppendUfloat16(b, uint64(f.DelayTime/time.Microsecond))
+	b = append(b, 0x01)          // Just one timestamp
+	b = append(b, 0x00)          // Delta Largest observed
+	b = utils.AppendUint32(b, 0) // First timestamp
 
 	if f.HasNACK() {
 		numRanges := f.numWrittenNackRanges()
 		if numRanges > 0xFF {
-			return errTooManyNackRanges
+			return b, errTooManyNackRanges
 		}
-		b.WriteByte(uint8(numRanges))
+		b = append(b, uint8(numRanges))
 
 		rangeCounter := uint8(0)
 		for i, nackRange := range f.NackRanges {
 			var missingPacketSequenceNumberDelta uint64
 			if i == 0 {
 				if nackRange.LastPacketNumber > f.LargestObserved {
-					return errors.New("AckFrame: Invalid NACK ranges")
+					return b, errors.New("AckFrame: Invalid NACK ranges")
 				}
 				missingPacketSequenceNumberDelta = uint64(f.LargestObserved) - uint64(nackRange.LastPacketNumber)
 			} else {
@@ -81,25 +86,25 @@ func (f *AckFrameLegacy) Write(b *bytes.Buffer, version protocol.VersionNumber)
 			}
 			rangeLength := nackRange.Len()
 
-			utils.WriteUint48(b, missingPacketSequenceNumberDelta)
-			b.WriteByte(uint8(rangeLength % 0x100))
+			b = utils.AppendUint48(b, missingPacketSequenceNumberDelta)
+			b = append(b, uint8(rangeLength%0x100))
 			rangeCounter++
 
 			rangeLength = rangeLength - (rangeLength % 0x100)
 			for rangeLength > 0 {
 				rangeCounter++
-				utils.WriteUint48(b, 0)
-				b.WriteByte(uint8(0xFF))
+				b = utils.AppendUint48(b, 0)
+				b = append(b, uint8(0xFF))
 				rangeLength -= 0x100
 			}
 		}
 
 		if rangeCounter != uint8(numRanges) {
-			return errors.New("BUG: Inconsistent number of NACK ranges written")
+			return b, errors.New("BUG: Inconsistent number of NACK ranges written")
 		}
 	}
 
-	return nil
+	return b, nil
 }
 
 // MinLength of a written frame
@@ -265,11 +270,8 @@ func ParseAckFrameLegacy(r *bytes.Reader, version protocol.VersionNumber) (*AckF
 func (f *AckFrameLegacy) numWrittenNackRanges() uint64 {
 	var numRanges uint64
 	for _, nackRange := range f.NackRanges {
-		rangeLength := nackRange.Len()
-		numRanges += rangeLength/0xFF + 1
-		if rangeLength > 0 && rangeLength%0xFF == 0 {
-			numRanges--
-		}
+		// every additional contiguous NACK range covers 0x100 packets, see AppendTo
+		numRanges += nackRange.Len()/0x100 + 1
 	}
 
 	return numRanges
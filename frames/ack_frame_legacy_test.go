@@ -389,6 +389,23 @@ var _ = Describe("AckFrame", func() {
 				Expect(missingPacketBytes[21]).To(Equal(uint8(0xFF)))                 // rangeLength #3
 			})
 
+			It("writes a frame with a NACK range that is one packet shorter than three contiguous NACK ranges", func() {
+				frame := AckFrameLegacy{
+					Entropy:         2,
+					LargestObserved: 600,
+					NackRanges:      []NackRange{{FirstPacketNumber: 2, LastPacketNumber: 513}},
+				}
+				err := frame.Write(b, protocol.Version32)
+				Expect(err).ToNot(HaveOccurred())
+				missingPacketBytes := b.Bytes()[b.Len()-(1+2*7):]
+				Expect(missingPacketBytes[0]).To(Equal(uint8(2)))                    // numRanges
+				Expect(missingPacketBytes[1:7]).To(Equal([]byte{87, 0, 0, 0, 0, 0})) // missingPacketSequenceNumberDelta #1
+				Expect(missingPacketBytes[7]).To(Equal(uint8(0xFF)))                 // rangeLength #1
+				Expect(missingPacketBytes[8:14]).To(Equal([]byte{0, 0, 0, 0, 0, 0})) // missingPacketSequenceNumberDelta #2
+				Expect(missingPacketBytes[14]).To(Equal(uint8(0xFF)))                // rangeLength #2
+				Expect(frame.MinLength(0)).To(Equal(protocol.ByteCount(b.Len())))
+			})
+
 			It("writes a frame with two contiguous NACK range", func() {
 				nackRange1 := NackRange{FirstPacketNumber: 2, LastPacketNumber: 351}
 				nackRange2 := NackRange{FirstPacketNumber: 355, LastPacketNumber: 654}
@@ -14,9 +14,14 @@ type BlockedFrame struct {
 
 //Write writes a BlockedFrame frame
 func (f *BlockedFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
-	b.WriteByte(0x05)
-	utils.WriteUint32(b, uint32(f.StreamID))
-	return nil
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends a BLOCKED frame to b
+func (f *BlockedFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
+	b = append(b, 0x05)
+	b = utils.AppendUint32(b, uint32(f.StreamID))
+	return b, nil
 }
 
 // MinLength of a written frame
@@ -58,16 +58,21 @@ func (f *ConnectionCloseFrame) MinLength(version protocol.VersionNumber) (protoc
 
 // Write writes an CONNECTION_CLOSE frame.
 func (f *ConnectionCloseFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
-	b.WriteByte(0x02)
-	utils.WriteUint32(b, uint32(f.ErrorCode))
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends a CONNECTION_CLOSE frame to b
+func (f *ConnectionCloseFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
+	b = append(b, 0x02)
+	b = utils.AppendUint32(b, uint32(f.ErrorCode))
 
 	if len(f.ReasonPhrase) > math.MaxUint16 {
-		return errors.New("ConnectionFrame: ReasonPhrase too long")
+		return b, errors.New("ConnectionFrame: ReasonPhrase too long")
 	}
 
 	reasonPhraseLen := uint16(len(f.ReasonPhrase))
-	utils.WriteUint16(b, reasonPhraseLen)
-	b.WriteString(f.ReasonPhrase)
+	b = utils.AppendUint16(b, reasonPhraseLen)
+	b = append(b, f.ReasonPhrase...)
 
-	return nil
+	return b, nil
 }
@@ -9,5 +9,15 @@ import (
 // A Frame in QUIC
 type Frame interface {
 	Write(b *bytes.Buffer, version protocol.VersionNumber) error
+	// AppendTo appends the frame to b, and returns the extended slice
+	// If an error occurs, the returned slice contains the part of the frame that was appended before the error.
+	AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error)
 	MinLength(version protocol.VersionNumber) (protocol.ByteCount, error)
 }
+
+// writeFrame writes a frame to a bytes.Buffer, using its AppendTo method
+func writeFrame(b *bytes.Buffer, f Frame, version protocol.VersionNumber) error {
+	data, err := f.AppendTo(nil, version)
+	b.Write(data)
+	return err
+}
@@ -56,15 +56,20 @@ func ParseGoawayFrame(r *bytes.Reader) (*GoawayFrame, error) {
 }
 
 func (f *GoawayFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends a GOAWAY frame to b
+func (f *GoawayFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
 	typeByte := uint8(0x03)
-	b.WriteByte(typeByte)
+	b = append(b, typeByte)
 
-	utils.WriteUint32(b, uint32(f.ErrorCode))
-	utils.WriteUint32(b, uint32(f.LastGoodStream))
-	utils.WriteUint16(b, uint16(len(f.ReasonPhrase)))
-	b.WriteString(f.ReasonPhrase)
+	b = utils.AppendUint32(b, uint32(f.ErrorCode))
+	b = utils.AppendUint32(b, uint32(f.LastGoodStream))
+	b = utils.AppendUint16(b, uint16(len(f.ReasonPhrase)))
+	b = append(b, f.ReasonPhrase...)
 
-	return nil
+	return b, nil
 }
 
 // MinLength of a written frame
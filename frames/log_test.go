@@ -28,22 +28,27 @@ var _ = Describe("Frame logging", func() {
 
 	It("doesn't log when debug is disabled", func() {
 		utils.SetLogLevel(utils.LogLevelInfo)
-		LogFrame(&RstStreamFrame{}, true)
+		LogFrame(utils.DefaultLogger, &RstStreamFrame{}, true)
 		Expect(buf.Len()).To(BeZero())
 	})
 
 	It("logs sent frames", func() {
-		LogFrame(&RstStreamFrame{}, true)
+		LogFrame(utils.DefaultLogger, &RstStreamFrame{}, true)
 		Expect(string(buf.Bytes())).To(Equal("\t-> &frames.RstStreamFrame{StreamID:0x0, ByteOffset:0x0, ErrorCode:0x0}\n"))
 	})
 
 	It("logs received frames", func() {
-		LogFrame(&RstStreamFrame{}, false)
+		LogFrame(utils.DefaultLogger, &RstStreamFrame{}, false)
 		Expect(string(buf.Bytes())).To(Equal("\t<- &frames.RstStreamFrame{StreamID:0x0, ByteOffset:0x0, ErrorCode:0x0}\n"))
 	})
 
+	It("logs to the logger", func() {
+		LogFrame(utils.NewLogger(&buf, utils.LogLevelDebug).With("connection", 42), &RstStreamFrame{}, true)
+		Expect(string(buf.Bytes())).To(Equal("[connection=42] \t-> &frames.RstStreamFrame{StreamID:0x0, ByteOffset:0x0, ErrorCode:0x0}\n"))
+	})
+
 	It("logs stream frames", func() {
-		LogFrame(&StreamFrame{}, false)
+		LogFrame(utils.DefaultLogger, &StreamFrame{}, false)
 		Expect(string(buf.Bytes())).To(Equal("\t<- &frames.StreamFrame{StreamID: 0, FinBit: false, Offset: 0x0, Data length: 0x0, Offset + Data length: 0x0}\n"))
 	})
 })
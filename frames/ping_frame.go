@@ -22,9 +22,14 @@ func ParsePingFrame(r *bytes.Reader) (*PingFrame, error) {
 }
 
 func (f *PingFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends a PING frame to b
+func (f *PingFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
 	typeByte := uint8(0x07)
-	b.WriteByte(typeByte)
-	return nil
+	b = append(b, typeByte)
+	return b, nil
 }
 
 // MinLength of a written frame
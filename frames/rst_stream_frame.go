@@ -16,11 +16,16 @@ type RstStreamFrame struct {
 
 //Write writes a RST_STREAM frame
 func (f *RstStreamFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
-	b.WriteByte(0x01)
-	utils.WriteUint32(b, uint32(f.StreamID))
-	utils.WriteUint64(b, uint64(f.ByteOffset))
-	utils.WriteUint32(b, f.ErrorCode)
-	return nil
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends a RST_STREAM frame to b
+func (f *RstStreamFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
+	b = append(b, 0x01)
+	b = utils.AppendUint32(b, uint32(f.StreamID))
+	b = utils.AppendUint64(b, uint64(f.ByteOffset))
+	b = utils.AppendUint32(b, f.ErrorCode)
+	return b, nil
 }
 
 // MinLength of a written frame
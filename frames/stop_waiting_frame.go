@@ -24,39 +24,44 @@ var (
 )
 
 func (f *StopWaitingFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends a STOP_WAITING frame to b
+func (f *StopWaitingFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
 	// packetNumber is the packet number of the packet that this StopWaitingFrame will be sent with
 	typeByte := uint8(0x06)
-	b.WriteByte(typeByte)
+	b = append(b, typeByte)
 
 	if version < protocol.Version34 {
-		b.WriteByte(f.Entropy)
+		b = append(b, f.Entropy)
 	}
 
 	// make sure the PacketNumber was set
 	if f.PacketNumber == protocol.PacketNumber(0) {
-		return errPacketNumberNotSet
+		return b, errPacketNumberNotSet
 	}
 
 	if f.LeastUnacked > f.PacketNumber {
-		return errLeastUnackedHigherThanPacketNumber
+		return b, errLeastUnackedHigherThanPacketNumber
 	}
 
 	leastUnackedDelta := uint64(f.PacketNumber - f.LeastUnacked)
 
 	switch f.PacketNumberLen {
 	case protocol.PacketNumberLen1:
-		b.WriteByte(uint8(leastUnackedDelta))
+		b = append(b, uint8(leastUnackedDelta))
 	case protocol.PacketNumberLen2:
-		utils.WriteUint16(b, uint16(leastUnackedDelta))
+		b = utils.AppendUint16(b, uint16(leastUnackedDelta))
 	case protocol.PacketNumberLen4:
-		utils.WriteUint32(b, uint32(leastUnackedDelta))
+		b = utils.AppendUint32(b, uint32(leastUnackedDelta))
 	case protocol.PacketNumberLen6:
-		utils.WriteUint48(b, leastUnackedDelta)
+		b = utils.AppendUint48(b, leastUnackedDelta)
 	default:
-		return errPacketNumberLenNotSet
+		return b, errPacketNumberLenNotSet
 	}
 
-	return nil
+	return b, nil
 }
 
 // MinLength of a written frame
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"os"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
@@ -18,6 +19,15 @@ type StreamFrame struct {
 	Offset         protocol.ByteCount
 	Data           []byte
 	DataLenPresent bool
+	// Buffer is the buffer of the received packet that Data references, if the frame was parsed by ParseStreamFrameNoCopy.
+	// Data is only valid while the packet is handled, unless a reference to the Buffer is retained.
+	Buffer PacketBuffer
+}
+
+// A PacketBuffer is a reference-counted buffer holding a received packet
+type PacketBuffer interface {
+	Retain()
+	Release()
 }
 
 var (
@@ -27,11 +37,67 @@ var (
 
 // ParseStreamFrame reads a stream frame. The type byte must not have been read yet.
 func ParseStreamFrame(r *bytes.Reader) (*StreamFrame, error) {
+	frame, dataLen, err := parseStreamFrameHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataLen == 0 {
+		// The rest of the packet is data
+		frame.Data, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		frame.Data = make([]byte, dataLen)
+		if _, err := io.ReadFull(r, frame.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if !frame.FinBit && len(frame.Data) == 0 {
+		return nil, qerr.EmptyStreamFrameNoFin
+	}
+
+	return frame, nil
+}
+
+// ParseStreamFrameNoCopy reads a stream frame, like ParseStreamFrame.
+// Instead of copying the data, the frame's Data references data, which has to be the slice that r reads from.
+func ParseStreamFrameNoCopy(r *bytes.Reader, data []byte) (*StreamFrame, error) {
+	frame, dataLen, err := parseStreamFrameHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	start := int(r.Size()) - r.Len()
+	length := int(dataLen)
+	if length == 0 {
+		// The rest of the packet is data
+		length = r.Len()
+	} else if length > r.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	frame.Data = data[start : start+length]
+	if _, err := r.Seek(int64(length), os.SEEK_CUR); err != nil {
+		return nil, err
+	}
+
+	if !frame.FinBit && len(frame.Data) == 0 {
+		return nil, qerr.EmptyStreamFrameNoFin
+	}
+
+	return frame, nil
+}
+
+// parseStreamFrameHeader reads everything of a stream frame except for the data
+// It returns the data length, which is 0 if the data extends to the end of the packet.
+func parseStreamFrameHeader(r *bytes.Reader) (*StreamFrame, uint16, error) {
 	frame := &StreamFrame{}
 
 	typeByte, err := r.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	frame.FinBit = typeByte&0x40 > 0
@@ -44,13 +110,13 @@ func ParseStreamFrame(r *bytes.Reader) (*StreamFrame, error) {
 
 	sid, err := utils.ReadUintN(r, streamIDLen)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	frame.StreamID = protocol.StreamID(sid)
 
 	offset, err := utils.ReadUintN(r, offsetLen)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	frame.Offset = protocol.ByteCount(offset)
 
@@ -58,38 +124,26 @@ func ParseStreamFrame(r *bytes.Reader) (*StreamFrame, error) {
 	if frame.DataLenPresent {
 		dataLen, err = utils.ReadUint16(r)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
 	if dataLen > uint16(protocol.MaxPacketSize) {
-		return nil, qerr.Error(qerr.InvalidStreamData, "data len too large")
+		return nil, 0, qerr.Error(qerr.InvalidStreamData, "data len too large")
 	}
 
-	if dataLen == 0 {
-		// The rest of the packet is data
-		frame.Data, err = ioutil.ReadAll(r)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		frame.Data = make([]byte, dataLen)
-		if _, err := io.ReadFull(r, frame.Data); err != nil {
-			return nil, err
-		}
-	}
-
-	if !frame.FinBit && len(frame.Data) == 0 {
-		return nil, qerr.EmptyStreamFrameNoFin
-	}
-
-	return frame, nil
+	return frame, dataLen, nil
 }
 
 // WriteStreamFrame writes a stream frame.
 func (f *StreamFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends a STREAM frame to b
+func (f *StreamFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
 	if len(f.Data) == 0 && !f.FinBit {
-		return errors.New("StreamFrame: attempting to write empty frame without FIN")
+		return b, errors.New("StreamFrame: attempting to write empty frame without FIN")
 	}
 
 	typeByte := uint8(0x80) // sets the leftmost bit to 1
@@ -111,48 +165,48 @@ func (f *StreamFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) err
 	streamIDLen := f.calculateStreamIDLength()
 	typeByte ^= streamIDLen - 1
 
-	b.WriteByte(typeByte)
+	b = append(b, typeByte)
 
 	switch streamIDLen {
 	case 1:
-		b.WriteByte(uint8(f.StreamID))
+		b = append(b, uint8(f.StreamID))
 	case 2:
-		utils.WriteUint16(b, uint16(f.StreamID))
+		b = utils.AppendUint16(b, uint16(f.StreamID))
 	case 3:
-		utils.WriteUint24(b, uint32(f.StreamID))
+		b = utils.AppendUint24(b, uint32(f.StreamID))
 	case 4:
-		utils.WriteUint32(b, uint32(f.StreamID))
+		b = utils.AppendUint32(b, uint32(f.StreamID))
 	default:
-		return errInvalidStreamIDLen
+		return b, errInvalidStreamIDLen
 	}
 
 	switch offsetLength {
 	case 0:
 	case 2:
-		utils.WriteUint16(b, uint16(f.Offset))
+		b = utils.AppendUint16(b, uint16(f.Offset))
 	case 3:
-		utils.WriteUint24(b, uint32(f.Offset))
+		b = utils.AppendUint24(b, uint32(f.Offset))
 	case 4:
-		utils.WriteUint32(b, uint32(f.Offset))
+		b = utils.AppendUint32(b, uint32(f.Offset))
 	case 5:
-		utils.WriteUint40(b, uint64(f.Offset))
+		b = utils.AppendUint40(b, uint64(f.Offset))
 	case 6:
-		utils.WriteUint48(b, uint64(f.Offset))
+		b = utils.AppendUint48(b, uint64(f.Offset))
 	case 7:
-		utils.WriteUint56(b, uint64(f.Offset))
+		b = utils.AppendUint56(b, uint64(f.Offset))
 	case 8:
-		utils.WriteUint64(b, uint64(f.Offset))
+		b = utils.AppendUint64(b, uint64(f.Offset))
 	default:
-		return errInvalidOffsetLen
+		return b, errInvalidOffsetLen
 	}
 
 	if f.DataLenPresent {
-		utils.WriteUint16(b, uint16(len(f.Data)))
+		b = utils.AppendUint16(b, uint16(len(f.Data)))
 	}
 
-	b.Write(f.Data)
+	b = append(b, f.Data...)
 
-	return nil
+	return b, nil
 }
 
 func (f *StreamFrame) calculateStreamIDLength() uint8 {
@@ -2,6 +2,7 @@ package frames
 
 import (
 	"bytes"
+	"testing"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
@@ -63,6 +64,38 @@ var _ = Describe("StreamFrame", func() {
 				Expect(err).To(HaveOccurred())
 			}
 		})
+
+		Context("without copying", func() {
+			It("references the data", func() {
+				data := []byte{0xa0, 0x1, 0x06, 0x00, 'f', 'o', 'o', 'b', 'a', 'r', 0x80, 0x3, 'r', 'a', 'w'}
+				r := bytes.NewReader(data)
+				frame, err := ParseStreamFrameNoCopy(r, data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.StreamID).To(Equal(protocol.StreamID(1)))
+				Expect(frame.Data).To(Equal([]byte("foobar")))
+				data[4] = 'b'
+				Expect(frame.Data).To(Equal([]byte("boobar")))
+				frame, err = ParseStreamFrameNoCopy(r, data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.StreamID).To(Equal(protocol.StreamID(3)))
+				Expect(frame.Data).To(Equal([]byte("raw")))
+				Expect(r.Len()).To(BeZero())
+			})
+
+			It("errors on empty stream frames that don't have the FinBit set", func() {
+				data := []byte{0x80 ^ 0x20, 0x1, 0, 0}
+				_, err := ParseStreamFrameNoCopy(bytes.NewReader(data), data)
+				Expect(err).To(MatchError(qerr.EmptyStreamFrameNoFin))
+			})
+
+			It("errors on EOFs", func() {
+				data := []byte{0xa0, 0x1, 0x06, 0x00, 'f', 'o', 'o', 'b', 'a', 'r'}
+				for i := range data {
+					_, err := ParseStreamFrameNoCopy(bytes.NewReader(data[0:i]), data[0:i])
+					Expect(err).To(HaveOccurred())
+				}
+			})
+		})
 	})
 
 	Context("when writing", func() {
@@ -77,6 +110,30 @@ var _ = Describe("StreamFrame", func() {
 			Expect(b.Bytes()).To(Equal([]byte{0xa0, 0x1, 0x06, 0x00, 'f', 'o', 'o', 'b', 'a', 'r'}))
 		})
 
+		It("appends to a slice", func() {
+			f := &StreamFrame{
+				StreamID:       1,
+				Data:           []byte("foobar"),
+				DataLenPresent: true,
+			}
+			b, err := f.AppendTo([]byte{0x42}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).To(Equal([]byte{0x42, 0xa0, 0x1, 0x06, 0x00, 'f', 'o', 'o', 'b', 'a', 'r'}))
+		})
+
+		It("doesn't allocate when appending to a slice with enough capacity", func() {
+			f := &StreamFrame{
+				StreamID: 1,
+				Offset:   0x1337,
+				Data:     []byte("foobar"),
+			}
+			buf := make([]byte, 0, 100)
+			allocs := testing.AllocsPerRun(10, func() {
+				f.AppendTo(buf, 0)
+			})
+			Expect(allocs).To(BeZero())
+		})
+
 		It("sets the FinBit", func() {
 			b := &bytes.Buffer{}
 			err := (&StreamFrame{
@@ -15,12 +15,17 @@ type WindowUpdateFrame struct {
 
 //Write writes a RST_STREAM frame
 func (f *WindowUpdateFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	return writeFrame(b, f, version)
+}
+
+// AppendTo appends a WINDOW_UPDATE frame to b
+func (f *WindowUpdateFrame) AppendTo(b []byte, version protocol.VersionNumber) ([]byte, error) {
 	typeByte := uint8(0x04)
-	b.WriteByte(typeByte)
+	b = append(b, typeByte)
 
-	utils.WriteUint32(b, uint32(f.StreamID))
-	utils.WriteUint64(b, uint64(f.ByteOffset))
-	return nil
+	b = utils.AppendUint32(b, uint32(f.StreamID))
+	b = utils.AppendUint64(b, uint64(f.ByteOffset))
+	return b, nil
 }
 
 // MinLength of a written frame
@@ -0,0 +1,88 @@
+package quic
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// groOOBSize is the size of the buffer needed to receive the UDP_GRO control message
+var groOOBSize = unix.CmsgSpace(4)
+
+// gsoSupported says if the kernel supports UDP generic segmentation offload for a connection
+func gsoSupported(conn *net.UDPConn) bool {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var serr error
+	if err := rawConn.Control(func(fd uintptr) {
+		_, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_SEGMENT)
+	}); err != nil {
+		return false
+	}
+	return serr == nil
+}
+
+// enableGRO enables UDP generic receive offload for a connection
+// It returns false if the connection is not a UDP connection, or if the kernel rejected the socket option.
+func enableGRO(pconn net.PacketConn) bool {
+	conn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return false
+	}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var serr error
+	if err := rawConn.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_GRO, 1)
+	}); err != nil {
+		return false
+	}
+	return serr == nil
+}
+
+// groSegmentSize parses the UDP_GRO control message
+// It returns 0 if the kernel didn't coalesce any packets.
+func groSegmentSize(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.IPPROTO_UDP && msg.Header.Type == unix.UDP_GRO && len(msg.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&msg.Data[0])))
+		}
+	}
+	return 0
+}
+
+// appendUDPSegmentSizeMsg appends the UDP_SEGMENT control message, telling the kernel to split the payload into segments of the given size
+func appendUDPSegmentSizeMsg(b []byte, size int) []byte {
+	const dataLen = 2 // the segment size is a uint16
+	startLen := len(b)
+	b = append(b, make([]byte, unix.CmsgSpace(dataLen))...)
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[startLen]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(dataLen))
+	*(*uint16)(unsafe.Pointer(&b[startLen+unix.CmsgSpace(0)])) = uint16(size)
+	return b
+}
+
+// isGSOError says if sending failed because the kernel or the network device doesn't support GSO
+func isGSOError(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	errno, ok := err.(syscall.Errno)
+	return ok && (errno == syscall.EIO || errno == syscall.EINVAL)
+}
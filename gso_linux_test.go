@@ -0,0 +1,78 @@
+package quic
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UDP segmentation offload", func() {
+	It("composes the UDP_SEGMENT control message", func() {
+		oob := appendUDPSegmentSizeMsg([]byte{}, 1350)
+		msgs, err := unix.ParseSocketControlMessage(oob)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(msgs).To(HaveLen(1))
+		Expect(msgs[0].Header.Level).To(BeEquivalentTo(unix.IPPROTO_UDP))
+		Expect(msgs[0].Header.Type).To(BeEquivalentTo(unix.UDP_SEGMENT))
+		Expect(*(*uint16)(unsafe.Pointer(&msgs[0].Data[0]))).To(BeEquivalentTo(1350))
+	})
+
+	It("parses the UDP_GRO control message", func() {
+		oob := make([]byte, unix.CmsgSpace(4))
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		h.Level = unix.IPPROTO_UDP
+		h.Type = unix.UDP_GRO
+		h.SetLen(unix.CmsgLen(4))
+		*(*int32)(unsafe.Pointer(&oob[unix.CmsgSpace(0)])) = 1234
+		Expect(groSegmentSize(oob)).To(Equal(1234))
+	})
+
+	It("returns 0 if there's no UDP_GRO control message", func() {
+		Expect(groSegmentSize(nil)).To(BeZero())
+	})
+
+	It("detects errors caused by missing GSO support", func() {
+		Expect(isGSOError(&net.OpError{Op: "write", Err: &os.SyscallError{Syscall: "sendmsg", Err: syscall.EIO}})).To(BeTrue())
+		Expect(isGSOError(&net.OpError{Op: "write", Err: &os.SyscallError{Syscall: "sendmsg", Err: syscall.ECONNREFUSED}})).To(BeFalse())
+	})
+
+	It("sends packets of the same size with a single syscall", func() {
+		serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer serverConn.Close()
+		clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+
+		c := newConn(clientConn, serverConn.LocalAddr())
+		if c.gsoConn == nil {
+			Skip("UDP GSO not supported by the kernel")
+		}
+		packets := [][]byte{
+			make([]byte, 100),
+			make([]byte, 100),
+			make([]byte, 50),
+			make([]byte, 200),
+		}
+		for i, p := range packets {
+			for j := range p {
+				p[j] = byte(i)
+			}
+		}
+		n, err := c.writeGSO(packets, serverConn.LocalAddr().(*net.UDPAddr))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(4))
+		for _, p := range packets {
+			data := make([]byte, 1000)
+			n, _, err := serverConn.ReadFrom(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data[:n]).To(Equal(p))
+		}
+	})
+})
@@ -0,0 +1,19 @@
+// +build !linux
+
+package quic
+
+import "net"
+
+// UDP generic segmentation and receive offload are only supported on Linux
+
+var groOOBSize = 0
+
+func gsoSupported(conn *net.UDPConn) bool { return false }
+
+func enableGRO(pconn net.PacketConn) bool { return false }
+
+func groSegmentSize(oob []byte) int { return 0 }
+
+func appendUDPSegmentSizeMsg(b []byte, size int) []byte { return b }
+
+func isGSOError(err error) bool { return false }
@@ -4,21 +4,37 @@ import (
 	"bytes"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/lucas-clemente/quic-go"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
 )
 
 type mockStream struct {
 	id protocol.StreamID
 	bytes.Buffer
 	remoteClosed bool
+	priority     *quic.StreamPriority
 }
 
-func (mockStream) Close() error                             { return nil }
-func (s *mockStream) CloseRemote(offset protocol.ByteCount) { s.remoteClosed = true }
-func (s mockStream) StreamID() protocol.StreamID            { return s.id }
+func (mockStream) Close() error                               { return nil }
+func (s *mockStream) CloseRemote(offset protocol.ByteCount)   { s.remoteClosed = true }
+func (s mockStream) StreamID() protocol.StreamID              { return s.id }
+func (s *mockStream) Reset(errorCode uint32)                  { panic("not implemented") }
+func (s *mockStream) SetPriority(p quic.StreamPriority) error { s.priority = &p; return nil }
+func (mockStream) SetDeadline(time.Time) error                { panic("not implemented") }
+func (mockStream) SetReadDeadline(time.Time) error            { panic("not implemented") }
+func (mockStream) SetWriteDeadline(time.Time) error           { panic("not implemented") }
+func (mockStream) ReadContext(context.Context, []byte) (int, error) {
+	panic("not implemented")
+}
+func (mockStream) WriteContext(context.Context, []byte) (int, error) {
+	panic("not implemented")
+}
 
 var _ = Describe("Response Writer", func() {
 	var (
@@ -30,7 +46,7 @@ var _ = Describe("Response Writer", func() {
 	BeforeEach(func() {
 		headerStream = &mockStream{}
 		dataStream = &mockStream{}
-		w = newResponseWriter(headerStream, &sync.Mutex{}, dataStream, 5)
+		w = newResponseWriter(headerStream, &sync.Mutex{}, dataStream, 5, utils.DefaultLogger)
 	})
 
 	It("writes status", func() {
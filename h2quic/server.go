@@ -15,26 +15,39 @@ import (
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 )
 
 type streamCreator interface {
-	GetOrOpenStream(protocol.StreamID) (utils.Stream, error)
+	AcceptStream() (quic.Stream, error)
+	GetOrOpenStream(protocol.StreamID) (quic.Stream, error)
 	Close(error) error
+	RemoteAddr() net.Addr
 }
 
+// closeGracefullyPollInterval is the interval in which CloseGracefully checks if all handlers have returned
+var closeGracefullyPollInterval = 50 * time.Millisecond
+
 // Server is a HTTP2 server listening for QUIC connections.
 type Server struct {
 	*http.Server
 
+	// QuicConfig is used for the QUIC listener, e.g. to set the flow control windows or the logger.
+	// Its TLSConfig is replaced by the TLS config of the http.Server, or by the certificates passed to ListenAndServeTLS.
+	// If not set, the default values are used.
+	QuicConfig *quic.Config
+
 	// Private flag for demo, do not use
 	CloseAfterFirstRequest bool
 
 	port uint32 // used atomically
 
-	server      *quic.Server
-	serverMutex sync.Mutex
+	activeRequests int32 // used atomically
+
+	listener      quic.Listener
+	listenerMutex sync.Mutex
 }
 
 // ListenAndServe listens on the UDP address s.Addr and calls s.Handler to handle HTTP/2 requests on incoming connections.
@@ -61,55 +74,93 @@ func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
 	return s.serveImpl(config, nil)
 }
 
-// Serve an existing UDP connection.
-func (s *Server) Serve(conn *net.UDPConn) error {
+// Serve an existing net.PacketConn, e.g. a UDP connection.
+func (s *Server) Serve(conn net.PacketConn) error {
 	return s.serveImpl(s.TLSConfig, conn)
 }
 
-func (s *Server) serveImpl(tlsConfig *tls.Config, conn *net.UDPConn) error {
+func (s *Server) serveImpl(tlsConfig *tls.Config, conn net.PacketConn) error {
 	if s.Server == nil {
 		return errors.New("use of h2quic.Server without http.Server")
 	}
-	s.serverMutex.Lock()
-	if s.server != nil {
-		s.serverMutex.Unlock()
+	s.listenerMutex.Lock()
+	if s.listener != nil {
+		s.listenerMutex.Unlock()
 		return errors.New("ListenAndServe may only be called once")
 	}
+	// copy the config, such that the QuicConfig of the server is not modified
+	config := &quic.Config{}
+	if s.QuicConfig != nil {
+		*config = *s.QuicConfig
+	}
+	config.TLSConfig = tlsConfig
+	var ln quic.Listener
 	var err error
-	server, err := quic.NewServer(s.Addr, s.TLSConfig, s.handleStreamCb)
+	if conn == nil {
+		ln, err = quic.ListenAddr(s.Addr, config)
+	} else {
+		ln, err = quic.Listen(conn, config)
+	}
 	if err != nil {
-		s.serverMutex.Unlock()
+		s.listenerMutex.Unlock()
 		return err
 	}
-	s.server = server
-	s.serverMutex.Unlock()
-	if conn == nil {
-		return server.ListenAndServe()
+	s.listener = ln
+	s.listenerMutex.Unlock()
+
+	for {
+		sess, err := ln.Accept()
+		if err != nil {
+			s.listenerMutex.Lock()
+			closed := s.listener == nil
+			s.listenerMutex.Unlock()
+			// the listener was closed by calling Close()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.handleHeaderStream(sess)
 	}
-	return server.Serve(conn)
 }
 
-func (s *Server) handleStreamCb(session *quic.Session, stream utils.Stream) {
-	s.handleStream(session, stream)
+// sessionLogger returns the logger for the messages concerning a session
+func (s *Server) sessionLogger(session streamCreator) utils.Logger {
+	logger := utils.DefaultLogger
+	if s.QuicConfig != nil && s.QuicConfig.Logger != nil {
+		logger = s.QuicConfig.Logger
+	}
+	return logger.With("remote", session.RemoteAddr())
 }
 
-func (s *Server) handleStream(session streamCreator, stream utils.Stream) {
+func (s *Server) handleHeaderStream(session streamCreator) {
+	stream, err := session.AcceptStream()
+	if err != nil {
+		session.Close(qerr.Error(qerr.InvalidHeadersStreamData, err.Error()))
+		return
+	}
 	if stream.StreamID() != 3 {
+		session.Close(qerr.Error(qerr.InvalidHeadersStreamData, "expected the first stream to be the headers stream"))
 		return
 	}
+	s.handleStream(session, stream)
+}
+
+func (s *Server) handleStream(session streamCreator, stream quic.Stream) {
 
 	hpackDecoder := hpack.NewDecoder(4096, nil)
 	h2framer := http2.NewFramer(nil, stream)
 
+	logger := s.sessionLogger(session)
 	go func() {
 		var headerStreamMutex sync.Mutex // Protects concurrent calls to Write()
 		for {
-			if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, h2framer); err != nil {
+			if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, h2framer, logger); err != nil {
 				// QuicErrors must originate from stream.Read() returning an error.
 				// In this case, the session has already logged the error, so we don't
 				// need to log it again.
 				if _, ok := err.(*qerr.QuicError); !ok {
-					utils.Errorf("error handling h2 request: %s", err.Error())
+					logger.Errorf("error handling h2 request: %s", err.Error())
 				}
 				return
 			}
@@ -117,7 +168,7 @@ func (s *Server) handleStream(session streamCreator, stream utils.Stream) {
 	}()
 }
 
-func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, h2framer *http2.Framer) error {
+func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, h2framer *http2.Framer, logger utils.Logger) error {
 	h2frame, err := h2framer.ReadFrame()
 	if err != nil {
 		return err
@@ -128,7 +179,7 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 	}
 	headers, err := hpackDecoder.DecodeFull(h2headersFrame.HeaderBlockFragment())
 	if err != nil {
-		utils.Errorf("invalid http2 headers encoding: %s", err.Error())
+		logger.Errorf("invalid http2 headers encoding: %s", err.Error())
 		return err
 	}
 
@@ -137,10 +188,10 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 		return err
 	}
 
-	if utils.Debug() {
-		utils.Infof("%s %s%s, on data stream %d", req.Method, req.Host, req.RequestURI, h2headersFrame.StreamID)
+	if logger.Debug() {
+		logger.Infof("%s %s%s, on data stream %d", req.Method, req.Host, req.RequestURI, h2headersFrame.StreamID)
 	} else {
-		utils.Infof("%s %s%s", req.Method, req.Host, req.RequestURI)
+		logger.Infof("%s %s%s", req.Method, req.Host, req.RequestURI)
 	}
 
 	dataStream, err := session.GetOrOpenStream(protocol.StreamID(h2headersFrame.StreamID))
@@ -148,6 +199,13 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 		return err
 	}
 
+	if h2headersFrame.HasPriority() {
+		weight := uint16(h2headersFrame.Priority.Weight) + 1
+		if err := dataStream.SetPriority(quic.StreamPriority{Level: priorityLevelFromWeight(weight), Weight: weight}); err != nil {
+			return err
+		}
+	}
+
 	if h2headersFrame.StreamEnded() {
 		dataStream.CloseRemote(0)
 		_, _ = dataStream.Read([]byte{0}) // read the eof
@@ -156,9 +214,11 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 	// stream's Close() closes the write side, not the read side
 	req.Body = ioutil.NopCloser(dataStream)
 
-	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, protocol.StreamID(h2headersFrame.StreamID))
+	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, protocol.StreamID(h2headersFrame.StreamID), logger)
 
+	atomic.AddInt32(&s.activeRequests, 1)
 	go func() {
+		defer atomic.AddInt32(&s.activeRequests, -1)
 		handler := s.Handler
 		if handler == nil {
 			handler = http.DefaultServeMux
@@ -176,21 +236,53 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 	return nil
 }
 
+// priorityLevelFromWeight converts the weight of an HTTP/2 priority to a SPDY priority level
+// Chromium encodes the SPDY priority of a request as an HTTP/2 weight, this is the reverse conversion.
+func priorityLevelFromWeight(weight uint16) uint8 {
+	const steps = 255.9 / protocol.LowestStreamPriorityLevel
+	return uint8(protocol.LowestStreamPriorityLevel - float32(weight-1)/steps)
+}
+
 // Close the server immediately, aborting requests and sending CONNECTION_CLOSE frames to connected clients
 func (s *Server) Close() error {
-	s.serverMutex.Lock()
-	defer s.serverMutex.Unlock()
-	if s.server != nil {
-		err := s.server.Close()
-		s.server = nil
+	s.listenerMutex.Lock()
+	defer s.listenerMutex.Unlock()
+	if s.listener != nil {
+		err := s.listener.Close()
+		s.listener = nil
 		return err
 	}
 	return nil
 }
 
 // CloseGracefully shuts down the server gracefully. The server sends a GOAWAY frame first, then waits for either timeout to trigger, or for all running requests to complete.
+// A request is complete once its handler returned and the client acknowledged the whole response.
+// If the timeout triggers before all requests completed, the remaining sessions are closed and context.DeadlineExceeded is returned.
 func (s *Server) CloseGracefully(timeout time.Duration) error {
-	// TODO: implement
+	s.listenerMutex.Lock()
+	ln := s.listener
+	s.listener = nil
+	s.listenerMutex.Unlock()
+	if ln == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// The listener waits until the data streams are finished, i.e. until the responses were sent and acknowledged.
+	if err := ln.Shutdown(ctx); err != nil {
+		return err
+	}
+	// The listener doesn't know about handlers of sessions that were already closed, so wait for them as well.
+	ticker := time.NewTicker(closeGracefullyPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&s.activeRequests) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 	return nil
 }
 
@@ -6,13 +6,17 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"golang.org/x/net/context"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 
+	"github.com/lucas-clemente/quic-go"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/testdata"
 	"github.com/lucas-clemente/quic-go/utils"
 
@@ -21,15 +25,38 @@ import (
 )
 
 type mockSession struct {
-	closed     bool
-	dataStream *mockStream
+	closed         bool
+	closedWithErr  error
+	dataStream     *mockStream
+	streamToAccept quic.Stream
 }
 
-func (s *mockSession) GetOrOpenStream(id protocol.StreamID) (utils.Stream, error) {
+func (s *mockSession) AcceptStream() (quic.Stream, error) {
+	return s.streamToAccept, nil
+}
+
+func (s *mockSession) GetOrOpenStream(id protocol.StreamID) (quic.Stream, error) {
 	return s.dataStream, nil
 }
 
-func (s *mockSession) Close(error) error { s.closed = true; return nil }
+func (s *mockSession) Close(e error) error  { s.closed = true; s.closedWithErr = e; return nil }
+func (s *mockSession) RemoteAddr() net.Addr { return &net.UDPAddr{} }
+
+type mockListener struct {
+	quic.Listener
+	shutdownCtx  context.Context
+	shutdownDone chan struct{}
+}
+
+func (l *mockListener) Shutdown(ctx context.Context) error {
+	l.shutdownCtx = ctx
+	select {
+	case <-l.shutdownDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
 var _ = Describe("H2 server", func() {
 	certPath := os.Getenv("GOPATH")
@@ -75,7 +102,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, utils.DefaultLogger)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeTrue())
@@ -92,11 +119,57 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, utils.DefaultLogger)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeFalse())
 		})
+
+		It("sets the priority of the data stream", func() {
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			err := http2.NewFramer(headerStream, nil).WriteHeaders(http2.HeadersFrameParam{
+				StreamID: 5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				BlockFragment: []byte{0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff},
+				EndStream:     true,
+				EndHeaders:    true,
+				// this is how Chromium encodes SPDY priority 1
+				Priority: http2.PriorityParam{Weight: 219},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, utils.DefaultLogger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dataStream.priority).To(Equal(&quic.StreamPriority{Level: 1, Weight: 220}))
+		})
+
+		It("doesn't set a priority if the HEADERS frame doesn't contain one", func() {
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			headerStream.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, utils.DefaultLogger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dataStream.priority).To(BeNil())
+		})
+
+		It("counts running requests", func() {
+			handlerDone := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-handlerDone
+			})
+			headerStream.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer, utils.DefaultLogger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(atomic.LoadInt32(&s.activeRequests)).To(BeEquivalentTo(1))
+			close(handlerDone)
+			Eventually(func() int32 { return atomic.LoadInt32(&s.activeRequests) }).Should(BeZero())
+		})
 	})
 
 	It("handles the header stream", func() {
@@ -111,11 +184,12 @@ var _ = Describe("H2 server", func() {
 			// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 			0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 		})
-		s.handleStream(session, headerStream)
+		session.streamToAccept = headerStream
+		s.handleHeaderStream(session)
 		Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 	})
 
-	It("ignores other streams", func() {
+	It("closes the session if the first stream is not the header stream", func() {
 		var handlerCalled bool
 		s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			Expect(r.Host).To(Equal("www.example.com"))
@@ -127,7 +201,10 @@ var _ = Describe("H2 server", func() {
 			// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 			0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 		})
-		s.handleStream(session, headerStream)
+		session.streamToAccept = headerStream
+		s.handleHeaderStream(session)
+		Expect(session.closed).To(BeTrue())
+		Expect(session.closedWithErr).To(MatchError(qerr.Error(qerr.InvalidHeadersStreamData, "expected the first stream to be the headers stream")))
 		Consistently(func() bool { return handlerCalled }).Should(BeFalse())
 	})
 
@@ -254,6 +331,13 @@ var _ = Describe("H2 server", func() {
 			err = s.Close()
 			Expect(err).NotTo(HaveOccurred())
 		}, 0.5)
+
+		It("uses the QuicConfig", func() {
+			s.QuicConfig = &quic.Config{InitialCongestionWindow: 10, MaxCongestionWindow: 5}
+			err := s.ListenAndServe()
+			Expect(err).To(MatchError(ContainSubstring("initial congestion window")))
+			Expect(s.QuicConfig.TLSConfig).To(BeNil())
+		})
 	})
 
 	Context("ListenAndServeTLS", func() {
@@ -298,6 +382,74 @@ var _ = Describe("H2 server", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	Context("closing gracefully", func() {
+		var serveReturned chan struct{}
+
+		BeforeEach(func() {
+			s.Server.Addr = "localhost:0"
+			serveReturned = make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				err := s.ListenAndServe()
+				Expect(err).NotTo(HaveOccurred())
+				close(serveReturned)
+			}()
+			Eventually(func() quic.Listener {
+				s.listenerMutex.Lock()
+				defer s.listenerMutex.Unlock()
+				return s.listener
+			}).ShouldNot(BeNil())
+		})
+
+		It("closes the listener if there are no running requests", func() {
+			err := s.CloseGracefully(time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(serveReturned).Should(BeClosed())
+		})
+
+		It("waits for running requests to complete", func() {
+			atomic.StoreInt32(&s.activeRequests, 1)
+			var returned bool
+			go func() {
+				defer GinkgoRecover()
+				err := s.CloseGracefully(10 * time.Second)
+				Expect(err).NotTo(HaveOccurred())
+				returned = true
+			}()
+			Consistently(func() bool { return returned }).Should(BeFalse())
+			atomic.StoreInt32(&s.activeRequests, 0)
+			Eventually(func() bool { return returned }).Should(BeTrue())
+			Eventually(serveReturned).Should(BeClosed())
+		})
+
+		It("waits for the listener to finish sending the responses", func() {
+			ln := &mockListener{shutdownDone: make(chan struct{})}
+			s.listenerMutex.Lock()
+			realListener := s.listener
+			s.listener = ln
+			s.listenerMutex.Unlock()
+			defer realListener.Close()
+			var returned bool
+			go func() {
+				defer GinkgoRecover()
+				err := s.CloseGracefully(10 * time.Second)
+				Expect(err).NotTo(HaveOccurred())
+				returned = true
+			}()
+			Consistently(func() bool { return returned }).Should(BeFalse())
+			Expect(ln.shutdownCtx.Err()).ToNot(HaveOccurred())
+			close(ln.shutdownDone)
+			Eventually(func() bool { return returned }).Should(BeTrue())
+		})
+
+		It("returns an error if requests are still running when the timeout is reached", func() {
+			atomic.StoreInt32(&s.activeRequests, 1)
+			err := s.CloseGracefully(50 * time.Millisecond)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Eventually(serveReturned).Should(BeClosed())
+		})
+	})
+
 	It("at least errors in global ListenAndServeQUIC", func() {
 		// It's quite hard to test this, since we cannot properly shutdown the server
 		// once it's started. So, we open a socket on the same port before the test,
@@ -14,7 +14,7 @@ import (
 
 // ConnectionParametersManager stores the connection parameters
 // Warning: Writes may only be done from the crypto stream, see the comment
-// in GetSHLOMap().
+// in GetHelloMap().
 type ConnectionParametersManager struct {
 	params map[Tag][]byte
 	mutex  sync.RWMutex
@@ -27,6 +27,10 @@ type ConnectionParametersManager struct {
 	sendConnectionFlowControlWindow    protocol.ByteCount
 	receiveStreamFlowControlWindow     protocol.ByteCount
 	receiveConnectionFlowControlWindow protocol.ByteCount
+
+	// the maximum values accepted during the negotiation
+	maxStreamsPerConnectionLimit   uint32
+	maxIdleConnectionStateLifetime time.Duration
 }
 
 var errTagNotInConnectionParameterMap = errors.New("ConnectionParametersManager: Tag not found in ConnectionsParameter map")
@@ -38,15 +42,22 @@ var (
 )
 
 // NewConnectionParamatersManager creates a new connection parameters manager
-func NewConnectionParamatersManager() *ConnectionParametersManager {
+func NewConnectionParamatersManager(
+	receiveStreamFlowControlWindow protocol.ByteCount,
+	receiveConnectionFlowControlWindow protocol.ByteCount,
+	maxStreamsPerConnection uint32,
+	maxIdleConnectionStateLifetime time.Duration,
+) *ConnectionParametersManager {
 	return &ConnectionParametersManager{
 		params: make(map[Tag][]byte),
-		idleConnectionStateLifetime:        protocol.InitialIdleConnectionStateLifetime,
+		idleConnectionStateLifetime:        utils.MinDuration(protocol.InitialIdleConnectionStateLifetime, maxIdleConnectionStateLifetime),
 		sendStreamFlowControlWindow:        protocol.InitialStreamFlowControlWindow,     // can only be changed by the client
 		sendConnectionFlowControlWindow:    protocol.InitialConnectionFlowControlWindow, // can only be changed by the client
-		receiveStreamFlowControlWindow:     protocol.ReceiveStreamFlowControlWindow,
-		receiveConnectionFlowControlWindow: protocol.ReceiveConnectionFlowControlWindow,
-		maxStreamsPerConnection:            protocol.MaxStreamsPerConnection,
+		receiveStreamFlowControlWindow:     receiveStreamFlowControlWindow,
+		receiveConnectionFlowControlWindow: receiveConnectionFlowControlWindow,
+		maxStreamsPerConnection:            maxStreamsPerConnection,
+		maxStreamsPerConnectionLimit:       maxStreamsPerConnection,
+		maxIdleConnectionStateLifetime:     maxIdleConnectionStateLifetime,
 	}
 }
 
@@ -102,12 +113,12 @@ func (h *ConnectionParametersManager) SetFromMap(params map[Tag][]byte) error {
 }
 
 func (h *ConnectionParametersManager) negotiateMaxStreamsPerConnection(clientValue uint32) uint32 {
-	return utils.MinUint32(clientValue, protocol.MaxStreamsPerConnection)
+	return utils.MinUint32(clientValue, h.maxStreamsPerConnectionLimit)
 }
 
 func (h *ConnectionParametersManager) negotiateIdleConnectionStateLifetime(clientValue time.Duration) time.Duration {
 	// TODO: what happens if the clients sets 0 seconds?
-	return utils.MinDuration(clientValue, protocol.MaxIdleConnectionStateLifetime)
+	return utils.MinDuration(clientValue, h.maxIdleConnectionStateLifetime)
 }
 
 // getRawValue gets the byte-slice for a tag
@@ -122,8 +133,8 @@ func (h *ConnectionParametersManager) getRawValue(tag Tag) ([]byte, error) {
 	return rawValue, nil
 }
 
-// GetSHLOMap gets all values (except crypto values) needed for the SHLO
-func (h *ConnectionParametersManager) GetSHLOMap() map[Tag][]byte {
+// GetHelloMap gets all values (except crypto values) needed for the SHLO or CHLO
+func (h *ConnectionParametersManager) GetHelloMap() map[Tag][]byte {
 	sfcw := bytes.NewBuffer([]byte{})
 	utils.WriteUint32(sfcw, uint32(h.GetReceiveStreamFlowControlWindow()))
 	cfcw := bytes.NewBuffer([]byte{})
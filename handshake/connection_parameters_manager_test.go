@@ -11,7 +11,7 @@ import (
 var _ = Describe("ConnectionsParameterManager", func() {
 	var cpm *ConnectionParametersManager
 	BeforeEach(func() {
-		cpm = NewConnectionParamatersManager()
+		cpm = NewConnectionParamatersManager(protocol.ReceiveStreamFlowControlWindow, protocol.ReceiveConnectionFlowControlWindow, protocol.MaxStreamsPerConnection, protocol.MaxIdleConnectionStateLifetime)
 	})
 
 	It("stores and retrieves a value", func() {
@@ -27,6 +27,16 @@ var _ = Describe("ConnectionsParameterManager", func() {
 		Expect(val).To(Equal(tcid))
 	})
 
+	It("uses the values passed to the constructor", func() {
+		cpm = NewConnectionParamatersManager(0x1000, 0x2000, 5, 10*time.Second)
+		Expect(cpm.GetReceiveStreamFlowControlWindow()).To(Equal(protocol.ByteCount(0x1000)))
+		Expect(cpm.GetReceiveConnectionFlowControlWindow()).To(Equal(protocol.ByteCount(0x2000)))
+		Expect(cpm.GetMaxStreamsPerConnection()).To(Equal(uint32(5)))
+		Expect(cpm.GetIdleConnectionStateLifetime()).To(Equal(10 * time.Second))
+		Expect(cpm.negotiateMaxStreamsPerConnection(10)).To(Equal(uint32(5)))
+		Expect(cpm.negotiateIdleConnectionStateLifetime(20 * time.Second)).To(Equal(10 * time.Second))
+	})
+
 	It("returns an error for a tag that is not set", func() {
 		_, err := cpm.getRawValue(TagKEXS)
 		Expect(err).To(MatchError(errTagNotInConnectionParameterMap))
@@ -34,35 +44,35 @@ var _ = Describe("ConnectionsParameterManager", func() {
 
 	Context("SHLO", func() {
 		It("returns all parameters necessary for the SHLO", func() {
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagICSL))
 			Expect(entryMap).To(HaveKey(TagMSPC))
 		})
 
 		It("sets the stream-level flow control windows in SHLO", func() {
 			cpm.receiveStreamFlowControlWindow = 0xDEADBEEF
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagSFCW))
 			Expect(entryMap[TagSFCW]).To(Equal([]byte{0xEF, 0xBE, 0xAD, 0xDE}))
 		})
 
 		It("sets the connection-level flow control windows in SHLO", func() {
 			cpm.receiveConnectionFlowControlWindow = 0xDECAFBAD
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagCFCW))
 			Expect(entryMap[TagCFCW]).To(Equal([]byte{0xAD, 0xFB, 0xCA, 0xDE}))
 		})
 
 		It("sets the connection-level flow control windows in SHLO", func() {
 			cpm.idleConnectionStateLifetime = 0xDECAFBAD * time.Second
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagICSL))
 			Expect(entryMap[TagICSL]).To(Equal([]byte{0xAD, 0xFB, 0xCA, 0xDE}))
 		})
 
 		It("sets the maximum streams per connection in SHLO", func() {
 			cpm.maxStreamsPerConnection = 0xDEADBEEF
-			entryMap := cpm.GetSHLOMap()
+			entryMap := cpm.GetHelloMap()
 			Expect(entryMap).To(HaveKey(TagMSPC))
 			Expect(entryMap[TagMSPC]).To(Equal([]byte{0xEF, 0xBE, 0xAD, 0xDE}))
 		})
@@ -3,6 +3,7 @@ package handshake
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
 	"io"
 	"net"
 	"sync"
@@ -10,11 +11,12 @@ import (
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/qlog"
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
 // KeyDerivationFunction is used for key derivation
-type KeyDerivationFunction func(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte) (crypto.AEAD, error)
+type KeyDerivationFunction func(version protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (crypto.AEAD, error)
 
 // KeyExchangeFunction is used to make a new KEX
 type KeyExchangeFunction func() crypto.KeyExchange
@@ -24,6 +26,7 @@ type CryptoSetup struct {
 	connID               protocol.ConnectionID
 	ip                   net.IP
 	version              protocol.VersionNumber
+	supportedVersions    []protocol.VersionNumber
 	scfg                 *ServerConfig
 	diversificationNonce []byte
 
@@ -40,6 +43,10 @@ type CryptoSetup struct {
 
 	connectionParametersManager *ConnectionParametersManager
 
+	// tracer is nil if the connection is not traced
+	tracer qlog.ConnectionTracer
+	logger utils.Logger
+
 	mutex sync.RWMutex
 }
 
@@ -53,21 +60,46 @@ func NewCryptoSetup(
 	scfg *ServerConfig,
 	cryptoStream utils.Stream,
 	connectionParametersManager *ConnectionParametersManager,
+	supportedVersions []protocol.VersionNumber,
+	tracer qlog.ConnectionTracer,
+	logger utils.Logger,
+	keyLogWriter io.Writer,
 	aeadChanged chan struct{},
 ) (*CryptoSetup, error) {
 	return &CryptoSetup{
 		connID:                      connID,
 		ip:                          ip,
 		version:                     version,
+		supportedVersions:           supportedVersions,
 		scfg:                        scfg,
-		keyDerivation:               crypto.DeriveKeysAESGCM,
+		keyDerivation:               getKeyDerivation(keyLogWriter, logger),
 		keyExchange:                 getEphermalKEX,
 		cryptoStream:                cryptoStream,
 		connectionParametersManager: connectionParametersManager,
+		tracer:                      tracer,
+		logger:                      logger,
 		aeadChanged:                 aeadChanged,
 	}, nil
 }
 
+// signalAEADChanged notifies the session that an AEAD was derived
+// It doesn't block, since it is called while holding the mutex, and the session needs the mutex to read the pending signal.
+// The session handles the signal by checking the current state, so a single pending signal is sufficient.
+func signalAEADChanged(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// getKeyDerivation returns the key derivation function, which also writes the keys to the keyLogWriter, if set
+func getKeyDerivation(keyLogWriter io.Writer, logger utils.Logger) KeyDerivationFunction {
+	if keyLogWriter == nil {
+		return crypto.DeriveKeysAESGCM
+	}
+	return crypto.NewKeyLoggingDeriveKeysAESGCM(keyLogWriter, logger)
+}
+
 // HandleCryptoStream reads and writes messages on the crypto stream
 func (h *CryptoSetup) HandleCryptoStream() error {
 	for {
@@ -76,14 +108,7 @@ func (h *CryptoSetup) HandleCryptoStream() error {
 		if err != nil {
 			return qerr.HandshakeFailed
 		}
-		if messageTag != TagCHLO {
-			return qerr.InvalidCryptoMessageType
-		}
-		chloData := cachingReader.Get()
-
-		utils.Debugf("Got CHLO:\n%s", printHandshakeMessage(cryptoData))
-
-		done, err := h.handleMessage(chloData, cryptoData)
+		done, err := h.handleParsedMessage(messageTag, cachingReader.Get(), cryptoData)
 		if err != nil {
 			return err
 		}
@@ -93,6 +118,43 @@ func (h *CryptoSetup) HandleCryptoStream() error {
 	}
 }
 
+// HandleCryptoData handles the complete handshake messages in data, and removes them from data
+// It is used instead of HandleCryptoStream if the caller reads the crypto stream itself, e.g. when running on an event loop.
+// An incomplete message is left in data, to be handled once the rest of it was received.
+// It returns true once the handshake is complete.
+func (h *CryptoSetup) HandleCryptoData(data *bytes.Buffer) (bool, error) {
+	for data.Len() > 0 {
+		cachingReader := utils.NewCachingReader(bytes.NewReader(data.Bytes()))
+		messageTag, cryptoData, err := ParseHandshakeMessage(cachingReader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, qerr.HandshakeFailed
+		}
+		chloData := cachingReader.Get()
+		data.Next(len(chloData))
+		done, err := h.handleParsedMessage(messageTag, chloData, cryptoData)
+		if err != nil || done {
+			return done, err
+		}
+	}
+	return false, nil
+}
+
+func (h *CryptoSetup) handleParsedMessage(messageTag Tag, chloData []byte, cryptoData map[Tag][]byte) (bool, error) {
+	if messageTag != TagCHLO {
+		return false, qerr.InvalidCryptoMessageType
+	}
+
+	h.logger.Debugf("Got CHLO:\n%s", printHandshakeMessage(cryptoData))
+	if h.tracer != nil {
+		h.tracer.ReceivedHandshakeMessage(tagToTraceString(messageTag), tagsForTracing(cryptoData))
+	}
+
+	return h.handleMessage(chloData, cryptoData)
+}
+
 func (h *CryptoSetup) handleMessage(chloData []byte, cryptoData map[Tag][]byte) (bool, error) {
 	sniSlice, ok := cryptoData[TagSNI]
 	if !ok {
@@ -178,7 +240,7 @@ func (h *CryptoSetup) isInchoateCHLO(cryptoData map[Tag][]byte) bool {
 		return true
 	}
 	if err := h.scfg.stkSource.VerifyToken(h.ip, cryptoData[TagSTK]); err != nil {
-		utils.Infof("STK invalid: %s", err.Error())
+		h.logger.Infof("STK invalid: %s", err.Error())
 		return false
 	}
 	return false
@@ -219,6 +281,9 @@ func (h *CryptoSetup) handleInchoateCHLO(sni string, chlo []byte, cryptoData map
 
 	var serverReply bytes.Buffer
 	WriteHandshakeMessage(&serverReply, TagREJ, replyMap)
+	if h.tracer != nil {
+		h.tracer.SentHandshakeMessage(tagToTraceString(TagREJ), tagsForTracing(replyMap))
+	}
 	return serverReply.Bytes(), nil
 }
 
@@ -257,6 +322,7 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 		h.scfg.Get(),
 		certUncompressed,
 		h.diversificationNonce,
+		protocol.PerspectiveServer,
 	)
 	if err != nil {
 		return nil, err
@@ -280,6 +346,7 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 		h.scfg.Get(),
 		certUncompressed,
 		nil,
+		protocol.PerspectiveServer,
 	)
 	if err != nil {
 		return nil, err
@@ -290,16 +357,19 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 		return nil, err
 	}
 
-	replyMap := h.connectionParametersManager.GetSHLOMap()
+	replyMap := h.connectionParametersManager.GetHelloMap()
 	// add crypto parameters
 	replyMap[TagPUBS] = ephermalKex.PublicKey()
 	replyMap[TagSNO] = nonce
-	replyMap[TagVER] = protocol.SupportedVersionsAsTags
+	replyMap[TagVER] = protocol.VersionsAsTags(h.supportedVersions)
 
 	var reply bytes.Buffer
 	WriteHandshakeMessage(&reply, TagSHLO, replyMap)
+	if h.tracer != nil {
+		h.tracer.SentHandshakeMessage(tagToTraceString(TagSHLO), tagsForTracing(replyMap))
+	}
 
-	h.aeadChanged <- struct{}{}
+	signalAEADChanged(h.aeadChanged)
 
 	return reply.Bytes(), nil
 }
@@ -315,6 +385,18 @@ func (h *CryptoSetup) DiversificationNonce() []byte {
 	return h.diversificationNonce
 }
 
+// SetDiversificationNonce is used by the client only
+func (h *CryptoSetup) SetDiversificationNonce(data []byte) error {
+	return errors.New("CryptoSetup: SetDiversificationNonce called on the server")
+}
+
+// HandshakeComplete returns true after the SHLO was sent
+func (h *CryptoSetup) HandshakeComplete() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.forwardSecureAEAD != nil
+}
+
 // LockForSealing should be called before Seal(). It is needed so that diversification nonces can be obtained before packets are sealed, and the AEADs are not changed in the meantime.
 func (h *CryptoSetup) LockForSealing() {
 	h.mutex.RLock()
@@ -0,0 +1,479 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/qlog"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// The CryptoSetupClient handles all things crypto for the client Session
+type CryptoSetupClient struct {
+	hostname string
+	connID   protocol.ConnectionID
+	version  protocol.VersionNumber
+
+	cryptoStream utils.Stream
+
+	serverConfig *serverConfigClient
+
+	stk                  []byte
+	sno                  []byte
+	nonc                 []byte
+	proof                []byte
+	diversificationNonce []byte
+	chloForSignature     []byte
+	lastSentCHLO         []byte
+	certManager          crypto.CertManager
+
+	clientHelloCounter int
+	serverVerified     bool // has the certificate chain and the proof already been verified
+	sentFullCHLO       bool // was the last CHLO sent a full CHLO, i.e. does it contain the server config ID
+
+	keyDerivation KeyDerivationFunction
+
+	receivedSecurePacket        bool
+	receivedForwardSecurePacket bool
+	secureAEAD                  crypto.AEAD
+	forwardSecureAEAD           crypto.AEAD
+	aeadChanged                 chan struct{}
+
+	connectionParametersManager *ConnectionParametersManager
+
+	// tracer is nil if the connection is not traced
+	tracer qlog.ConnectionTracer
+	logger utils.Logger
+
+	mutex sync.RWMutex
+}
+
+var _ crypto.AEAD = &CryptoSetupClient{}
+
+var (
+	errNoObitForClientNonce             = errors.New("CryptoSetup BUG: No OBIT for client nonce available")
+	errClientNonceAlreadyExists         = errors.New("CryptoSetup BUG: A client nonce was already generated")
+	errConflictingDiversificationNonces = errors.New("Received two different diversification nonces")
+)
+
+// NewCryptoSetupClient creates a new CryptoSetup instance for a client
+func NewCryptoSetupClient(
+	hostname string,
+	connID protocol.ConnectionID,
+	version protocol.VersionNumber,
+	cryptoStream utils.Stream,
+	tlsConfig *tls.Config,
+	connectionParametersManager *ConnectionParametersManager,
+	tracer qlog.ConnectionTracer,
+	logger utils.Logger,
+	keyLogWriter io.Writer,
+	aeadChanged chan struct{},
+) (*CryptoSetupClient, error) {
+	return &CryptoSetupClient{
+		hostname:                    hostname,
+		connID:                      connID,
+		version:                     version,
+		cryptoStream:                cryptoStream,
+		certManager:                 crypto.NewCertManager(tlsConfig),
+		connectionParametersManager: connectionParametersManager,
+		keyDerivation:               getKeyDerivation(keyLogWriter, logger),
+		tracer:                      tracer,
+		logger:                      logger,
+		aeadChanged:                 aeadChanged,
+	}, nil
+}
+
+// HandleCryptoStream reads and writes messages on the crypto stream
+func (h *CryptoSetupClient) HandleCryptoStream() error {
+	for {
+		err := h.sendCHLO()
+		if err != nil {
+			return err
+		}
+
+		h.mutex.Lock()
+		err = h.maybeUpgradeCrypto()
+		h.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+
+		messageTag, cryptoData, err := ParseHandshakeMessage(h.cryptoStream)
+		if err != nil {
+			return qerr.HandshakeFailed
+		}
+		if h.tracer != nil {
+			h.tracer.ReceivedHandshakeMessage(tagToTraceString(messageTag), tagsForTracing(cryptoData))
+		}
+
+		switch messageTag {
+		case TagREJ:
+			h.logger.Debugf("Got REJ:\n%s", printHandshakeMessage(cryptoData))
+			err = h.handleREJMessage(cryptoData)
+			if err != nil {
+				return err
+			}
+		case TagSHLO:
+			h.logger.Debugf("Got SHLO:\n%s", printHandshakeMessage(cryptoData))
+			return h.handleSHLOMessage(cryptoData)
+		default:
+			return qerr.InvalidCryptoMessageType
+		}
+	}
+}
+
+func (h *CryptoSetupClient) handleREJMessage(cryptoData map[Tag][]byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var err error
+
+	if stk, ok := cryptoData[TagSTK]; ok {
+		h.stk = stk
+	}
+
+	if sno, ok := cryptoData[TagSNO]; ok {
+		h.sno = sno
+	}
+
+	// TODO: what happens if the server sends a different server config in two packets?
+	if scfg, ok := cryptoData[TagSCFG]; ok {
+		h.serverConfig, err = parseServerConfig(scfg)
+		if err != nil {
+			return err
+		}
+
+		if h.serverConfig.IsExpired() {
+			return qerr.CryptoServerConfigExpired
+		}
+
+		// now that we have a server config, we can use its OBIT value to generate a client nonce
+		if len(h.nonc) == 0 {
+			err = h.generateClientNonce()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if proof, ok := cryptoData[TagPROF]; ok {
+		h.proof = proof
+		h.chloForSignature = h.lastSentCHLO
+	}
+
+	if crt, ok := cryptoData[TagCERT]; ok {
+		err := h.certManager.SetData(crt)
+		if err != nil {
+			return qerr.Error(qerr.InvalidCryptoMessageParameter, "Certificate data invalid")
+		}
+
+		err = h.certManager.Verify(h.hostname)
+		if err != nil {
+			h.logger.Infof("Certificate validation failed: %s", err.Error())
+			return qerr.ProofInvalid
+		}
+	}
+
+	if h.serverConfig != nil && len(h.proof) != 0 && h.certManager.GetLeafCert() != nil {
+		validProof := h.certManager.VerifyServerProof(h.proof, h.chloForSignature, h.serverConfig.Get())
+		if !validProof {
+			h.logger.Infof("Server proof verification failed")
+			return qerr.ProofInvalid
+		}
+
+		h.serverVerified = true
+	}
+
+	return nil
+}
+
+func (h *CryptoSetupClient) handleSHLOMessage(cryptoData map[Tag][]byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.receivedSecurePacket {
+		return qerr.Error(qerr.CryptoEncryptionLevelIncorrect, "unencrypted SHLO message")
+	}
+
+	if sno, ok := cryptoData[TagSNO]; ok {
+		h.sno = sno
+	}
+
+	serverPubs, ok := cryptoData[TagPUBS]
+	if !ok {
+		return qerr.Error(qerr.CryptoMessageParameterNotFound, "PUBS")
+	}
+
+	verTags, ok := cryptoData[TagVER]
+	if !ok {
+		return qerr.Error(qerr.InvalidCryptoMessageParameter, "server hello missing version list")
+	}
+	if !h.validateVersionList(verTags) {
+		return qerr.Error(qerr.VersionNegotiationMismatch, "Downgrade attack detected")
+	}
+
+	nonce := append(h.nonc, h.sno...)
+
+	ephermalSharedSecret, err := h.serverConfig.kex.CalculateSharedKey(serverPubs)
+	if err != nil {
+		return err
+	}
+
+	leafCert := h.certManager.GetLeafCert()
+
+	h.forwardSecureAEAD, err = h.keyDerivation(
+		h.version,
+		true,
+		ephermalSharedSecret,
+		nonce,
+		h.connID,
+		h.lastSentCHLO,
+		h.serverConfig.Get(),
+		leafCert,
+		nil,
+		protocol.PerspectiveClient,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = h.connectionParametersManager.SetFromMap(cryptoData)
+	if err != nil {
+		return qerr.InvalidCryptoMessageParameter
+	}
+
+	signalAEADChanged(h.aeadChanged)
+
+	return nil
+}
+
+// validateVersionList checks that the version we're using is contained in the list of versions supported by the server
+func (h *CryptoSetupClient) validateVersionList(verTags []byte) bool {
+	if len(verTags)%4 != 0 {
+		return false
+	}
+	for i := 0; i < len(verTags); i += 4 {
+		v := protocol.VersionTagToNumber(binary.LittleEndian.Uint32(verTags[i : i+4]))
+		if v == h.version {
+			return true
+		}
+	}
+	return false
+}
+
+// Open a message
+func (h *CryptoSetupClient) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if h.forwardSecureAEAD != nil {
+		data, err := h.forwardSecureAEAD.Open(dst, src, packetNumber, associatedData)
+		if err == nil {
+			h.receivedForwardSecurePacket = true
+			return data, nil
+		}
+		if h.receivedForwardSecurePacket {
+			return nil, err
+		}
+	}
+
+	if h.secureAEAD != nil {
+		data, err := h.secureAEAD.Open(dst, src, packetNumber, associatedData)
+		if err == nil {
+			h.receivedSecurePacket = true
+			return data, nil
+		}
+		if h.receivedSecurePacket {
+			return nil, err
+		}
+	}
+
+	return (&crypto.NullAEAD{}).Open(dst, src, packetNumber, associatedData)
+}
+
+// Seal a message, call LockForSealing() before!
+func (h *CryptoSetupClient) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	if h.forwardSecureAEAD != nil {
+		return h.forwardSecureAEAD.Seal(dst, src, packetNumber, associatedData)
+	}
+	if h.secureAEAD != nil {
+		return h.secureAEAD.Seal(dst, src, packetNumber, associatedData)
+	}
+	return (&crypto.NullAEAD{}).Seal(dst, src, packetNumber, associatedData)
+}
+
+// DiversificationNonce is only sent by the server
+func (h *CryptoSetupClient) DiversificationNonce() []byte {
+	return nil
+}
+
+// SetDiversificationNonce sets the diversification nonce sent by the server in the public header
+// it has to be called before the first packet carrying a diversification nonce is opened
+func (h *CryptoSetupClient) SetDiversificationNonce(data []byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.diversificationNonce) == 0 {
+		h.diversificationNonce = data
+		return h.maybeUpgradeCrypto()
+	}
+	if !bytes.Equal(h.diversificationNonce, data) {
+		return errConflictingDiversificationNonces
+	}
+	return nil
+}
+
+// HandshakeComplete returns true after the SHLO was received
+func (h *CryptoSetupClient) HandshakeComplete() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.forwardSecureAEAD != nil
+}
+
+// LockForSealing should be called before Seal(). It is needed so that the AEADs are not changed in the meantime.
+func (h *CryptoSetupClient) LockForSealing() {
+	h.mutex.RLock()
+}
+
+// UnlockForSealing should be called after Seal() is complete, see LockForSealing().
+func (h *CryptoSetupClient) UnlockForSealing() {
+	h.mutex.RUnlock()
+}
+
+func (h *CryptoSetupClient) sendCHLO() error {
+	h.clientHelloCounter++
+	if h.clientHelloCounter > protocol.MaxClientHellos {
+		return qerr.Error(qerr.CryptoTooManyRejects, "too many REJ messages")
+	}
+
+	b := &bytes.Buffer{}
+
+	tags, err := h.getTags()
+	if err != nil {
+		return err
+	}
+	h.addPadding(tags)
+
+	h.logger.Debugf("Sending CHLO:\n%s", printHandshakeMessage(tags))
+	WriteHandshakeMessage(b, TagCHLO, tags)
+	if h.tracer != nil {
+		h.tracer.SentHandshakeMessage(tagToTraceString(TagCHLO), tagsForTracing(tags))
+	}
+
+	_, err = h.cryptoStream.Write(b.Bytes())
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	h.lastSentCHLO = b.Bytes()
+	_, h.sentFullCHLO = tags[TagSCID]
+	h.mutex.Unlock()
+
+	return nil
+}
+
+func (h *CryptoSetupClient) getTags() (map[Tag][]byte, error) {
+	tags := h.connectionParametersManager.GetHelloMap()
+	tags[TagSNI] = []byte(h.hostname)
+	tags[TagPDMD] = []byte("X509")
+	tags[TagCCS] = h.certManager.GetCommonCertificateHashes()
+
+	versionTag := make([]byte, 4)
+	binary.LittleEndian.PutUint32(versionTag, protocol.VersionNumberToTag(h.version))
+	tags[TagVER] = versionTag
+
+	if len(h.stk) > 0 {
+		tags[TagSTK] = h.stk
+	}
+
+	if len(h.sno) > 0 {
+		tags[TagSNO] = h.sno
+	}
+
+	if h.serverConfig != nil && h.serverVerified {
+		tags[TagSCID] = h.serverConfig.ID
+		tags[TagPUBS] = h.serverConfig.kex.PublicKey()
+		tags[TagNONC] = h.nonc
+		tags[TagKEXS] = []byte("C255")
+		tags[TagAEAD] = []byte("AESG")
+	}
+
+	return tags, nil
+}
+
+// add a TagPAD to a tagMap, such that the total size will be bigger than the ClientHelloMinimumSize
+func (h *CryptoSetupClient) addPadding(tags map[Tag][]byte) {
+	var size int
+	for _, tag := range tags {
+		size += 8 + len(tag) // 4 bytes for the tag + 4 bytes for the offset + the length of the data
+	}
+	paddingSize := protocol.ClientHelloMinimumSize - size
+	if paddingSize > 0 {
+		tags[TagPAD] = bytes.Repeat([]byte{0}, paddingSize)
+	}
+}
+
+// maybeUpgradeCrypto derives the secure AEAD as soon as all necessary values are available
+// the mutex has to be held when calling this function
+func (h *CryptoSetupClient) maybeUpgradeCrypto() error {
+	if h.secureAEAD != nil || !h.serverVerified || !h.sentFullCHLO {
+		return nil
+	}
+	// for version 33 and higher, the server's keys are diversified using the nonce sent in the public header
+	if h.version >= protocol.Version33 && len(h.diversificationNonce) == 0 {
+		return nil
+	}
+
+	var err error
+	h.secureAEAD, err = h.keyDerivation(
+		h.version,
+		false,
+		h.serverConfig.sharedSecret,
+		h.nonc,
+		h.connID,
+		h.lastSentCHLO,
+		h.serverConfig.Get(),
+		h.certManager.GetLeafCert(),
+		h.diversificationNonce,
+		protocol.PerspectiveClient,
+	)
+	if err != nil {
+		return err
+	}
+
+	signalAEADChanged(h.aeadChanged)
+	return nil
+}
+
+func (h *CryptoSetupClient) generateClientNonce() error {
+	if len(h.nonc) > 0 {
+		return errClientNonceAlreadyExists
+	}
+
+	nonc := make([]byte, 32)
+	binary.BigEndian.PutUint32(nonc, uint32(time.Now().Unix()))
+
+	if len(h.serverConfig.obit) != 8 {
+		return errNoObitForClientNonce
+	}
+
+	copy(nonc[4:12], h.serverConfig.obit)
+
+	_, err := rand.Read(nonc[12:])
+	if err != nil {
+		return err
+	}
+
+	h.nonc = nonc
+	return nil
+}
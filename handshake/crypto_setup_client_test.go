@@ -0,0 +1,448 @@
+package handshake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockCertManager struct {
+	setDataCalledWith []byte
+	setDataError      error
+
+	leafCert []byte
+
+	verifyServerProofResult bool
+	verifyServerProofCalled bool
+
+	verifyError  error
+	verifyCalled bool
+}
+
+func (m *mockCertManager) SetData(data []byte) error {
+	m.setDataCalledWith = data
+	return m.setDataError
+}
+
+func (m *mockCertManager) GetCommonCertificateHashes() []byte {
+	return []byte("common certs")
+}
+
+func (m *mockCertManager) GetLeafCert() []byte {
+	return m.leafCert
+}
+
+func (m *mockCertManager) VerifyServerProof(proof, chlo, serverConfigData []byte) bool {
+	m.verifyServerProofCalled = true
+	return m.verifyServerProofResult
+}
+
+func (m *mockCertManager) Verify(hostname string) error {
+	m.verifyCalled = true
+	return m.verifyError
+}
+
+var _ = Describe("Crypto setup", func() {
+	var (
+		cs          *CryptoSetupClient
+		certManager *mockCertManager
+		stream      *mockStream
+		tagMap      map[Tag][]byte
+	)
+
+	BeforeEach(func() {
+		stream = &mockStream{}
+		certManager = &mockCertManager{}
+		csInt, err := NewCryptoSetupClient("hostname", 0, protocol.Version34, stream, nil, NewConnectionParamatersManager(protocol.ReceiveStreamFlowControlWindow, protocol.ReceiveConnectionFlowControlWindow, protocol.MaxStreamsPerConnection, protocol.MaxIdleConnectionStateLifetime), nil, utils.DefaultLogger, nil, make(chan struct{}, 1))
+		Expect(err).ToNot(HaveOccurred())
+		cs = csInt
+		cs.certManager = certManager
+		cs.keyDerivation = mockKeyDerivation
+		expectedInitialNonceLen = 32
+		expectedFSNonceLen = 64
+		tagMap = make(map[Tag][]byte)
+	})
+
+	getServerConfig := func() []byte {
+		kex, err := crypto.NewCurve25519KEX()
+		Expect(err).ToNot(HaveOccurred())
+		scfg, err := NewServerConfig(kex, &mockSigner{})
+		Expect(err).ToNot(HaveOccurred())
+		return scfg.Get()
+	}
+
+	Context("Reading REJ", func() {
+		It("rejects handshake messages with the wrong message tag", func() {
+			WriteHandshakeMessage(&stream.dataToRead, TagCHLO, tagMap)
+			err := cs.HandleCryptoStream()
+			Expect(err).To(MatchError(qerr.InvalidCryptoMessageType))
+		})
+
+		It("errors on invalid handshake messages", func() {
+			stream.dataToRead.Write([]byte("invalid message"))
+			err := cs.HandleCryptoStream()
+			Expect(err).To(MatchError(qerr.HandshakeFailed))
+		})
+
+		It("saves the STK", func() {
+			tagMap[TagSTK] = []byte("foobar")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.stk).To(Equal([]byte("foobar")))
+		})
+
+		It("saves the server nonce", func() {
+			tagMap[TagSNO] = []byte("foobar")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.sno).To(Equal([]byte("foobar")))
+		})
+
+		It("saves the proof and the CHLO it was generated for", func() {
+			cs.lastSentCHLO = []byte("last CHLO")
+			tagMap[TagPROF] = []byte("proof")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.proof).To(Equal([]byte("proof")))
+			Expect(cs.chloForSignature).To(Equal([]byte("last CHLO")))
+		})
+
+		It("passes the certificates to the CertManager and verifies them", func() {
+			tagMap[TagCERT] = []byte("cert")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(certManager.setDataCalledWith).To(Equal([]byte("cert")))
+			Expect(certManager.verifyCalled).To(BeTrue())
+		})
+
+		It("errors if the certificate data can't be parsed", func() {
+			certManager.setDataError = errors.New("can't parse")
+			tagMap[TagCERT] = []byte("cert")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidCryptoMessageParameter, "Certificate data invalid")))
+		})
+
+		It("errors if the certificate chain is invalid", func() {
+			certManager.verifyError = errors.New("invalid")
+			tagMap[TagCERT] = []byte("cert")
+			err := cs.handleREJMessage(tagMap)
+			Expect(err).To(MatchError(qerr.ProofInvalid))
+		})
+
+		Context("Server Config", func() {
+			It("parses the server config and generates a client nonce", func() {
+				tagMap[TagSCFG] = getServerConfig()
+				err := cs.handleREJMessage(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.serverConfig).ToNot(BeNil())
+				Expect(cs.nonc).To(HaveLen(32))
+				Expect(cs.nonc[4:12]).To(Equal(cs.serverConfig.obit))
+			})
+
+			It("doesn't regenerate the client nonce when receiving a second server config", func() {
+				tagMap[TagSCFG] = getServerConfig()
+				err := cs.handleREJMessage(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				nonc := cs.nonc
+				err = cs.handleREJMessage(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.nonc).To(Equal(nonc))
+			})
+
+			It("errors on invalid server configs", func() {
+				tagMap[TagSCFG] = []byte("foobar")
+				err := cs.handleREJMessage(tagMap)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("errors on expired server configs", func() {
+				_, scfgMap, err := ParseHandshakeMessage(bytes.NewReader(getServerConfig()))
+				Expect(err).ToNot(HaveOccurred())
+				scfgMap[TagEXPY] = []byte{0x80, 0, 0, 0, 0, 0, 0, 0}
+				var b bytes.Buffer
+				WriteHandshakeMessage(&b, TagSCFG, scfgMap)
+				tagMap[TagSCFG] = b.Bytes()
+				err = cs.handleREJMessage(tagMap)
+				Expect(err).To(MatchError(qerr.CryptoServerConfigExpired))
+			})
+		})
+
+		Context("verifying the server proof", func() {
+			BeforeEach(func() {
+				tagMap[TagSCFG] = getServerConfig()
+				tagMap[TagPROF] = []byte("proof")
+				certManager.leafCert = []byte("leafcert")
+			})
+
+			It("verifies the signature", func() {
+				certManager.verifyServerProofResult = true
+				err := cs.handleREJMessage(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(certManager.verifyServerProofCalled).To(BeTrue())
+				Expect(cs.serverVerified).To(BeTrue())
+			})
+
+			It("rejects wrong signatures", func() {
+				certManager.verifyServerProofResult = false
+				err := cs.handleREJMessage(tagMap)
+				Expect(err).To(MatchError(qerr.ProofInvalid))
+				Expect(cs.serverVerified).To(BeFalse())
+			})
+
+			It("doesn't try to verify the signature before the certificates are available", func() {
+				certManager.leafCert = nil
+				err := cs.handleREJMessage(tagMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(certManager.verifyServerProofCalled).To(BeFalse())
+				Expect(cs.serverVerified).To(BeFalse())
+			})
+		})
+	})
+
+	Context("CHLO generation", func() {
+		It("is longer than the miminum client hello size", func() {
+			err := cs.sendCHLO()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.dataWritten.Len()).To(BeNumerically(">", protocol.ClientHelloMinimumSize))
+			Expect(cs.lastSentCHLO).To(Equal(stream.dataWritten.Bytes()))
+		})
+
+		It("doesn't send more than MaxClientHellos CHLOs", func() {
+			for i := 0; i < protocol.MaxClientHellos; i++ {
+				err := cs.sendCHLO()
+				Expect(err).ToNot(HaveOccurred())
+			}
+			err := cs.sendCHLO()
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoTooManyRejects, "too many REJ messages")))
+		})
+
+		It("has the right values for an inchoate CHLO", func() {
+			cs.certManager = crypto.NewCertManager(nil)
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags).To(HaveKeyWithValue(TagSNI, []byte("hostname")))
+			Expect(tags).To(HaveKeyWithValue(TagPDMD, []byte("X509")))
+			Expect(tags).To(HaveKey(TagCCS))
+			Expect(tags).ToNot(HaveKey(TagSCID))
+			verTag := make([]byte, 4)
+			binary.LittleEndian.PutUint32(verTag, protocol.VersionNumberToTag(protocol.Version34))
+			Expect(tags).To(HaveKeyWithValue(TagVER, verTag))
+		})
+
+		It("includes the STK and the server nonce", func() {
+			cs.certManager = crypto.NewCertManager(nil)
+			cs.stk = []byte("stk")
+			cs.sno = []byte("sno")
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags).To(HaveKeyWithValue(TagSTK, []byte("stk")))
+			Expect(tags).To(HaveKeyWithValue(TagSNO, []byte("sno")))
+		})
+
+		It("sends a full CHLO after the server was verified", func() {
+			cs.certManager = crypto.NewCertManager(nil)
+			var err error
+			cs.serverConfig, err = parseServerConfig(getServerConfig())
+			Expect(err).ToNot(HaveOccurred())
+			cs.nonc = []byte("client nonce")
+			cs.serverVerified = true
+			tags, err := cs.getTags()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags).To(HaveKeyWithValue(TagSCID, cs.serverConfig.ID))
+			Expect(tags).To(HaveKeyWithValue(TagNONC, []byte("client nonce")))
+			Expect(tags).To(HaveKeyWithValue(TagPUBS, cs.serverConfig.kex.PublicKey()))
+			Expect(tags).To(HaveKeyWithValue(TagKEXS, []byte("C255")))
+			Expect(tags).To(HaveKeyWithValue(TagAEAD, []byte("AESG")))
+		})
+	})
+
+	Context("Diversification Nonces", func() {
+		It("sets a diversification nonce", func() {
+			nonce := []byte("foobar")
+			err := cs.SetDiversificationNonce(nonce)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.diversificationNonce).To(Equal(nonce))
+		})
+
+		It("doesn't do anything when called multiple times with the same nonce", func() {
+			nonce := []byte("foobar")
+			err := cs.SetDiversificationNonce(nonce)
+			Expect(err).ToNot(HaveOccurred())
+			err = cs.SetDiversificationNonce(nonce)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.diversificationNonce).To(Equal(nonce))
+		})
+
+		It("rejects a different diversification nonce", func() {
+			err := cs.SetDiversificationNonce([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			err = cs.SetDiversificationNonce([]byte("raboof"))
+			Expect(err).To(MatchError(errConflictingDiversificationNonces))
+		})
+	})
+
+	Context("key derivation", func() {
+		BeforeEach(func() {
+			var err error
+			cs.serverConfig, err = parseServerConfig(getServerConfig())
+			Expect(err).ToNot(HaveOccurred())
+			cs.nonc = make([]byte, 32)
+			cs.serverVerified = true
+			cs.sentFullCHLO = true
+		})
+
+		It("waits for the diversification nonce before deriving the secure AEAD", func() {
+			err := cs.maybeUpgradeCrypto()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).To(BeNil())
+			err = cs.SetDiversificationNonce([]byte("div"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).ToNot(BeNil())
+			Expect(cs.aeadChanged).To(Receive())
+		})
+
+		It("doesn't derive the secure AEAD before the server was verified", func() {
+			cs.serverVerified = false
+			err := cs.SetDiversificationNonce([]byte("div"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).To(BeNil())
+		})
+
+		It("doesn't derive the secure AEAD before a full CHLO was sent", func() {
+			cs.sentFullCHLO = false
+			err := cs.SetDiversificationNonce([]byte("div"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).To(BeNil())
+		})
+
+		It("doesn't need a diversification nonce for QUIC 32", func() {
+			cs.version = protocol.Version32
+			err := cs.maybeUpgradeCrypto()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.secureAEAD).ToNot(BeNil())
+		})
+	})
+
+	Context("Reading SHLO", func() {
+		var serverVersionTag []byte
+
+		BeforeEach(func() {
+			var err error
+			cs.serverConfig, err = parseServerConfig(getServerConfig())
+			Expect(err).ToNot(HaveOccurred())
+			cs.nonc = make([]byte, 32)
+			cs.receivedSecurePacket = true
+			serverVersionTag = make([]byte, 4)
+			binary.LittleEndian.PutUint32(serverVersionTag, protocol.VersionNumberToTag(protocol.Version34))
+			kex, err := crypto.NewCurve25519KEX()
+			Expect(err).ToNot(HaveOccurred())
+			tagMap[TagPUBS] = kex.PublicKey()
+			tagMap[TagSNO] = make([]byte, 32)
+			tagMap[TagVER] = serverVersionTag
+		})
+
+		It("derives the forward secure AEAD", func() {
+			err := cs.handleSHLOMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.forwardSecureAEAD).ToNot(BeNil())
+			Expect(cs.HandshakeComplete()).To(BeTrue())
+			Expect(cs.aeadChanged).To(Receive())
+		})
+
+		It("doesn't block if the secure AEAD change wasn't handled yet", func(done Done) {
+			cs.aeadChanged <- struct{}{}
+			err := cs.handleSHLOMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.forwardSecureAEAD).ToNot(BeNil())
+			Expect(cs.aeadChanged).To(Receive())
+			close(done)
+		})
+
+		It("rejects unencrypted SHLOs", func() {
+			cs.receivedSecurePacket = false
+			err := cs.handleSHLOMessage(tagMap)
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoEncryptionLevelIncorrect, "unencrypted SHLO message")))
+			Expect(cs.HandshakeComplete()).To(BeFalse())
+		})
+
+		It("errors if the PUBS is missing", func() {
+			delete(tagMap, TagPUBS)
+			err := cs.handleSHLOMessage(tagMap)
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "PUBS")))
+		})
+
+		It("errors if the version list is missing", func() {
+			delete(tagMap, TagVER)
+			err := cs.handleSHLOMessage(tagMap)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidCryptoMessageParameter, "server hello missing version list")))
+		})
+
+		It("detects a downgrade attack", func() {
+			binary.LittleEndian.PutUint32(serverVersionTag, protocol.VersionNumberToTag(protocol.Version33))
+			err := cs.handleSHLOMessage(tagMap)
+			Expect(err).To(MatchError(qerr.Error(qerr.VersionNegotiationMismatch, "Downgrade attack detected")))
+		})
+
+		It("reads the connection parameters", func() {
+			tagMap[TagICSL] = []byte{13, 0, 0, 0}
+			err := cs.handleSHLOMessage(tagMap)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cs.connectionParametersManager.GetIdleConnectionStateLifetime()).To(Equal(13 * time.Second))
+		})
+	})
+
+	Context("Encryption", func() {
+		It("is unencrypted before the handshake", func() {
+			d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+			Expect(d).To(Equal((&crypto.NullAEAD{}).Seal(nil, []byte("foobar"), 0, []byte{})))
+			Expect(cs.HandshakeComplete()).To(BeFalse())
+		})
+
+		It("uses the secure AEAD after it was derived", func() {
+			cs.secureAEAD = &mockAEAD{}
+			d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+			Expect(d).To(Equal([]byte("foobar  normal sec")))
+		})
+
+		It("uses the forward secure AEAD after the SHLO was received", func() {
+			cs.secureAEAD = &mockAEAD{}
+			cs.forwardSecureAEAD = &mockAEAD{forwardSecure: true}
+			d := cs.Seal(nil, []byte("foobar"), 0, []byte{})
+			Expect(d).To(Equal([]byte("foobar forward sec")))
+		})
+
+		It("opens secure packets and remembers that it received one", func() {
+			cs.secureAEAD = &mockAEAD{}
+			d, err := cs.Open(nil, []byte("encrypted"), 0, []byte{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal([]byte("decrypted")))
+			Expect(cs.receivedSecurePacket).To(BeTrue())
+		})
+
+		It("doesn't accept unencrypted packets after receiving a secure packet", func() {
+			cs.secureAEAD = &mockAEAD{}
+			cs.receivedSecurePacket = true
+			nullSealed := (&crypto.NullAEAD{}).Seal(nil, []byte("foobar"), 0, []byte{})
+			_, err := cs.Open(nil, nullSealed, 0, []byte{})
+			Expect(err).To(MatchError("authentication failed"))
+		})
+
+		It("opens forward secure packets", func() {
+			cs.secureAEAD = &mockAEAD{}
+			cs.forwardSecureAEAD = &mockAEAD{forwardSecure: true}
+			d, err := cs.Open(nil, []byte("forward secure encrypted"), 0, []byte{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal([]byte("decrypted")))
+			Expect(cs.receivedForwardSecurePacket).To(BeTrue())
+		})
+	})
+})
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"errors"
 	"net"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -81,7 +84,7 @@ func (mockAEAD) DiversificationNonce() []byte { return nil }
 var expectedInitialNonceLen int
 var expectedFSNonceLen int
 
-func mockKeyDerivation(v protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte) (crypto.AEAD, error) {
+func mockKeyDerivation(v protocol.VersionNumber, forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte, pers protocol.Perspective) (crypto.AEAD, error) {
 	if forwardSecure {
 		Expect(nonces).To(HaveLen(expectedFSNonceLen))
 	} else {
@@ -110,6 +113,15 @@ func (s *mockStream) Write(p []byte) (int, error) {
 func (s *mockStream) Close() error                       { panic("not implemented") }
 func (mockStream) CloseRemote(offset protocol.ByteCount) { panic("not implemented") }
 func (s mockStream) StreamID() protocol.StreamID         { panic("not implemented") }
+func (mockStream) SetDeadline(time.Time) error           { panic("not implemented") }
+func (mockStream) SetReadDeadline(time.Time) error       { panic("not implemented") }
+func (mockStream) SetWriteDeadline(time.Time) error      { panic("not implemented") }
+func (mockStream) ReadContext(context.Context, []byte) (int, error) {
+	panic("not implemented")
+}
+func (mockStream) WriteContext(context.Context, []byte) (int, error) {
+	panic("not implemented")
+}
 
 type mockStkSource struct{}
 
@@ -161,8 +173,8 @@ var _ = Describe("Crypto setup", func() {
 		Expect(err).NotTo(HaveOccurred())
 		scfg.stkSource = &mockStkSource{}
 		v := protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
-		cpm = NewConnectionParamatersManager()
-		cs, err = NewCryptoSetup(protocol.ConnectionID(42), ip, v, scfg, stream, cpm, aeadChanged)
+		cpm = NewConnectionParamatersManager(protocol.ReceiveStreamFlowControlWindow, protocol.ReceiveConnectionFlowControlWindow, protocol.MaxStreamsPerConnection, protocol.MaxIdleConnectionStateLifetime)
+		cs, err = NewCryptoSetup(protocol.ConnectionID(42), ip, v, scfg, stream, cpm, protocol.SupportedVersions, nil, utils.DefaultLogger, nil, aeadChanged)
 		Expect(err).NotTo(HaveOccurred())
 		cs.keyDerivation = mockKeyDerivation
 		cs.keyExchange = func() crypto.KeyExchange { return &mockKEX{ephermal: true} }
@@ -282,6 +294,31 @@ var _ = Describe("Crypto setup", func() {
 			Expect(aeadChanged).To(Receive())
 		})
 
+		It("handles a CHLO read by the caller", func() {
+			data := &bytes.Buffer{}
+			WriteHandshakeMessage(data, TagCHLO, map[Tag][]byte{
+				TagSCID: scfg.ID,
+				TagSNI:  []byte("quic.clemente.io"),
+				TagNONC: nonce32,
+				TagSTK:  validSTK,
+				TagPUBS: nil,
+			})
+			chlo := data.Bytes()
+			buf := bytes.NewBuffer(chlo[:len(chlo)-1])
+			done, err := cs.HandleCryptoData(buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(done).To(BeFalse())
+			Expect(buf.Len()).To(Equal(len(chlo) - 1))
+			Expect(stream.dataWritten.Len()).To(BeZero())
+			buf.WriteByte(chlo[len(chlo)-1])
+			done, err = cs.HandleCryptoData(buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(done).To(BeTrue())
+			Expect(buf.Len()).To(BeZero())
+			Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+			Expect(aeadChanged).To(Receive())
+		})
+
 		It("recognizes inchoate CHLOs missing SCID", func() {
 			Expect(cs.isInchoateCHLO(map[Tag][]byte{TagPUBS: nil})).To(BeTrue())
 		})
@@ -331,6 +368,13 @@ var _ = Describe("Crypto setup", func() {
 		Expect(err).To(MatchError(qerr.InvalidCryptoMessageType))
 	})
 
+	It("errors with non-CHLO message read by the caller", func() {
+		data := &bytes.Buffer{}
+		WriteHandshakeMessage(data, TagPAD, nil)
+		_, err := cs.HandleCryptoData(data)
+		Expect(err).To(MatchError(qerr.InvalidCryptoMessageType))
+	})
+
 	Context("escalating crypto", func() {
 		foobarFNVSigned := []byte{0x18, 0x6f, 0x44, 0xba, 0x97, 0x35, 0xd, 0x6f, 0xbf, 0x64, 0x3c, 0x79, 0x66, 0x6f, 0x6f, 0x62, 0x61, 0x72}
 
@@ -445,4 +489,16 @@ var _ = Describe("Crypto setup", func() {
 			Expect(stream.dataWritten.Bytes()).To(ContainSubstring(string(validSTK)))
 		})
 	})
+
+	Context("key log", func() {
+		It("writes the derived keys to the key log writer", func() {
+			keyLog := &bytes.Buffer{}
+			cs, err := NewCryptoSetup(protocol.ConnectionID(42), ip, protocol.Version33, scfg, stream, cpm, protocol.SupportedVersions, nil, utils.DefaultLogger, keyLog, aeadChanged)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cs.keyDerivation(protocol.Version33, true, []byte("secret"), []byte("nonces"), 42, []byte("chlo"), []byte("scfg"), []byte("cert"), nil, protocol.PerspectiveServer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keyLog.String()).To(HavePrefix("QUIC_FORWARD_SECURE_KEYS 000000000000002a "))
+		})
+
+	})
 })
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
@@ -104,6 +105,28 @@ func printHandshakeMessage(data map[Tag][]byte) string {
 	return res
 }
 
+// tagsForTracing returns the tags contained in a handshake message, in ascending order
+func tagsForTracing(data map[Tag][]byte) []string {
+	tags := make([]uint32, 0, len(data))
+	for t := range data {
+		if t == TagPAD {
+			continue
+		}
+		tags = append(tags, uint32(t))
+	}
+	sort.Sort(utils.Uint32Slice(tags))
+	res := make([]string, len(tags))
+	for i, t := range tags {
+		res[i] = tagToTraceString(Tag(t))
+	}
+	return res
+}
+
+// tagToTraceString is the same as tagToString, without the padding of shorter tags
+func tagToTraceString(tag Tag) string {
+	return strings.TrimRight(tagToString(tag), " ")
+}
+
 func tagToString(tag Tag) string {
 	b := make([]byte, 4)
 	binary.LittleEndian.PutUint32(b, uint32(tag))
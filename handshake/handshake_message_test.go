@@ -42,4 +42,20 @@ var _ = Describe("Handshake Message", func() {
 			Expect(b.Bytes()).To(Equal(sampleCHLO))
 		})
 	})
+
+	Context("tracing", func() {
+		It("gets the sorted tags without padding", func() {
+			tags := tagsForTracing(map[Tag][]byte{
+				TagSNI:  []byte("quic.clemente.io"),
+				TagVER:  []byte("Q034"),
+				TagPAD:  []byte("0000"),
+				TagAEAD: []byte("AESG"),
+			})
+			Expect(tags).To(Equal([]string{"SNI", "VER", "AEAD"}))
+		})
+
+		It("removes the padding from short message tags", func() {
+			Expect(tagToTraceString(TagREJ)).To(Equal("REJ"))
+		})
+	})
 })
@@ -14,6 +14,8 @@ type ServerConfig struct {
 	signer    crypto.Signer
 	ID        []byte
 	stkSource crypto.StkSource
+	// raw is the serialized server config, it doesn't change after the server config was created
+	raw []byte
 }
 
 // NewServerConfig creates a new server config
@@ -33,16 +35,23 @@ func NewServerConfig(kex crypto.KeyExchange, signer crypto.Signer) (*ServerConfi
 		return nil, err
 	}
 
-	return &ServerConfig{
+	s := &ServerConfig{
 		kex:       kex,
 		signer:    signer,
 		ID:        id,
 		stkSource: stkSource,
-	}, nil
+	}
+	s.raw = s.serialize()
+	return s, nil
 }
 
 // Get the server config binary representation
+// The returned slice is shared, and must not be modified.
 func (s *ServerConfig) Get() []byte {
+	return s.raw
+}
+
+func (s *ServerConfig) serialize() []byte {
 	var serverConfig bytes.Buffer
 	WriteHandshakeMessage(&serverConfig, TagSCFG, map[Tag][]byte{
 		TagSCID: s.ID,
@@ -0,0 +1,165 @@
+package handshake
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server Config", func() {
+	var tagMap map[Tag][]byte
+
+	BeforeEach(func() {
+		kex, err := crypto.NewCurve25519KEX()
+		Expect(err).ToNot(HaveOccurred())
+		scfg, err := NewServerConfig(kex, &mockSigner{})
+		Expect(err).ToNot(HaveOccurred())
+		_, tagMap, err = ParseHandshakeMessage(bytes.NewReader(scfg.Get()))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	getServerConfig := func() []byte {
+		var b bytes.Buffer
+		WriteHandshakeMessage(&b, TagSCFG, tagMap)
+		return b.Bytes()
+	}
+
+	It("parses the server config generated by the server", func() {
+		data := getServerConfig()
+		scfg, err := parseServerConfig(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scfg.ID).To(Equal(tagMap[TagSCID]))
+		Expect(scfg.obit).To(Equal(tagMap[TagOBIT]))
+		Expect(scfg.sharedSecret).ToNot(BeEmpty())
+		Expect(scfg.kex).ToNot(BeNil())
+		Expect(scfg.IsExpired()).To(BeFalse())
+		Expect(scfg.Get()).To(Equal(data))
+	})
+
+	It("rejects messages that are not server configs", func() {
+		var b bytes.Buffer
+		WriteHandshakeMessage(&b, TagCHLO, tagMap)
+		_, err := parseServerConfig(b.Bytes())
+		Expect(err).To(MatchError(errMessageNotServerConfig))
+	})
+
+	It("rejects invalid messages", func() {
+		_, err := parseServerConfig([]byte("foobar"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("SCID", func() {
+		It("errors if it is missing", func() {
+			delete(tagMap, TagSCID)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "SCID")))
+		})
+
+		It("errors if it has the wrong length", func() {
+			tagMap[TagSCID] = bytes.Repeat([]byte{'F'}, 13)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "SCID")))
+		})
+	})
+
+	Context("KEXS", func() {
+		It("errors if it is missing", func() {
+			delete(tagMap, TagKEXS)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "KEXS")))
+		})
+
+		It("errors if it has the wrong length", func() {
+			tagMap[TagKEXS] = []byte("C255foo")
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "KEXS")))
+		})
+
+		It("errors if it doesn't contain C255", func() {
+			tagMap[TagKEXS] = []byte("P256")
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoNoSupport, "KEXS")))
+		})
+	})
+
+	Context("AEAD", func() {
+		It("errors if it is missing", func() {
+			delete(tagMap, TagAEAD)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "AEAD")))
+		})
+
+		It("errors if it has the wrong length", func() {
+			tagMap[TagAEAD] = []byte("AESGfoo")
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "AEAD")))
+		})
+
+		It("accepts a list that contains AESG", func() {
+			tagMap[TagAEAD] = []byte("CC20AESG")
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("errors if it doesn't contain AESG", func() {
+			tagMap[TagAEAD] = []byte("CC20")
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoNoSupport, "AEAD")))
+		})
+	})
+
+	Context("PUBS", func() {
+		It("errors if it is missing", func() {
+			delete(tagMap, TagPUBS)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "PUBS")))
+		})
+
+		It("errors if it has the wrong length", func() {
+			tagMap[TagPUBS] = bytes.Repeat([]byte{'F'}, 34)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "PUBS")))
+		})
+	})
+
+	Context("OBIT", func() {
+		It("errors if it is missing", func() {
+			delete(tagMap, TagOBIT)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "OBIT")))
+		})
+
+		It("errors if it has the wrong length", func() {
+			tagMap[TagOBIT] = bytes.Repeat([]byte{'F'}, 7)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "OBIT")))
+		})
+	})
+
+	Context("EXPY", func() {
+		It("errors if it is missing", func() {
+			delete(tagMap, TagEXPY)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoMessageParameterNotFound, "EXPY")))
+		})
+
+		It("errors if it has the wrong length", func() {
+			tagMap[TagEXPY] = bytes.Repeat([]byte{'F'}, 7)
+			_, err := parseServerConfig(getServerConfig())
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoInvalidValueLength, "EXPY")))
+		})
+
+		It("detects expired server configs", func() {
+			tagMap[TagEXPY] = []byte{0x10, 0, 0, 0, 0, 0, 0, 0}
+			scfg, err := parseServerConfig(getServerConfig())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(scfg.expiry).To(Equal(time.Unix(0x10, 0)))
+			Expect(scfg.IsExpired()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,126 @@
+package quic
+
+import (
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
+)
+
+// Stream is the interface implemented by QUIC streams
+type Stream interface {
+	utils.Stream
+	// Reset aborts the stream in both directions, and sends a RST_STREAM frame with the given error code to the peer.
+	// Data that was written, but not yet sent, is discarded.
+	Reset(errorCode uint32)
+	// SetPriority sets the priority of the stream, which determines when the stream gets to send data.
+	// The crypto and the headers stream are always sent first, setting their priority has no effect.
+	SetPriority(StreamPriority) error
+}
+
+// A StreamPriority is the priority of a stream
+// Streams of a higher priority level are always sent before streams of a lower level, as in SPDY.
+// Streams of the same level share the bandwidth according to their weights, as in HTTP/2.
+type StreamPriority struct {
+	// Level is the priority level, from 0 (highest) to protocol.LowestStreamPriorityLevel
+	Level uint8
+	// Weight is the weight, from 1 to protocol.MaxStreamWeight
+	Weight uint16
+}
+
+// A Session is a QUIC connection between two peers.
+type Session interface {
+	// AcceptStream returns the next stream opened by the peer, blocking until one is available.
+	// Since the number of streams a peer may open is limited, not accepting streams applies backpressure to the peer.
+	// Streams are returned in the order of their IDs. Opening a stream implicitly opens all streams of the peer with lower IDs.
+	AcceptStream() (Stream, error)
+	// OpenStream opens a new stream, using the next available stream ID.
+	// Servers open streams with even IDs, clients with odd IDs.
+	// If the maximum number of streams negotiated with the peer is reached, it returns qerr.TooManyOpenStreams.
+	OpenStream() (Stream, error)
+	// OpenStreamSync opens a new stream, using the next available stream ID.
+	// If the maximum number of streams negotiated with the peer is reached, it blocks until a stream is closed.
+	OpenStreamSync() (Stream, error)
+	// GetOrOpenStream returns the stream with the given ID, opening it if it doesn't exist yet.
+	GetOrOpenStream(protocol.StreamID) (Stream, error)
+	// GoAway sends a GOAWAY frame to the peer. Streams opened by the peer after that are rejected, while streams that already exist are not affected.
+	GoAway(errorCode qerr.ErrorCode, reason string) error
+	// LocalAddr returns the local address.
+	LocalAddr() net.Addr
+	// RemoteAddr returns the address of the peer.
+	RemoteAddr() net.Addr
+	// Close closes the connection. The error will be sent to the remote peer in a CONNECTION_CLOSE frame. An error value of nil is allowed and will cause a normal PeerGoingAway to be sent.
+	Close(error) error
+	// Stats returns a snapshot of the transport statistics of the connection.
+	Stats() ConnectionStats
+}
+
+// ConnectionStats are the transport statistics of a session
+// The RTT, congestion and packet statistics are updated every time the session handled a packet or a timer event.
+type ConnectionStats struct {
+	SmoothedRTT time.Duration
+	MinRTT      time.Duration
+	LatestRTT   time.Duration
+
+	CongestionWindow   protocol.ByteCount
+	SlowStartThreshold protocol.ByteCount
+	BytesInFlight      protocol.ByteCount
+
+	PacketsSent     uint64
+	BytesSent       protocol.ByteCount
+	PacketsReceived uint64
+	BytesReceived   protocol.ByteCount
+	// PacketsLost and BytesLost count the packets that were declared lost, either by a NACK or a retransmission timeout
+	PacketsLost uint64
+	BytesLost   protocol.ByteCount
+	// PacketsRetransmitted counts the lost packets whose frames were sent again
+	PacketsRetransmitted uint64
+	// PacketsUndecryptable counts the packets that couldn't be decrypted when they were received, because the keys weren't available yet
+	PacketsUndecryptable uint64
+	// PacketsDropped counts the packets that were discarded without being processed, either because too many packets were queued, or because they were duplicates
+	PacketsDropped uint64
+
+	// SendWindow and ReceiveWindow are the remaining connection level flow control windows
+	SendWindow    protocol.ByteCount
+	ReceiveWindow protocol.ByteCount
+
+	// Streams contains the statistics of all open streams, including the crypto and the headers stream
+	Streams map[protocol.StreamID]StreamStats
+}
+
+// StreamStats are the statistics of a single stream
+type StreamStats struct {
+	BytesSent protocol.ByteCount
+	// BytesReceived is the highest byte offset received from the peer
+	BytesReceived protocol.ByteCount
+	BytesRead     protocol.ByteCount
+	// SendWindow and ReceiveWindow are the remaining stream level flow control windows
+	SendWindow    protocol.ByteCount
+	ReceiveWindow protocol.ByteCount
+}
+
+// A ConnectionMigration describes a change of the peer's address
+type ConnectionMigration struct {
+	OldAddr net.Addr
+	NewAddr net.Addr
+	// NATRebinding is true if only the port changed, which is usually caused by a NAT rebinding.
+	// The congestion controller and the RTT estimate are only reset if the IP address changed.
+	NATRebinding bool
+}
+
+// A Listener for incoming QUIC connections
+type Listener interface {
+	// Close the server, sending CONNECTION_CLOSE frames to each peer.
+	Close() error
+	// Shutdown gracefully shuts down the listener. It stops accepting new connections and sends a GOAWAY frame to each peer.
+	// It then waits until all streams are finished or the context is done, and closes all sessions and the underlying connection.
+	// A stream is finished once all data sent on it was acknowledged. The crypto and the headers stream are not waited for.
+	Shutdown(context.Context) error
+	// Addr returns the local network addr that the server is listening on.
+	Addr() net.Addr
+	// Accept returns new sessions. It should be called in a loop.
+	Accept() (Session, error)
+}
@@ -1,7 +1,6 @@
 package quic
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 
@@ -12,28 +11,39 @@ import (
 )
 
 type packedPacket struct {
+	header     *publicHeader
 	number     protocol.PacketNumber
 	entropyBit bool
 	raw        []byte
 	frames     []frames.Frame
+	// buffer is the packet buffer that raw is part of
+	buffer *packetBuffer
 }
 
 type packetPacker struct {
 	connectionID     protocol.ConnectionID
+	perspective      protocol.Perspective
 	version          protocol.VersionNumber
-	cryptoSetup      *handshake.CryptoSetup
+	cryptoSetup      cryptoSetup
 	lastPacketNumber protocol.PacketNumber
 
 	connectionParametersManager *handshake.ConnectionParametersManager
 
 	streamFramer  *streamFramer
 	controlFrames []frames.Frame
+
+	// header, packet and payloadFrames are reused for every packet, so that packing a packet doesn't allocate
+	// A packed packet is only valid until the next packet is packed.
+	header        publicHeader
+	packet        packedPacket
+	payloadFrames []frames.Frame
 }
 
-func newPacketPacker(connectionID protocol.ConnectionID, cryptoSetup *handshake.CryptoSetup, connectionParametersHandler *handshake.ConnectionParametersManager, streamFramer *streamFramer, version protocol.VersionNumber) *packetPacker {
+func newPacketPacker(connectionID protocol.ConnectionID, cryptoSetup cryptoSetup, connectionParametersHandler *handshake.ConnectionParametersManager, streamFramer *streamFramer, perspective protocol.Perspective, version protocol.VersionNumber) *packetPacker {
 	return &packetPacker{
 		cryptoSetup:                 cryptoSetup,
 		connectionID:                connectionID,
+		perspective:                 perspective,
 		connectionParametersManager: connectionParametersHandler,
 		version:                     version,
 		streamFramer:                streamFramer,
@@ -55,21 +65,31 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, con
 
 	currentPacketNumber := p.lastPacketNumber + 1
 
+	// the client sends the version in every packet, until the handshake is complete
+	// this has to be checked before locking the cryptoSetup for sealing
+	sendVersion := p.perspective == protocol.PerspectiveClient && !p.cryptoSetup.HandshakeComplete()
+
 	// cryptoSetup needs to be locked here, so that the AEADs are not changed between
 	// calling DiversificationNonce() and Seal().
 	p.cryptoSetup.LockForSealing()
 	defer p.cryptoSetup.UnlockForSealing()
 
 	packetNumberLen := protocol.GetPacketNumberLengthForPublicHeader(currentPacketNumber, largestObserved)
-	responsePublicHeader := &publicHeader{
+	p.header = publicHeader{
 		ConnectionID:         p.connectionID,
 		PacketNumber:         currentPacketNumber,
 		PacketNumberLen:      packetNumberLen,
-		TruncateConnectionID: p.connectionParametersManager.TruncateConnectionID(),
+		TruncateConnectionID: p.perspective == protocol.PerspectiveServer && p.connectionParametersManager.TruncateConnectionID(),
 		DiversificationNonce: p.cryptoSetup.DiversificationNonce(),
 	}
+	responsePublicHeader := &p.header
+
+	if sendVersion {
+		responsePublicHeader.VersionFlag = true
+		responsePublicHeader.VersionNumber = p.version
+	}
 
-	publicHeaderLength, err := responsePublicHeader.GetLength()
+	publicHeaderLength, err := responsePublicHeader.GetLength(p.perspective)
 	if err != nil {
 		return nil, err
 	}
@@ -81,13 +101,14 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, con
 
 	var payloadFrames []frames.Frame
 	if onlySendOneControlFrame {
-		payloadFrames = []frames.Frame{controlFrames[0]}
+		payloadFrames = append(p.payloadFrames[:0], controlFrames[0])
 	} else {
 		payloadFrames, err = p.composeNextPacket(stopWaitingFrame, publicHeaderLength)
 		if err != nil {
 			return nil, err
 		}
 	}
+	p.payloadFrames = payloadFrames
 
 	// Check if we have enough frames to send
 	if len(payloadFrames) == 0 {
@@ -110,53 +131,62 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, con
 		}
 	}
 
-	raw := getPacketBuffer()
-	buffer := bytes.NewBuffer(raw)
-
-	if responsePublicHeader.WritePublicHeader(buffer, p.version) != nil {
+	packetBuffer := getPacketBuffer()
+	raw, err := responsePublicHeader.AppendTo(packetBuffer.Slice, p.perspective, p.version)
+	if err != nil {
+		packetBuffer.Release()
 		return nil, err
 	}
 
-	payloadStartIndex := buffer.Len()
+	payloadStartIndex := len(raw)
 
 	// set entropy bit in Private Header, for QUIC version < 34
 	var entropyBit bool
 	if p.version < protocol.Version34 {
 		entropyBit, err = utils.RandomBit()
 		if err != nil {
+			packetBuffer.Release()
 			return nil, err
 		}
 		if entropyBit {
-			buffer.WriteByte(1)
+			raw = append(raw, 1)
 		} else {
-			buffer.WriteByte(0)
+			raw = append(raw, 0)
 		}
 	}
 
 	for _, frame := range payloadFrames {
-		frame.Write(buffer, p.version)
+		raw, err = frame.AppendTo(raw, p.version)
+		if err != nil {
+			packetBuffer.Release()
+			return nil, err
+		}
 	}
 
-	if protocol.ByteCount(buffer.Len()+12) > protocol.MaxPacketSize {
+	if protocol.ByteCount(len(raw)+12) > protocol.MaxPacketSize {
+		packetBuffer.Release()
 		return nil, errors.New("PacketPacker BUG: packet too large")
 	}
 
-	raw = raw[0:buffer.Len()]
+	// seal in place, the packet buffer has enough capacity for the authentication tag
 	p.cryptoSetup.Seal(raw[payloadStartIndex:payloadStartIndex], raw[payloadStartIndex:], currentPacketNumber, raw[:payloadStartIndex])
-	raw = raw[0 : buffer.Len()+12]
+	raw = raw[0 : len(raw)+12]
 
 	p.lastPacketNumber++
-	return &packedPacket{
+	p.packet = packedPacket{
+		header:     responsePublicHeader,
 		number:     currentPacketNumber,
 		entropyBit: entropyBit,
 		raw:        raw,
 		frames:     payloadFrames,
-	}, nil
+		buffer:     packetBuffer,
+	}
+	return &p.packet, nil
 }
 
 func (p *packetPacker) composeNextPacket(stopWaitingFrame *frames.StopWaitingFrame, publicHeaderLength protocol.ByteCount) ([]frames.Frame, error) {
 	var payloadLength protocol.ByteCount
-	var payloadFrames []frames.Frame
+	payloadFrames := p.payloadFrames[:0]
 
 	maxFrameSize := protocol.MaxFrameAndPublicHeaderSize - publicHeaderLength
 
@@ -174,16 +204,22 @@ func (p *packetPacker) composeNextPacket(stopWaitingFrame *frames.StopWaitingFra
 		payloadLength += minLength
 	}
 
-	for len(p.controlFrames) > 0 {
-		frame := p.controlFrames[0]
+	var numControlFrames int
+	for _, frame := range p.controlFrames {
 		minLength, _ := frame.MinLength(p.version) // controlFrames does not contain any StopWaitingFrames. So it will *never* return an error
 		if payloadLength+minLength > maxFrameSize {
 			break
 		}
 		payloadFrames = append(payloadFrames, frame)
 		payloadLength += minLength
-		p.controlFrames = p.controlFrames[1:]
+		numControlFrames++
+	}
+	// move the remaining control frames to the front, so that the slice can be reused
+	n := copy(p.controlFrames, p.controlFrames[numControlFrames:])
+	for i := n; i < len(p.controlFrames); i++ {
+		p.controlFrames[i] = nil
 	}
+	p.controlFrames = p.controlFrames[:n]
 
 	if payloadLength > maxFrameSize {
 		return nil, fmt.Errorf("Packet Packer BUG: packet payload (%d) too large (%d)", payloadLength, maxFrameSize)
@@ -199,7 +235,6 @@ func (p *packetPacker) composeNextPacket(stopWaitingFrame *frames.StopWaitingFra
 		fs[len(fs)-1].DataLenPresent = false
 	}
 
-	// TODO: Simplify
 	for _, f := range fs {
 		payloadFrames = append(payloadFrames, f)
 	}
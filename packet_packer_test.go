@@ -3,7 +3,9 @@ package quic
 import (
 	"bytes"
 	"sync"
+	"testing"
 
+	"github.com/lucas-clemente/quic-go/ackhandlerlegacy"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -11,25 +13,64 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// raceEnabled is set when running with the race detector, sync.Pool doesn't reuse all objects then
+var raceEnabled bool
+
+// mockCryptoSetup seals packets without encrypting them
+type mockCryptoSetup struct{}
+
+func (*mockCryptoSetup) HandleCryptoStream() error { panic("not implemented") }
+func (*mockCryptoSetup) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	panic("not implemented")
+}
+func (*mockCryptoSetup) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	return append(dst, src...)
+}
+func (*mockCryptoSetup) LockForSealing()                      {}
+func (*mockCryptoSetup) UnlockForSealing()                    {}
+func (*mockCryptoSetup) HandshakeComplete() bool              { return true }
+func (*mockCryptoSetup) DiversificationNonce() []byte         { return nil }
+func (*mockCryptoSetup) SetDiversificationNonce([]byte) error { panic("not implemented") }
+
 var _ = Describe("Packet packer", func() {
 	var (
 		packer          *packetPacker
 		publicHeaderLen protocol.ByteCount
 		streamFramer    *streamFramer
+		streamsMap      map[protocol.StreamID]*stream
 	)
 
+	// queueForRetransmission puts the data of the frame into the send buffer of the stream, as if it had been sent before, and queues the frame for retransmission
+	queueForRetransmission := func(f *frames.StreamFrame) {
+		str, ok := streamsMap[f.StreamID]
+		if !ok {
+			str = &stream{streamID: f.StreamID, sendBuffer: newSendBuffer(protocol.MaxByteCount)}
+			streamsMap[f.StreamID] = str
+		}
+		if end := str.sendBuffer.End(); end < f.Offset {
+			str.sendBuffer.Write(make([]byte, f.Offset-end))
+		}
+		if end := str.sendBuffer.End(); end < f.Offset+f.DataLen() {
+			str.sendBuffer.Write(f.Data[end-f.Offset:])
+		}
+		str.writeOffset = str.sendBuffer.End()
+		streamFramer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(f))
+	}
+
 	BeforeEach(func() {
 		fcm := newMockFlowControlHandler()
 		fcm.sendWindowSizes[3] = protocol.MaxByteCount
 		fcm.sendWindowSizes[5] = protocol.MaxByteCount
 		fcm.sendWindowSizes[7] = protocol.MaxByteCount
 
-		streamFramer = newStreamFramer(&map[protocol.StreamID]*stream{}, &sync.RWMutex{}, fcm)
+		streamsMap = make(map[protocol.StreamID]*stream)
+		streamFramer = newStreamFramer(&streamsMap, &sync.RWMutex{}, fcm, newPriorityScheduler())
 
 		packer = &packetPacker{
 			cryptoSetup:                 &handshake.CryptoSetup{},
-			connectionParametersManager: handshake.NewConnectionParamatersManager(),
+			connectionParametersManager: handshake.NewConnectionParamatersManager(protocol.ReceiveStreamFlowControlWindow, protocol.ReceiveConnectionFlowControlWindow, protocol.MaxStreamsPerConnection, protocol.MaxIdleConnectionStateLifetime),
 			streamFramer:                streamFramer,
+			perspective:                 protocol.PerspectiveServer,
 		}
 		publicHeaderLen = 1 + 8 + 1 // 1 flag byte, 8 connection ID, 1 packet number
 		packer.version = protocol.Version34
@@ -54,18 +95,20 @@ var _ = Describe("Packet packer", func() {
 		}
 		// pack the packet for QUIC version 33
 		packer.version = protocol.Version33
-		streamFramer.AddFrameForRetransmission(f)
+		queueForRetransmission(f)
 		p33, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(p33).ToNot(BeNil())
+		// the packed packet is reused when packing the next packet
+		p33Len := len(p33.raw)
 		// pack the packet for QUIC version 34
 		packer.version = protocol.Version34
-		streamFramer.AddFrameForRetransmission(f)
+		queueForRetransmission(f)
 		p34, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(p34).ToNot(BeNil())
 		Expect(p34.entropyBit).To(BeFalse())
-		Expect(p34.raw).To(HaveLen(len(p33.raw) - 1))
+		Expect(p34.raw).To(HaveLen(p33Len - 1))
 	})
 
 	It("packs single packets", func() {
@@ -73,7 +116,7 @@ var _ = Describe("Packet packer", func() {
 			StreamID: 5,
 			Data:     []byte{0xDE, 0xCA, 0xFB, 0xAD},
 		}
-		streamFramer.AddFrameForRetransmission(f)
+		queueForRetransmission(f)
 		p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(p).ToNot(BeNil())
@@ -113,8 +156,15 @@ var _ = Describe("Packet packer", func() {
 		Expect(p.raw).NotTo(BeEmpty())
 	})
 
+	It("packs the packet into a packet buffer", func() {
+		p, err := packer.PackPacket(nil, []frames.Frame{&frames.ConnectionCloseFrame{}}, 0, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.buffer).ToNot(BeNil())
+		Expect(&p.raw[0]).To(Equal(&p.buffer.Slice[:1][0]))
+	})
+
 	It("packs a StopWaitingFrame first", func() {
-		swf := &frames.StopWaitingFrame{LeastUnacked: 10}
+		swf := &frames.StopWaitingFrame{LeastUnacked: 1}
 		p, err := packer.PackPacket(swf, []frames.Frame{&frames.ConnectionCloseFrame{}}, 0, true)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(p).ToNot(BeNil())
@@ -187,7 +237,7 @@ var _ = Describe("Packet packer", func() {
 			StreamID: 5,
 			Data:     []byte{0xDE, 0xCA, 0xFB, 0xAD},
 		}
-		streamFramer.AddFrameForRetransmission(f)
+		queueForRetransmission(f)
 		p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 		Expect(p).ToNot(BeNil())
 		Expect(err).ToNot(HaveOccurred())
@@ -196,7 +246,7 @@ var _ = Describe("Packet packer", func() {
 		Expect(p).To(BeNil())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(packer.lastPacketNumber).To(Equal(protocol.PacketNumber(1)))
-		streamFramer.AddFrameForRetransmission(f)
+		queueForRetransmission(f)
 		p, err = packer.PackPacket(nil, []frames.Frame{}, 0, true)
 		Expect(p).ToNot(BeNil())
 		Expect(err).ToNot(HaveOccurred())
@@ -213,7 +263,7 @@ var _ = Describe("Packet packer", func() {
 			minLength, _ := f.MinLength(0)
 			maxStreamFrameDataLen := protocol.MaxFrameAndPublicHeaderSize - publicHeaderLen - minLength
 			f.Data = bytes.Repeat([]byte{'f'}, int(maxStreamFrameDataLen))
-			streamFramer.AddFrameForRetransmission(f)
+			queueForRetransmission(f)
 			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(payloadFrames).To(HaveLen(1))
@@ -235,8 +285,8 @@ var _ = Describe("Packet packer", func() {
 				Offset:   1,
 				Data:     []byte("foobar"),
 			}
-			streamFramer.AddFrameForRetransmission(f1)
-			streamFramer.AddFrameForRetransmission(f2)
+			queueForRetransmission(f1)
+			queueForRetransmission(f2)
 			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(p.raw).To(HaveLen(int(protocol.MaxPacketSize - 1)))
@@ -255,15 +305,16 @@ var _ = Describe("Packet packer", func() {
 			}
 			f2 := &frames.StreamFrame{
 				StreamID: 5,
+				Offset:   4,
 				Data:     []byte{0xBE, 0xEF, 0x13, 0x37},
 			}
 			f3 := &frames.StreamFrame{
 				StreamID: 3,
 				Data:     []byte{0xCA, 0xFE},
 			}
-			streamFramer.AddFrameForRetransmission(f1)
-			streamFramer.AddFrameForRetransmission(f2)
-			streamFramer.AddFrameForRetransmission(f3)
+			queueForRetransmission(f1)
+			queueForRetransmission(f2)
+			queueForRetransmission(f3)
 			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(p).ToNot(BeNil())
 			Expect(err).ToNot(HaveOccurred())
@@ -287,7 +338,7 @@ var _ = Describe("Packet packer", func() {
 				Offset:   1,
 				Data:     bytes.Repeat([]byte{'f'}, int(protocol.MaxPacketSize)+100),
 			}
-			streamFramer.AddFrameForRetransmission(f)
+			queueForRetransmission(f)
 			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(p.raw).To(HaveLen(int(protocol.MaxPacketSize)))
@@ -301,7 +352,7 @@ var _ = Describe("Packet packer", func() {
 			minLength, _ := f.MinLength(0)
 			maxStreamFrameDataLen := protocol.MaxFrameAndPublicHeaderSize - publicHeaderLen - minLength
 			f.Data = bytes.Repeat([]byte{'f'}, int(maxStreamFrameDataLen)+200)
-			streamFramer.AddFrameForRetransmission(f)
+			queueForRetransmission(f)
 			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(payloadFrames).To(HaveLen(1))
@@ -329,8 +380,8 @@ var _ = Describe("Packet packer", func() {
 				Data:     bytes.Repeat([]byte{'f'}, int(maxStreamFrameDataLen)+100),
 				Offset:   1,
 			}
-			streamFramer.AddFrameForRetransmission(f1)
-			streamFramer.AddFrameForRetransmission(f2)
+			queueForRetransmission(f1)
+			queueForRetransmission(f2)
 			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(p.frames).To(HaveLen(1))
@@ -359,7 +410,7 @@ var _ = Describe("Packet packer", func() {
 			}
 			minLength, _ := f.MinLength(0)
 			f.Data = bytes.Repeat([]byte{'f'}, int(protocol.MaxFrameAndPublicHeaderSize-publicHeaderLen-minLength+1)) // + 1 since MinceLength is 1 bigger than the actual StreamFrame header
-			streamFramer.AddFrameForRetransmission(f)
+			queueForRetransmission(f)
 			p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(p).ToNot(BeNil())
@@ -374,7 +425,7 @@ var _ = Describe("Packet packer", func() {
 			minLength, _ := f.MinLength(0)
 			f.Data = bytes.Repeat([]byte{'f'}, int(protocol.MaxFrameAndPublicHeaderSize-publicHeaderLen-minLength+2)) // + 2 since MinceLength is 1 bigger than the actual StreamFrame header
 
-			streamFramer.AddFrameForRetransmission(f)
+			queueForRetransmission(f)
 			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(payloadFrames).To(HaveLen(1))
@@ -392,7 +443,7 @@ var _ = Describe("Packet packer", func() {
 				StreamID: 5,
 				Data:     bytes.Repeat([]byte{'f'}, length),
 			}
-			streamFramer.AddFrameForRetransmission(f)
+			queueForRetransmission(f)
 			_, err := packer.composeNextPacket(nil, publicHeaderLen)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(packer.controlFrames[0]).To(Equal(&frames.BlockedFrame{StreamID: 5}))
@@ -405,7 +456,7 @@ var _ = Describe("Packet packer", func() {
 				StreamID: 5,
 				Data:     bytes.Repeat([]byte{'f'}, length),
 			}
-			streamFramer.AddFrameForRetransmission(f)
+			queueForRetransmission(f)
 			p, err := packer.composeNextPacket(nil, publicHeaderLen)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(p).To(HaveLen(1))
@@ -418,7 +469,7 @@ var _ = Describe("Packet packer", func() {
 				StreamID: 5,
 				Data:     []byte("foobar"),
 			}
-			streamFramer.AddFrameForRetransmission(f)
+			queueForRetransmission(f)
 			_, err := packer.composeNextPacket(nil, publicHeaderLen)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(packer.controlFrames[0]).To(Equal(&frames.BlockedFrame{StreamID: 0}))
@@ -448,4 +499,40 @@ var _ = Describe("Packet packer", func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(p).ToNot(BeNil())
 	})
+
+	It("doesn't allocate when packing packets", func() {
+		if raceEnabled {
+			Skip("sync.Pool doesn't reuse all packet buffers when running with the race detector")
+		}
+		packer.cryptoSetup = &mockCryptoSetup{}
+		str := &stream{streamID: 5, sendBuffer: newSendBuffer(protocol.MaxByteCount)}
+		str.sendBuffer.Write(make([]byte, 200*protocol.MaxPacketSize))
+		streamsMap[5] = str
+		streamFramer.scheduler.AddStream(5)
+		streamFramer.flowControlManager.(*mockFlowControlHandler).remainingConnectionWindowSize = protocol.MaxByteCount
+		ack := &frames.AckFrame{LargestAcked: 0x1337, LowestAcked: 1}
+		controlFrames := []frames.Frame{ack}
+		var p *packedPacket
+		var err error
+		pack := func() {
+			p, err = packer.PackPacket(nil, controlFrames, 0x1337, true)
+			p.buffer.Release()
+		}
+		pack() // the reused slices are allocated for the first packet
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.frames).To(HaveLen(2))
+		Expect(testing.AllocsPerRun(100, pack)).To(BeZero())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.frames).To(HaveLen(2))
+	})
+
+	It("returns the error if a frame can't be written", func() {
+		ack := &frames.AckFrameLegacy{
+			LargestObserved: 3,
+			NackRanges:      []frames.NackRange{{FirstPacketNumber: 2, LastPacketNumber: 4}},
+		}
+		p, err := packer.PackPacket(nil, []frames.Frame{ack}, 0, true)
+		Expect(err).To(MatchError("AckFrame: Invalid NACK ranges"))
+		Expect(p).To(BeNil())
+	})
 })
@@ -54,7 +54,7 @@ ReadLoop:
 
 		var frame frames.Frame
 		if typeByte&0x80 == 0x80 {
-			frame, err = frames.ParseStreamFrame(r)
+			frame, err = frames.ParseStreamFrameNoCopy(r, data)
 			if err != nil {
 				err = qerr.Error(qerr.InvalidStreamData, err.Error())
 			}
@@ -33,6 +33,20 @@ type StreamID uint32
 // A ByteCount in QUIC
 type ByteCount uint64
 
+// LowestStreamPriorityLevel is the lowest priority level of a stream, as in SPDY
+// Priority level 0 is the highest.
+const LowestStreamPriorityLevel = 7
+
+// DefaultStreamPriorityLevel is the priority level of a stream that didn't set a priority
+// Value taken from Chromium.
+const DefaultStreamPriorityLevel = 3
+
+// MaxStreamWeight is the maximum weight of a stream, as in HTTP/2
+const MaxStreamWeight = 256
+
+// DefaultStreamWeight is the weight of a stream that didn't set a priority, as in HTTP/2
+const DefaultStreamWeight = 16
+
 // MaxByteCount is the maximum value of a ByteCount
 const MaxByteCount = math.MaxUint64
 
@@ -64,3 +78,6 @@ const MinRetransmissionTime = 200 * time.Millisecond
 
 // ClientHelloMinimumSize is the minimum size the server expectes an inchoate CHLO to have.
 const ClientHelloMinimumSize = 1024
+
+// MaxClientHellos is the maximum number of times we're willing to send a CHLO, i.e. receive a REJ
+const MaxClientHellos = 3
@@ -28,6 +28,9 @@ const ReceiveStreamFlowControlWindow ByteCount = (1 << 20) // 1 MB
 // This is the value that Google servers are using
 const ReceiveConnectionFlowControlWindow ByteCount = (1 << 20) * 1.5 // 1.5 MB
 
+// DefaultStreamSendBufferSize is the default number of bytes a stream buffers until they are acknowledged by the peer
+const DefaultStreamSendBufferSize ByteCount = (1 << 20) // 1 MB
+
 // MaxStreamsPerConnection is the maximum value accepted for the number of streams per connection
 const MaxStreamsPerConnection uint32 = 100
 
@@ -41,6 +44,18 @@ const MaxIdleConnectionStateLifetime = 60 * time.Second
 // MaxSessionUnprocessedPackets is the max number of packets stored in each session that are not yet processed.
 const MaxSessionUnprocessedPackets = 128
 
+// MaxAcceptQueueSize is the max number of sessions that completed the handshake, but were not yet accepted by the application.
+const MaxAcceptQueueSize = 32
+
+// MaxPublicResetsPerSecond is the maximum number of public resets a server sends per second for packets with unknown connection IDs
+const MaxPublicResetsPerSecond = 100
+
+// TimeWaitDuration is the time a server keeps state for a closed connection, to answer late packets with the CONNECTION_CLOSE
+const TimeWaitDuration = 3 * DefaultRetransmissionTime
+
+// MaxTimeWaitEntries is the maximum number of closed connections a server keeps in time-wait state
+const MaxTimeWaitEntries = 10000
+
 // RetransmissionThreshold + 1 is the number of times a packet has to be NACKed so that it gets retransmitted
 const RetransmissionThreshold uint8 = 3
 
@@ -65,7 +80,23 @@ const MaxStreamFrameSorterGaps = 50
 const CryptoMaxParams = 128
 
 // CryptoParameterMaxLength is the upper limit for the length of a parameter in a crypto message.
-const CryptoParameterMaxLength = 2000
+const CryptoParameterMaxLength = 4000
 
 // EphermalKeyLifetime is the lifetime of the ephermal key during the handshake, see handshake.getEphermalKEX.
 const EphermalKeyLifetime = time.Minute
+
+// PacketBatchSize is the maximum number of packets read or written with a single syscall, if the platform supports it
+const PacketBatchSize = 32
+
+// MaxCoalescedPacketSize is the maximum size of multiple packets coalesced by UDP generic receive offload, or sent using UDP generic segmentation offload
+const MaxCoalescedPacketSize = 65507
+
+// EventLoopTimerGranularity is the granularity of the timer wheel used by event loop workers
+const EventLoopTimerGranularity = 5 * time.Millisecond
+
+// EventLoopTimerWheelSlots is the number of slots of the timer wheel used by event loop workers
+// Deadlines further in the future than EventLoopTimerWheelSlots * EventLoopTimerGranularity wrap around the wheel.
+const EventLoopTimerWheelSlots = 1024
+
+// MaxEventLoopUnprocessedPackets is the max number of packets queued in an event loop worker that are not yet processed
+const MaxEventLoopUnprocessedPackets = 4096
@@ -39,9 +39,9 @@ func VersionTagToNumber(v uint32) VersionNumber {
 	return VersionNumber(((v>>8)&0xff-'0')*100 + ((v>>16)&0xff-'0')*10 + ((v>>24)&0xff - '0'))
 }
 
-// IsSupportedVersion returns true if the server supports this version
-func IsSupportedVersion(v VersionNumber) bool {
-	for _, t := range SupportedVersions {
+// IsSupportedVersion returns true if v is contained in the list of supported versions
+func IsSupportedVersion(supported []VersionNumber, v VersionNumber) bool {
+	for _, t := range supported {
 		if t == v {
 			return true
 		}
@@ -49,14 +49,19 @@ func IsSupportedVersion(v VersionNumber) bool {
 	return false
 }
 
-func init() {
+// VersionsAsTags writes the tags of the versions, as needed for the version lists in the SHLO and in Version Negotiation Packets
+func VersionsAsTags(versions []VersionNumber) []byte {
 	var b bytes.Buffer
-	for _, v := range SupportedVersions {
+	for _, v := range versions {
 		s := make([]byte, 4)
 		binary.LittleEndian.PutUint32(s, VersionNumberToTag(v))
 		b.Write(s)
 	}
-	SupportedVersionsAsTags = b.Bytes()
+	return b.Bytes()
+}
+
+func init() {
+	SupportedVersionsAsTags = VersionsAsTags(SupportedVersions)
 
 	for i := len(SupportedVersions) - 1; i >= 0; i-- {
 		SupportedVersionsAsString += strconv.Itoa(int(SupportedVersions[i]))
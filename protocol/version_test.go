@@ -25,7 +25,13 @@ var _ = Describe("Version", func() {
 	})
 
 	It("recognizes supported versions", func() {
-		Expect(IsSupportedVersion(0)).To(BeFalse())
-		Expect(IsSupportedVersion(SupportedVersions[0])).To(BeTrue())
+		Expect(IsSupportedVersion(SupportedVersions, 0)).To(BeFalse())
+		Expect(IsSupportedVersion(SupportedVersions, SupportedVersions[0])).To(BeTrue())
+		Expect(IsSupportedVersion([]VersionNumber{Version33}, Version34)).To(BeFalse())
+	})
+
+	It("writes the tags for a list of versions", func() {
+		Expect(VersionsAsTags([]VersionNumber{Version33, Version34})).To(Equal([]byte("Q033Q034")))
+		Expect(VersionsAsTags(nil)).To(BeEmpty())
 	})
 })
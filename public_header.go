@@ -11,11 +11,13 @@ import (
 )
 
 var (
-	errPacketNumberLenNotSet          = errors.New("PublicHeader: PacketNumberLen not set")
-	errResetAndVersionFlagSet         = errors.New("PublicHeader: Reset Flag and Version Flag should not be set at the same time")
-	errReceivedTruncatedConnectionID  = qerr.Error(qerr.InvalidPacketHeader, "receiving packets with truncated ConnectionID is not supported")
-	errInvalidConnectionID            = qerr.Error(qerr.InvalidPacketHeader, "connection ID cannot be 0")
-	errGetLengthOnlyForRegularPackets = errors.New("PublicHeader: GetLength can only be called for regular packets")
+	errPacketNumberLenNotSet             = errors.New("PublicHeader: PacketNumberLen not set")
+	errResetAndVersionFlagSet            = errors.New("PublicHeader: Reset Flag and Version Flag should not be set at the same time")
+	errReceivedTruncatedConnectionID     = qerr.Error(qerr.InvalidPacketHeader, "receiving packets with truncated ConnectionID is not supported")
+	errInvalidConnectionID               = qerr.Error(qerr.InvalidPacketHeader, "connection ID cannot be 0")
+	errGetLengthNotForVersionNegotiation = errors.New("PublicHeader: GetLength cannot be called for VersionNegotiation packets")
+	errGetLengthNotForPublicReset        = errors.New("PublicHeader: GetLength cannot be called for PublicReset packets")
+	errEmptyVersionNegotiationPacket     = qerr.Error(qerr.InvalidVersionNegotiationPacket, "empty list of versions")
 )
 
 // The publicHeader of a QUIC packet
@@ -25,17 +27,26 @@ type publicHeader struct {
 	ResetFlag            bool
 	ConnectionID         protocol.ConnectionID
 	TruncateConnectionID bool
-	VersionNumber        protocol.VersionNumber
+	VersionNumber        protocol.VersionNumber   // VersionNumber sent by the client
+	SupportedVersions    []protocol.VersionNumber // VersionNumbers sent by the server
 	PacketNumberLen      protocol.PacketNumberLen
 	PacketNumber         protocol.PacketNumber
 	DiversificationNonce []byte
 }
 
 // WritePublicHeader writes a public header
-func (h *publicHeader) WritePublicHeader(b *bytes.Buffer, version protocol.VersionNumber) error {
+func (h *publicHeader) WritePublicHeader(b *bytes.Buffer, pers protocol.Perspective, version protocol.VersionNumber) error {
+	data, err := h.AppendTo(nil, pers, version)
+	b.Write(data)
+	return err
+}
+
+// AppendTo appends the public header to b
+// If an error occurs, the returned slice contains the part of the header that was appended before the error.
+func (h *publicHeader) AppendTo(b []byte, pers protocol.Perspective, version protocol.VersionNumber) ([]byte, error) {
 	publicFlagByte := uint8(0x00)
 	if h.VersionFlag && h.ResetFlag {
-		return errResetAndVersionFlagSet
+		return b, errResetAndVersionFlagSet
 	}
 	if h.VersionFlag {
 		publicFlagByte |= 0x01
@@ -52,12 +63,13 @@ func (h *publicHeader) WritePublicHeader(b *bytes.Buffer, version protocol.Versi
 	}
 	if len(h.DiversificationNonce) > 0 {
 		if len(h.DiversificationNonce) != 32 {
-			return errors.New("invalid diversification nonce length")
+			return b, errors.New("invalid diversification nonce length")
 		}
 		publicFlagByte |= 0x04
 	}
 
-	if !h.ResetFlag && !h.VersionFlag {
+	// only set PacketNumberLen bits if a packet number will be written
+	if h.hasPacketNumber(pers) {
 		switch h.PacketNumberLen {
 		case protocol.PacketNumberLen1:
 			publicFlagByte |= 0x00
@@ -70,36 +82,44 @@ func (h *publicHeader) WritePublicHeader(b *bytes.Buffer, version protocol.Versi
 		}
 	}
 
-	b.WriteByte(publicFlagByte)
+	b = append(b, publicFlagByte)
 
 	if !h.TruncateConnectionID {
-		utils.WriteUint64(b, uint64(h.ConnectionID))
+		b = utils.AppendUint64(b, uint64(h.ConnectionID))
+	}
+
+	if h.VersionFlag && pers == protocol.PerspectiveClient {
+		b = utils.AppendUint32(b, protocol.VersionNumberToTag(h.VersionNumber))
 	}
 
 	if len(h.DiversificationNonce) > 0 {
-		b.Write(h.DiversificationNonce)
+		b = append(b, h.DiversificationNonce...)
 	}
 
-	if !h.ResetFlag && !h.VersionFlag {
-		switch h.PacketNumberLen {
-		case protocol.PacketNumberLen1:
-			b.WriteByte(uint8(h.PacketNumber))
-		case protocol.PacketNumberLen2:
-			utils.WriteUint16(b, uint16(h.PacketNumber))
-		case protocol.PacketNumberLen4:
-			utils.WriteUint32(b, uint32(h.PacketNumber))
-		case protocol.PacketNumberLen6:
-			utils.WriteUint48(b, uint64(h.PacketNumber))
-		default:
-			return errPacketNumberLenNotSet
-		}
+	// Public Resets and Version Negotiation Packets sent by the server don't have a packet number
+	if !h.hasPacketNumber(pers) {
+		return b, nil
+	}
+
+	switch h.PacketNumberLen {
+	case protocol.PacketNumberLen1:
+		b = append(b, uint8(h.PacketNumber))
+	case protocol.PacketNumberLen2:
+		b = utils.AppendUint16(b, uint16(h.PacketNumber))
+	case protocol.PacketNumberLen4:
+		b = utils.AppendUint32(b, uint32(h.PacketNumber))
+	case protocol.PacketNumberLen6:
+		b = utils.AppendUint48(b, uint64(h.PacketNumber))
+	default:
+		return b, errPacketNumberLenNotSet
 	}
 
-	return nil
+	return b, nil
 }
 
 // parsePublicHeader parses a QUIC packet's public header
-func parsePublicHeader(b io.ByteReader) (*publicHeader, error) {
+// the packetSentBy is the perspective of the peer that sent this PublicHeader, i.e. if we're the server, packetSentBy should be PerspectiveClient
+func parsePublicHeader(b io.ByteReader, packetSentBy protocol.Perspective) (*publicHeader, error) {
 	header := &publicHeader{}
 
 	// First byte
@@ -140,14 +160,47 @@ func parsePublicHeader(b io.ByteReader) (*publicHeader, error) {
 		return nil, errInvalidConnectionID
 	}
 
+	// the rest of a Public Reset packet is a handshake message, see parsePublicReset
+	if packetSentBy == protocol.PerspectiveServer && header.ResetFlag {
+		return header, nil
+	}
+
 	// Version (optional)
 	if header.VersionFlag {
-		var versionTag uint32
-		versionTag, err = utils.ReadUint32(b)
-		if err != nil {
-			return nil, err
+		if packetSentBy == protocol.PerspectiveClient {
+			var versionTag uint32
+			versionTag, err = utils.ReadUint32(b)
+			if err != nil {
+				return nil, err
+			}
+			header.VersionNumber = protocol.VersionTagToNumber(versionTag)
+		} else {
+			// a Version Negotiation Packet contains the list of versions supported by the server, and no packet number
+			for {
+				var versionTag uint32
+				versionTag, err = utils.ReadUint32(b)
+				if err != nil {
+					break
+				}
+				header.SupportedVersions = append(header.SupportedVersions, protocol.VersionTagToNumber(versionTag))
+			}
+			if len(header.SupportedVersions) == 0 {
+				return nil, errEmptyVersionNegotiationPacket
+			}
+			return header, nil
+		}
+	}
+
+	// Diversification Nonce, only sent by the server
+	// the client only speaks versions >= 33, thus the 0x04 flag is unambiguous here
+	if packetSentBy == protocol.PerspectiveServer && publicFlagByte&0x04 > 0 {
+		header.DiversificationNonce = make([]byte, 32)
+		for i := 0; i < 32; i++ {
+			header.DiversificationNonce[i], err = b.ReadByte()
+			if err != nil {
+				return nil, err
+			}
 		}
-		header.VersionNumber = protocol.VersionTagToNumber(versionTag)
 	}
 
 	// Packet number
@@ -162,9 +215,17 @@ func parsePublicHeader(b io.ByteReader) (*publicHeader, error) {
 
 // GetLength gets the length of the publicHeader in bytes
 // can only be called for regular packets
-func (h *publicHeader) GetLength() (protocol.ByteCount, error) {
-	if h.VersionFlag || h.ResetFlag {
-		return 0, errGetLengthOnlyForRegularPackets
+func (h *publicHeader) GetLength(pers protocol.Perspective) (protocol.ByteCount, error) {
+	if h.VersionFlag && h.ResetFlag {
+		return 0, errResetAndVersionFlagSet
+	}
+
+	if h.VersionFlag && pers == protocol.PerspectiveServer {
+		return 0, errGetLengthNotForVersionNegotiation
+	}
+
+	if h.ResetFlag {
+		return 0, errGetLengthNotForPublicReset
 	}
 
 	length := protocol.ByteCount(1) // 1 byte for public flags
@@ -174,7 +235,23 @@ func (h *publicHeader) GetLength() (protocol.ByteCount, error) {
 	if !h.TruncateConnectionID {
 		length += 8 // 8 bytes for the connection ID
 	}
+	// Version Number in packets sent by the client
+	if h.VersionFlag {
+		length += 4
+	}
 	length += protocol.ByteCount(len(h.DiversificationNonce))
 	length += protocol.ByteCount(h.PacketNumberLen)
 	return length, nil
 }
+
+// hasPacketNumber determines if this PublicHeader will contain a packet number
+// this depends on the ResetFlag, the VersionFlag and who sent the packet
+func (h *publicHeader) hasPacketNumber(packetSentBy protocol.Perspective) bool {
+	if h.ResetFlag {
+		return false
+	}
+	if h.VersionFlag && packetSentBy == protocol.PerspectiveServer {
+		return false
+	}
+	return true
+}
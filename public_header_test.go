@@ -12,7 +12,7 @@ var _ = Describe("Public Header", func() {
 	Context("when parsing", func() {
 		It("accepts a sample client header", func() {
 			b := bytes.NewReader([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x34, 0x01})
-			hdr, err := parsePublicHeader(b)
+			hdr, err := parsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.VersionFlag).To(BeTrue())
 			Expect(hdr.ResetFlag).To(BeFalse())
@@ -24,19 +24,19 @@ var _ = Describe("Public Header", func() {
 
 		It("does not accept 0-byte connection ID", func() {
 			b := bytes.NewReader([]byte{0x00, 0x01})
-			_, err := parsePublicHeader(b)
+			_, err := parsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).To(MatchError(errReceivedTruncatedConnectionID))
 		})
 
 		It("rejects 0 as a connection ID", func() {
 			b := bytes.NewReader([]byte{0x09, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x51, 0x30, 0x33, 0x30, 0x01})
-			_, err := parsePublicHeader(b)
+			_, err := parsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).To(MatchError(errInvalidConnectionID))
 		})
 
 		It("accepts 1-byte packet numbers", func() {
 			b := bytes.NewReader([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xde})
-			hdr, err := parsePublicHeader(b)
+			hdr, err := parsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xde)))
 			Expect(b.Len()).To(BeZero())
@@ -44,7 +44,7 @@ var _ = Describe("Public Header", func() {
 
 		It("accepts 2-byte packet numbers", func() {
 			b := bytes.NewReader([]byte{0x18, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xde, 0xca})
-			hdr, err := parsePublicHeader(b)
+			hdr, err := parsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xcade)))
 			Expect(b.Len()).To(BeZero())
@@ -52,7 +52,7 @@ var _ = Describe("Public Header", func() {
 
 		It("accepts 4-byte packet numbers", func() {
 			b := bytes.NewReader([]byte{0x28, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xad, 0xfb, 0xca, 0xde})
-			hdr, err := parsePublicHeader(b)
+			hdr, err := parsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xdecafbad)))
 			Expect(b.Len()).To(BeZero())
@@ -60,18 +60,54 @@ var _ = Describe("Public Header", func() {
 
 		It("accepts 6-byte packet numbers", func() {
 			b := bytes.NewReader([]byte{0x38, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x23, 0x42, 0xad, 0xfb, 0xca, 0xde})
-			hdr, err := parsePublicHeader(b)
+			hdr, err := parsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xdecafbad4223)))
 			Expect(b.Len()).To(BeZero())
 		})
 
+		It("parses a Version Negotiation packet sent by the server", func() {
+			b := bytes.NewReader(append([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c}, protocol.SupportedVersionsAsTags...))
+			hdr, err := parsePublicHeader(b, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.VersionFlag).To(BeTrue())
+			Expect(hdr.ConnectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+			Expect(hdr.SupportedVersions).To(Equal(protocol.SupportedVersions))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors on Version Negotiation packets that don't contain any versions", func() {
+			b := bytes.NewReader([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c})
+			_, err := parsePublicHeader(b, protocol.PerspectiveServer)
+			Expect(err).To(MatchError(errEmptyVersionNegotiationPacket))
+		})
+
+		It("parses a Public Reset sent by the server up to the connection ID", func() {
+			b := bytes.NewReader([]byte{0x0a, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x13, 0x37})
+			hdr, err := parsePublicHeader(b, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.ResetFlag).To(BeTrue())
+			Expect(hdr.ConnectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+			Expect(b.Len()).To(Equal(2))
+		})
+
+		It("reads the diversification nonce sent by the server", func() {
+			divNonce := bytes.Repeat([]byte{0x42}, 32)
+			data := append([]byte{0x0c, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c}, divNonce...)
+			b := bytes.NewReader(append(data, 0x37))
+			hdr, err := parsePublicHeader(b, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.DiversificationNonce).To(Equal(divNonce))
+			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0x37)))
+			Expect(b.Len()).To(BeZero())
+		})
+
 		PIt("rejects diversification nonces", func() {
 			b := bytes.NewReader([]byte{0x0c, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c,
 				0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1,
 				0x01,
 			})
-			_, err := parsePublicHeader(b)
+			_, err := parsePublicHeader(b, protocol.PerspectiveClient)
 			Expect(err).To(MatchError("diversification nonces should only be sent by servers"))
 		})
 	})
@@ -84,10 +120,21 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    2,
 				PacketNumberLen: protocol.PacketNumberLen6,
 			}
-			hdr.WritePublicHeader(b, protocol.Version32)
+			hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 			Expect(b.Bytes()).To(Equal([]byte{0x38 | 0x04, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 2, 0, 0, 0, 0, 0}))
 		})
 
+		It("appends to a slice", func() {
+			hdr := publicHeader{
+				ConnectionID:    0x4cfa9f9b668619f6,
+				PacketNumber:    2,
+				PacketNumberLen: protocol.PacketNumberLen6,
+			}
+			b, err := hdr.AppendTo([]byte{0x42}, protocol.PerspectiveServer, protocol.Version32)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).To(Equal([]byte{0x42, 0x38 | 0x04, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 2, 0, 0, 0, 0, 0}))
+		})
+
 		It("sets the Version Flag", func() {
 			b := &bytes.Buffer{}
 			hdr := publicHeader{
@@ -96,13 +143,27 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    2,
 				PacketNumberLen: protocol.PacketNumberLen6,
 			}
-			hdr.WritePublicHeader(b, protocol.Version32)
+			hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 			// must be the first assertion
 			Expect(b.Len()).To(Equal(1 + 8)) // 1 FlagByte + 8 ConnectionID
 			firstByte, _ := b.ReadByte()
 			Expect(firstByte & 0x01).To(Equal(uint8(1)))
 		})
 
+		It("writes the version and the packet number if the Version Flag is set by the client", func() {
+			b := &bytes.Buffer{}
+			hdr := publicHeader{
+				VersionFlag:     true,
+				VersionNumber:   protocol.Version34,
+				ConnectionID:    0x4cfa9f9b668619f6,
+				PacketNumber:    0x42,
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			err := hdr.WritePublicHeader(b, protocol.PerspectiveClient, protocol.Version34)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.Bytes()).To(Equal([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 'Q', '0', '3', '4', 0x42}))
+		})
+
 		It("sets the Reset Flag", func() {
 			b := &bytes.Buffer{}
 			hdr := publicHeader{
@@ -111,7 +172,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    2,
 				PacketNumberLen: protocol.PacketNumberLen6,
 			}
-			hdr.WritePublicHeader(b, protocol.Version32)
+			hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 			// must be the first assertion
 			Expect(b.Len()).To(Equal(1 + 8)) // 1 FlagByte + 8 ConnectionID
 			firstByte, _ := b.ReadByte()
@@ -127,7 +188,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    2,
 				PacketNumberLen: protocol.PacketNumberLen6,
 			}
-			err := hdr.WritePublicHeader(b, protocol.Version32)
+			err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 			Expect(err).To(MatchError(errResetAndVersionFlagSet))
 		})
 
@@ -139,7 +200,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumberLen:      protocol.PacketNumberLen6,
 				PacketNumber:         1,
 			}
-			err := hdr.WritePublicHeader(b, protocol.Version32)
+			err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(b.Bytes()).To(Equal([]byte{0x30, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0}))
 		})
@@ -151,7 +212,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumber:    1,
 				PacketNumberLen: protocol.PacketNumberLen1,
 			}
-			err := hdr.WritePublicHeader(b, protocol.Version33)
+			err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version33)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(b.Bytes()).To(Equal([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}))
 		})
@@ -164,7 +225,7 @@ var _ = Describe("Public Header", func() {
 				PacketNumberLen:      protocol.PacketNumberLen1,
 				DiversificationNonce: bytes.Repeat([]byte{1}, 32),
 			}
-			err := hdr.WritePublicHeader(b, protocol.Version33)
+			err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version33)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(b.Bytes()).To(Equal([]byte{
 				0x0c, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c,
@@ -176,14 +237,26 @@ var _ = Describe("Public Header", func() {
 		Context("GetLength", func() {
 			It("errors when calling GetLength for Version Negotiation packets", func() {
 				hdr := publicHeader{VersionFlag: true}
-				_, err := hdr.GetLength()
-				Expect(err).To(MatchError(errGetLengthOnlyForRegularPackets))
+				_, err := hdr.GetLength(protocol.PerspectiveServer)
+				Expect(err).To(MatchError(errGetLengthNotForVersionNegotiation))
 			})
 
 			It("errors when calling GetLength for Public Reset packets", func() {
 				hdr := publicHeader{ResetFlag: true}
-				_, err := hdr.GetLength()
-				Expect(err).To(MatchError(errGetLengthOnlyForRegularPackets))
+				_, err := hdr.GetLength(protocol.PerspectiveServer)
+				Expect(err).To(MatchError(errGetLengthNotForPublicReset))
+			})
+
+			It("gets the length of a packet with the Version Flag sent by the client", func() {
+				hdr := publicHeader{
+					VersionFlag:     true,
+					ConnectionID:    0x4cfa9f9b668619f6,
+					PacketNumber:    0xDECAFBAD,
+					PacketNumberLen: protocol.PacketNumberLen6,
+				}
+				length, err := hdr.GetLength(protocol.PerspectiveClient)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(length).To(Equal(protocol.ByteCount(1 + 8 + 4 + 6))) // 1 byte public flag, 8 bytes connectionID, 4 bytes version and packet number
 			})
 
 			It("errors when PacketNumberLen is not set", func() {
@@ -191,7 +264,7 @@ var _ = Describe("Public Header", func() {
 					ConnectionID: 0x4cfa9f9b668619f6,
 					PacketNumber: 0xDECAFBAD,
 				}
-				_, err := hdr.GetLength()
+				_, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).To(MatchError(errPacketNumberLenNotSet))
 			})
 
@@ -201,7 +274,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xDECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen6,
 				}
-				length, err := hdr.GetLength()
+				length, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(length).To(Equal(protocol.ByteCount(1 + 8 + 6))) // 1 byte public flag, 8 bytes connectionID, and packet number
 			})
@@ -213,7 +286,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:         0xDECAFBAD,
 					PacketNumberLen:      protocol.PacketNumberLen6,
 				}
-				length, err := hdr.GetLength()
+				length, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(length).To(Equal(protocol.ByteCount(1 + 6))) // 1 byte public flag, and packet number
 			})
@@ -224,7 +297,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xDECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen2,
 				}
-				length, err := hdr.GetLength()
+				length, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(length).To(Equal(protocol.ByteCount(1 + 8 + 2))) // 1 byte public flag, 8 byte connectionID, and packet number
 			})
@@ -234,7 +307,7 @@ var _ = Describe("Public Header", func() {
 					DiversificationNonce: []byte("foo"),
 					PacketNumberLen:      protocol.PacketNumberLen1,
 				}
-				length, err := hdr.GetLength()
+				length, err := hdr.GetLength(protocol.PerspectiveServer)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(length).To(Equal(protocol.ByteCount(1 + 8 + 3 + 1)))
 			})
@@ -247,7 +320,7 @@ var _ = Describe("Public Header", func() {
 					ConnectionID: 0x4cfa9f9b668619f6,
 					PacketNumber: 0xDECAFBAD,
 				}
-				err := hdr.WritePublicHeader(b, protocol.Version32)
+				err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 				Expect(err).To(MatchError(errPacketNumberLenNotSet))
 			})
 
@@ -258,7 +331,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xDECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen1,
 				}
-				err := hdr.WritePublicHeader(b, protocol.Version32)
+				err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(b.Bytes()).To(Equal([]byte{0x08 | 0x04, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xAD}))
 			})
@@ -270,7 +343,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xDECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen2,
 				}
-				err := hdr.WritePublicHeader(b, protocol.Version32)
+				err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(b.Bytes()).To(Equal([]byte{0x18 | 0x04, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xAD, 0xFB}))
 			})
@@ -282,7 +355,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0x13DECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen4,
 				}
-				err := hdr.WritePublicHeader(b, protocol.Version32)
+				err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(b.Bytes()).To(Equal([]byte{0x28 | 0x04, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xAD, 0xFB, 0xCA, 0xDE}))
 			})
@@ -294,7 +367,7 @@ var _ = Describe("Public Header", func() {
 					PacketNumber:    0xBE1337DECAFBAD,
 					PacketNumberLen: protocol.PacketNumberLen6,
 				}
-				err := hdr.WritePublicHeader(b, protocol.Version32)
+				err := hdr.WritePublicHeader(b, protocol.PerspectiveServer, protocol.Version32)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(b.Bytes()).To(Equal([]byte{0x38 | 0x04, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0xAD, 0xFB, 0xCA, 0xDE, 0x37, 0x13}))
 			})
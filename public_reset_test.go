@@ -1,6 +1,11 @@
 package quic
 
 import (
+	"bytes"
+
+	"github.com/lucas-clemente/quic-go/handshake"
+	"github.com/lucas-clemente/quic-go/protocol"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -22,4 +27,83 @@ var _ = Describe("public reset", func() {
 			}))
 		})
 	})
+
+	Context("nonce proofs", func() {
+		It("derives the same nonce proof for the same connection", func() {
+			Expect(publicResetNonceProof([]byte("foobar"), 0x1337)).To(Equal(publicResetNonceProof([]byte("foobar"), 0x1337)))
+		})
+
+		It("derives different nonce proofs for different connections", func() {
+			Expect(publicResetNonceProof([]byte("foobar"), 0x1337)).ToNot(Equal(publicResetNonceProof([]byte("foobar"), 0x1338)))
+		})
+
+		It("derives different nonce proofs for different secrets", func() {
+			Expect(publicResetNonceProof([]byte("foobar"), 0x1337)).ToNot(Equal(publicResetNonceProof([]byte("raboof"), 0x1337)))
+		})
+	})
+
+	Context("parsing", func() {
+		var b *bytes.Buffer
+
+		BeforeEach(func() {
+			b = &bytes.Buffer{}
+		})
+
+		It("parses a public reset", func() {
+			packet := writePublicReset(0xdeadbeef, 0x8badf00d, 0xdecafbad)
+			pr, err := parsePublicReset(bytes.NewReader(packet[9:])) // 1 byte Public Flag, 8 bytes connection ID
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pr.nonce).To(Equal(uint64(0xdecafbad)))
+			Expect(pr.rejectedPacketNumber).To(Equal(protocol.PacketNumber(0x8badf00d)))
+		})
+
+		It("rejects packets that it can't parse", func() {
+			_, err := parsePublicReset(bytes.NewReader([]byte{}))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects packets with the wrong tag", func() {
+			handshake.WriteHandshakeMessage(b, handshake.TagREJ, nil)
+			_, err := parsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError("wrong public reset tag"))
+		})
+
+		It("rejects packets missing the nonce", func() {
+			data := map[handshake.Tag][]byte{
+				handshake.TagRSEQ: []byte{0xbe, 0xba, 0xfe, 0xca, 0x37, 0x13, 0x0, 0x0},
+			}
+			handshake.WriteHandshakeMessage(b, handshake.TagPRST, data)
+			_, err := parsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError("RNON missing"))
+		})
+
+		It("rejects packets with a wrong length nonce", func() {
+			data := map[handshake.Tag][]byte{
+				handshake.TagRSEQ: []byte{0xbe, 0xba, 0xfe, 0xca, 0x37, 0x13, 0x0, 0x0},
+				handshake.TagRNON: []byte{0x13, 0x37},
+			}
+			handshake.WriteHandshakeMessage(b, handshake.TagPRST, data)
+			_, err := parsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError("invalid RNON tag"))
+		})
+
+		It("rejects packets missing the rejected packet number", func() {
+			data := map[handshake.Tag][]byte{
+				handshake.TagRNON: []byte{0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0xba, 0xbe},
+			}
+			handshake.WriteHandshakeMessage(b, handshake.TagPRST, data)
+			_, err := parsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError("RSEQ missing"))
+		})
+
+		It("rejects packets with a wrong length rejected packet number", func() {
+			data := map[handshake.Tag][]byte{
+				handshake.TagRSEQ: []byte{0xbe, 0xba, 0xfe},
+				handshake.TagRNON: []byte{0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0xba, 0xbe},
+			}
+			handshake.WriteHandshakeMessage(b, handshake.TagPRST, data)
+			_, err := parsePublicReset(bytes.NewReader(b.Bytes()))
+			Expect(err).To(MatchError("invalid RSEQ tag"))
+		})
+	})
 })
@@ -2,8 +2,6 @@ package qerr
 
 import (
 	"fmt"
-
-	"github.com/lucas-clemente/quic-go/utils"
 )
 
 // ErrorCode can be used as a normal error without reason.
@@ -33,6 +31,7 @@ func (e *QuicError) Error() string {
 
 // ToQuicError converts an arbitrary error to a QuicError. It leaves QuicErrors
 // unchanged, and properly handles `ErrorCode`s.
+// Other errors are converted to an InternalError, it's up to the caller to log them.
 func ToQuicError(err error) *QuicError {
 	switch e := err.(type) {
 	case *QuicError:
@@ -40,6 +39,5 @@ func ToQuicError(err error) *QuicError {
 	case ErrorCode:
 		return Error(e, "")
 	}
-	utils.Errorf("Internal error: %v", err)
 	return Error(InternalError, err.Error())
 }
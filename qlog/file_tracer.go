@@ -0,0 +1,223 @@
+package qlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+type fileTracer struct {
+	dir    string
+	logger utils.Logger
+}
+
+var _ Tracer = &fileTracer{}
+
+// NewFileTracer creates a tracer that writes one trace file per connection into dir.
+// The files are named after the connection ID and the perspective, e.g. 0123456789abcdef_server.qlog.
+// Every line of a file is a JSON object: the first line describes the trace, all following lines are events.
+// To only trace some connections, wrap the tracer and return nil from TracerForConnection for all other connections.
+// Errors writing the trace files are logged to the logger, this should usually be the Logger of the quic.Config.
+func NewFileTracer(dir string, logger utils.Logger) Tracer {
+	return &fileTracer{dir: dir, logger: logger}
+}
+
+func (t *fileTracer) TracerForConnection(p protocol.Perspective, connectionID protocol.ConnectionID) ConnectionTracer {
+	filename := filepath.Join(t.dir, fmt.Sprintf("%016x_%s.qlog", uint64(connectionID), perspectiveString(p)))
+	// the client recreates the session with the same connection ID after a version negotiation
+	logger := t.logger.With("connection", fmt.Sprintf("%016x", uint64(connectionID)))
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		logger.Errorf("Error creating trace file: %s", err.Error())
+		return nil
+	}
+	return newConnectionTracer(f, p, connectionID, logger)
+}
+
+type jsonEvent struct {
+	// Time is the time since the start of the trace, in milliseconds
+	Time float64     `json:"time"`
+	Name string      `json:"name"`
+	Data interface{} `json:"data"`
+}
+
+type jsonPacketHeader struct {
+	ConnectionID    string                   `json:"connection_id"`
+	PacketNumber    protocol.PacketNumber    `json:"packet_number"`
+	PacketNumberLen protocol.PacketNumberLen `json:"packet_number_length,omitempty"`
+	VersionNumber   protocol.VersionNumber   `json:"version,omitempty"`
+}
+
+type connectionTracer struct {
+	mutex sync.Mutex
+
+	w      io.WriteCloser
+	buf    *bufio.Writer
+	start  time.Time
+	closed bool
+
+	logger utils.Logger
+}
+
+var _ ConnectionTracer = &connectionTracer{}
+
+func newConnectionTracer(w io.WriteCloser, p protocol.Perspective, connectionID protocol.ConnectionID, logger utils.Logger) *connectionTracer {
+	t := &connectionTracer{
+		w:      w,
+		buf:    bufio.NewWriter(w),
+		start:  time.Now(),
+		logger: logger,
+	}
+	t.writeLine(map[string]interface{}{
+		"qlog_format":  "NDJSON",
+		"qlog_version": "draft-02",
+		"trace": map[string]interface{}{
+			"vantage_point": map[string]string{"type": perspectiveString(p)},
+			"common_fields": map[string]interface{}{
+				"connection_id":  fmt.Sprintf("%016x", uint64(connectionID)),
+				"reference_time": milliseconds(time.Duration(t.start.UnixNano())),
+				"time_format":    "relative",
+			},
+		},
+	})
+	return t
+}
+
+func (t *connectionTracer) SentPacket(hdr *PacketHeader, size protocol.ByteCount, fs []frames.Frame) {
+	t.recordEvent("transport:packet_sent", map[string]interface{}{
+		"header": transformPacketHeader(hdr),
+		"size":   size,
+		"frames": transformFrames(fs),
+	})
+}
+
+func (t *connectionTracer) ReceivedPacket(hdr *PacketHeader, size protocol.ByteCount, fs []frames.Frame) {
+	t.recordEvent("transport:packet_received", map[string]interface{}{
+		"header": transformPacketHeader(hdr),
+		"size":   size,
+		"frames": transformFrames(fs),
+	})
+}
+
+func (t *connectionTracer) LostPacket(packetNumber protocol.PacketNumber, reason LossReason) {
+	t.recordEvent("recovery:packet_lost", map[string]interface{}{
+		"packet_number": packetNumber,
+		"trigger":       reason.String(),
+	})
+}
+
+func (t *connectionTracer) UpdatedRTT(smoothedRTT, minRTT, latestRTT time.Duration) {
+	t.recordEvent("recovery:metrics_updated", map[string]interface{}{
+		"smoothed_rtt": milliseconds(smoothedRTT),
+		"min_rtt":      milliseconds(minRTT),
+		"latest_rtt":   milliseconds(latestRTT),
+	})
+}
+
+func (t *connectionTracer) UpdatedCongestionWindow(congestionWindow, bytesInFlight protocol.ByteCount) {
+	t.recordEvent("recovery:metrics_updated", map[string]interface{}{
+		"congestion_window": congestionWindow,
+		"bytes_in_flight":   bytesInFlight,
+	})
+}
+
+func (t *connectionTracer) OpenedStream(id protocol.StreamID) {
+	t.recordEvent("transport:stream_opened", map[string]interface{}{"stream_id": id})
+}
+
+func (t *connectionTracer) ClosedStream(id protocol.StreamID) {
+	t.recordEvent("transport:stream_closed", map[string]interface{}{"stream_id": id})
+}
+
+func (t *connectionTracer) SentHandshakeMessage(messageTag string, tags []string) {
+	t.recordEvent("security:handshake_message_sent", map[string]interface{}{
+		"message_type": messageTag,
+		"tags":         tags,
+	})
+}
+
+func (t *connectionTracer) ReceivedHandshakeMessage(messageTag string, tags []string) {
+	t.recordEvent("security:handshake_message_received", map[string]interface{}{
+		"message_type": messageTag,
+		"tags":         tags,
+	})
+}
+
+func (t *connectionTracer) ClosedConnection(err error, remote bool) {
+	quicErr := qerr.ToQuicError(err)
+	t.recordEvent("connectivity:connection_closed", map[string]interface{}{
+		"error_code": quicErr.ErrorCode.String(),
+		"reason":     quicErr.ErrorMessage,
+		"remote":     remote,
+	})
+}
+
+func (t *connectionTracer) Close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if err := t.buf.Flush(); err != nil {
+		t.logger.Errorf("Error writing trace: %s", err.Error())
+	}
+	if err := t.w.Close(); err != nil {
+		t.logger.Errorf("Error closing trace: %s", err.Error())
+	}
+}
+
+func (t *connectionTracer) recordEvent(name string, data interface{}) {
+	t.writeLine(&jsonEvent{
+		Time: milliseconds(time.Now().Sub(t.start)),
+		Name: name,
+		Data: data,
+	})
+}
+
+func (t *connectionTracer) writeLine(v interface{}) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		t.logger.Errorf("Error encoding trace event: %s", err.Error())
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return
+	}
+	t.buf.Write(line)
+	t.buf.WriteByte('\n')
+}
+
+func transformPacketHeader(hdr *PacketHeader) *jsonPacketHeader {
+	return &jsonPacketHeader{
+		ConnectionID:    fmt.Sprintf("%016x", uint64(hdr.ConnectionID)),
+		PacketNumber:    hdr.PacketNumber,
+		PacketNumberLen: hdr.PacketNumberLen,
+		VersionNumber:   hdr.VersionNumber,
+	}
+}
+
+func perspectiveString(p protocol.Perspective) string {
+	if p == protocol.PerspectiveClient {
+		return "client"
+	}
+	return "server"
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
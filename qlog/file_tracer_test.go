@@ -0,0 +1,221 @@
+package qlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("File Tracer", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "quic-go-qlog")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	readTrace := func(filename string) []map[string]interface{} {
+		f, err := os.Open(filepath.Join(dir, filename))
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			Expect(json.Unmarshal(scanner.Bytes(), &line)).To(Succeed())
+			lines = append(lines, line)
+		}
+		Expect(scanner.Err()).ToNot(HaveOccurred())
+		return lines
+	}
+
+	// traceEvents traces the events, and returns the data of all events, except for the first line describing the trace
+	traceEvents := func(trace func(ConnectionTracer)) []map[string]interface{} {
+		tracer := NewFileTracer(dir, utils.DefaultLogger).TracerForConnection(protocol.PerspectiveServer, 0x1337)
+		trace(tracer)
+		tracer.Close()
+		lines := readTrace("0000000000001337_server.qlog")
+		Expect(lines).ToNot(BeEmpty())
+		return lines[1:]
+	}
+
+	It("writes one file per connection", func() {
+		tracer := NewFileTracer(dir, utils.DefaultLogger)
+		tracer.TracerForConnection(protocol.PerspectiveServer, 0xdeadbeef).Close()
+		tracer.TracerForConnection(protocol.PerspectiveClient, 0xdecafbad).Close()
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		Expect(files[0].Name()).To(Equal("00000000deadbeef_server.qlog"))
+		Expect(files[1].Name()).To(Equal("00000000decafbad_client.qlog"))
+	})
+
+	It("doesn't trace if the file can't be created", func() {
+		tracer := NewFileTracer(filepath.Join(dir, "foo", "bar"), utils.DefaultLogger)
+		Expect(tracer.TracerForConnection(protocol.PerspectiveServer, 0x1337)).To(BeNil())
+	})
+
+	It("starts the file with a description of the trace", func() {
+		NewFileTracer(dir, utils.DefaultLogger).TracerForConnection(protocol.PerspectiveClient, 0x1337).Close()
+		lines := readTrace("0000000000001337_client.qlog")
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(HaveKeyWithValue("qlog_format", "NDJSON"))
+		trace := lines[0]["trace"].(map[string]interface{})
+		Expect(trace["vantage_point"]).To(HaveKeyWithValue("type", "client"))
+		Expect(trace["common_fields"]).To(HaveKeyWithValue("connection_id", "0000000000001337"))
+	})
+
+	It("traces sent packets", func() {
+		events := traceEvents(func(t ConnectionTracer) {
+			hdr := &PacketHeader{ConnectionID: 0x1337, PacketNumber: 42, PacketNumberLen: protocol.PacketNumberLen2}
+			t.SentPacket(hdr, 1200, []frames.Frame{
+				&frames.StreamFrame{StreamID: 5, Offset: 10, Data: []byte("foobar"), FinBit: true},
+				&frames.PingFrame{},
+			})
+		})
+		Expect(events).To(HaveLen(1))
+		Expect(events[0]).To(HaveKeyWithValue("name", "transport:packet_sent"))
+		Expect(events[0]).To(HaveKey("time"))
+		data := events[0]["data"].(map[string]interface{})
+		Expect(data).To(HaveKeyWithValue("size", BeEquivalentTo(1200)))
+		Expect(data["header"]).To(Equal(map[string]interface{}{
+			"connection_id":        "0000000000001337",
+			"packet_number":        float64(42),
+			"packet_number_length": float64(2),
+		}))
+		Expect(data["frames"]).To(Equal([]interface{}{
+			map[string]interface{}{"frame_type": "stream", "stream_id": float64(5), "offset": float64(10), "length": float64(6), "fin": true},
+			map[string]interface{}{"frame_type": "ping"},
+		}))
+	})
+
+	It("traces received packets", func() {
+		events := traceEvents(func(t ConnectionTracer) {
+			hdr := &PacketHeader{ConnectionID: 0x1337, PacketNumber: 42, VersionNumber: protocol.Version34}
+			t.ReceivedPacket(hdr, 1000, []frames.Frame{&frames.WindowUpdateFrame{StreamID: 3, ByteOffset: 0x1000}})
+		})
+		Expect(events).To(HaveLen(1))
+		Expect(events[0]).To(HaveKeyWithValue("name", "transport:packet_received"))
+		data := events[0]["data"].(map[string]interface{})
+		Expect(data["header"]).To(HaveKeyWithValue("version", BeEquivalentTo(protocol.Version34)))
+		Expect(data["frames"]).To(HaveLen(1))
+	})
+
+	It("traces lost packets", func() {
+		events := traceEvents(func(t ConnectionTracer) {
+			t.LostPacket(42, LossReasonRetransmissionTimeout)
+		})
+		Expect(events).To(HaveLen(1))
+		Expect(events[0]).To(HaveKeyWithValue("name", "recovery:packet_lost"))
+		Expect(events[0]["data"]).To(Equal(map[string]interface{}{
+			"packet_number": float64(42),
+			"trigger":       "retransmission_timeout",
+		}))
+	})
+
+	It("traces RTT and congestion window updates", func() {
+		events := traceEvents(func(t ConnectionTracer) {
+			t.UpdatedRTT(10*time.Millisecond, 5*time.Millisecond, 1500*time.Microsecond)
+			t.UpdatedCongestionWindow(20000, 5000)
+		})
+		Expect(events).To(HaveLen(2))
+		Expect(events[0]).To(HaveKeyWithValue("name", "recovery:metrics_updated"))
+		Expect(events[0]["data"]).To(Equal(map[string]interface{}{
+			"smoothed_rtt": float64(10),
+			"min_rtt":      float64(5),
+			"latest_rtt":   1.5,
+		}))
+		Expect(events[1]).To(HaveKeyWithValue("name", "recovery:metrics_updated"))
+		Expect(events[1]["data"]).To(Equal(map[string]interface{}{
+			"congestion_window": float64(20000),
+			"bytes_in_flight":   float64(5000),
+		}))
+	})
+
+	It("traces opened and closed streams", func() {
+		events := traceEvents(func(t ConnectionTracer) {
+			t.OpenedStream(5)
+			t.ClosedStream(5)
+		})
+		Expect(events).To(HaveLen(2))
+		Expect(events[0]).To(HaveKeyWithValue("name", "transport:stream_opened"))
+		Expect(events[0]["data"]).To(HaveKeyWithValue("stream_id", float64(5)))
+		Expect(events[1]).To(HaveKeyWithValue("name", "transport:stream_closed"))
+	})
+
+	It("traces handshake messages", func() {
+		events := traceEvents(func(t ConnectionTracer) {
+			t.ReceivedHandshakeMessage("CHLO", []string{"SNI", "VER"})
+			t.SentHandshakeMessage("REJ", []string{"SCFG"})
+		})
+		Expect(events).To(HaveLen(2))
+		Expect(events[0]).To(HaveKeyWithValue("name", "security:handshake_message_received"))
+		Expect(events[0]["data"]).To(Equal(map[string]interface{}{
+			"message_type": "CHLO",
+			"tags":         []interface{}{"SNI", "VER"},
+		}))
+		Expect(events[1]).To(HaveKeyWithValue("name", "security:handshake_message_sent"))
+	})
+
+	It("traces the close reason", func() {
+		events := traceEvents(func(t ConnectionTracer) {
+			t.ClosedConnection(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."), false)
+		})
+		Expect(events).To(HaveLen(1))
+		Expect(events[0]).To(HaveKeyWithValue("name", "connectivity:connection_closed"))
+		Expect(events[0]["data"]).To(Equal(map[string]interface{}{
+			"error_code": "NetworkIdleTimeout",
+			"reason":     "No recent network activity.",
+			"remote":     false,
+		}))
+	})
+
+	It("doesn't trace events after it was closed", func() {
+		tracer := NewFileTracer(dir, utils.DefaultLogger).TracerForConnection(protocol.PerspectiveServer, 0x1337)
+		tracer.Close()
+		tracer.OpenedStream(5)
+		tracer.Close()
+		Expect(readTrace("0000000000001337_server.qlog")).To(HaveLen(1))
+	})
+
+	It("appends to existing trace files", func() {
+		tracer := NewFileTracer(dir, utils.DefaultLogger)
+		tracer.TracerForConnection(protocol.PerspectiveClient, 0x1337).Close()
+		tracer.TracerForConnection(protocol.PerspectiveClient, 0x1337).Close()
+		Expect(readTrace("0000000000001337_client.qlog")).To(HaveLen(2))
+	})
+
+	It("closes the file even if writing fails", func() {
+		w := &errorWriteCloser{}
+		tracer := newConnectionTracer(w, protocol.PerspectiveServer, 0x1337, utils.DefaultLogger)
+		tracer.Close()
+		Expect(w.closed).To(BeTrue())
+	})
+})
+
+type errorWriteCloser struct {
+	closed bool
+}
+
+func (w *errorWriteCloser) Write([]byte) (int, error) { return 0, errors.New("write error") }
+func (w *errorWriteCloser) Close() error {
+	w.closed = true
+	return nil
+}
@@ -0,0 +1,102 @@
+package qlog
+
+import (
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+type jsonFrame map[string]interface{}
+
+// transformFrames converts frames to their JSON representation
+// The data of STREAM frames is not included, only its length.
+func transformFrames(fs []frames.Frame) []jsonFrame {
+	res := make([]jsonFrame, 0, len(fs))
+	for _, f := range fs {
+		res = append(res, transformFrame(f))
+	}
+	return res
+}
+
+func transformFrame(frame frames.Frame) jsonFrame {
+	switch f := frame.(type) {
+	case *frames.StreamFrame:
+		return jsonFrame{
+			"frame_type": "stream",
+			"stream_id":  f.StreamID,
+			"offset":     f.Offset,
+			"length":     len(f.Data),
+			"fin":        f.FinBit,
+		}
+	case *frames.AckFrame:
+		if f.AckFrameLegacy != nil {
+			return transformAckFrameLegacy(f.AckFrameLegacy)
+		}
+		ranges := make([][2]protocol.PacketNumber, 0, len(f.AckRanges))
+		for _, r := range f.AckRanges {
+			ranges = append(ranges, [2]protocol.PacketNumber{r.FirstPacketNumber, r.LastPacketNumber})
+		}
+		if len(ranges) == 0 {
+			ranges = append(ranges, [2]protocol.PacketNumber{f.LowestAcked, f.LargestAcked})
+		}
+		return jsonFrame{
+			"frame_type":   "ack",
+			"ack_delay":    milliseconds(f.DelayTime),
+			"acked_ranges": ranges,
+		}
+	case *frames.AckFrameLegacy:
+		return transformAckFrameLegacy(f)
+	case *frames.StopWaitingFrame:
+		return jsonFrame{
+			"frame_type":    "stop_waiting",
+			"least_unacked": f.LeastUnacked,
+		}
+	case *frames.WindowUpdateFrame:
+		return jsonFrame{
+			"frame_type":  "window_update",
+			"stream_id":   f.StreamID,
+			"byte_offset": f.ByteOffset,
+		}
+	case *frames.BlockedFrame:
+		return jsonFrame{
+			"frame_type": "blocked",
+			"stream_id":  f.StreamID,
+		}
+	case *frames.RstStreamFrame:
+		return jsonFrame{
+			"frame_type":  "rst_stream",
+			"stream_id":   f.StreamID,
+			"byte_offset": f.ByteOffset,
+			"error_code":  f.ErrorCode,
+		}
+	case *frames.PingFrame:
+		return jsonFrame{"frame_type": "ping"}
+	case *frames.ConnectionCloseFrame:
+		return jsonFrame{
+			"frame_type": "connection_close",
+			"error_code": f.ErrorCode.String(),
+			"reason":     f.ReasonPhrase,
+		}
+	case *frames.GoawayFrame:
+		return jsonFrame{
+			"frame_type":       "goaway",
+			"error_code":       f.ErrorCode.String(),
+			"last_good_stream": f.LastGoodStream,
+			"reason":           f.ReasonPhrase,
+		}
+	default:
+		return jsonFrame{"frame_type": "unknown"}
+	}
+}
+
+func transformAckFrameLegacy(f *frames.AckFrameLegacy) jsonFrame {
+	ranges := make([][2]protocol.PacketNumber, 0, len(f.NackRanges))
+	for _, r := range f.NackRanges {
+		ranges = append(ranges, [2]protocol.PacketNumber{r.FirstPacketNumber, r.LastPacketNumber})
+	}
+	return jsonFrame{
+		"frame_type":       "ack",
+		"ack_delay":        milliseconds(f.DelayTime),
+		"largest_observed": f.LargestObserved,
+		"nacked_ranges":    ranges,
+	}
+}
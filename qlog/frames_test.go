@@ -0,0 +1,51 @@
+package qlog
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Frames", func() {
+	It("transforms ACK frames", func() {
+		f := transformFrame(&frames.AckFrame{
+			LargestAcked: 10,
+			LowestAcked:  1,
+			AckRanges: []frames.AckRange{
+				{FirstPacketNumber: 8, LastPacketNumber: 10},
+				{FirstPacketNumber: 1, LastPacketNumber: 5},
+			},
+			DelayTime: 2 * time.Millisecond,
+		})
+		Expect(f).To(HaveKeyWithValue("frame_type", "ack"))
+		Expect(f).To(HaveKeyWithValue("ack_delay", float64(2)))
+		Expect(f["acked_ranges"]).To(HaveLen(2))
+	})
+
+	It("transforms ACK frames without missing ranges", func() {
+		f := transformFrame(&frames.AckFrame{LargestAcked: 10, LowestAcked: 1})
+		Expect(f["acked_ranges"]).To(HaveLen(1))
+	})
+
+	It("transforms legacy ACK frames", func() {
+		f := transformFrame(&frames.AckFrame{AckFrameLegacy: &frames.AckFrameLegacy{
+			LargestObserved: 10,
+			NackRanges:      []frames.NackRange{{FirstPacketNumber: 5, LastPacketNumber: 6}},
+		}})
+		Expect(f).To(HaveKeyWithValue("frame_type", "ack"))
+		Expect(f).To(HaveKey("largest_observed"))
+		Expect(f["nacked_ranges"]).To(HaveLen(1))
+	})
+
+	It("transforms control frames", func() {
+		Expect(transformFrame(&frames.StopWaitingFrame{LeastUnacked: 3})).To(HaveKeyWithValue("frame_type", "stop_waiting"))
+		Expect(transformFrame(&frames.BlockedFrame{StreamID: 3})).To(HaveKeyWithValue("frame_type", "blocked"))
+		Expect(transformFrame(&frames.RstStreamFrame{StreamID: 3})).To(HaveKeyWithValue("frame_type", "rst_stream"))
+		Expect(transformFrame(&frames.ConnectionCloseFrame{ErrorCode: qerr.PeerGoingAway})).To(HaveKeyWithValue("error_code", "PeerGoingAway"))
+		Expect(transformFrame(&frames.GoawayFrame{ErrorCode: qerr.PeerGoingAway, LastGoodStream: 7})).To(HaveKeyWithValue("frame_type", "goaway"))
+	})
+})
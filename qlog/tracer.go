@@ -0,0 +1,64 @@
+package qlog
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// A Tracer creates a ConnectionTracer for every new connection
+type Tracer interface {
+	// TracerForConnection is called when a new session is created.
+	// It may return nil, if the connection should not be traced.
+	TracerForConnection(p protocol.Perspective, connectionID protocol.ConnectionID) ConnectionTracer
+}
+
+// A ConnectionTracer receives the events of a single connection
+// Its methods are called from different go routines, and must not block.
+type ConnectionTracer interface {
+	SentPacket(hdr *PacketHeader, size protocol.ByteCount, frames []frames.Frame)
+	ReceivedPacket(hdr *PacketHeader, size protocol.ByteCount, frames []frames.Frame)
+	LostPacket(packetNumber protocol.PacketNumber, reason LossReason)
+	UpdatedRTT(smoothedRTT, minRTT, latestRTT time.Duration)
+	UpdatedCongestionWindow(congestionWindow, bytesInFlight protocol.ByteCount)
+	OpenedStream(protocol.StreamID)
+	ClosedStream(protocol.StreamID)
+	// SentHandshakeMessage and ReceivedHandshakeMessage are called with the message tag (e.g. CHLO) and the tags contained in the message
+	SentHandshakeMessage(messageTag string, tags []string)
+	ReceivedHandshakeMessage(messageTag string, tags []string)
+	// ClosedConnection is called when the connection is closed, either by us or by the peer
+	ClosedConnection(err error, remote bool)
+	// Close is called after the session's run loop returned. No more events are traced after that.
+	Close()
+}
+
+// A PacketHeader contains the public header fields of a traced packet
+type PacketHeader struct {
+	ConnectionID    protocol.ConnectionID
+	PacketNumber    protocol.PacketNumber
+	PacketNumberLen protocol.PacketNumberLen
+	// VersionNumber is only set if the packet contained a version
+	VersionNumber protocol.VersionNumber
+}
+
+// A LossReason is the reason why a packet was declared lost
+type LossReason uint8
+
+const (
+	// LossReasonNack means that the packet was missing in too many ACK frames
+	LossReasonNack LossReason = iota + 1
+	// LossReasonRetransmissionTimeout means that the packet wasn't acknowledged before the retransmission timeout
+	LossReasonRetransmissionTimeout
+)
+
+func (r LossReason) String() string {
+	switch r {
+	case LossReasonNack:
+		return "nack"
+	case LossReasonRetransmissionTimeout:
+		return "retransmission_timeout"
+	default:
+		return "unknown"
+	}
+}
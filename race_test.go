@@ -0,0 +1,7 @@
+// +build race
+
+package quic
+
+func init() {
+	raceEnabled = true
+}
@@ -0,0 +1,85 @@
+package quic
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenUDPReusePort opens n UDP sockets bound to the same address, using SO_REUSEPORT
+// the kernel then distributes incoming packets between the sockets, based on a hash of the 4-tuple
+func listenUDPReusePort(addr *net.UDPAddr, n int) ([]net.PacketConn, error) {
+	conns := make([]net.PacketConn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := listenUDPWithReusePort(addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+		// if the port was chosen by the kernel, all other sockets have to use the same port
+		if addr.Port == 0 {
+			addr = conn.LocalAddr().(*net.UDPAddr)
+		}
+	}
+	return conns, nil
+}
+
+func listenUDPWithReusePort(addr *net.UDPAddr) (net.PacketConn, error) {
+	// like the net package, listen on both IPv4 and IPv6 if no IP or an unspecified IP is given
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		conn, err := listenUDPSocketWithReusePort(syscall.AF_INET6, &syscall.SockaddrInet6{Port: addr.Port})
+		if err == nil || !isAddressFamilyNotSupported(err) {
+			return conn, err
+		}
+		// IPv6 is disabled on this host
+		return listenUDPSocketWithReusePort(syscall.AF_INET, &syscall.SockaddrInet4{Port: addr.Port})
+	}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		sa := &syscall.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip4)
+		return listenUDPSocketWithReusePort(syscall.AF_INET, sa)
+	}
+	sa := &syscall.SockaddrInet6{Port: addr.Port}
+	copy(sa.Addr[:], addr.IP.To16())
+	return listenUDPSocketWithReusePort(syscall.AF_INET6, sa)
+}
+
+// listenUDPSocketWithReusePort opens a UDP socket with SO_REUSEPORT and binds it to sockaddr
+// IPv6 sockets also accept IPv4 packets, if they are bound to the unspecified address.
+func listenUDPSocketWithReusePort(family int, sockaddr syscall.Sockaddr) (net.PacketConn, error) {
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_UDP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if family == syscall.AF_INET6 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0); err != nil {
+			syscall.Close(fd)
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if err := syscall.Bind(fd, sockaddr); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	// net.FilePacketConn duplicates the file descriptor, so we can close the file afterwards
+	f := os.NewFile(uintptr(fd), "quic-reuseport")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+func isAddressFamilyNotSupported(err error) bool {
+	if serr, ok := err.(*os.SyscallError); ok {
+		return serr.Err == syscall.EAFNOSUPPORT
+	}
+	return false
+}
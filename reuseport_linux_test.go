@@ -0,0 +1,83 @@
+package quic
+
+import (
+	"net"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/testdata"
+	"github.com/lucas-clemente/quic-go/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SO_REUSEPORT", func() {
+	It("opens multiple sockets on the same port", func() {
+		conns, err := listenUDPReusePort(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, 4)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conns).To(HaveLen(4))
+		addr := conns[0].LocalAddr().(*net.UDPAddr)
+		Expect(addr.Port).ToNot(BeZero())
+		for _, conn := range conns {
+			Expect(conn.LocalAddr()).To(Equal(addr))
+			Expect(conn.Close()).To(Succeed())
+		}
+	})
+
+	It("listens on IPv4 and IPv6 if no IP is given", func() {
+		conns, err := listenUDPReusePort(&net.UDPAddr{}, 2)
+		Expect(err).ToNot(HaveOccurred())
+		addr := conns[0].LocalAddr().(*net.UDPAddr)
+		Expect(addr.IP.IsUnspecified()).To(BeTrue())
+		Expect(conns[1].LocalAddr()).To(Equal(addr))
+
+		// the kernel distributes the packets between the sockets, so read on all of them
+		received := make(chan []byte, 2)
+		for _, conn := range conns {
+			go func(conn net.PacketConn) {
+				data := make([]byte, 100)
+				n, _, err := conn.ReadFrom(data)
+				if err == nil {
+					received <- data[:n]
+				}
+			}(conn)
+		}
+		clientConn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: addr.Port})
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		_, err = clientConn.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(received).Should(Receive(Equal([]byte("foobar"))))
+		for _, conn := range conns {
+			Expect(conn.Close()).To(Succeed())
+		}
+	})
+
+	It("listens with one receive loop per socket", func() {
+		ln, err := ListenAddr("127.0.0.1:0", &Config{
+			TLSConfig:        testdata.GetTLSConfig(),
+			Versions:         []protocol.VersionNumber{protocol.Version34},
+			ReusePortSockets: 4,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ln.(*server).conns).To(HaveLen(4))
+
+		// send packets from multiple client ports, so that they are distributed to different sockets
+		for i := 0; i < 8; i++ {
+			clientConn, err := net.DialUDP("udp", nil, ln.Addr().(*net.UDPAddr))
+			Expect(err).ToNot(HaveOccurred())
+			// Q033, which is not configured
+			_, err = clientConn.Write([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x33, 0x01})
+			Expect(err).ToNot(HaveOccurred())
+			data := make([]byte, 1000)
+			n, err := clientConn.Read(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data[:n]).To(Equal(composeVersionNegotiation(0x4cfa9f9b668619f6, []protocol.VersionNumber{protocol.Version34}, utils.DefaultLogger)))
+			clientConn.Close()
+		}
+
+		Expect(ln.Close()).To(Succeed())
+		_, err = ln.Accept()
+		Expect(err).To(HaveOccurred())
+	})
+})
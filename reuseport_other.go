@@ -0,0 +1,12 @@
+// +build !linux
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+func listenUDPReusePort(addr *net.UDPAddr, n int) ([]net.PacketConn, error) {
+	return nil, errors.New("quic: SO_REUSEPORT is only supported on Linux")
+}
@@ -0,0 +1,151 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// sendBufferMinCompactionSize is the minimum number of acknowledged bytes at the beginning of the underlying array, before the data is copied to a new array
+const sendBufferMinCompactionSize = 16 * 1024
+
+// A sendBuffer holds the data written to a stream until it was acknowledged by the peer
+// Data is never modified once it was written, so slices returned by Get stay valid after the data was acknowledged.
+type sendBuffer struct {
+	// data starts at offset, all data before offset was acknowledged
+	data   []byte
+	offset protocol.ByteCount
+	// acked contains the acknowledged ranges after offset, in ascending order
+	acked *utils.ByteIntervalList
+	// freed is the number of acknowledged bytes that are still kept alive by the underlying array of data
+	freed protocol.ByteCount
+
+	size protocol.ByteCount
+}
+
+func newSendBuffer(size protocol.ByteCount) *sendBuffer {
+	return &sendBuffer{
+		acked: utils.NewByteIntervalList(),
+		size:  size,
+	}
+}
+
+// Len returns the number of bytes that were written but not yet acknowledged
+func (b *sendBuffer) Len() protocol.ByteCount {
+	return protocol.ByteCount(len(b.data))
+}
+
+// End returns the offset of the end of the written data
+func (b *sendBuffer) End() protocol.ByteCount {
+	return b.offset + protocol.ByteCount(len(b.data))
+}
+
+// Write copies as much of p into the buffer as fits, and returns the number of bytes copied
+func (b *sendBuffer) Write(p []byte) int {
+	if b.Len() >= b.size {
+		return 0
+	}
+	n := len(p)
+	if available := b.size - b.Len(); protocol.ByteCount(n) > available {
+		n = int(available)
+	}
+	// append copies only the unacknowledged data if it has to grow the array
+	if len(b.data)+n > cap(b.data) {
+		b.freed = 0
+	}
+	b.data = append(b.data, p[:n]...)
+	return n
+}
+
+// Get returns at most maxLen bytes starting at offset
+func (b *sendBuffer) Get(offset, maxLen protocol.ByteCount) []byte {
+	if offset < b.offset || offset >= b.End() {
+		return nil
+	}
+	start := offset - b.offset
+	end := utils.MinByteCount(start+maxLen, b.Len())
+	return b.data[start:end]
+}
+
+// UnackedRange returns the first range in [start, end) that was not yet acknowledged
+// If all data in [start, end) was acknowledged, the returned range is empty.
+func (b *sendBuffer) UnackedRange(start, end protocol.ByteCount) (protocol.ByteCount, protocol.ByteCount) {
+	end = utils.MinByteCount(end, b.End())
+	if start < b.offset {
+		start = b.offset
+	}
+	for el := b.acked.Front(); el != nil && start < end; el = el.Next() {
+		if el.Value.End <= start {
+			continue
+		}
+		if el.Value.Start > start {
+			return start, utils.MinByteCount(end, el.Value.Start)
+		}
+		start = el.Value.End
+	}
+	if start >= end {
+		return end, end
+	}
+	return start, end
+}
+
+// Ack marks the data in [start, end) as acknowledged
+// It returns true if the beginning of the buffer was acknowledged, and the memory was freed.
+func (b *sendBuffer) Ack(start, end protocol.ByteCount) bool {
+	if start < b.offset {
+		start = b.offset
+	}
+	end = utils.MinByteCount(end, b.End())
+	if start >= end {
+		return false
+	}
+
+	// insert the range into the list of acknowledged ranges, merging it with overlapping and adjacent ranges
+	var el *utils.ByteIntervalElement
+	for el = b.acked.Front(); el != nil; el = el.Next() {
+		if el.Value.End >= start {
+			break
+		}
+	}
+	if el == nil || el.Value.Start > end {
+		intv := utils.ByteInterval{Start: start, End: end}
+		if el == nil {
+			el = b.acked.PushBack(intv)
+		} else {
+			el = b.acked.InsertBefore(intv, el)
+		}
+	} else {
+		el.Value.Start = utils.MinByteCount(el.Value.Start, start)
+		el.Value.End = utils.MaxByteCount(el.Value.End, end)
+		for next := el.Next(); next != nil && next.Value.Start <= el.Value.End; next = el.Next() {
+			el.Value.End = utils.MaxByteCount(el.Value.End, next.Value.End)
+			b.acked.Remove(next)
+		}
+	}
+
+	front := b.acked.Front()
+	if front.Value.Start != b.offset {
+		return false
+	}
+	b.acked.Remove(front)
+	b.freed += front.Value.End - b.offset
+	b.data = b.data[front.Value.End-b.offset:]
+	b.offset = front.Value.End
+	if len(b.data) == 0 {
+		b.data = nil
+		b.freed = 0
+	} else if b.freed >= sendBufferMinCompactionSize && b.freed > b.Len() {
+		// Most of the array was acknowledged. Copy the rest, so that the array can be garbage collected.
+		// The data is copied to a new array, so that slices returned by Get stay valid.
+		b.data = append([]byte(nil), b.data...)
+		b.freed = 0
+	}
+	return true
+}
+
+// Discard drops all data, as if it was acknowledged
+func (b *sendBuffer) Discard() {
+	b.offset = b.End()
+	b.data = nil
+	b.freed = 0
+	b.acked.Init()
+}
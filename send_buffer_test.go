@@ -0,0 +1,160 @@
+package quic
+
+import (
+	"bytes"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Send Buffer", func() {
+	var buf *sendBuffer
+
+	BeforeEach(func() {
+		buf = newSendBuffer(10)
+	})
+
+	Context("writing", func() {
+		It("writes data", func() {
+			Expect(buf.Write([]byte("foobar"))).To(Equal(6))
+			Expect(buf.Len()).To(Equal(protocol.ByteCount(6)))
+			Expect(buf.End()).To(Equal(protocol.ByteCount(6)))
+		})
+
+		It("only writes as much data as fits", func() {
+			Expect(buf.Write([]byte("foobar"))).To(Equal(6))
+			Expect(buf.Write([]byte("foobar"))).To(Equal(4))
+			Expect(buf.Write([]byte("foobar"))).To(BeZero())
+			Expect(buf.Len()).To(Equal(protocol.ByteCount(10)))
+		})
+
+		It("copies the data", func() {
+			b := []byte("foobar")
+			buf.Write(b)
+			b[0] = 'v'
+			Expect(buf.Get(0, 6)).To(Equal([]byte("foobar")))
+		})
+	})
+
+	Context("getting data", func() {
+		BeforeEach(func() {
+			buf.Write([]byte("foobar"))
+		})
+
+		It("gets data", func() {
+			Expect(buf.Get(0, 3)).To(Equal([]byte("foo")))
+			Expect(buf.Get(2, 3)).To(Equal([]byte("oba")))
+		})
+
+		It("doesn't return more data than was written", func() {
+			Expect(buf.Get(4, 10)).To(Equal([]byte("ar")))
+		})
+
+		It("returns nil for offsets outside of the buffer", func() {
+			Expect(buf.Get(6, 10)).To(BeNil())
+			buf.Ack(0, 3)
+			Expect(buf.Get(2, 10)).To(BeNil())
+		})
+	})
+
+	Context("acknowledging data", func() {
+		BeforeEach(func() {
+			buf.Write([]byte("foobar"))
+		})
+
+		It("frees the data at the beginning of the buffer", func() {
+			Expect(buf.Ack(0, 3)).To(BeTrue())
+			Expect(buf.Len()).To(Equal(protocol.ByteCount(3)))
+			Expect(buf.End()).To(Equal(protocol.ByteCount(6)))
+			Expect(buf.Get(3, 10)).To(Equal([]byte("bar")))
+			Expect(buf.Write([]byte("foobar"))).To(Equal(6))
+			Expect(buf.Len()).To(Equal(protocol.ByteCount(9)))
+		})
+
+		It("keeps the data until all data before it was acknowledged", func() {
+			Expect(buf.Ack(3, 6)).To(BeFalse())
+			Expect(buf.Len()).To(Equal(protocol.ByteCount(6)))
+			Expect(buf.Ack(0, 3)).To(BeTrue())
+			Expect(buf.Len()).To(BeZero())
+			Expect(buf.data).To(BeNil())
+			Expect(buf.acked.Len()).To(BeZero())
+		})
+
+		It("merges overlapping and adjacent ranges", func() {
+			buf.Ack(1, 2)
+			buf.Ack(4, 5)
+			buf.Ack(2, 4)
+			Expect(buf.acked.Len()).To(Equal(1))
+			Expect(buf.acked.Front().Value.Start).To(Equal(protocol.ByteCount(1)))
+			Expect(buf.acked.Front().Value.End).To(Equal(protocol.ByteCount(5)))
+			buf.Ack(0, 2)
+			Expect(buf.acked.Len()).To(BeZero())
+			Expect(buf.Len()).To(Equal(protocol.ByteCount(1)))
+		})
+
+		It("ignores data that was already acknowledged", func() {
+			buf.Ack(0, 3)
+			Expect(buf.Ack(0, 3)).To(BeFalse())
+			Expect(buf.Ack(1, 4)).To(BeTrue())
+			Expect(buf.Len()).To(Equal(protocol.ByteCount(2)))
+		})
+
+		It("copies the remaining data to a new array if most of the array was acknowledged", func() {
+			buf = newSendBuffer(4 * sendBufferMinCompactionSize)
+			buf.Write(bytes.Repeat([]byte{'a'}, 2*sendBufferMinCompactionSize))
+			data := buf.Get(0, 2*sendBufferMinCompactionSize)
+			buf.Ack(0, sendBufferMinCompactionSize/2)
+			Expect(&buf.data[0]).To(BeIdenticalTo(&data[sendBufferMinCompactionSize/2]))
+			buf.Ack(sendBufferMinCompactionSize/2, sendBufferMinCompactionSize+1)
+			Expect(buf.Len()).To(Equal(protocol.ByteCount(sendBufferMinCompactionSize - 1)))
+			Expect(cap(buf.data)).To(BeNumerically("<", sendBufferMinCompactionSize+100))
+			Expect(&buf.data[0]).ToNot(BeIdenticalTo(&data[sendBufferMinCompactionSize+1]))
+			Expect(buf.Get(sendBufferMinCompactionSize+1, 1)).To(Equal([]byte{'a'}))
+		})
+
+		It("discards all data", func() {
+			buf.Ack(2, 4)
+			buf.Discard()
+			Expect(buf.Len()).To(BeZero())
+			Expect(buf.End()).To(Equal(protocol.ByteCount(6)))
+			Expect(buf.acked.Len()).To(BeZero())
+		})
+	})
+
+	Context("unacknowledged ranges", func() {
+		BeforeEach(func() {
+			buf.Write([]byte("foobar"))
+		})
+
+		It("returns the whole range if nothing was acknowledged", func() {
+			start, end := buf.UnackedRange(1, 5)
+			Expect(start).To(Equal(protocol.ByteCount(1)))
+			Expect(end).To(Equal(protocol.ByteCount(5)))
+		})
+
+		It("skips acknowledged data", func() {
+			buf.Ack(0, 2)
+			buf.Ack(3, 4)
+			start, end := buf.UnackedRange(0, 6)
+			Expect(start).To(Equal(protocol.ByteCount(2)))
+			Expect(end).To(Equal(protocol.ByteCount(3)))
+			start, end = buf.UnackedRange(3, 6)
+			Expect(start).To(Equal(protocol.ByteCount(4)))
+			Expect(end).To(Equal(protocol.ByteCount(6)))
+		})
+
+		It("returns an empty range if all data was acknowledged", func() {
+			buf.Ack(2, 5)
+			start, end := buf.UnackedRange(2, 5)
+			Expect(start).To(Equal(end))
+		})
+
+		It("doesn't return data that wasn't written", func() {
+			start, end := buf.UnackedRange(4, 10)
+			Expect(start).To(Equal(protocol.ByteCount(4)))
+			Expect(end).To(Equal(protocol.ByteCount(6)))
+		})
+	})
+})
@@ -2,186 +2,466 @@ package quic
 
 import (
 	"bytes"
-	"crypto/tls"
+	"crypto/rand"
 	"net"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
+	"golang.org/x/net/ipv4"
 )
 
 // packetHandler handles packets
 type packetHandler interface {
-	handlePacket(addr interface{}, hdr *publicHeader, data []byte)
+	Session
+	handlePacket(*receivedPacket)
 	run()
-	Close(error) error
+	runOnEventLoop(*eventLoop)
+	// discard releases the resources of a session that was created, but is never run
+	discard()
+	hasActiveStreams() bool
 }
 
-// A Server of QUIC
-type Server struct {
-	addr *net.UDPAddr
+// shutdownPollInterval is the interval in which Shutdown checks if all sessions are idle
+var shutdownPollInterval = 100 * time.Millisecond
 
-	conn      *net.UDPConn
-	connMutex sync.Mutex
+// A Listener of QUIC
+type server struct {
+	// conns are the sockets the server reads packets from, there's one receive loop for each of them
+	// usually there's only a single socket, unless the Config requests multiple sockets using SO_REUSEPORT
+	conns  []net.PacketConn
+	config *Config
 
 	signer crypto.Signer
 	scfg   *handshake.ServerConfig
 
-	sessions      map[protocol.ConnectionID]packetHandler
-	sessionsMutex sync.RWMutex
+	sessions *sessionMap
+	// eventLoop is used to run the sessions if config.EventLoopWorkers is set, it is nil otherwise
+	eventLoop *eventLoop
+	// timeWait contains recently closed sessions, it is protected by the timeWaitMutex
+	timeWait      *timeWaitTable
+	timeWaitMutex sync.Mutex
 
-	streamCallback StreamCallback
+	// publicResetWindowStart and publicResetsInWindow are used to rate-limit public resets for unknown connection IDs
+	// they are protected by the publicResetMutex
+	publicResetWindowStart time.Time
+	publicResetsInWindow   int
+	publicResetMutex       sync.Mutex
 
-	newSession func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error)
+	serverError     error
+	serverErrorOnce sync.Once
+	sessionQueue    chan Session
+	errorChan       chan struct{}
+
+	newSession func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, closeCallback closeCallback, handshakeChan chan<- error, config *Config) (packetHandler, error)
 }
 
-// NewServer makes a new server
-func NewServer(addr string, tlsConfig *tls.Config, cb StreamCallback) (*Server, error) {
-	signer, err := crypto.NewProofSource(tlsConfig)
+var _ Listener = &server{}
+
+// ListenAddr creates a QUIC server listening on a given address.
+// The config must contain a TLSConfig, all other values are optional.
+// If config.ReusePortSockets is larger than 1, it opens that many sockets using SO_REUSEPORT.
+func ListenAddr(addr string, config *Config) (Listener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
 	}
-
-	kex, err := crypto.NewCurve25519KEX()
+	var conns []net.PacketConn
+	if config != nil && config.ReusePortSockets > 1 {
+		conns, err = listenUDPReusePort(udpAddr, config.ReusePortSockets)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		conns = []net.PacketConn{conn}
+	}
+	ln, err := listen(conns, config)
 	if err != nil {
+		for _, conn := range conns {
+			conn.Close()
+		}
 		return nil, err
 	}
-	scfg, err := handshake.NewServerConfig(kex, signer)
+	return ln, nil
+}
+
+// Listen listens for QUIC connections on a given net.PacketConn.
+// The listener takes ownership of the connection, it is closed when the listener is closed.
+func Listen(conn net.PacketConn, config *Config) (Listener, error) {
+	return listen([]net.PacketConn{conn}, config)
+}
+
+func listen(conns []net.PacketConn, config *Config) (Listener, error) {
+	config = populateConfig(config)
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	if len(config.ResetSecret) == 0 {
+		config.ResetSecret = make([]byte, 32)
+		if _, err := rand.Read(config.ResetSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	signer, err := crypto.NewProofSource(config.TLSConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	kex, err := crypto.NewCurve25519KEX()
+	if err != nil {
+		return nil, err
+	}
+	scfg, err := handshake.NewServerConfig(kex, signer)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{
-		addr:           udpAddr,
-		signer:         signer,
-		scfg:           scfg,
-		streamCallback: cb,
-		sessions:       map[protocol.ConnectionID]packetHandler{},
-		newSession:     newSession,
-	}, nil
+	s := &server{
+		conns:        conns,
+		config:       config,
+		signer:       signer,
+		scfg:         scfg,
+		sessions:     newSessionMap(),
+		timeWait:     newTimeWaitTable(),
+		newSession:   newSession,
+		sessionQueue: make(chan Session, protocol.MaxAcceptQueueSize),
+		errorChan:    make(chan struct{}),
+	}
+	if config.EventLoopWorkers > 0 {
+		s.eventLoop = newEventLoop(config.EventLoopWorkers)
+	}
+	for _, conn := range conns {
+		go s.serve(conn)
+	}
+	return s, nil
 }
 
-// ListenAndServe listens and serves a connection
-func (s *Server) ListenAndServe() error {
-	conn, err := net.ListenUDP("udp", s.addr)
-	if err != nil {
-		return err
+// serve reads packets from a connection until it is closed
+func (s *server) serve(conn net.PacketConn) {
+	if bconn := newBatchConn(conn); bconn != nil {
+		s.serveBatch(conn, bconn)
+		return
+	}
+	for {
+		buffer := getPacketBuffer()
+		n, remoteAddr, err := conn.ReadFrom(buffer.Slice[:protocol.MaxPacketSize])
+		if err != nil {
+			buffer.Release()
+			s.stopServing(err)
+			return
+		}
+		buffer.Slice = buffer.Slice[:n]
+		if err := s.handlePacket(conn, remoteAddr, buffer); err != nil {
+			s.config.Logger.Errorf("error handling packet: %s", err.Error())
+		}
+		buffer.Release()
 	}
-	return s.Serve(conn)
 }
 
-// Serve on an existing UDP connection.
-func (s *Server) Serve(conn *net.UDPConn) error {
-	s.connMutex.Lock()
-	s.conn = conn
-	s.connMutex.Unlock()
-
+// serveBatch reads packets from a connection until it is closed, reading multiple packets with a single syscall
+// If supported, UDP generic receive offload is used, and the coalesced packets are split before handling them.
+func (s *server) serveBatch(conn net.PacketConn, bconn batchConn) {
+	gro := enableGRO(conn)
+	msgs := make([]ipv4.Message, protocol.PacketBatchSize)
+	// buffers are the packet buffers that the messages are read into, if GRO is disabled
+	var buffers []*packetBuffer
+	if !gro {
+		buffers = make([]*packetBuffer, len(msgs))
+	}
+	for i := range msgs {
+		if gro {
+			msgs[i].Buffers = [][]byte{make([]byte, protocol.MaxCoalescedPacketSize)}
+			msgs[i].OOB = make([]byte, groOOBSize)
+		} else {
+			buffers[i] = getPacketBuffer()
+			msgs[i].Buffers = [][]byte{buffers[i].Slice[:protocol.MaxPacketSize]}
+		}
+	}
 	for {
-		data := getPacketBuffer()
-		data = data[:protocol.MaxPacketSize]
-		n, remoteAddr, err := conn.ReadFromUDP(data)
+		n, err := bconn.ReadBatch(msgs, 0)
 		if err != nil {
-			if strings.HasSuffix(err.Error(), "use of closed network connection") {
-				return nil
+			for _, buffer := range buffers {
+				buffer.Release()
 			}
-			return err
+			s.stopServing(err)
+			return
 		}
-		data = data[:n]
-		if err := s.handlePacket(conn, remoteAddr, data); err != nil {
-			utils.Errorf("error handling packet: %s", err.Error())
+		for i := 0; i < n; i++ {
+			if gro {
+				data := msgs[i].Buffers[0][:msgs[i].N]
+				s.handleCoalescedPackets(conn, msgs[i].Addr, data, groSegmentSize(msgs[i].OOB[:msgs[i].NN]))
+				continue
+			}
+			buffers[i].Slice = buffers[i].Slice[:msgs[i].N]
+			if err := s.handlePacket(conn, msgs[i].Addr, buffers[i]); err != nil {
+				s.config.Logger.Errorf("error handling packet: %s", err.Error())
+			}
+			// the session might have retained the buffer, use a new one for the next read
+			buffers[i].Release()
+			buffers[i] = getPacketBuffer()
+			msgs[i].Buffers[0] = buffers[i].Slice[:protocol.MaxPacketSize]
+		}
+	}
+}
+
+// handleCoalescedPackets splits packets that were coalesced by UDP generic receive offload
+// All packets have the segment size, except for the last one, which may be smaller.
+// A segment size of 0 means that data only contains a single packet.
+func (s *server) handleCoalescedPackets(conn net.PacketConn, remoteAddr net.Addr, data []byte, segmentSize int) {
+	if segmentSize <= 0 {
+		segmentSize = len(data)
+	}
+	for len(data) > 0 {
+		size := utils.Min(segmentSize, len(data))
+		// the data buffer is reused for the next read, so every packet has to be copied
+		buffer := getPacketBuffer()
+		buffer.Slice = append(buffer.Slice, data[:size]...)
+		if err := s.handlePacket(conn, remoteAddr, buffer); err != nil {
+			s.config.Logger.Errorf("error handling packet: %s", err.Error())
 		}
+		buffer.Release()
+		data = data[size:]
+	}
+}
+
+// stopServing is called when reading from one of the connections failed
+// Accept then returns the error.
+func (s *server) stopServing(err error) {
+	s.serverErrorOnce.Do(func() {
+		s.serverError = err
+		close(s.errorChan)
+	})
+}
+
+// Accept returns newly opened sessions, once their handshake has completed
+func (s *server) Accept() (Session, error) {
+	var sess Session
+	select {
+	case sess = <-s.sessionQueue:
+		return sess, nil
+	case <-s.errorChan:
+		return nil, s.serverError
 	}
 }
 
 // Close the server
-func (s *Server) Close() error {
-	s.sessionsMutex.Lock()
-	for _, session := range s.sessions {
-		if session != nil {
-			s.sessionsMutex.Unlock()
-			_ = session.Close(nil)
-			s.sessionsMutex.Lock()
+func (s *server) Close() error {
+	for _, session := range s.sessions.all() {
+		_ = session.Close(nil)
+	}
+
+	// let the event loop send the CONNECTION_CLOSEs before closing the connections
+	if s.eventLoop != nil {
+		s.eventLoop.close()
+	}
+
+	var err error
+	for _, conn := range s.conns {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
 		}
 	}
-	s.sessionsMutex.Unlock()
+	return err
+}
 
-	s.connMutex.Lock()
-	conn := s.conn
-	s.conn = nil
-	s.connMutex.Unlock()
+// Shutdown gracefully shuts down the server.
+// It stops creating sessions for new connection IDs, and sends a GOAWAY frame on all existing sessions.
+// It then waits until all streams are finished, or until the context is done, and then closes the server.
+// If the context is done before all streams finished, the context's error is returned.
+func (s *server) Shutdown(ctx context.Context) error {
+	for _, session := range s.sessions.freeze() {
+		_ = session.GoAway(qerr.PeerGoingAway, "server shutting down")
+	}
 
-	if conn == nil {
-		return nil
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	var ctxErr error
+	for ctxErr == nil && s.hasActiveStreams() {
+		select {
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		return err
+	}
+	return ctxErr
+}
+
+// hasActiveStreams says if any session still has streams that are not finished
+func (s *server) hasActiveStreams() bool {
+	for _, session := range s.sessions.all() {
+		if session.hasActiveStreams() {
+			return true
+		}
 	}
-	return conn.Close()
+	return false
+}
+
+// Addr returns the server's network address
+func (s *server) Addr() net.Addr {
+	return s.conns[0].LocalAddr()
 }
 
-func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet []byte) error {
+// handlePacket handles a packet received on pconn
+// The caller keeps its reference to the packet buffer, sessions retain the buffer if they queue the packet.
+func (s *server) handlePacket(pconn net.PacketConn, remoteAddr net.Addr, buffer *packetBuffer) error {
+	packet := buffer.Slice
 	if protocol.ByteCount(len(packet)) > protocol.MaxPacketSize {
 		return qerr.PacketTooLarge
 	}
 
 	r := bytes.NewReader(packet)
 
-	hdr, err := parsePublicHeader(r)
+	hdr, err := parsePublicHeader(r, protocol.PerspectiveClient)
 	if err != nil {
 		return qerr.Error(qerr.InvalidPacketHeader, err.Error())
 	}
 	hdr.Raw = packet[:len(packet)-r.Len()]
 
 	// Send Version Negotiation Packet if the client is speaking a different protocol version
-	if hdr.VersionFlag && !protocol.IsSupportedVersion(hdr.VersionNumber) {
-		utils.Infof("Client offered version %d, sending VersionNegotiationPacket", hdr.VersionNumber)
-		_, err = conn.WriteToUDP(composeVersionNegotiation(hdr.ConnectionID), remoteAddr)
+	if hdr.VersionFlag && !protocol.IsSupportedVersion(s.config.Versions, hdr.VersionNumber) {
+		s.config.Logger.Infof("Client offered version %d, sending VersionNegotiationPacket", hdr.VersionNumber)
+		_, err = pconn.WriteTo(composeVersionNegotiation(hdr.ConnectionID, s.config.Versions, s.config.Logger), remoteAddr)
 		return err
 	}
 
-	s.sessionsMutex.RLock()
-	session, ok := s.sessions[hdr.ConnectionID]
-	s.sessionsMutex.RUnlock()
+	session, ok := s.sessions.get(hdr.ConnectionID)
+
+	// Answer late packets for recently closed sessions by retransmitting the CONNECTION_CLOSE
+	if !ok {
+		s.timeWaitMutex.Lock()
+		connectionClosePacket, inTimeWait := s.timeWait.receivedPacket(hdr.ConnectionID, time.Now())
+		s.timeWaitMutex.Unlock()
+		if inTimeWait {
+			if connectionClosePacket == nil {
+				return nil
+			}
+			s.config.Logger.Debugf("Retransmitting CONNECTION_CLOSE for closed connection %x", hdr.ConnectionID)
+			_, err = pconn.WriteTo(connectionClosePacket, remoteAddr)
+			return err
+		}
+	}
 
+	// Only a CHLO can open a new connection, and the client always sends the version with it.
+	// Any other packet belongs to a connection we don't have any state for, e.g. because the server was restarted.
+	if !ok && !hdr.VersionFlag {
+		return s.maybeSendPublicReset(pconn, remoteAddr, hdr)
+	}
 	if !ok {
-		utils.Infof("Serving new connection: %x, version %d from %v", hdr.ConnectionID, hdr.VersionNumber, remoteAddr)
-		session, err = s.newSession(
-			&udpConn{conn: conn, currentAddr: remoteAddr},
-			hdr.VersionNumber,
-			hdr.ConnectionID,
-			s.scfg,
-			s.streamCallback,
-			s.closeCallback,
-		)
+		session, err = s.createSession(pconn, remoteAddr, hdr)
 		if err != nil {
 			return err
 		}
+		if session == nil {
+			s.config.Logger.Infof("Ignoring packet for new connection %x, the server is shutting down", hdr.ConnectionID)
+			return nil
+		}
+	}
+	session.handlePacket(&receivedPacket{
+		remoteAddr:   remoteAddr,
+		publicHeader: hdr,
+		data:         packet[len(packet)-r.Len():],
+		buffer:       buffer,
+	})
+	return nil
+}
+
+// createSession creates a new session for a connection ID, and starts its run loop
+// If another receive loop created a session for the same connection ID in the meantime, that session is returned instead.
+// It returns nil if the server is shutting down.
+func (s *server) createSession(pconn net.PacketConn, remoteAddr net.Addr, hdr *publicHeader) (packetHandler, error) {
+	// Creating a session is expensive, so don't do it if it would be discarded anyway.
+	if existing, ok, frozen := s.sessions.lookup(hdr.ConnectionID); frozen {
+		return nil, nil
+	} else if ok {
+		return existing, nil
+	}
+	// The session is created without holding any lock. It's only started if it was actually added to the map.
+	handshakeChan := make(chan error, 1)
+	session, err := s.newSession(
+		newConn(pconn, remoteAddr),
+		hdr.VersionNumber,
+		hdr.ConnectionID,
+		s.scfg,
+		s.closeCallback,
+		handshakeChan,
+		s.config,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if actual := s.sessions.add(hdr.ConnectionID, session); actual != session {
+		session.discard()
+		return actual, nil
+	}
+
+	s.config.Logger.Infof("Serving new connection: %x, version %d from %v", hdr.ConnectionID, hdr.VersionNumber, remoteAddr)
+	if s.eventLoop != nil {
+		session.runOnEventLoop(s.eventLoop)
+	} else {
 		go session.run()
-		s.sessionsMutex.Lock()
-		s.sessions[hdr.ConnectionID] = session
-		s.sessionsMutex.Unlock()
 	}
-	if session == nil {
-		// Late packet for closed session
+	go s.waitForHandshake(session, handshakeChan)
+	return session, nil
+}
+
+// maybeSendPublicReset sends a public reset for a packet with an unknown connection ID, unless the rate limit was reached
+func (s *server) maybeSendPublicReset(pconn net.PacketConn, remoteAddr net.Addr, hdr *publicHeader) error {
+	now := time.Now()
+	s.publicResetMutex.Lock()
+	if now.Sub(s.publicResetWindowStart) >= time.Second {
+		s.publicResetWindowStart = now
+		s.publicResetsInWindow = 0
+	}
+	if s.publicResetsInWindow >= protocol.MaxPublicResetsPerSecond {
+		s.publicResetMutex.Unlock()
+		s.config.Logger.Debugf("Not sending public reset for unknown connection %x, rate limit reached", hdr.ConnectionID)
 		return nil
 	}
-	session.handlePacket(remoteAddr, hdr, packet[len(packet)-r.Len():])
-	return nil
+	s.publicResetsInWindow++
+	s.publicResetMutex.Unlock()
+	s.config.Logger.Infof("Sending public reset for unknown connection %x to %v", hdr.ConnectionID, remoteAddr)
+	_, err := pconn.WriteTo(writePublicReset(hdr.ConnectionID, hdr.PacketNumber, publicResetNonceProof(s.config.ResetSecret, hdr.ConnectionID)), remoteAddr)
+	return err
+}
+
+// waitForHandshake queues the session for Accept once the handshake has completed
+func (s *server) waitForHandshake(session Session, handshakeChan <-chan error) {
+	if err := <-handshakeChan; err != nil {
+		return
+	}
+	select {
+	case s.sessionQueue <- session:
+	default:
+		s.config.Logger.Infof("Accept queue full, closing connection")
+		session.Close(qerr.Error(qerr.InternalError, "accept queue full"))
+	}
 }
 
-func (s *Server) closeCallback(id protocol.ConnectionID) {
-	s.sessionsMutex.Lock()
-	s.sessions[id] = nil
-	s.sessionsMutex.Unlock()
+// closeCallback moves a closed session to the time-wait table
+func (s *server) closeCallback(id protocol.ConnectionID, connectionClosePacket []byte) {
+	// add the connection ID to the time-wait table first, so that packets arriving in between don't look like packets for an unknown connection
+	s.timeWaitMutex.Lock()
+	s.timeWait.add(id, connectionClosePacket, time.Now())
+	s.timeWaitMutex.Unlock()
+	s.sessions.remove(id)
 }
 
-func composeVersionNegotiation(connectionID protocol.ConnectionID) []byte {
+func composeVersionNegotiation(connectionID protocol.ConnectionID, versions []protocol.VersionNumber, logger utils.Logger) []byte {
 	fullReply := &bytes.Buffer{}
 	responsePublicHeader := publicHeader{
 		ConnectionID: connectionID,
@@ -189,10 +469,10 @@ func composeVersionNegotiation(connectionID protocol.ConnectionID) []byte {
 		VersionFlag:  true,
 	}
 	// TODO: Update version number
-	err := responsePublicHeader.WritePublicHeader(fullReply, protocol.Version32)
+	err := responsePublicHeader.WritePublicHeader(fullReply, protocol.PerspectiveServer, protocol.Version32)
 	if err != nil {
-		utils.Errorf("error composing version negotiation packet: %s", err.Error())
+		logger.Errorf("error composing version negotiation packet: %s", err.Error())
 	}
-	fullReply.Write(protocol.SupportedVersionsAsTags)
+	fullReply.Write(protocol.VersionsAsTags(versions))
 	return fullReply.Bytes()
 }
@@ -3,118 +3,527 @@ package quic
 import (
 	"bytes"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/testdata"
+	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 type mockSession struct {
-	connectionID protocol.ConnectionID
-	packetCount  int
-	closed       bool
+	mutex sync.Mutex
+
+	connectionID  protocol.ConnectionID
+	packetCount   int
+	closed        bool
+	closeReason   error
+	handshakeChan chan<- error
+	goawayCode    qerr.ErrorCode
+	goawaySent    bool
+	activeStreams bool
+
+	runOnEventLoopCalled bool
+	discarded            bool
 }
 
-func (s *mockSession) handlePacket(addr interface{}, hdr *publicHeader, data []byte) {
+func (s *mockSession) handlePacket(*receivedPacket) {
 	s.packetCount++
 }
 
-func (s *mockSession) run()              {}
-func (s *mockSession) Close(error) error { s.closed = true; return nil }
+func (s *mockSession) run()                      {}
+func (s *mockSession) runOnEventLoop(*eventLoop) { s.runOnEventLoopCalled = true }
+func (s *mockSession) discard()                  { s.discarded = true }
+func (s *mockSession) hasActiveStreams() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.activeStreams
+}
+func (s *mockSession) setActiveStreams(active bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.activeStreams = active
+}
+func (s *mockSession) Close(e error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	s.closeReason = e
+	return nil
+}
+func (s *mockSession) isClosed() (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed, s.closeReason
+}
+func (s *mockSession) AcceptStream() (Stream, error) { panic("not implemented") }
+func (s *mockSession) OpenStream() (Stream, error)   { panic("not implemented") }
+func (s *mockSession) OpenStreamSync() (Stream, error) {
+	panic("not implemented")
+}
+func (s *mockSession) GetOrOpenStream(protocol.StreamID) (Stream, error) {
+	panic("not implemented")
+}
+func (s *mockSession) GoAway(code qerr.ErrorCode, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.goawaySent = true
+	s.goawayCode = code
+	return nil
+}
+func (s *mockSession) hasSentGoaway() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.goawaySent
+}
+func (s *mockSession) LocalAddr() net.Addr    { panic("not implemented") }
+func (s *mockSession) RemoteAddr() net.Addr   { panic("not implemented") }
+func (s *mockSession) Stats() ConnectionStats { panic("not implemented") }
+
+var _ Session = &mockSession{}
 
-func newMockSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error) {
+func newMockSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, closeCallback closeCallback, handshakeChan chan<- error, config *Config) (packetHandler, error) {
 	return &mockSession{
-		connectionID: connectionID,
+		connectionID:  connectionID,
+		handshakeChan: handshakeChan,
 	}, nil
 }
 
 var _ = Describe("Server", func() {
 	Describe("with mock session", func() {
 		var (
-			server *Server
+			serv *server
 		)
 
+		getSession := func(id protocol.ConnectionID) *mockSession {
+			session, ok := serv.sessions.get(id)
+			Expect(ok).To(BeTrue())
+			return session.(*mockSession)
+		}
+
 		BeforeEach(func() {
-			server = &Server{
-				sessions:   map[protocol.ConnectionID]packetHandler{},
-				newSession: newMockSession,
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+			Expect(err).ToNot(HaveOccurred())
+			serv = &server{
+				conns:        []net.PacketConn{conn},
+				config:       populateConfig(nil),
+				sessions:     newSessionMap(),
+				timeWait:     newTimeWaitTable(),
+				newSession:   newMockSession,
+				sessionQueue: make(chan Session, protocol.MaxAcceptQueueSize),
+				errorChan:    make(chan struct{}),
 			}
 		})
 
+		AfterEach(func() {
+			serv.conns[0].Close()
+		})
+
 		It("composes version negotiation packets", func() {
 			expected := append(
 				[]byte{0x01 | 0x08 | 0x04, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
 				protocol.SupportedVersionsAsTags...,
 			)
-			Expect(composeVersionNegotiation(1)).To(Equal(expected))
+			Expect(composeVersionNegotiation(1, protocol.SupportedVersions, utils.DefaultLogger)).To(Equal(expected))
+		})
+
+		It("only includes the configured versions in version negotiation packets", func() {
+			expected := append(
+				[]byte{0x01 | 0x08 | 0x04, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+				protocol.VersionsAsTags([]protocol.VersionNumber{protocol.Version34})...,
+			)
+			Expect(composeVersionNegotiation(1, []protocol.VersionNumber{protocol.Version34}, utils.DefaultLogger)).To(Equal(expected))
 		})
 
 		It("creates new sessions", func() {
-			err := server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+			err := serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(serv.sessions.len()).To(Equal(1))
+			Expect(getSession(0x4cfa9f9b668619f6).connectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+			Expect(getSession(0x4cfa9f9b668619f6).packetCount).To(Equal(1))
+		})
+
+		It("runs new sessions on the event loop, if configured", func() {
+			serv.eventLoop = newEventLoop(1)
+			defer serv.eventLoop.close()
+			err := serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
 			Expect(err).ToNot(HaveOccurred())
-			Expect(server.sessions).To(HaveLen(1))
-			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).connectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
-			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).packetCount).To(Equal(1))
+			Expect(getSession(0x4cfa9f9b668619f6).runOnEventLoopCalled).To(BeTrue())
 		})
 
 		It("assigns packets to existing sessions", func() {
-			err := server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+			err := serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
 			Expect(err).ToNot(HaveOccurred())
-			err = server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+			err = serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
 			Expect(err).ToNot(HaveOccurred())
-			Expect(server.sessions).To(HaveLen(1))
-			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).connectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
-			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).packetCount).To(Equal(2))
+			Expect(serv.sessions.len()).To(Equal(1))
+			Expect(getSession(0x4cfa9f9b668619f6).connectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+			Expect(getSession(0x4cfa9f9b668619f6).packetCount).To(Equal(2))
+		})
+
+		It("doesn't create a session if there already is a session for the connection ID", func() {
+			serv.newSession = func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, closeCallback closeCallback, handshakeChan chan<- error, config *Config) (packetHandler, error) {
+				Fail("didn't expect a session to be created")
+				return nil, nil
+			}
+			existing := &mockSession{}
+			serv.sessions.add(0x1337, existing)
+			session, err := serv.createSession(nil, nil, &publicHeader{ConnectionID: 0x1337, VersionNumber: protocol.Version34})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session).To(BeIdenticalTo(existing))
+		})
+
+		It("discards a session if another session was added for the same connection ID in the meantime", func() {
+			var created *mockSession
+			existing := &mockSession{}
+			serv.newSession = func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, closeCallback closeCallback, handshakeChan chan<- error, config *Config) (packetHandler, error) {
+				// another receive loop adds a session while this one is created
+				serv.sessions.add(connectionID, existing)
+				created = &mockSession{connectionID: connectionID}
+				return created, nil
+			}
+			session, err := serv.createSession(nil, nil, &publicHeader{ConnectionID: 0x1337, VersionNumber: protocol.Version34})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session).To(BeIdenticalTo(existing))
+			Expect(created.discarded).To(BeTrue())
+			Expect(existing.discarded).To(BeFalse())
+		})
+
+		It("splits coalesced packets", func() {
+			packet := []byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}
+			data := append(append([]byte{}, packet...), packet...)
+			serv.handleCoalescedPackets(nil, nil, data, len(packet))
+			Expect(serv.sessions.len()).To(Equal(1))
+			Expect(getSession(0x4cfa9f9b668619f6).packetCount).To(Equal(2))
+		})
+
+		It("handles data without a segment size as a single packet", func() {
+			serv.handleCoalescedPackets(nil, nil, []byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}, 0)
+			Expect(serv.sessions.len()).To(Equal(1))
+			Expect(getSession(0x4cfa9f9b668619f6).packetCount).To(Equal(1))
 		})
 
 		It("closes and deletes sessions", func() {
 			pheader := []byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}
-			err := server.handlePacket(nil, nil, append(pheader, (&crypto.NullAEAD{}).Seal(nil, nil, 0, pheader)...))
+			err := serv.handlePacket(nil, nil, getPacketBufferWithData(append(pheader, (&crypto.NullAEAD{}).Seal(nil, nil, 0, pheader)...)))
 			Expect(err).ToNot(HaveOccurred())
-			Expect(server.sessions).To(HaveLen(1))
-			server.closeCallback(0x4cfa9f9b668619f6)
-			// The server should now have closed the session, leaving a nil value in the sessions map
-			Expect(server.sessions).To(HaveLen(1))
-			Expect(server.sessions[0x4cfa9f9b668619f6]).To(BeNil())
+			Expect(serv.sessions.len()).To(Equal(1))
+			serv.closeCallback(0x4cfa9f9b668619f6, []byte("connection close"))
+			// The server should now have moved the session to the time-wait table
+			Expect(serv.sessions.len()).To(BeZero())
+			Expect(serv.timeWait.len()).To(Equal(1))
 		})
 
 		It("closes sessions when Close is called", func() {
 			session := &mockSession{}
-			server.sessions[1] = session
-			err := server.Close()
+			serv.sessions.add(1, session)
+			err := serv.Close()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(session.closed).To(BeTrue())
 		})
 
-		It("ignores packets for closed sessions", func() {
-			server.sessions[0x4cfa9f9b668619f6] = nil
-			err := server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+		Context("shutting down", func() {
+			It("sends a GOAWAY and closes all sessions", func() {
+				session := &mockSession{}
+				serv.sessions.add(1, session)
+				err := serv.Shutdown(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.goawaySent).To(BeTrue())
+				Expect(session.goawayCode).To(Equal(qerr.PeerGoingAway))
+				Expect(session.closed).To(BeTrue())
+			})
+
+			It("closes the connection", func() {
+				err := serv.Shutdown(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				_, err = serv.conns[0].WriteTo([]byte("foobar"), serv.conns[0].LocalAddr())
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("waits until all streams are finished", func() {
+				session := &mockSession{activeStreams: true}
+				serv.sessions.add(1, session)
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					err := serv.Shutdown(context.Background())
+					Expect(err).ToNot(HaveOccurred())
+					close(done)
+				}()
+				Eventually(session.hasSentGoaway).Should(BeTrue())
+				Consistently(done).ShouldNot(BeClosed())
+				closed, _ := session.isClosed()
+				Expect(closed).To(BeFalse())
+				session.setActiveStreams(false)
+				Eventually(done).Should(BeClosed())
+				closed, _ = session.isClosed()
+				Expect(closed).To(BeTrue())
+			})
+
+			It("closes all sessions when the context is done", func() {
+				session := &mockSession{activeStreams: true}
+				serv.sessions.add(1, session)
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				err := serv.Shutdown(ctx)
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+				Expect(session.closed).To(BeTrue())
+			})
+
+			It("doesn't create new sessions", func() {
+				serv.newSession = func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, closeCallback closeCallback, handshakeChan chan<- error, config *Config) (packetHandler, error) {
+					Fail("didn't expect a session to be created")
+					return nil, nil
+				}
+				serv.sessions.freeze()
+				err := serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(serv.sessions.len()).To(BeZero())
+			})
+
+			It("discards a session if the server started shutting down while it was created", func() {
+				var session *mockSession
+				serv.newSession = func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, closeCallback closeCallback, handshakeChan chan<- error, config *Config) (packetHandler, error) {
+					serv.sessions.freeze()
+					session = &mockSession{connectionID: connectionID}
+					return session, nil
+				}
+				err := serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(serv.sessions.len()).To(BeZero())
+				Expect(session.discarded).To(BeTrue())
+			})
+
+			It("still passes packets to existing sessions", func() {
+				session := &mockSession{}
+				serv.sessions.add(0x4cfa9f9b668619f6, session)
+				serv.sessions.freeze()
+				err := serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.packetCount).To(Equal(1))
+			})
+		})
+
+		It("retransmits the CONNECTION_CLOSE for late packets for closed sessions", func() {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			serv.closeCallback(0x4cfa9f9b668619f6, []byte("connection close"))
+			err = serv.handlePacket(serv.conns[0], conn.LocalAddr().(*net.UDPAddr), getPacketBufferWithData([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(serv.sessions.len()).To(BeZero())
+			data := make([]byte, 1000)
+			n, _, err := conn.ReadFromUDP(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data[:n]).To(Equal([]byte("connection close")))
+		})
+
+		It("ignores late packets for closed sessions that didn't send a CONNECTION_CLOSE", func() {
+			serv.closeCallback(0x4cfa9f9b668619f6, nil)
+			err := serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
 			Expect(err).ToNot(HaveOccurred())
-			Expect(server.sessions).To(HaveLen(1))
-			Expect(server.sessions[0x4cfa9f9b668619f6]).To(BeNil())
+			Expect(serv.sessions.len()).To(BeZero())
+		})
+
+		It("sends a version negotiation packet for versions that are supported, but not configured", func() {
+			serv.config.Versions = []protocol.VersionNumber{protocol.Version34}
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			// Q032
+			err = serv.handlePacket(serv.conns[0], conn.LocalAddr().(*net.UDPAddr), getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(serv.sessions.len()).To(BeZero())
+			data := make([]byte, 1000)
+			n, _, err := conn.ReadFromUDP(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data[:n]).To(Equal(composeVersionNegotiation(0x4cfa9f9b668619f6, serv.config.Versions, utils.DefaultLogger)))
+		})
+
+		Context("public resets for unknown connection IDs", func() {
+			var client *net.UDPConn
+
+			BeforeEach(func() {
+				var err error
+				client, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+				Expect(err).ToNot(HaveOccurred())
+				serv.config.ResetSecret = []byte("foobar")
+			})
+
+			AfterEach(func() {
+				client.Close()
+			})
+
+			It("sends a public reset for packets without the version flag", func() {
+				err := serv.handlePacket(serv.conns[0], client.LocalAddr().(*net.UDPAddr), getPacketBufferWithData([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(serv.sessions.len()).To(BeZero())
+				data := make([]byte, 1000)
+				n, _, err := client.ReadFromUDP(data)
+				Expect(err).ToNot(HaveOccurred())
+				r := bytes.NewReader(data[:n])
+				hdr, err := parsePublicHeader(r, protocol.PerspectiveServer)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hdr.ResetFlag).To(BeTrue())
+				Expect(hdr.ConnectionID).To(Equal(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+				pr, err := parsePublicReset(r)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pr.rejectedPacketNumber).To(Equal(protocol.PacketNumber(1)))
+				Expect(pr.nonce).To(Equal(publicResetNonceProof([]byte("foobar"), 0x4cfa9f9b668619f6)))
+			})
+
+			It("rate-limits public resets", func() {
+				for i := 0; i < protocol.MaxPublicResetsPerSecond+10; i++ {
+					err := serv.handlePacket(serv.conns[0], client.LocalAddr().(*net.UDPAddr), getPacketBufferWithData([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(serv.publicResetsInWindow).To(Equal(protocol.MaxPublicResetsPerSecond))
+				// the limit is reset after one second
+				serv.publicResetWindowStart = serv.publicResetWindowStart.Add(-time.Second)
+				err := serv.handlePacket(serv.conns[0], client.LocalAddr().(*net.UDPAddr), getPacketBufferWithData([]byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01}))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(serv.publicResetsInWindow).To(Equal(1))
+			})
 		})
 
 		It("errors on invalid public header", func() {
-			err := server.handlePacket(nil, nil, nil)
+			err := serv.handlePacket(nil, nil, getPacketBufferWithData(nil))
 			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidPacketHeader))
 		})
 
 		It("errors on large packets", func() {
-			err := server.handlePacket(nil, nil, bytes.Repeat([]byte{'a'}, int(protocol.MaxPacketSize)+1))
+			err := serv.handlePacket(nil, nil, getPacketBufferWithData(bytes.Repeat([]byte{'a'}, int(protocol.MaxPacketSize)+1)))
 			Expect(err).To(MatchError(qerr.PacketTooLarge))
 		})
+
+		Context("accepting sessions", func() {
+			var sess *mockSession
+
+			BeforeEach(func() {
+				err := serv.handlePacket(nil, nil, getPacketBufferWithData([]byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x32, 0x01}))
+				Expect(err).ToNot(HaveOccurred())
+				sess = getSession(0x4cfa9f9b668619f6)
+			})
+
+			It("accepts a session once the handshake has completed", func() {
+				sessChan := make(chan Session)
+				go func() {
+					defer GinkgoRecover()
+					acceptedSess, err := serv.Accept()
+					Expect(err).ToNot(HaveOccurred())
+					sessChan <- acceptedSess
+				}()
+				Consistently(sessChan).ShouldNot(Receive())
+				sess.handshakeChan <- nil
+				Eventually(sessChan).Should(Receive(Equal(sess)))
+			})
+
+			It("doesn't accept sessions that failed the handshake", func() {
+				sess.handshakeChan <- qerr.Error(qerr.HandshakeFailed, "foobar")
+				Consistently(serv.sessionQueue).ShouldNot(Receive())
+			})
+
+			It("closes sessions if the accept queue is full", func() {
+				for i := 0; i < protocol.MaxAcceptQueueSize; i++ {
+					serv.sessionQueue <- &mockSession{}
+				}
+				sess.handshakeChan <- nil
+				Eventually(func() bool { closed, _ := sess.isClosed(); return closed }).Should(BeTrue())
+				_, closeReason := sess.isClosed()
+				Expect(closeReason).To(MatchError(qerr.Error(qerr.InternalError, "accept queue full")))
+			})
+
+			It("returns an error from Accept when the server is closed", func() {
+				go serv.serve(serv.conns[0])
+				errChan := make(chan error, 1)
+				go func() {
+					defer GinkgoRecover()
+					_, err := serv.Accept()
+					errChan <- err
+				}()
+				Consistently(errChan).ShouldNot(Receive())
+				err := serv.Close()
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(errChan).Should(Receive(HaveOccurred()))
+			})
+		})
 	})
 
-	It("setups and responds with version negotiation", func(done Done) {
-		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	It("returns the address of the listener", func() {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := Listen(conn, &Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ln.Addr()).To(Equal(conn.LocalAddr()))
+		err = ln.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("listens on a given address", func() {
+		ln, err := ListenAddr("127.0.0.1:0", &Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ln.Addr().(*net.UDPAddr).IP.String()).To(Equal("127.0.0.1"))
+		err = ln.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("generates a reset secret if none is configured", func() {
+		ln, err := ListenAddr("127.0.0.1:0", &Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		Expect(ln.(*server).config.ResetSecret).To(HaveLen(32))
+	})
+
+	It("uses the configured reset secret", func() {
+		ln, err := ListenAddr("127.0.0.1:0", &Config{TLSConfig: testdata.GetTLSConfig(), ResetSecret: []byte("foobar")})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		Expect(ln.(*server).config.ResetSecret).To(Equal([]byte("foobar")))
+	})
+
+	It("serves over a net.PacketConn that isn't a UDP connection", func() {
+		pconn := newMockPacketConn()
+		ln, err := Listen(pconn, &Config{TLSConfig: testdata.GetTLSConfig(), Versions: []protocol.VersionNumber{protocol.Version34}})
+		Expect(err).ToNot(HaveOccurred())
+		defer ln.Close()
+		Expect(ln.Addr()).To(Equal(pconn.localAddr))
+		remoteAddr := &mockAddr{network: "mock", address: "remote"}
+		// Q033, which is not configured
+		pconn.dataToRead <- packetWithAddr{
+			data: []byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x51, 0x30, 0x33, 0x33, 0x01},
+			addr: remoteAddr,
+		}
+		var p packetWithAddr
+		Eventually(pconn.dataWritten).Should(Receive(&p))
+		Expect(p.addr).To(Equal(remoteAddr))
+		Expect(p.data).To(Equal(composeVersionNegotiation(0x4cfa9f9b668619f6, []protocol.VersionNumber{protocol.Version34}, utils.DefaultLogger)))
+	})
+
+	It("errors if the address can't be resolved", func() {
+		_, err := ListenAddr("invalid address", &Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("errors when the config contains unsupported versions", func() {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
 		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		_, err = Listen(conn, &Config{
+			TLSConfig: testdata.GetTLSConfig(),
+			Versions:  []protocol.VersionNumber{1},
+		})
+		Expect(err).To(MatchError("quic: unsupported version 1"))
+	})
 
-		server, err := NewServer("", testdata.GetTLSConfig(), nil)
+	It("setups and responds with version negotiation", func(done Done) {
+		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 		Expect(err).ToNot(HaveOccurred())
 
 		serverConn, err := net.ListenUDP("udp", addr)
@@ -122,10 +531,13 @@ var _ = Describe("Server", func() {
 
 		addr = serverConn.LocalAddr().(*net.UDPAddr)
 
+		ln, err := Listen(serverConn, &Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+
 		go func() {
 			defer GinkgoRecover()
-			err2 := server.Serve(serverConn)
-			Expect(err2).ToNot(HaveOccurred())
+			_, err2 := ln.Accept()
+			Expect(err2).To(HaveOccurred())
 			close(done)
 		}()
 
@@ -145,7 +557,7 @@ var _ = Describe("Server", func() {
 		)
 		Expect(data).To(Equal(expected))
 
-		err = server.Close()
+		err = ln.Close()
 		Expect(err).ToNot(HaveOccurred())
 	})
 
@@ -153,18 +565,18 @@ var _ = Describe("Server", func() {
 		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 		Expect(err).ToNot(HaveOccurred())
 
-		server, err := NewServer("", testdata.GetTLSConfig(), nil)
-		Expect(err).ToNot(HaveOccurred())
-
 		serverConn, err := net.ListenUDP("udp", addr)
 		Expect(err).NotTo(HaveOccurred())
 
 		addr = serverConn.LocalAddr().(*net.UDPAddr)
 
+		ln, err := Listen(serverConn, &Config{TLSConfig: testdata.GetTLSConfig()})
+		Expect(err).ToNot(HaveOccurred())
+
 		go func() {
 			defer GinkgoRecover()
-			err2 := server.Serve(serverConn)
-			Expect(err2).ToNot(HaveOccurred())
+			_, err2 := ln.Accept()
+			Expect(err2).To(HaveOccurred())
 			close(done)
 		}()
 
@@ -179,7 +591,7 @@ var _ = Describe("Server", func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(n).ToNot(BeZero())
 
-		err = server.Close()
+		err = ln.Close()
 		Expect(err).ToNot(HaveOccurred())
 	})
 })
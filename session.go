@@ -1,8 +1,10 @@
 package quic
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,17 +16,49 @@ import (
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/qlog"
 	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
 )
 
+// nonBlockingContext is an already cancelled context
+// Reading from a stream with it only returns the data that is available right away.
+var nonBlockingContext context.Context
+
+func init() {
+	var cancel context.CancelFunc
+	nonBlockingContext, cancel = context.WithCancel(context.Background())
+	cancel()
+}
+
 type unpacker interface {
 	Unpack(publicHeaderBinary []byte, hdr *publicHeader, data []byte) (*unpackedPacket, error)
 }
 
+// eventLoopCryptoSetup is implemented by crypto setups that can be driven by the event loop worker, instead of a go routine reading the crypto stream
+type eventLoopCryptoSetup interface {
+	HandleCryptoData(data *bytes.Buffer) (bool, error)
+}
+
+type cryptoSetup interface {
+	HandleCryptoStream() error
+	Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error)
+	Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte
+	LockForSealing()
+	UnlockForSealing()
+	HandshakeComplete() bool
+	DiversificationNonce() []byte
+	SetDiversificationNonce([]byte) error
+}
+
 type receivedPacket struct {
-	remoteAddr   interface{}
+	remoteAddr   net.Addr
 	publicHeader *publicHeader
 	data         []byte
+	// buffer is the packet buffer that the public header and the data are part of
+	buffer *packetBuffer
+	// queuedForDecryption is set once the packet was queued because it couldn't be decrypted, so that it isn't counted twice in the stats
+	queuedForDecryption bool
 }
 
 var (
@@ -33,37 +67,67 @@ var (
 	errWindowUpdateOnClosedStream  = errors.New("WINDOW_UPDATE received for an already closed stream")
 )
 
-// StreamCallback gets a stream frame and returns a reply frame
-type StreamCallback func(*Session, utils.Stream)
-
-// closeCallback is called when a session is closed
-type closeCallback func(id protocol.ConnectionID)
+// closeCallback is called when a session is closed, after the run loop returned
+// connectionClosePacket is the CONNECTION_CLOSE packet sent to the peer, it is nil if none was sent
+type closeCallback func(id protocol.ConnectionID, connectionClosePacket []byte)
 
 // A Session is a QUIC session
-type Session struct {
+type session struct {
 	connectionID protocol.ConnectionID
+	perspective  protocol.Perspective
 	version      protocol.VersionNumber
+	config       *Config
 
-	streamCallback StreamCallback
-	closeCallback  closeCallback
+	closeCallback closeCallback
 
 	conn connection
 
-	streams          map[protocol.StreamID]*stream
-	openStreamsCount uint32
-	streamsMutex     sync.RWMutex
+	streams      map[protocol.StreamID]*stream
+	streamsMutex sync.RWMutex
+	// numIncomingStreams and numOutgoingStreams count the open streams, they are protected by the streamsMutex
+	// the crypto stream doesn't count towards either limit
+	numIncomingStreams uint32
+	numOutgoingStreams uint32
+	// nextStreamToAccept is the ID of the next stream opened by the peer that will be returned by AcceptStream
+	// All streams of the peer up to the largestPeerStreamID are in the streams map, see newStreamImpl.
+	nextStreamToAccept protocol.StreamID
+	// nextStreamToOpen is the ID of the next stream opened by OpenStream or OpenStreamSync
+	nextStreamToOpen protocol.StreamID
+	// newStreamCond is signaled when a new stream is opened, it uses the streamsMutex
+	newStreamCond *sync.Cond
+	// outgoingStreamClosedCond is signaled when a stream opened by us is garbage collected, it uses the streamsMutex
+	outgoingStreamClosedCond *sync.Cond
+	// largestPeerStreamID is the largest ID of a stream opened by the peer
+	largestPeerStreamID protocol.StreamID
+	// goawaySent is set once we sent a GOAWAY, streams opened by the peer with IDs larger than lastGoodStream are rejected after that
+	goawaySent     bool
+	lastGoodStream protocol.StreamID
+	// rejectedStreams contains the highest offset received on every stream rejected after sending a GOAWAY
+	rejectedStreams map[protocol.StreamID]protocol.ByteCount
+	// goawayErr is set once a GOAWAY was received, no new streams can be opened after that
+	goawayErr error
+
+	// queuedControlFrames are control frames sent with the next packet, they may be queued from outside the run loop
+	queuedControlFrames      []frames.Frame
+	queuedControlFramesMutex sync.Mutex
 
 	sentPacketHandler     ackhandler.SentPacketHandler
 	receivedPacketHandler ackhandler.ReceivedPacketHandler
 	stopWaitingManager    ackhandler.StopWaitingManager
 	streamFramer          *streamFramer
+	streamScheduler       streamScheduler
 
 	flowControlManager flowcontrol.FlowControlManager
 
 	unpacker unpacker
 	packer   *packetPacker
 
-	cryptoSetup *handshake.CryptoSetup
+	cryptoSetup cryptoSetup
+
+	// tracer is nil if the connection is not traced
+	tracer qlog.ConnectionTracer
+	// logger attaches the connection ID and the remote address to all messages
+	logger utils.Logger
 
 	receivedPackets  chan receivedPacket
 	sendingScheduled chan struct{}
@@ -71,106 +135,186 @@ type Session struct {
 	// If the value is not nil, the error is sent as a CONNECTION_CLOSE.
 	closeChan chan *qerr.QuicError
 	closed    uint32 // atomic bool
+	// closeErr is the error the session was closed with, it is protected by the streamsMutex
+	closeErr error
 
 	undecryptablePackets []receivedPacket
 	aeadChanged          chan struct{}
 
+	// handshakeChan is used to signal that the handshake completed
+	// nil is sent on successful completion, the error the session was closed with otherwise
+	handshakeChan     chan<- error
+	handshakeComplete bool
+
 	delayedAckOriginTime time.Time
 
+	// packetsToSend are packed packets that are sent to the peer in a batch, they are only accessed from the run loop
+	packetsToSend [][]byte
+	// packetBuffersToSend are the buffers of the packetsToSend, they are released once the packets were sent
+	packetBuffersToSend []*packetBuffer
+
 	connectionParametersManager *handshake.ConnectionParametersManager
 
 	// Used to calculate the next packet number from the truncated wire
 	// representation, and sent back in public reset packets
 	lastRcvdPacketNumber protocol.PacketNumber
+	// largestRcvdPacketNumber is the largest packet number that was successfully unpacked
+	// only packets with a larger packet number may change the remote address
+	largestRcvdPacketNumber protocol.PacketNumber
 
 	lastNetworkActivityTime time.Time
 
 	timer           *time.Timer
 	currentDeadline time.Time
 	timerRead       bool
+
+	// loop is the event loop worker this session is multiplexed onto
+	// It is nil if the session runs its own go routine.
+	loop *eventLoopWorker
+	// runLoopDone is set once the session was closed on the event loop, it is only accessed by the event loop worker
+	runLoopDone bool
+	// loopScheduled is set while the session is scheduled on the event loop worker, it is protected by the worker's mutex
+	loopScheduled bool
+	// loopQueuedPackets is the number of packets queued for this session on the event loop worker, it is protected by the worker's mutex
+	loopQueuedPackets int
+	// cryptoDataReceived is set when the peer sent data on the crypto stream that the event loop worker didn't read yet, it is only accessed by the event loop worker
+	cryptoDataReceived bool
+	// cryptoData holds the data read from the crypto stream on the event loop, until a complete handshake message was received
+	cryptoData bytes.Buffer
+	// cryptoStreamDone is set once the handshake completed on the event loop, it is only accessed by the event loop worker
+	cryptoStreamDone bool
+
+	// stats are the packet counters, they are only accessed from the run loop
+	stats ConnectionStats
+	// publishedStats is the snapshot returned by Stats, it is updated after every run loop event and protected by the statsMutex
+	publishedStats ConnectionStats
+	statsMutex     sync.Mutex
+	// packetsDropped counts the packets discarded because the queue was full, it is accessed atomically
+	packetsDropped uint64
 }
 
+var _ Session = &session{}
+
 // newSession makes a new session
-func newSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error) {
-	connectionParametersManager := handshake.NewConnectionParamatersManager()
-	flowControlManager := flowcontrol.NewFlowControlManager(connectionParametersManager)
-
-	var stopWaitingManager ackhandler.StopWaitingManager
-	var sentPacketHandler ackhandler.SentPacketHandler
-	var receivedPacketHandler ackhandler.ReceivedPacketHandler
-
-	if v <= protocol.Version33 {
-		stopWaitingManager = ackhandlerlegacy.NewStopWaitingManager().(ackhandler.StopWaitingManager)
-		sentPacketHandler = ackhandlerlegacy.NewSentPacketHandler(stopWaitingManager).(ackhandler.SentPacketHandler)
-		receivedPacketHandler = ackhandlerlegacy.NewReceivedPacketHandler().(ackhandler.ReceivedPacketHandler)
-	} else {
-		sentPacketHandler = ackhandler.NewSentPacketHandler()
-		receivedPacketHandler = ackhandler.NewReceivedPacketHandler()
-	}
-
-	session := &Session{
-		connectionID:                connectionID,
-		version:                     v,
-		conn:                        conn,
-		streamCallback:              streamCallback,
-		closeCallback:               closeCallback,
-		streams:                     make(map[protocol.StreamID]*stream),
-		sentPacketHandler:           sentPacketHandler,
-		receivedPacketHandler:       receivedPacketHandler,
-		stopWaitingManager:          stopWaitingManager,
-		flowControlManager:          flowControlManager,
-		receivedPackets:             make(chan receivedPacket, protocol.MaxSessionUnprocessedPackets),
-		closeChan:                   make(chan *qerr.QuicError, 1),
-		sendingScheduled:            make(chan struct{}, 1),
-		connectionParametersManager: connectionParametersManager,
-		undecryptablePackets:        make([]receivedPacket, 0, protocol.MaxUndecryptablePackets),
-		aeadChanged:                 make(chan struct{}, 1),
-		timer:                       time.NewTimer(0),
-		lastNetworkActivityTime: time.Now(),
-	}
-
-	cryptoStream, _ := session.OpenStream(1)
+func newSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, closeCallback closeCallback, handshakeChan chan<- error, config *Config) (packetHandler, error) {
+	s := &session{
+		conn:          conn,
+		connectionID:  connectionID,
+		perspective:   protocol.PerspectiveServer,
+		version:       v,
+		config:        config,
+		closeCallback: closeCallback,
+		handshakeChan: handshakeChan,
+	}
+	s.setup()
+
+	cryptoStream, _ := s.GetOrOpenStream(1)
+	var err error
+	s.cryptoSetup, err = handshake.NewCryptoSetup(connectionID, conn.IP(), v, sCfg, cryptoStream, s.connectionParametersManager, config.Versions, s.tracer, s.logger, config.KeyLogWriter, s.aeadChanged)
+	if err != nil {
+		return nil, err
+	}
+
+	s.packer = newPacketPacker(connectionID, s.cryptoSetup, s.connectionParametersManager, s.streamFramer, s.perspective, v)
+	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: v}
+
+	return s, err
+}
+
+// newClientSession makes a new session for a client
+func newClientSession(conn connection, hostname string, v protocol.VersionNumber, connectionID protocol.ConnectionID, config *Config, closeCallback closeCallback, handshakeChan chan<- error) (*session, error) {
+	s := &session{
+		conn:          conn,
+		connectionID:  connectionID,
+		perspective:   protocol.PerspectiveClient,
+		version:       v,
+		config:        config,
+		closeCallback: closeCallback,
+		handshakeChan: handshakeChan,
+	}
+	s.setup()
+
+	cryptoStream, _ := s.GetOrOpenStream(1)
 	var err error
-	session.cryptoSetup, err = handshake.NewCryptoSetup(connectionID, conn.IP(), v, sCfg, cryptoStream, session.connectionParametersManager, session.aeadChanged)
+	s.cryptoSetup, err = handshake.NewCryptoSetupClient(hostname, connectionID, v, cryptoStream, config.TLSConfig, s.connectionParametersManager, s.tracer, s.logger, config.KeyLogWriter, s.aeadChanged)
 	if err != nil {
 		return nil, err
 	}
 
-	session.streamFramer = newStreamFramer(&session.streams, &session.streamsMutex, flowControlManager)
-	session.packer = newPacketPacker(connectionID, session.cryptoSetup, session.connectionParametersManager, session.streamFramer, v)
-	session.unpacker = &packetUnpacker{aead: session.cryptoSetup, version: v}
+	s.packer = newPacketPacker(connectionID, s.cryptoSetup, s.connectionParametersManager, s.streamFramer, s.perspective, v)
+	s.unpacker = &packetUnpacker{aead: s.cryptoSetup, version: v}
+
+	return s, err
+}
+
+// setup initializes all members of the session that are used by both the client and the server
+func (s *session) setup() {
+	s.logger = s.config.Logger.With("connection", fmt.Sprintf("%016x", uint64(s.connectionID))).With("remote", remoteAddrField{s.conn})
+	s.conn.setLogger(s.logger)
+	if s.config.Tracer != nil {
+		s.tracer = s.config.Tracer.TracerForConnection(s.perspective, s.connectionID)
+	}
+	s.connectionParametersManager = handshake.NewConnectionParamatersManager(
+		s.config.ReceiveStreamFlowControlWindow,
+		s.config.ReceiveConnectionFlowControlWindow,
+		s.config.MaxStreamsPerConnection,
+		s.config.MaxIdleConnectionStateLifetime,
+	)
+	s.flowControlManager = flowcontrol.NewFlowControlManager(s.connectionParametersManager)
+
+	if s.version <= protocol.Version33 {
+		s.stopWaitingManager = ackhandlerlegacy.NewStopWaitingManager().(ackhandler.StopWaitingManager)
+		s.sentPacketHandler = ackhandlerlegacy.NewSentPacketHandler(s.stopWaitingManager, s.config.InitialCongestionWindow, s.config.MaxCongestionWindow, s.tracer).(ackhandler.SentPacketHandler)
+		s.receivedPacketHandler = ackhandlerlegacy.NewReceivedPacketHandler().(ackhandler.ReceivedPacketHandler)
+	} else {
+		s.sentPacketHandler = ackhandler.NewSentPacketHandler(s.config.InitialCongestionWindow, s.config.MaxCongestionWindow, s.tracer)
+		s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler()
+	}
+
+	s.streams = make(map[protocol.StreamID]*stream)
+	s.newStreamCond = sync.NewCond(&s.streamsMutex)
+	s.outgoingStreamClosedCond = sync.NewCond(&s.streamsMutex)
+	// the crypto stream is opened by the client, so the first stream a server accepts is stream 3
+	if s.perspective == protocol.PerspectiveServer {
+		s.nextStreamToAccept = 3
+		s.nextStreamToOpen = 2
+	} else {
+		s.nextStreamToAccept = 2
+		s.nextStreamToOpen = 3
+	}
+	// servers using an event loop run their sessions on it, these sessions don't need their own packet queue and timer
+	if s.perspective == protocol.PerspectiveClient || s.config.EventLoopWorkers == 0 {
+		s.receivedPackets = make(chan receivedPacket, s.config.MaxSessionUnprocessedPackets)
+		s.timer = time.NewTimer(0)
+	}
+	s.closeChan = make(chan *qerr.QuicError, 1)
+	s.sendingScheduled = make(chan struct{}, 1)
+	s.undecryptablePackets = make([]receivedPacket, 0, protocol.MaxUndecryptablePackets)
+	s.aeadChanged = make(chan struct{}, 1)
+	s.lastNetworkActivityTime = time.Now()
 
-	return session, err
+	s.streamScheduler = newPriorityScheduler()
+	s.streamFramer = newStreamFramer(&s.streams, &s.streamsMutex, s.flowControlManager, s.streamScheduler)
 }
 
 // run the session main loop
-func (s *Session) run() {
-	// Start the crypto stream handler
-	go func() {
-		if err := s.cryptoSetup.HandleCryptoStream(); err != nil {
-			s.Close(err)
-		}
-	}()
+func (s *session) run() {
+	s.startCryptoStreamHandler()
 
 	for {
 		// Close immediately if requested
 		select {
 		case errForConnClose := <-s.closeChan:
-			if errForConnClose != nil {
-				s.sendConnectionClose(errForConnClose)
-			}
+			s.closeRunLoop(errForConnClose)
 			return
 		default:
 		}
 
 		s.maybeResetTimer()
 
-		var err error
 		select {
 		case errForConnClose := <-s.closeChan:
-			if errForConnClose != nil {
-				s.sendConnectionClose(errForConnClose)
-			}
+			s.closeRunLoop(errForConnClose)
 			return
 		case <-s.timer.C:
 			s.timerRead = true
@@ -180,36 +324,203 @@ func (s *Session) run() {
 			// We do all the interesting stuff after the switch statement, so
 			// nothing to see here.
 		case p := <-s.receivedPackets:
-			err = s.handlePacketImpl(p.remoteAddr, p.publicHeader, p.data)
-			if qErr, ok := err.(*qerr.QuicError); ok && qErr.ErrorCode == qerr.DecryptionFailure {
-				s.tryQueueingUndecryptablePacket(p)
+			if queued := s.handleReceivedPacket(p); queued {
 				continue
 			}
-			// This is a bit unclean, but works properly, since the packet always
-			// begins with the public header and we never copy it.
-			putPacketBuffer(p.publicHeader.Raw)
-			if s.delayedAckOriginTime.IsZero() {
-				s.delayedAckOriginTime = time.Now()
-			}
 		case <-s.aeadChanged:
-			s.tryDecryptingQueuedPackets()
+			s.handleAEADChanged()
+		}
+
+		s.afterRunLoopEvent()
+	}
+}
+
+// discard releases the resources of a session that is never run
+// The server creates sessions before adding them to the session map, and discards them if another session was added for the same connection ID in the meantime.
+func (s *session) discard() {
+	if s.tracer != nil {
+		s.tracer.Close()
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// runOnEventLoop multiplexes the session onto an event loop worker, instead of running its own go routine
+// The session then doesn't need its own timer and packet queue, the worker calls handleEventLoopEvent instead.
+// The worker also handles the crypto stream, see handleCryptoDataOnEventLoop.
+func (s *session) runOnEventLoop(loop *eventLoop) {
+	s.loop = loop.worker(s.connectionID)
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.receivedPackets = nil
+	if _, ok := s.cryptoSetup.(eventLoopCryptoSetup); !ok {
+		s.startCryptoStreamHandler()
+	}
+	// handle the first event right away, to register the idle timeout with the worker
+	s.loop.schedule(s)
+}
+
+// handleEventLoopEvent does the same as one iteration of the run loop, for sessions multiplexed onto an event loop worker
+// p is the packet that was received, it is nil if the session was scheduled, or one of its deadlines expired.
+func (s *session) handleEventLoopEvent(p *receivedPacket) {
+	if s.runLoopDone {
+		if p != nil {
+			p.buffer.Release()
+		}
+		return
+	}
+	select {
+	case errForConnClose := <-s.closeChan:
+		if p != nil {
+			p.buffer.Release()
+		}
+		s.runLoopDone = true
+		s.loop.timers.remove(s)
+		s.closeRunLoop(errForConnClose)
+		return
+	default:
+	}
+
+	if p != nil {
+		if queued := s.handleReceivedPacket(*p); queued {
+			return
 		}
+	}
+	if err := s.handleCryptoDataOnEventLoop(); err != nil {
+		s.Close(err)
+	}
+	// The AEAD change is handled before sending, since the reply to the handshake message was just written to the crypto stream.
+	select {
+	case <-s.aeadChanged:
+		s.handleAEADChanged()
+	default:
+	}
+
+	s.afterRunLoopEvent()
+	s.loop.timers.set(s, s.nextDeadline())
+}
 
+// handleCryptoDataOnEventLoop reads the data the peer sent on the crypto stream, and passes the complete handshake messages to the crypto setup
+// This way, sessions running on an event loop don't need a go routine for the handshake.
+func (s *session) handleCryptoDataOnEventLoop() error {
+	if !s.cryptoDataReceived || s.cryptoStreamDone {
+		return nil
+	}
+	s.cryptoDataReceived = false
+	s.streamsMutex.RLock()
+	cryptoStream := s.streams[1]
+	s.streamsMutex.RUnlock()
+	if cryptoStream == nil {
+		return qerr.HandshakeFailed
+	}
+	var b [512]byte
+	for {
+		n, err := cryptoStream.ReadContext(nonBlockingContext, b[:])
+		s.cryptoData.Write(b[:n])
+		if err == context.Canceled {
+			break
+		}
 		if err != nil {
-			s.Close(err)
+			return qerr.HandshakeFailed
 		}
+	}
+	done, err := s.cryptoSetup.(eventLoopCryptoSetup).HandleCryptoData(&s.cryptoData)
+	if err != nil {
+		return err
+	}
+	if done {
+		s.cryptoStreamDone = true
+		s.cryptoData = bytes.Buffer{}
+	}
+	return nil
+}
 
-		if err := s.sendPacket(); err != nil {
+func (s *session) startCryptoStreamHandler() {
+	go func() {
+		if err := s.cryptoSetup.HandleCryptoStream(); err != nil {
 			s.Close(err)
 		}
-		if time.Now().Sub(s.lastNetworkActivityTime) >= s.connectionParametersManager.GetIdleConnectionStateLifetime() {
-			s.Close(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."))
+	}()
+}
+
+// handleReceivedPacket processes a packet received by the run loop
+// It returns true if the packet couldn't be decrypted yet, and was queued for later decryption.
+// Otherwise, the reference to the packet buffer that was retained when queueing the packet is released.
+func (s *session) handleReceivedPacket(p receivedPacket) bool {
+	if !p.queuedForDecryption {
+		s.stats.PacketsReceived++
+		s.stats.BytesReceived += protocol.ByteCount(len(p.publicHeader.Raw) + len(p.data))
+	}
+	err := s.handlePacketImpl(&p)
+	if qErr, ok := err.(*qerr.QuicError); ok && qErr.ErrorCode == qerr.DecryptionFailure {
+		if !p.queuedForDecryption {
+			s.stats.PacketsUndecryptable++
 		}
-		s.garbageCollectStreams()
+		s.tryQueueingUndecryptablePacket(p)
+		return true
+	}
+	p.buffer.Release()
+	if s.delayedAckOriginTime.IsZero() {
+		s.delayedAckOriginTime = time.Now()
 	}
+	if err != nil {
+		s.Close(err)
+	}
+	return false
+}
+
+func (s *session) handleAEADChanged() {
+	s.tryDecryptingQueuedPackets()
+	s.maybeSignalHandshakeComplete()
+}
+
+// afterRunLoopEvent is called by the run loop after handling an event
+// It sends all packets that can be sent, and checks if the session timed out.
+func (s *session) afterRunLoopEvent() {
+	if err := s.sendPacket(); err != nil {
+		s.Close(err)
+	}
+	if time.Now().Sub(s.lastNetworkActivityTime) >= s.connectionParametersManager.GetIdleConnectionStateLifetime() {
+		s.Close(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."))
+	}
+	s.garbageCollectStreams()
+	s.publishStats()
+}
+
+// publishStats updates the snapshot returned by Stats
+func (s *session) publishStats() {
+	sentStats := s.sentPacketHandler.GetStats()
+	s.stats.SmoothedRTT = sentStats.SmoothedRTT
+	s.stats.MinRTT = sentStats.MinRTT
+	s.stats.LatestRTT = sentStats.LatestRTT
+	s.stats.CongestionWindow = sentStats.CongestionWindow
+	s.stats.SlowStartThreshold = sentStats.SlowStartThreshold
+	s.stats.BytesInFlight = sentStats.BytesInFlight
+	s.stats.PacketsLost = sentStats.PacketsLost
+	s.stats.BytesLost = sentStats.BytesLost
+
+	s.statsMutex.Lock()
+	s.publishedStats = s.stats
+	s.statsMutex.Unlock()
 }
 
-func (s *Session) maybeResetTimer() {
+// maybeSignalHandshakeComplete notifies the handshakeChan once the handshake has completed
+func (s *session) maybeSignalHandshakeComplete() {
+	if s.handshakeChan == nil || s.handshakeComplete || !s.cryptoSetup.HandshakeComplete() {
+		return
+	}
+	s.handshakeComplete = true
+	select {
+	case s.handshakeChan <- nil:
+	default:
+	}
+}
+
+// nextDeadline is the earliest of the idle timeout, the ack delay and the retransmission timeout
+func (s *session) nextDeadline() time.Time {
 	nextDeadline := s.lastNetworkActivityTime.Add(s.connectionParametersManager.GetIdleConnectionStateLifetime())
 
 	if !s.delayedAckOriginTime.IsZero() {
@@ -218,6 +529,11 @@ func (s *Session) maybeResetTimer() {
 	if rtoTime := s.sentPacketHandler.TimeOfFirstRTO(); !rtoTime.IsZero() {
 		nextDeadline = utils.MinTime(nextDeadline, rtoTime)
 	}
+	return nextDeadline
+}
+
+func (s *session) maybeResetTimer() {
+	nextDeadline := s.nextDeadline()
 
 	if nextDeadline.Equal(s.currentDeadline) {
 		// No need to reset the timer
@@ -235,7 +551,9 @@ func (s *Session) maybeResetTimer() {
 	s.currentDeadline = nextDeadline
 }
 
-func (s *Session) handlePacketImpl(remoteAddr interface{}, hdr *publicHeader, data []byte) error {
+func (s *session) handlePacketImpl(p *receivedPacket) error {
+	hdr := p.publicHeader
+	data := p.data
 	s.lastNetworkActivityTime = time.Now()
 
 	// Calculate packet number
@@ -245,25 +563,42 @@ func (s *Session) handlePacketImpl(remoteAddr interface{}, hdr *publicHeader, da
 		hdr.PacketNumber,
 	)
 	s.lastRcvdPacketNumber = hdr.PacketNumber
-	if utils.Debug() {
-		utils.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID)
+	if s.logger.Debug() {
+		s.logger.Debugf("<- Reading packet 0x%x (%d bytes)", hdr.PacketNumber, len(data)+len(hdr.Raw))
 	}
 
-	// TODO: Only do this after authenticating
-	s.conn.setCurrentRemoteAddr(remoteAddr)
+	// the diversification nonce is needed to derive the keys used for decrypting this packet
+	if s.perspective == protocol.PerspectiveClient && len(hdr.DiversificationNonce) > 0 {
+		if err := s.cryptoSetup.SetDiversificationNonce(hdr.DiversificationNonce); err != nil {
+			return err
+		}
+	}
 
 	packet, err := s.unpacker.Unpack(hdr.Raw, hdr, data)
 	if err != nil {
 		return err
 	}
+	if s.tracer != nil {
+		s.tracer.ReceivedPacket(tracePacketHeader(hdr), protocol.ByteCount(len(hdr.Raw)+len(data)), packet.frames)
+	}
+	// Stream frames reference the packet data. Streams retain the buffer if they queue a frame.
+	if p.buffer != nil {
+		for _, f := range packet.frames {
+			if frame, ok := f.(*frames.StreamFrame); ok {
+				frame.Buffer = p.buffer
+			}
+		}
+	}
 
 	err = s.receivedPacketHandler.ReceivedPacket(hdr.PacketNumber, packet.entropyBit)
 	// ignore duplicate packets
 	if err == ackhandlerlegacy.ErrDuplicatePacket || err == ackhandler.ErrDuplicatePacket {
+		s.stats.PacketsDropped++
 		return nil
 	}
 	// ignore packets with packet numbers smaller than the LeastUnacked of a StopWaiting
 	if err == ackhandlerlegacy.ErrPacketSmallerThanLastStopWaiting || err == ackhandler.ErrPacketSmallerThanLastStopWaiting {
+		s.stats.PacketsDropped++
 		return nil
 	}
 
@@ -271,13 +606,56 @@ func (s *Session) handlePacketImpl(remoteAddr interface{}, hdr *publicHeader, da
 		return err
 	}
 
+	// the packet was authenticated, so it's safe to switch to the address it was sent from
+	// reordered packets from an old address must not switch back to that address
+	if hdr.PacketNumber > s.largestRcvdPacketNumber {
+		s.largestRcvdPacketNumber = hdr.PacketNumber
+		s.maybeMigrateConnection(p.remoteAddr)
+	}
+
 	return s.handleFrames(packet.frames)
 }
 
-func (s *Session) handleFrames(fs []frames.Frame) error {
+// maybeMigrateConnection switches to a new remote address, if the peer's address changed
+// If only the port changed, this is most likely a NAT rebinding, and the path characteristics stay the same.
+// Otherwise, the congestion controller and the RTT measurements are reset.
+func (s *session) maybeMigrateConnection(newAddr net.Addr) {
+	// only the client can migrate a connection
+	if s.perspective == protocol.PerspectiveClient || newAddr == nil {
+		return
+	}
+	oldAddr := s.conn.RemoteAddr()
+	if oldAddr.Network() == newAddr.Network() && oldAddr.String() == newAddr.String() {
+		return
+	}
+	// for UDP addresses, we can tell if only the port changed
+	var natRebinding bool
+	if oldUDPAddr, ok := oldAddr.(*net.UDPAddr); ok {
+		if newUDPAddr, ok := newAddr.(*net.UDPAddr); ok {
+			natRebinding = oldUDPAddr.IP.Equal(newUDPAddr.IP)
+		}
+	}
+
+	s.conn.setCurrentRemoteAddr(newAddr)
+	if natRebinding {
+		s.logger.Infof("NAT rebinding from %s to %s", oldAddr, newAddr)
+	} else {
+		s.logger.Infof("Connection migrated from %s to %s", oldAddr, newAddr)
+		s.sentPacketHandler.OnConnectionMigration()
+	}
+	if s.config.ConnectionMigrated != nil {
+		s.config.ConnectionMigrated(s, ConnectionMigration{
+			OldAddr:      oldAddr,
+			NewAddr:      newAddr,
+			NATRebinding: natRebinding,
+		})
+	}
+}
+
+func (s *session) handleFrames(fs []frames.Frame) error {
 	for _, ff := range fs {
 		var err error
-		frames.LogFrame(ff, false)
+		frames.LogFrame(s.logger, ff, false)
 		switch frame := ff.(type) {
 		case *frames.StreamFrame:
 			err = s.handleStreamFrame(frame)
@@ -287,7 +665,7 @@ func (s *Session) handleFrames(fs []frames.Frame) error {
 		case *frames.ConnectionCloseFrame:
 			s.closeImpl(qerr.Error(frame.ErrorCode, frame.ReasonPhrase), true)
 		case *frames.GoawayFrame:
-			err = errors.New("unimplemented: handling GOAWAY frames")
+			s.handleGoawayFrame(frame)
 		case *frames.StopWaitingFrame:
 			err = s.receivedPacketHandler.ReceivedStopWaiting(frame)
 		case *frames.RstStreamFrame:
@@ -308,7 +686,7 @@ func (s *Session) handleFrames(fs []frames.Frame) error {
 				// Can happen e.g. when packets thought missing arrive late
 			case errRstStreamOnInvalidStream:
 				// Can happen when RST_STREAMs arrive early or late (?)
-				utils.Errorf("Ignoring error in session: %s", err.Error())
+				s.logger.Errorf("Ignoring error in session: %s", err.Error())
 			case errWindowUpdateOnClosedStream:
 				// Can happen when we already sent the last StreamFrame with the FinBit, but the client already sent a WindowUpdate for this Stream
 			default:
@@ -320,16 +698,27 @@ func (s *Session) handleFrames(fs []frames.Frame) error {
 }
 
 // handlePacket handles a packet
-func (s *Session) handlePacket(remoteAddr interface{}, hdr *publicHeader, data []byte) {
+// The packet buffer is retained until the packet was processed.
+func (s *session) handlePacket(p *receivedPacket) {
+	p.buffer.Retain()
+	if s.loop != nil {
+		if !s.loop.queuePacket(s, *p) {
+			p.buffer.Release()
+			atomic.AddUint64(&s.packetsDropped, 1)
+		}
+		return
+	}
 	// Discard packets once the amount of queued packets is larger than
-	// the channel size, protocol.MaxSessionUnprocessedPackets
+	// the channel size, config.MaxSessionUnprocessedPackets
 	select {
-	case s.receivedPackets <- receivedPacket{remoteAddr: remoteAddr, publicHeader: hdr, data: data}:
+	case s.receivedPackets <- *p:
 	default:
+		p.buffer.Release()
+		atomic.AddUint64(&s.packetsDropped, 1)
 	}
 }
 
-func (s *Session) handleStreamFrame(frame *frames.StreamFrame) error {
+func (s *session) handleStreamFrame(frame *frames.StreamFrame) error {
 	s.streamsMutex.Lock()
 	defer s.streamsMutex.Unlock()
 	str, streamExists := s.streams[frame.StreamID]
@@ -339,6 +728,9 @@ func (s *Session) handleStreamFrame(frame *frames.StreamFrame) error {
 		if !s.isValidStreamID(frame.StreamID) {
 			return qerr.InvalidStreamID
 		}
+		if s.goawaySent && frame.StreamID > s.lastGoodStream {
+			return s.handleRejectedStreamData(frame.StreamID, frame.Offset+frame.DataLen())
+		}
 
 		str, err = s.newStreamImpl(frame.StreamID)
 		if err != nil {
@@ -349,23 +741,114 @@ func (s *Session) handleStreamFrame(frame *frames.StreamFrame) error {
 		// Stream is closed, ignore
 		return nil
 	}
-	err = str.AddStreamFrame(frame)
-	if err != nil {
+	if err := str.AddStreamFrame(frame); err != nil {
 		return err
 	}
-	if !streamExists {
-		s.streamCallback(s, str)
+	// On the event loop, the crypto stream is read by the worker after handling the packet.
+	if frame.StreamID == 1 && s.loop != nil {
+		s.cryptoDataReceived = true
+	}
+	return nil
+}
+
+// handleRejectedStreamData handles data received on a stream opened by the peer after we sent a GOAWAY
+// The peer already spent connection level flow control credit on the data, so it is treated as read.
+// A RST_STREAM frame is only sent when the first data of the stream is received.
+// The streamsMutex has to be held when calling this function.
+func (s *session) handleRejectedStreamData(id protocol.StreamID, maxOffset protocol.ByteCount) error {
+	highestOffset, rejected := s.rejectedStreams[id]
+	if maxOffset > highestOffset {
+		if err := s.flowControlManager.DiscardConnectionData(maxOffset - highestOffset); err != nil {
+			return qerr.FlowControlReceivedTooMuchData
+		}
+		highestOffset = maxOffset
 	}
+	s.rejectedStreams[id] = highestOffset
+	if rejected {
+		return nil
+	}
+	s.logger.Debugf("Rejecting stream %d, since we already sent a GOAWAY", id)
+	s.queueControlFrame(&frames.RstStreamFrame{
+		StreamID:   id,
+		ByteOffset: maxOffset,
+		ErrorCode:  uint32(qerr.PeerGoingAway),
+	})
 	return nil
 }
 
-func (s *Session) isValidStreamID(streamID protocol.StreamID) bool {
+// isValidStreamID checks if a stream ID is valid for a stream opened by the peer
+// streams opened by the client have odd IDs, those opened by the server have even IDs
+func (s *session) isValidStreamID(streamID protocol.StreamID) bool {
+	if s.perspective == protocol.PerspectiveClient {
+		return streamID%2 == 0
+	}
 	return streamID%2 == 1
 }
 
-func (s *Session) handleWindowUpdateFrame(frame *frames.WindowUpdateFrame) error {
-	s.streamsMutex.RLock()
-	defer s.streamsMutex.RUnlock()
+// handleGoawayFrame stops opening new streams, and closes the streams that the peer won't process
+func (s *session) handleGoawayFrame(frame *frames.GoawayFrame) {
+	s.streamsMutex.Lock()
+	defer s.streamsMutex.Unlock()
+	if s.goawayErr != nil {
+		return
+	}
+	s.goawayErr = qerr.Error(frame.ErrorCode, "GOAWAY received: "+frame.ReasonPhrase)
+	// unblock all calls to OpenStreamSync
+	s.outgoingStreamClosedCond.Broadcast()
+	for id, str := range s.streams {
+		if str == nil || s.isValidStreamID(id) || id <= frame.LastGoodStream {
+			continue
+		}
+		s.closeStreamWithError(str, s.goawayErr)
+	}
+}
+
+// GoAway sends a GOAWAY frame. Streams opened by the peer afterwards are rejected, existing streams are not affected.
+func (s *session) GoAway(errorCode qerr.ErrorCode, reason string) error {
+	s.streamsMutex.Lock()
+	if s.closeErr != nil {
+		s.streamsMutex.Unlock()
+		return s.closeErr
+	}
+	if s.goawaySent {
+		s.streamsMutex.Unlock()
+		return nil
+	}
+	s.goawaySent = true
+	s.lastGoodStream = s.largestPeerStreamID
+	s.rejectedStreams = make(map[protocol.StreamID]protocol.ByteCount)
+	lastGoodStream := s.lastGoodStream
+	s.streamsMutex.Unlock()
+
+	s.queueControlFrame(&frames.GoawayFrame{
+		ErrorCode:      errorCode,
+		LastGoodStream: lastGoodStream,
+		ReasonPhrase:   reason,
+	})
+	s.scheduleSending()
+	return nil
+}
+
+// queueControlFrame queues a control frame that will be sent in the next packet
+// it may be called from outside the run loop
+func (s *session) queueControlFrame(f frames.Frame) {
+	s.queuedControlFramesMutex.Lock()
+	s.queuedControlFrames = append(s.queuedControlFrames, f)
+	s.queuedControlFramesMutex.Unlock()
+}
+
+func (s *session) popQueuedControlFrames() []frames.Frame {
+	s.queuedControlFramesMutex.Lock()
+	fs := s.queuedControlFrames
+	s.queuedControlFrames = nil
+	s.queuedControlFramesMutex.Unlock()
+	return fs
+}
+
+func (s *session) handleWindowUpdateFrame(frame *frames.WindowUpdateFrame) error {
+	// a new stream might be opened, so the write lock is needed
+	s.streamsMutex.Lock()
+	defer s.streamsMutex.Unlock()
 	if frame.StreamID != 0 {
 		stream, ok := s.streams[frame.StreamID]
 		if ok && stream == nil {
@@ -382,70 +865,168 @@ func (s *Session) handleWindowUpdateFrame(frame *frames.WindowUpdateFrame) error
 	return err
 }
 
-// TODO: Handle frame.byteOffset
-func (s *Session) handleRstStreamFrame(frame *frames.RstStreamFrame) error {
-	s.streamsMutex.RLock()
+func (s *session) handleRstStreamFrame(frame *frames.RstStreamFrame) error {
+	s.streamsMutex.Lock()
 	str, streamExists := s.streams[frame.StreamID]
-	s.streamsMutex.RUnlock()
+	if _, rejected := s.rejectedStreams[frame.StreamID]; rejected {
+		// the final offset of a rejected stream also counts towards connection level flow control
+		err := s.handleRejectedStreamData(frame.StreamID, frame.ByteOffset)
+		s.streamsMutex.Unlock()
+		return err
+	}
+	s.streamsMutex.Unlock()
 	if !streamExists || str == nil {
 		return errRstStreamOnInvalidStream
 	}
+	err := s.flowControlManager.ResetStream(frame.StreamID, frame.ByteOffset)
+	if err == flowcontrol.ErrStreamFlowControlViolation || err == flowcontrol.ErrConnectionFlowControlViolation {
+		return qerr.FlowControlReceivedTooMuchData
+	}
+	if err != nil {
+		return err
+	}
+	str.receivedRstStream(frame.ByteOffset)
 	s.closeStreamWithError(str, fmt.Errorf("RST_STREAM received with code %d", frame.ErrorCode))
 	return nil
 }
 
-func (s *Session) handleAckFrame(frame *frames.AckFrame) error {
+func (s *session) handleAckFrame(frame *frames.AckFrame) error {
 	if err := s.sentPacketHandler.ReceivedAck(frame, s.lastRcvdPacketNumber); err != nil {
 		return err
 	}
+	// the acknowledged stream data can be removed from the send buffers
+	for packet := s.sentPacketHandler.DequeueAckedPacket(); packet != nil; packet = s.sentPacketHandler.DequeueAckedPacket() {
+		for _, r := range packet.StreamRanges {
+			s.streamsMutex.RLock()
+			str := s.streams[r.StreamID]
+			s.streamsMutex.RUnlock()
+			if str != nil {
+				str.dataAcked(r.Offset, r.Length, r.FinBit)
+			}
+		}
+	}
 	return nil
 }
 
+// LocalAddr returns the local address
+func (s *session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer
+func (s *session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Stats returns a snapshot of the transport statistics
+// The flow control and stream statistics are up to date, the other values are updated after every run loop event.
+func (s *session) Stats() ConnectionStats {
+	s.statsMutex.Lock()
+	stats := s.publishedStats
+	s.statsMutex.Unlock()
+	stats.PacketsDropped += atomic.LoadUint64(&s.packetsDropped)
+
+	if connStats, err := s.flowControlManager.GetStats(0); err == nil {
+		stats.SendWindow = connStats.SendWindowSize
+		stats.ReceiveWindow = connStats.ReceiveWindowSize
+	}
+
+	s.streamsMutex.RLock()
+	defer s.streamsMutex.RUnlock()
+	stats.Streams = make(map[protocol.StreamID]StreamStats, len(s.streams))
+	for id, str := range s.streams {
+		if str == nil {
+			continue
+		}
+		streamStats, err := s.flowControlManager.GetStats(id)
+		if err != nil {
+			continue
+		}
+		stats.Streams[id] = StreamStats{
+			BytesSent:     streamStats.BytesSent,
+			BytesReceived: streamStats.BytesReceived,
+			BytesRead:     streamStats.BytesRead,
+			SendWindow:    streamStats.SendWindowSize,
+			ReceiveWindow: streamStats.ReceiveWindowSize,
+		}
+	}
+	return stats
+}
+
 // Close the connection. If err is nil it will be set to qerr.PeerGoingAway.
-func (s *Session) Close(e error) error {
+func (s *session) Close(e error) error {
 	return s.closeImpl(e, false)
 }
 
-func (s *Session) closeImpl(e error, remoteClose bool) error {
+func (s *session) closeImpl(e error, remoteClose bool) error {
 	// Only close once
 	if !atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
 		return nil
 	}
 
+	// the client closes the session when it has to recreate it with a different version
+	// the connection itself isn't closed in that case
+	if e == errCloseSessionForNewVersion {
+		s.closeStreamsWithError(e)
+		s.signalClose(nil)
+		return nil
+	}
+
 	if e == nil {
 		e = qerr.PeerGoingAway
 	}
 
 	quicErr := qerr.ToQuicError(e)
+	if s.tracer != nil {
+		s.tracer.ClosedConnection(quicErr, remoteClose)
+	}
 
 	// Don't log 'normal' reasons
 	if quicErr.ErrorCode == qerr.PeerGoingAway || quicErr.ErrorCode == qerr.NetworkIdleTimeout {
-		utils.Infof("Closing connection %x", s.connectionID)
+		s.logger.Infof("Closing connection")
 	} else {
-		utils.Errorf("Closing session with error: %s", e.Error())
+		s.logger.Errorf("Closing session with error: %s", e.Error())
 	}
 
 	s.closeStreamsWithError(quicErr)
-	s.closeCallback(s.connectionID)
+
+	if s.handshakeChan != nil && !s.handshakeComplete {
+		select {
+		case s.handshakeChan <- quicErr:
+		default:
+		}
+	}
 
 	if remoteClose {
 		// If this is a remote close we don't need to send a CONNECTION_CLOSE
-		s.closeChan <- nil
+		s.signalClose(nil)
 		return nil
 	}
 
-	if quicErr.ErrorCode == qerr.DecryptionFailure {
+	if quicErr.ErrorCode == qerr.DecryptionFailure && s.perspective == protocol.PerspectiveServer {
 		// If we send a public reset, don't send a CONNECTION_CLOSE
-		s.closeChan <- nil
+		s.signalClose(nil)
 		return s.sendPublicReset(s.lastRcvdPacketNumber)
 	}
-	s.closeChan <- quicErr
+	s.signalClose(quicErr)
 	return nil
 }
 
-func (s *Session) closeStreamsWithError(err error) {
+// signalClose notifies the run loop that it should terminate
+func (s *session) signalClose(quicErr *qerr.QuicError) {
+	s.closeChan <- quicErr
+	if s.loop != nil {
+		s.loop.schedule(s)
+	}
+}
+
+func (s *session) closeStreamsWithError(err error) {
 	s.streamsMutex.Lock()
 	defer s.streamsMutex.Unlock()
+	// unblock all calls to AcceptStream and OpenStreamSync
+	s.closeErr = err
+	s.newStreamCond.Broadcast()
+	s.outgoingStreamClosedCond.Broadcast()
 	for _, str := range s.streams {
 		if str == nil {
 			continue
@@ -454,11 +1035,20 @@ func (s *Session) closeStreamsWithError(err error) {
 	}
 }
 
-func (s *Session) closeStreamWithError(str *stream, err error) {
+func (s *session) closeStreamWithError(str *stream, err error) {
 	str.RegisterError(err)
 }
 
-func (s *Session) sendPacket() error {
+// sendPacket packs as many packets as possible, and sends them to the peer in batches
+func (s *session) sendPacket() error {
+	err := s.packPackets()
+	if flushErr := s.flushPackets(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func (s *session) packPackets() error {
 	// Repeatedly try sending until we don't have any more data, or run out of the congestion window
 	for {
 		err := s.sentPacketHandler.CheckForError()
@@ -478,18 +1068,24 @@ func (s *Session) sendPacket() error {
 			if retransmitPacket == nil {
 				break
 			}
-			utils.Debugf("\tDequeueing retransmission for packet 0x%x", retransmitPacket.PacketNumber)
+			s.logger.Debugf("\tDequeueing retransmission for packet 0x%x", retransmitPacket.PacketNumber)
+			s.stats.PacketsRetransmitted++
 
 			if s.version <= protocol.Version33 {
 				s.stopWaitingManager.RegisterPacketForRetransmission(retransmitPacket)
 			}
 			// resend the frames that were in the packet
 			controlFrames = append(controlFrames, retransmitPacket.GetControlFramesForRetransmission()...)
-			for _, streamFrame := range retransmitPacket.GetStreamFramesForRetransmission() {
-				s.streamFramer.AddFrameForRetransmission(streamFrame)
+			for _, r := range retransmitPacket.StreamRanges {
+				s.streamFramer.AddRangeForRetransmission(r)
 			}
 		}
 
+		controlFrames = append(controlFrames, s.popQueuedControlFrames()...)
+		for _, f := range s.streamFramer.PopRstStreamFrames() {
+			controlFrames = append(controlFrames, f)
+		}
+
 		windowUpdateFrames, err := s.getWindowUpdateFrames()
 		if err != nil {
 			return err
@@ -536,13 +1132,16 @@ func (s *Session) sendPacket() error {
 			s.packer.QueueControlFrameForNextPacket(f)
 		}
 
-		err = s.sentPacketHandler.SentPacket(&ackhandlerlegacy.Packet{
+		// Unlike the packed packet, the entry in the sent packet history is allocated for every packet.
+		// It is kept until it is acknowledged or retransmitted, and the retransmission queue might still reference it after that.
+		sentPacket := &ackhandlerlegacy.Packet{
 			PacketNumber: packet.number,
-			Frames:       packet.frames,
 			EntropyBit:   packet.entropyBit,
 			Length:       protocol.ByteCount(len(packet.raw)),
-		})
-		if err != nil {
+		}
+		// the history doesn't keep the stream data, it is retransmitted from the send buffers
+		sentPacket.SetFrames(packet.frames)
+		if err := s.sentPacketHandler.SentPacket(sentPacket); err != nil {
 			return err
 		}
 
@@ -552,48 +1151,191 @@ func (s *Session) sendPacket() error {
 		s.logPacket(packet)
 		s.delayedAckOriginTime = time.Time{}
 
-		err = s.conn.write(packet.raw)
-		putPacketBuffer(packet.raw)
+		s.stats.PacketsSent++
+		s.stats.BytesSent += protocol.ByteCount(len(packet.raw))
+		s.packetsToSend = append(s.packetsToSend, packet.raw)
+		s.packetBuffersToSend = append(s.packetBuffersToSend, packet.buffer)
+		if len(s.packetsToSend) >= protocol.PacketBatchSize {
+			if err := s.flushPackets(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// flushPackets sends all packed packets to the peer
+func (s *session) flushPackets() error {
+	if len(s.packetsToSend) == 0 {
+		return nil
+	}
+	err := s.conn.writeBatch(s.packetsToSend)
+	for i, buffer := range s.packetBuffersToSend {
+		buffer.Release()
+		s.packetsToSend[i] = nil
+		s.packetBuffersToSend[i] = nil
+	}
+	s.packetsToSend = s.packetsToSend[:0]
+	s.packetBuffersToSend = s.packetBuffersToSend[:0]
+	return err
+}
+
+// closeRunLoop is called by the run loop before it returns
+// It sends the CONNECTION_CLOSE, if there's an error to send, and calls the closeCallback.
+func (s *session) closeRunLoop(quicErr *qerr.QuicError) {
+	for _, p := range s.undecryptablePackets {
+		p.buffer.Release()
+	}
+	s.undecryptablePackets = nil
+
+	var connectionClosePacket []byte
+	if quicErr != nil {
+		var err error
+		connectionClosePacket, err = s.sendConnectionClose(quicErr)
 		if err != nil {
-			return err
+			s.logger.Errorf("Error sending CONNECTION_CLOSE: %s", err.Error())
 		}
 	}
+	if s.tracer != nil {
+		s.tracer.Close()
+	}
+
+	s.streamsMutex.RLock()
+	closedForNewVersion := s.closeErr == errCloseSessionForNewVersion
+	s.streamsMutex.RUnlock()
+	// the client closes the session when it has to recreate it with a different version
+	// the connection itself isn't closed in that case
+	if closedForNewVersion {
+		return
+	}
+	s.closeCallback(s.connectionID, connectionClosePacket)
 }
 
-func (s *Session) sendConnectionClose(quicErr *qerr.QuicError) error {
+// sendConnectionClose sends a CONNECTION_CLOSE, and returns the packet that was sent
+func (s *session) sendConnectionClose(quicErr *qerr.QuicError) ([]byte, error) {
 	packet, err := s.packer.PackConnectionClose(&frames.ConnectionCloseFrame{ErrorCode: quicErr.ErrorCode, ReasonPhrase: quicErr.ErrorMessage}, s.sentPacketHandler.GetLargestAcked())
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if packet == nil {
-		return errors.New("Session BUG: expected packet not to be nil")
+		return nil, errors.New("Session BUG: expected packet not to be nil")
 	}
 	s.logPacket(packet)
-	return s.conn.write(packet.raw)
+	return packet.raw, s.conn.write(packet.raw)
 }
 
-func (s *Session) logPacket(packet *packedPacket) {
-	if !utils.Debug() {
+func (s *session) logPacket(packet *packedPacket) {
+	if s.tracer != nil {
+		s.tracer.SentPacket(tracePacketHeader(packet.header), protocol.ByteCount(len(packet.raw)), packet.frames)
+	}
+	if !s.logger.Debug() {
 		// We don't need to allocate the slices for calling the format functions
 		return
 	}
-	if utils.Debug() {
-		utils.Debugf("-> Sending packet 0x%x (%d bytes)", packet.number, len(packet.raw))
-		for _, frame := range packet.frames {
-			frames.LogFrame(frame, true)
+	s.logger.Debugf("-> Sending packet 0x%x (%d bytes)", packet.number, len(packet.raw))
+	for _, frame := range packet.frames {
+		frames.LogFrame(s.logger, frame, true)
+	}
+}
+
+// tracePacketHeader converts a public header for the tracer
+func tracePacketHeader(hdr *publicHeader) *qlog.PacketHeader {
+	traced := &qlog.PacketHeader{
+		ConnectionID:    hdr.ConnectionID,
+		PacketNumber:    hdr.PacketNumber,
+		PacketNumberLen: hdr.PacketNumberLen,
+	}
+	if hdr.VersionFlag {
+		traced.VersionNumber = hdr.VersionNumber
+	}
+	return traced
+}
+
+// remoteAddrField is used as a log field, it always logs the current address of the peer
+type remoteAddrField struct {
+	conn connection
+}
+
+func (f remoteAddrField) String() string {
+	return f.conn.RemoteAddr().String()
+}
+
+// AcceptStream returns the next stream opened by the peer
+// it blocks until a new stream is opened, or the session is closed
+// Streams are returned in the order of their stream IDs. Streams that were already closed and garbage collected are skipped.
+func (s *session) AcceptStream() (Stream, error) {
+	s.streamsMutex.Lock()
+	defer s.streamsMutex.Unlock()
+	for {
+		if s.closeErr != nil {
+			return nil, s.closeErr
 		}
+		if s.nextStreamToAccept <= s.largestPeerStreamID {
+			str := s.streams[s.nextStreamToAccept]
+			s.nextStreamToAccept += 2
+			// the stream was implicitly opened or opened locally using GetOrOpenStream, and then garbage collected
+			if str == nil {
+				continue
+			}
+			return str, nil
+		}
+		s.newStreamCond.Wait()
 	}
 }
 
-// OpenStream creates a new stream open for reading and writing
-func (s *Session) OpenStream(id protocol.StreamID) (utils.Stream, error) {
+// OpenStream opens a new stream, using the next available stream ID
+// servers use even stream IDs, clients use odd stream IDs
+// if the peer's limit for the number of streams is reached, it returns qerr.TooManyOpenStreams
+func (s *session) OpenStream() (Stream, error) {
 	s.streamsMutex.Lock()
 	defer s.streamsMutex.Unlock()
-	return s.newStreamImpl(id)
+	if s.closeErr != nil {
+		return nil, s.closeErr
+	}
+	if s.goawayErr != nil {
+		return nil, s.goawayErr
+	}
+	return s.openNextStream()
+}
+
+// OpenStreamSync opens a new stream, using the next available stream ID
+// if the peer's limit for the number of streams is reached, it blocks until a stream is closed, or the session is closed
+func (s *session) OpenStreamSync() (Stream, error) {
+	s.streamsMutex.Lock()
+	defer s.streamsMutex.Unlock()
+	for {
+		if s.closeErr != nil {
+			return nil, s.closeErr
+		}
+		if s.goawayErr != nil {
+			return nil, s.goawayErr
+		}
+		if s.numOutgoingStreams < s.connectionParametersManager.GetMaxStreamsPerConnection() {
+			return s.openNextStream()
+		}
+		s.outgoingStreamClosedCond.Wait()
+	}
+}
+
+// openNextStream opens the stream with the next unused stream ID
+// the streamsMutex has to be held when calling this function
+func (s *session) openNextStream() (*stream, error) {
+	// skip IDs of streams that were already opened with GetOrOpenStream
+	for {
+		if _, ok := s.streams[s.nextStreamToOpen]; !ok {
+			break
+		}
+		s.nextStreamToOpen += 2
+	}
+	str, err := s.newStreamImpl(s.nextStreamToOpen)
+	if err != nil {
+		return nil, err
+	}
+	s.nextStreamToOpen += 2
+	return str, nil
 }
 
 // GetOrOpenStream returns an existing stream with the given id, or opens a new stream
-func (s *Session) GetOrOpenStream(id protocol.StreamID) (utils.Stream, error) {
+func (s *session) GetOrOpenStream(id protocol.StreamID) (Stream, error) {
 	s.streamsMutex.Lock()
 	defer s.streamsMutex.Unlock()
 	if stream, ok := s.streams[id]; ok {
@@ -603,16 +1345,31 @@ func (s *Session) GetOrOpenStream(id protocol.StreamID) (utils.Stream, error) {
 }
 
 // The streamsMutex is locked by OpenStream or GetOrOpenStream before calling this function.
-func (s *Session) newStreamImpl(id protocol.StreamID) (*stream, error) {
-	maxAllowedStreams := uint32(protocol.MaxStreamsMultiplier * float32(s.connectionParametersManager.GetMaxStreamsPerConnection()))
-	if atomic.LoadUint32(&s.openStreamsCount) >= maxAllowedStreams {
-		go s.Close(qerr.TooManyOpenStreams)
-		return nil, qerr.TooManyOpenStreams
+// When the peer opens a stream, all of its streams with lower IDs are opened implicitly, if they weren't opened yet.
+func (s *session) newStreamImpl(id protocol.StreamID) (*stream, error) {
+	if s.isValidStreamID(id) {
+		for lower := s.largestPeerStreamID + 2; lower < id; lower += 2 {
+			if _, err := s.newStreamImpl(lower); err != nil {
+				return nil, err
+			}
+		}
+	}
+	maxStreams := s.connectionParametersManager.GetMaxStreamsPerConnection()
+	if id != 1 {
+		if s.isValidStreamID(id) {
+			// the peer opened too many streams
+			if s.numIncomingStreams >= uint32(protocol.MaxStreamsMultiplier*float32(maxStreams)) {
+				go s.Close(qerr.TooManyOpenStreams)
+				return nil, qerr.TooManyOpenStreams
+			}
+		} else if s.numOutgoingStreams >= maxStreams {
+			return nil, qerr.TooManyOpenStreams
+		}
 	}
 	if _, ok := s.streams[id]; ok {
 		return nil, fmt.Errorf("Session: stream with ID %d already exists", id)
 	}
-	stream, err := newStream(s.scheduleSending, s.connectionParametersManager, s.flowControlManager, id)
+	stream, err := newStream(s.scheduleSending, s.streamScheduler.SetPriority, s.connectionParametersManager, s.flowControlManager, s.config.StreamSendBufferSize, id)
 	if err != nil {
 		return nil, err
 	}
@@ -624,14 +1381,28 @@ func (s *Session) newStreamImpl(id protocol.StreamID) (*stream, error) {
 		s.flowControlManager.NewStream(id, true)
 	}
 
-	atomic.AddUint32(&s.openStreamsCount, 1)
+	if s.isValidStreamID(id) && id > s.largestPeerStreamID {
+		s.largestPeerStreamID = id
+	}
+	if id != 1 {
+		if s.isValidStreamID(id) {
+			s.numIncomingStreams++
+		} else {
+			s.numOutgoingStreams++
+		}
+	}
 	s.streams[id] = stream
+	s.streamScheduler.AddStream(id)
+	if s.tracer != nil {
+		s.tracer.OpenedStream(id)
+	}
+	s.newStreamCond.Broadcast()
 	return stream, nil
 }
 
 // garbageCollectStreams goes through all streams and removes EOF'ed streams
 // from the streams map.
-func (s *Session) garbageCollectStreams() {
+func (s *session) garbageCollectStreams() {
 	s.streamsMutex.Lock()
 	defer s.streamsMutex.Unlock()
 	for k, v := range s.streams {
@@ -639,43 +1410,77 @@ func (s *Session) garbageCollectStreams() {
 			continue
 		}
 		if v.finished() {
-			utils.Debugf("Garbage-collecting stream %d", k)
-			atomic.AddUint32(&s.openStreamsCount, ^uint32(0)) // decrement
+			s.logger.Debugf("Garbage-collecting stream %d", k)
+			if k != 1 {
+				if s.isValidStreamID(k) {
+					s.numIncomingStreams--
+				} else {
+					s.numOutgoingStreams--
+					s.outgoingStreamClosedCond.Signal()
+				}
+			}
 			s.streams[k] = nil
 			s.flowControlManager.RemoveStream(k)
+			s.streamScheduler.RemoveStream(k)
+			s.streamFramer.RemoveStream(k)
+			if s.tracer != nil {
+				s.tracer.ClosedStream(k)
+			}
 		}
 	}
 }
 
-func (s *Session) sendPublicReset(rejectedPacketNumber protocol.PacketNumber) error {
-	utils.Infof("Sending public reset for connection %x, packet number %d", s.connectionID, rejectedPacketNumber)
-	return s.conn.write(writePublicReset(s.connectionID, rejectedPacketNumber, 0))
+// hasActiveStreams says if there are any streams that are not finished yet
+// The crypto and the headers stream are not counted, since they stay open for the lifetime of the session.
+func (s *session) hasActiveStreams() bool {
+	s.streamsMutex.RLock()
+	defer s.streamsMutex.RUnlock()
+	if s.closeErr != nil {
+		return false
+	}
+	for id, str := range s.streams {
+		if id != 1 && id != 3 && str != nil && !str.finished() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) sendPublicReset(rejectedPacketNumber protocol.PacketNumber) error {
+	s.logger.Infof("Sending public reset, packet number %d", rejectedPacketNumber)
+	return s.conn.write(writePublicReset(s.connectionID, rejectedPacketNumber, publicResetNonceProof(s.config.ResetSecret, s.connectionID)))
 }
 
 // scheduleSending signals that we have data for sending
-func (s *Session) scheduleSending() {
+func (s *session) scheduleSending() {
+	if s.loop != nil {
+		s.loop.schedule(s)
+		return
+	}
 	select {
 	case s.sendingScheduled <- struct{}{}:
 	default:
 	}
 }
 
-func (s *Session) tryQueueingUndecryptablePacket(p receivedPacket) {
-	utils.Debugf("Queueing packet 0x%x for later decryption", p.publicHeader.PacketNumber)
+func (s *session) tryQueueingUndecryptablePacket(p receivedPacket) {
+	s.logger.Debugf("Queueing packet 0x%x for later decryption", p.publicHeader.PacketNumber)
 	if len(s.undecryptablePackets)+1 >= protocol.MaxUndecryptablePackets {
 		s.Close(qerr.Error(qerr.DecryptionFailure, "too many undecryptable packets received"))
 	}
+	p.queuedForDecryption = true
 	s.undecryptablePackets = append(s.undecryptablePackets, p)
 }
 
-func (s *Session) tryDecryptingQueuedPackets() {
+func (s *session) tryDecryptingQueuedPackets() {
 	for _, p := range s.undecryptablePackets {
-		s.handlePacket(p.remoteAddr, p.publicHeader, p.data)
+		s.handlePacket(&p)
+		p.buffer.Release()
 	}
 	s.undecryptablePackets = s.undecryptablePackets[:0]
 }
 
-func (s *Session) getWindowUpdateFrames() ([]*frames.WindowUpdateFrame, error) {
+func (s *session) getWindowUpdateFrames() ([]*frames.WindowUpdateFrame, error) {
 	s.streamsMutex.RLock()
 	defer s.streamsMutex.RUnlock()
 
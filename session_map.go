@@ -0,0 +1,126 @@
+package quic
+
+import (
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// sessionMapShards is the number of shards of a sessionMap, it must be a power of 2
+const sessionMapShards = 64
+
+type sessionMapShard struct {
+	mutex    sync.RWMutex
+	sessions map[protocol.ConnectionID]packetHandler
+	// frozen is set by freeze, no sessions can be added after that
+	frozen bool
+}
+
+// A sessionMap maps connection IDs to sessions
+// It is split into shards, each protected by its own mutex, so that concurrent lookups of different connections don't contend on a single lock.
+// It is safe for concurrent use.
+type sessionMap struct {
+	shards [sessionMapShards]sessionMapShard
+}
+
+func newSessionMap() *sessionMap {
+	m := &sessionMap{}
+	for i := range m.shards {
+		m.shards[i].sessions = make(map[protocol.ConnectionID]packetHandler)
+	}
+	return m
+}
+
+func (m *sessionMap) shard(id protocol.ConnectionID) *sessionMapShard {
+	return &m.shards[uint64(id)&(sessionMapShards-1)]
+}
+
+// get returns the session for a connection ID
+func (m *sessionMap) get(id protocol.ConnectionID) (packetHandler, bool) {
+	shard := m.shard(id)
+	shard.mutex.RLock()
+	session, ok := shard.sessions[id]
+	shard.mutex.RUnlock()
+	return session, ok
+}
+
+// lookup returns the session for a connection ID, and whether the map was frozen
+func (m *sessionMap) lookup(id protocol.ConnectionID) (packetHandler, bool, bool) {
+	shard := m.shard(id)
+	shard.mutex.RLock()
+	session, ok := shard.sessions[id]
+	frozen := shard.frozen
+	shard.mutex.RUnlock()
+	return session, ok, frozen
+}
+
+// add adds a session, unless there already is a session for the connection ID
+// It returns the session that is stored for the connection ID afterwards, so if it doesn't return the session passed in, the session was not added.
+// It returns nil if the map was frozen.
+func (m *sessionMap) add(id protocol.ConnectionID, session packetHandler) packetHandler {
+	shard := m.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.frozen {
+		return nil
+	}
+	if existing, ok := shard.sessions[id]; ok {
+		return existing
+	}
+	shard.sessions[id] = session
+	return session
+}
+
+// remove removes the session for a connection ID
+func (m *sessionMap) remove(id protocol.ConnectionID) {
+	shard := m.shard(id)
+	shard.mutex.Lock()
+	delete(shard.sessions, id)
+	shard.mutex.Unlock()
+}
+
+// len returns the number of sessions
+func (m *sessionMap) len() int {
+	var n int
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mutex.RLock()
+		n += len(shard.sessions)
+		shard.mutex.RUnlock()
+	}
+	return n
+}
+
+// all returns all sessions
+// The map is not locked as a whole, so sessions added or removed concurrently may or may not be contained.
+func (m *sessionMap) all() []packetHandler {
+	return m.collect(false)
+}
+
+// freeze prevents adding sessions to the map, and returns all sessions
+// Every session added before freeze is contained in the result.
+func (m *sessionMap) freeze() []packetHandler {
+	return m.collect(true)
+}
+
+func (m *sessionMap) collect(freeze bool) []packetHandler {
+	var sessions []packetHandler
+	for i := range m.shards {
+		shard := &m.shards[i]
+		if freeze {
+			shard.mutex.Lock()
+			shard.frozen = true
+		} else {
+			shard.mutex.RLock()
+		}
+		for _, session := range shard.sessions {
+			sessions = append(sessions, session)
+		}
+		if freeze {
+			shard.mutex.Unlock()
+		} else {
+			shard.mutex.RUnlock()
+		}
+	}
+	return sessions
+}
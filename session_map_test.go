@@ -0,0 +1,101 @@
+package quic
+
+import (
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session map", func() {
+	var m *sessionMap
+
+	BeforeEach(func() {
+		m = newSessionMap()
+	})
+
+	It("adds and gets sessions", func() {
+		session := &mockSession{}
+		Expect(m.add(5, session)).To(BeIdenticalTo(session))
+		s, ok := m.get(5)
+		Expect(ok).To(BeTrue())
+		Expect(s).To(BeIdenticalTo(session))
+		_, ok = m.get(6)
+		Expect(ok).To(BeFalse())
+		Expect(m.len()).To(Equal(1))
+	})
+
+	It("doesn't replace existing sessions", func() {
+		session1 := &mockSession{}
+		session2 := &mockSession{}
+		m.add(5, session1)
+		Expect(m.add(5, session2)).To(BeIdenticalTo(session1))
+		s, _ := m.get(5)
+		Expect(s).To(BeIdenticalTo(session1))
+	})
+
+	It("removes sessions", func() {
+		m.add(5, &mockSession{})
+		m.remove(5)
+		_, ok := m.get(5)
+		Expect(ok).To(BeFalse())
+		Expect(m.len()).To(BeZero())
+	})
+
+	It("returns all sessions", func() {
+		session1 := &mockSession{}
+		session2 := &mockSession{}
+		m.add(1, session1)
+		m.add(sessionMapShards+1, session2) // in the same shard
+		m.add(2, &mockSession{})
+		Expect(m.all()).To(HaveLen(3))
+		Expect(m.all()).To(ContainElement(session1))
+		Expect(m.all()).To(ContainElement(session2))
+	})
+
+	It("doesn't add sessions after it was frozen", func() {
+		session := &mockSession{}
+		m.add(1, session)
+		Expect(m.freeze()).To(Equal([]packetHandler{session}))
+		Expect(m.add(2, &mockSession{})).To(BeNil())
+		Expect(m.len()).To(Equal(1))
+	})
+
+	It("looks up sessions and the frozen state", func() {
+		session := &mockSession{}
+		m.add(1, session)
+		s, ok, frozen := m.lookup(1)
+		Expect(s).To(BeIdenticalTo(session))
+		Expect(ok).To(BeTrue())
+		Expect(frozen).To(BeFalse())
+		m.freeze()
+		_, ok, frozen = m.lookup(2)
+		Expect(ok).To(BeFalse())
+		Expect(frozen).To(BeTrue())
+	})
+
+	It("handles concurrent access", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					id := protocol.ConnectionID(i*100 + j)
+					m.add(id, &mockSession{})
+					_, ok := m.get(id)
+					Expect(ok).To(BeTrue())
+					m.all()
+					if j%2 == 0 {
+						m.remove(id)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		Expect(m.len()).To(Equal(500))
+	})
+})
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,38 +20,87 @@ import (
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/qlog"
 	"github.com/lucas-clemente/quic-go/testdata"
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
 type mockConnection struct {
-	written [][]byte
+	// written and batches are protected by the mutex, use getWritten while the session is running
+	written    [][]byte
+	batches    []int // the number of packets in each call to writeBatch
+	mutex      sync.Mutex
+	remoteAddr net.Addr
 }
 
 func (m *mockConnection) write(p []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeImpl(p)
+	return nil
+}
+
+func (m *mockConnection) writeBatch(packets [][]byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.batches = append(m.batches, len(packets))
+	for _, p := range packets {
+		m.writeImpl(p)
+	}
+	return nil
+}
+
+func (m *mockConnection) writeImpl(p []byte) {
 	b := make([]byte, len(p))
 	copy(b, p)
 	m.written = append(m.written, b)
-	return nil
 }
 
-func (*mockConnection) setCurrentRemoteAddr(addr interface{}) {}
-func (*mockConnection) IP() net.IP                            { return nil }
+// getWritten returns the packets written so far
+func (m *mockConnection) getWritten() [][]byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([][]byte(nil), m.written...)
+}
+
+func (m *mockConnection) setCurrentRemoteAddr(addr net.Addr) { m.remoteAddr = addr }
+func (*mockConnection) setLogger(utils.Logger)               {}
+func (*mockConnection) IP() net.IP                           { return nil }
+func (*mockConnection) LocalAddr() net.Addr                  { return &net.UDPAddr{} }
+func (m *mockConnection) RemoteAddr() net.Addr {
+	if m.remoteAddr == nil {
+		return &net.UDPAddr{}
+	}
+	return m.remoteAddr
+}
 
-type mockUnpacker struct{}
+type mockUnpacker struct {
+	unpackErr error
+	frames    []frames.Frame
+}
 
 func (m *mockUnpacker) Unpack(publicHeaderBinary []byte, hdr *publicHeader, data []byte) (*unpackedPacket, error) {
+	if m.unpackErr != nil {
+		return nil, m.unpackErr
+	}
 	return &unpackedPacket{
 		entropyBit: false,
-		frames:     nil,
+		frames:     m.frames,
 	}, nil
 }
 
 type mockSentPacketHandler struct {
+	sentPackets         []*ackhandlerlegacy.Packet
 	retransmissionQueue []*ackhandlerlegacy.Packet
+	ackedPacketQueue    []*ackhandlerlegacy.Packet
+	connectionMigrated  bool
+	stats               ackhandlerlegacy.SentPacketStats
 }
 
-func (h *mockSentPacketHandler) SentPacket(packet *ackhandlerlegacy.Packet) error { return nil }
+func (h *mockSentPacketHandler) SentPacket(packet *ackhandlerlegacy.Packet) error {
+	h.sentPackets = append(h.sentPackets, packet)
+	return nil
+}
 func (h *mockSentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNumber protocol.PacketNumber) error {
 	return nil
 }
@@ -62,6 +112,10 @@ func (h *mockSentPacketHandler) GetStopWaitingFrame() *frames.StopWaitingFrame {
 func (h *mockSentPacketHandler) CongestionAllowsSending() bool { return true }
 func (h *mockSentPacketHandler) CheckForError() error          { return nil }
 func (h *mockSentPacketHandler) TimeOfFirstRTO() time.Time     { panic("not implemented") }
+func (h *mockSentPacketHandler) OnConnectionMigration()        { h.connectionMigrated = true }
+func (h *mockSentPacketHandler) GetStats() ackhandlerlegacy.SentPacketStats {
+	return h.stats
+}
 
 func (h *mockSentPacketHandler) ProbablyHasPacketForRetransmission() bool {
 	return len(h.retransmissionQueue) > 0
@@ -76,16 +130,85 @@ func (h *mockSentPacketHandler) DequeuePacketForRetransmission() *ackhandlerlega
 	return nil
 }
 
+func (h *mockSentPacketHandler) DequeueAckedPacket() *ackhandlerlegacy.Packet {
+	if len(h.ackedPacketQueue) > 0 {
+		packet := h.ackedPacketQueue[0]
+		h.ackedPacketQueue = h.ackedPacketQueue[1:]
+		return packet
+	}
+	return nil
+}
+
 func newMockSentPacketHandler() ackhandlerlegacy.SentPacketHandler {
 	return &mockSentPacketHandler{}
 }
 
+type mockTracer struct {
+	perspective  protocol.Perspective
+	connectionID protocol.ConnectionID
+	tracer       *mockConnectionTracer
+}
+
+var _ qlog.Tracer = &mockTracer{}
+
+func (t *mockTracer) TracerForConnection(p protocol.Perspective, connectionID protocol.ConnectionID) qlog.ConnectionTracer {
+	t.perspective = p
+	t.connectionID = connectionID
+	if t.tracer == nil {
+		return nil
+	}
+	return t.tracer
+}
+
+// mockConnectionTracer records the traced events as strings
+type mockConnectionTracer struct {
+	mutex  sync.Mutex
+	events []string
+	closed bool
+}
+
+var _ qlog.ConnectionTracer = &mockConnectionTracer{}
+
+func (t *mockConnectionTracer) record(format string, args ...interface{}) {
+	t.mutex.Lock()
+	t.events = append(t.events, fmt.Sprintf(format, args...))
+	t.mutex.Unlock()
+}
+
+func (t *mockConnectionTracer) getEvents() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]string(nil), t.events...)
+}
+
+func (t *mockConnectionTracer) SentPacket(hdr *qlog.PacketHeader, size protocol.ByteCount, fs []frames.Frame) {
+	t.record("sent packet %d", hdr.PacketNumber)
+}
+func (t *mockConnectionTracer) ReceivedPacket(hdr *qlog.PacketHeader, size protocol.ByteCount, fs []frames.Frame) {
+	t.record("received packet %d, %d bytes, %d frames", hdr.PacketNumber, size, len(fs))
+}
+func (t *mockConnectionTracer) LostPacket(protocol.PacketNumber, qlog.LossReason)      {}
+func (t *mockConnectionTracer) UpdatedRTT(time.Duration, time.Duration, time.Duration) {}
+func (t *mockConnectionTracer) UpdatedCongestionWindow(protocol.ByteCount, protocol.ByteCount) {
+}
+func (t *mockConnectionTracer) OpenedStream(id protocol.StreamID)         { t.record("opened stream %d", id) }
+func (t *mockConnectionTracer) ClosedStream(id protocol.StreamID)         { t.record("closed stream %d", id) }
+func (t *mockConnectionTracer) SentHandshakeMessage(string, []string)     {}
+func (t *mockConnectionTracer) ReceivedHandshakeMessage(string, []string) {}
+func (t *mockConnectionTracer) ClosedConnection(err error, remote bool) {
+	t.record("closed connection: %s, remote: %t", err.Error(), remote)
+}
+func (t *mockConnectionTracer) Close() {
+	t.mutex.Lock()
+	t.closed = true
+	t.mutex.Unlock()
+}
+
 var _ = Describe("Session", func() {
 	var (
-		session              *Session
-		streamCallbackCalled bool
-		closeCallbackCalled  bool
-		conn                 *mockConnection
+		sess              *session
+		closeCallbackChan chan []byte // receives the packet passed to the close callback
+		conn              *mockConnection
 	)
 
 	for _, versionLoop := range []protocol.VersionNumber{protocol.Version33, protocol.Version34} {
@@ -95,8 +218,9 @@ var _ = Describe("Session", func() {
 
 			BeforeEach(func() {
 				conn = &mockConnection{}
-				streamCallbackCalled = false
-				closeCallbackCalled = false
+				// every session gets its own channel, since sessions of previous tests might still be running
+				closeChan := make(chan []byte, 1)
+				closeCallbackChan = closeChan
 
 				signer, err := crypto.NewProofSource(testdata.GetTLSConfig())
 				Expect(err).ToNot(HaveOccurred())
@@ -109,30 +233,39 @@ var _ = Describe("Session", func() {
 					version,
 					0,
 					scfg,
-					func(*Session, utils.Stream) { streamCallbackCalled = true },
-					func(protocol.ConnectionID) { closeCallbackCalled = true },
+					func(_ protocol.ConnectionID, p []byte) { closeChan <- p },
+					nil,
+					populateConfig(nil),
 				)
 				Expect(err).NotTo(HaveOccurred())
-				session = pSession.(*Session)
-				Expect(session.streams).To(HaveLen(1)) // Crypto stream
+				sess = pSession.(*session)
+				Expect(sess.streams).To(HaveLen(1)) // Crypto stream
 			})
 
 			Context("when handling stream frames", func() {
 				It("makes new streams", func() {
-					session.handleStreamFrame(&frames.StreamFrame{
+					sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 5,
 						Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 					})
-					Expect(session.streams).To(HaveLen(2))
-					Expect(streamCallbackCalled).To(BeTrue())
+					Expect(sess.streams).To(HaveLen(3))
 					p := make([]byte, 4)
-					_, err := session.streams[5].Read(p)
+					_, err := sess.streams[5].Read(p)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(p).To(Equal([]byte{0xde, 0xca, 0xfb, 0xad}))
 				})
 
+				It("implicitly opens streams with lower IDs", func() {
+					err := sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7, Data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.streams).To(HaveLen(4))
+					Expect(sess.streams).To(HaveKey(protocol.StreamID(3)))
+					Expect(sess.streams).To(HaveKey(protocol.StreamID(5)))
+					Expect(sess.numIncomingStreams).To(BeEquivalentTo(3))
+				})
+
 				It("rejects streams with even StreamIDs", func() {
-					err := session.handleStreamFrame(&frames.StreamFrame{
+					err := sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 4,
 						Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 					})
@@ -140,143 +273,153 @@ var _ = Describe("Session", func() {
 				})
 
 				It("does not reject existing streams with even StreamIDs", func() {
-					_, err := session.OpenStream(4)
+					str, err := sess.OpenStream()
 					Expect(err).ToNot(HaveOccurred())
-					err = session.handleStreamFrame(&frames.StreamFrame{
-						StreamID: 4,
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(2)))
+					err = sess.handleStreamFrame(&frames.StreamFrame{
+						StreamID: 2,
 						Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 					})
 					Expect(err).ToNot(HaveOccurred())
 				})
 
 				It("handles existing streams", func() {
-					session.handleStreamFrame(&frames.StreamFrame{
+					sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 5,
 						Data:     []byte{0xde, 0xca},
 					})
-					Expect(session.streams).To(HaveLen(2))
-					Expect(streamCallbackCalled).To(BeTrue())
-					session.handleStreamFrame(&frames.StreamFrame{
+					Expect(sess.streams).To(HaveLen(3))
+					sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 5,
 						Offset:   2,
 						Data:     []byte{0xfb, 0xad},
 					})
-					Expect(session.streams).To(HaveLen(2))
+					Expect(sess.streams).To(HaveLen(3))
 					p := make([]byte, 4)
-					_, err := session.streams[5].Read(p)
+					_, err := sess.streams[5].Read(p)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(p).To(Equal([]byte{0xde, 0xca, 0xfb, 0xad}))
 				})
 
 				It("does not delete streams with Close()", func() {
-					str, err := session.OpenStream(5)
+					str, err := sess.GetOrOpenStream(5)
 					Expect(err).ToNot(HaveOccurred())
 					str.Close()
-					session.garbageCollectStreams()
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).ToNot(BeNil())
+					sess.garbageCollectStreams()
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).ToNot(BeNil())
 				})
 
 				It("does not delete streams with FIN bit", func() {
-					session.handleStreamFrame(&frames.StreamFrame{
+					sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 5,
 						Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 						FinBit:   true,
 					})
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).ToNot(BeNil())
-					Expect(streamCallbackCalled).To(BeTrue())
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).ToNot(BeNil())
 					p := make([]byte, 4)
-					_, err := session.streams[5].Read(p)
+					_, err := sess.streams[5].Read(p)
 					Expect(err).To(MatchError(io.EOF))
 					Expect(p).To(Equal([]byte{0xde, 0xca, 0xfb, 0xad}))
-					session.garbageCollectStreams()
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).ToNot(BeNil())
+					sess.garbageCollectStreams()
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).ToNot(BeNil())
 				})
 
 				It("deletes streams with FIN bit & close", func() {
-					session.handleStreamFrame(&frames.StreamFrame{
+					sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 5,
 						Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 						FinBit:   true,
 					})
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).ToNot(BeNil())
-					Expect(streamCallbackCalled).To(BeTrue())
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).ToNot(BeNil())
 					p := make([]byte, 4)
-					_, err := session.streams[5].Read(p)
+					_, err := sess.streams[5].Read(p)
 					Expect(err).To(MatchError(io.EOF))
 					Expect(p).To(Equal([]byte{0xde, 0xca, 0xfb, 0xad}))
-					session.garbageCollectStreams()
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).ToNot(BeNil())
+					sess.garbageCollectStreams()
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).ToNot(BeNil())
 					// We still need to close the stream locally
-					session.streams[5].Close()
-					// ... and simulate that we actually the FIN
-					session.streams[5].sentFin()
-					session.garbageCollectStreams()
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).To(BeNil())
+					sess.streams[5].Close()
+					// ... and simulate that we actually sent the FIN, and that it was acknowledged
+					sess.streams[5].sentFin()
+					sess.streams[5].dataAcked(0, 0, true)
+					sess.garbageCollectStreams()
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).To(BeNil())
 					// flow controller should have been notified
-					_, err = session.flowControlManager.SendWindowSize(5)
+					_, err = sess.flowControlManager.SendWindowSize(5)
 					Expect(err).To(MatchError("Error accessing the flowController map."))
+					// the stream should have been removed from the scheduler
+					Expect(sess.streamScheduler.(*priorityScheduler).streams).ToNot(HaveKey(protocol.StreamID(5)))
+				})
+
+				It("sets the priority of streams", func() {
+					str, err := sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.streamScheduler.(*priorityScheduler).streams).To(HaveKey(protocol.StreamID(5)))
+					err = str.SetPriority(StreamPriority{Level: 1, Weight: 42})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.streamScheduler.(*priorityScheduler).streams[5].priority).To(Equal(StreamPriority{Level: 1, Weight: 42}))
 				})
 
 				It("closes streams with error", func() {
 					testErr := errors.New("test")
-					session.handleStreamFrame(&frames.StreamFrame{
+					sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 5,
 						Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 					})
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).ToNot(BeNil())
-					Expect(streamCallbackCalled).To(BeTrue())
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).ToNot(BeNil())
 					p := make([]byte, 4)
-					_, err := session.streams[5].Read(p)
+					_, err := sess.streams[5].Read(p)
 					Expect(err).ToNot(HaveOccurred())
-					session.closeStreamsWithError(testErr)
-					_, err = session.streams[5].Read(p)
+					sess.closeStreamsWithError(testErr)
+					_, err = sess.streams[5].Read(p)
 					Expect(err).To(MatchError(testErr))
-					session.garbageCollectStreams()
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).To(BeNil())
+					sess.garbageCollectStreams()
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).To(BeNil())
 				})
 
 				It("closes empty streams with error", func() {
 					testErr := errors.New("test")
-					session.newStreamImpl(5)
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).ToNot(BeNil())
-					session.closeStreamsWithError(testErr)
-					_, err := session.streams[5].Read([]byte{0})
+					sess.newStreamImpl(5)
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).ToNot(BeNil())
+					sess.closeStreamsWithError(testErr)
+					_, err := sess.streams[5].Read([]byte{0})
 					Expect(err).To(MatchError(testErr))
-					session.garbageCollectStreams()
-					Expect(session.streams).To(HaveLen(2))
-					Expect(session.streams[5]).To(BeNil())
+					sess.garbageCollectStreams()
+					Expect(sess.streams).To(HaveLen(3))
+					Expect(sess.streams[5]).To(BeNil())
 				})
 
 				It("informs the FlowControlManager about new streams", func() {
 					// since the stream doesn't yet exist, this will throw an error
-					err := session.flowControlManager.UpdateHighestReceived(5, 1000)
+					err := sess.flowControlManager.UpdateHighestReceived(5, 1000)
 					Expect(err).To(HaveOccurred())
-					session.newStreamImpl(5)
-					err = session.flowControlManager.UpdateHighestReceived(5, 2000)
+					sess.newStreamImpl(5)
+					err = sess.flowControlManager.UpdateHighestReceived(5, 2000)
 					Expect(err).ToNot(HaveOccurred())
 				})
 
 				It("ignores streams that existed previously", func() {
-					session.handleStreamFrame(&frames.StreamFrame{
+					sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 5,
 						Data:     []byte{},
 						FinBit:   true,
 					})
-					_, err := session.streams[5].Read([]byte{0})
+					_, err := sess.streams[5].Read([]byte{0})
 					Expect(err).To(MatchError(io.EOF))
-					session.streams[5].Close()
-					session.streams[5].sentFin()
-					session.garbageCollectStreams()
-					err = session.handleStreamFrame(&frames.StreamFrame{
+					sess.streams[5].Close()
+					sess.streams[5].sentFin()
+					sess.streams[5].dataAcked(0, 0, true)
+					sess.garbageCollectStreams()
+					err = sess.handleStreamFrame(&frames.StreamFrame{
 						StreamID: 5,
 						Data:     []byte{},
 					})
@@ -284,11 +427,188 @@ var _ = Describe("Session", func() {
 				})
 			})
 
+			Context("accepting streams", func() {
+				It("waits for new streams", func() {
+					strChan := make(chan Stream, 1)
+					go func() {
+						defer GinkgoRecover()
+						str, err := sess.AcceptStream()
+						Expect(err).ToNot(HaveOccurred())
+						strChan <- str
+					}()
+					Consistently(strChan).ShouldNot(Receive())
+					sess.handleStreamFrame(&frames.StreamFrame{
+						StreamID: 3,
+					})
+					var str Stream
+					Eventually(strChan).Should(Receive(&str))
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+				})
+
+				It("accepts streams in the order of their stream IDs", func() {
+					sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5})
+					sess.handleStreamFrame(&frames.StreamFrame{StreamID: 3})
+					str, err := sess.AcceptStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+					str, err = sess.AcceptStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(5)))
+				})
+
+				It("skips streams that were already garbage collected", func() {
+					sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5})
+					sess.streams[3] = nil
+					str, err := sess.AcceptStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(5)))
+				})
+
+				It("doesn't block on streams that were implicitly opened and already closed", func() {
+					sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7})
+					sess.streams[3] = nil
+					sess.streams[5] = nil
+					str, err := sess.AcceptStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(7)))
+				})
+
+				It("accepts implicitly opened streams", func() {
+					sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5})
+					str, err := sess.AcceptStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(3)))
+					str, err = sess.AcceptStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(5)))
+				})
+
+				It("returns an error when the session is closed", func() {
+					testErr := errors.New("test error")
+					errChan := make(chan error, 1)
+					go func() {
+						defer GinkgoRecover()
+						_, err := sess.AcceptStream()
+						errChan <- err
+					}()
+					Consistently(errChan).ShouldNot(Receive())
+					sess.Close(testErr)
+					Eventually(errChan).Should(Receive(MatchError(qerr.ToQuicError(testErr))))
+				})
+
+				It("returns an error after the session was closed", func() {
+					sess.Close(nil)
+					_, err := sess.AcceptStream()
+					Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PeerGoingAway))
+				})
+			})
+
+			Context("opening streams", func() {
+				// finishStream closes a stream in both directions, such that it can be garbage collected
+				finishStream := func(str Stream) {
+					err := str.Close()
+					Expect(err).NotTo(HaveOccurred())
+					str.(*stream).sentFin()
+					str.(*stream).dataAcked(0, 0, true)
+					str.CloseRemote(0)
+					_, err = str.Read([]byte("a"))
+					Expect(err).To(MatchError(io.EOF))
+				}
+
+				It("opens streams with even stream IDs", func() {
+					str, err := sess.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(2)))
+					str, err = sess.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(4)))
+				})
+
+				It("skips stream IDs that were already opened with GetOrOpenStream", func() {
+					_, err := sess.GetOrOpenStream(2)
+					Expect(err).ToNot(HaveOccurred())
+					str, err := sess.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(4)))
+				})
+
+				It("errors when the peer's stream limit is reached, without closing the session", func() {
+					for i := 0; i < 100; i++ {
+						_, err := sess.OpenStream()
+						Expect(err).ToNot(HaveOccurred())
+					}
+					_, err := sess.OpenStream()
+					Expect(err).To(MatchError(qerr.TooManyOpenStreams))
+					Expect(sess.closeChan).ToNot(Receive())
+				})
+
+				It("opens a new stream once a stream was garbage collected", func() {
+					var strs []Stream
+					for i := 0; i < 100; i++ {
+						str, err := sess.OpenStream()
+						Expect(err).ToNot(HaveOccurred())
+						strs = append(strs, str)
+					}
+					finishStream(strs[0])
+					sess.garbageCollectStreams()
+					str, err := sess.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(202)))
+				})
+
+				It("blocks in OpenStreamSync until a stream is garbage collected", func() {
+					var strs []Stream
+					for i := 0; i < 100; i++ {
+						str, err := sess.OpenStreamSync()
+						Expect(err).ToNot(HaveOccurred())
+						strs = append(strs, str)
+					}
+					strChan := make(chan Stream)
+					go func() {
+						defer GinkgoRecover()
+						str, err := sess.OpenStreamSync()
+						Expect(err).ToNot(HaveOccurred())
+						strChan <- str
+					}()
+					Consistently(strChan).ShouldNot(Receive())
+					finishStream(strs[10])
+					sess.garbageCollectStreams()
+					var str Stream
+					Eventually(strChan).Should(Receive(&str))
+					Expect(str.StreamID()).To(Equal(protocol.StreamID(202)))
+				})
+
+				It("unblocks OpenStreamSync when the session is closed", func() {
+					for i := 0; i < 100; i++ {
+						_, err := sess.OpenStreamSync()
+						Expect(err).ToNot(HaveOccurred())
+					}
+					testErr := errors.New("test error")
+					errChan := make(chan error)
+					go func() {
+						defer GinkgoRecover()
+						_, err := sess.OpenStreamSync()
+						errChan <- err
+					}()
+					Consistently(errChan).ShouldNot(Receive())
+					sess.Close(testErr)
+					Eventually(errChan).Should(Receive(MatchError(qerr.ToQuicError(testErr))))
+				})
+
+				It("returns an error after the session was closed", func() {
+					sess.Close(nil)
+					_, err := sess.OpenStream()
+					Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PeerGoingAway))
+					_, err = sess.OpenStreamSync()
+					Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PeerGoingAway))
+				})
+			})
+
 			Context("handling RST_STREAM frames", func() {
 				It("closes the receiving streams for writing and reading", func() {
-					s, err := session.OpenStream(5)
+					s, err := sess.GetOrOpenStream(5)
 					Expect(err).ToNot(HaveOccurred())
-					err = session.handleRstStreamFrame(&frames.RstStreamFrame{
+					err = sess.handleRstStreamFrame(&frames.RstStreamFrame{
 						StreamID:  5,
 						ErrorCode: 42,
 					})
@@ -301,8 +621,33 @@ var _ = Describe("Session", func() {
 					Expect(err).To(MatchError("RST_STREAM received with code 42"))
 				})
 
+				It("credits the data that won't be read to the connection level flow controller", func() {
+					_, err := sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handleRstStreamFrame(&frames.RstStreamFrame{
+						StreamID:   5,
+						ByteOffset: protocol.ReceiveStreamFlowControlWindow,
+					})
+					Expect(err).ToNot(HaveOccurred())
+					frames, err := sess.getWindowUpdateFrames()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(frames).To(HaveLen(1))
+					Expect(frames[0].StreamID).To(Equal(protocol.StreamID(0)))
+					Expect(frames[0].ByteOffset).To(Equal(protocol.ReceiveStreamFlowControlWindow + protocol.ReceiveConnectionFlowControlWindow))
+				})
+
+				It("errors when the final offset violates flow control", func() {
+					_, err := sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handleRstStreamFrame(&frames.RstStreamFrame{
+						StreamID:   5,
+						ByteOffset: protocol.ReceiveStreamFlowControlWindow + 1,
+					})
+					Expect(err).To(MatchError(qerr.FlowControlReceivedTooMuchData))
+				})
+
 				It("errors when the stream is not known", func() {
-					err := session.handleRstStreamFrame(&frames.RstStreamFrame{
+					err := sess.handleRstStreamFrame(&frames.RstStreamFrame{
 						StreamID:  5,
 						ErrorCode: 42,
 					})
@@ -310,7 +655,7 @@ var _ = Describe("Session", func() {
 				})
 
 				It("ignores the error when the stream is not known", func() {
-					err := session.handleFrames([]frames.Frame{&frames.RstStreamFrame{
+					err := sess.handleFrames([]frames.Frame{&frames.RstStreamFrame{
 						StreamID:  5,
 						ErrorCode: 42,
 					}})
@@ -318,20 +663,221 @@ var _ = Describe("Session", func() {
 				})
 			})
 
+			Context("resetting streams", func() {
+				It("sends a RST_STREAM frame", func() {
+					str, err := sess.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					str.Reset(0x1337)
+					err = sess.sendPacket()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(conn.written).To(HaveLen(1))
+					b := &bytes.Buffer{}
+					(&frames.RstStreamFrame{StreamID: str.StreamID(), ErrorCode: 0x1337}).Write(b, 0)
+					Expect(conn.written[0]).To(ContainSubstring(string(b.Bytes())))
+				})
+
+				It("keeps the stream until the peer's RST_STREAM was received, and credits the unread data to the connection", func() {
+					str, err := sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					str.Reset(0x1337)
+					err = sess.sendPacket()
+					Expect(err).ToNot(HaveOccurred())
+					sess.garbageCollectStreams()
+					Expect(sess.streams[5]).ToNot(BeNil())
+					err = sess.handleRstStreamFrame(&frames.RstStreamFrame{StreamID: 5, ByteOffset: 10})
+					Expect(err).ToNot(HaveOccurred())
+					stats, err := sess.flowControlManager.GetStats(0)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(stats.BytesRead).To(Equal(protocol.ByteCount(10)))
+					sess.garbageCollectStreams()
+					Expect(sess.streams[5]).To(BeNil())
+					Expect(sess.streamFramer.resetStreams).ToNot(HaveKey(protocol.StreamID(5)))
+				})
+			})
+
+			Context("GOAWAY", func() {
+				It("sends a GOAWAY frame with the largest stream opened by the peer", func() {
+					err := sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Data: []byte("foo")})
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.GoAway(qerr.PeerGoingAway, "shutting down")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.sendingScheduled).To(Receive())
+					err = sess.sendPacket()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(conn.written).To(HaveLen(1))
+					b := &bytes.Buffer{}
+					(&frames.GoawayFrame{
+						ErrorCode:      qerr.PeerGoingAway,
+						LastGoodStream: 5,
+						ReasonPhrase:   "shutting down",
+					}).Write(b, 0)
+					Expect(conn.written[0]).To(ContainSubstring(string(b.Bytes())))
+				})
+
+				It("only sends a single GOAWAY frame", func() {
+					err := sess.GoAway(qerr.PeerGoingAway, "")
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.GoAway(qerr.PeerGoingAway, "")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.popQueuedControlFrames()).To(HaveLen(1))
+				})
+
+				It("rejects streams opened by the peer after sending a GOAWAY", func() {
+					err := sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Data: []byte("foo")})
+					Expect(err).ToNot(HaveOccurred())
+					sess.GoAway(qerr.PeerGoingAway, "")
+					sess.popQueuedControlFrames()
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7, Data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.streams).ToNot(HaveKey(protocol.StreamID(7)))
+					Expect(sess.popQueuedControlFrames()).To(Equal([]frames.Frame{&frames.RstStreamFrame{
+						StreamID:   7,
+						ByteOffset: 6,
+						ErrorCode:  uint32(qerr.PeerGoingAway),
+					}}))
+				})
+
+				It("only sends a single RST_STREAM for a rejected stream", func() {
+					sess.GoAway(qerr.PeerGoingAway, "")
+					sess.popQueuedControlFrames()
+					err := sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7, Data: []byte("foo")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.popQueuedControlFrames()).To(HaveLen(1))
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7, Offset: 3, Data: []byte("bar")})
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7, Data: []byte("foo")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.popQueuedControlFrames()).To(BeEmpty())
+				})
+
+				It("credits the data of rejected streams to the connection level flow controller", func() {
+					sess.GoAway(qerr.PeerGoingAway, "")
+					err := sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7, Data: []byte("foo")})
+					Expect(err).ToNot(HaveOccurred())
+					// retransmissions are only counted once
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7, Data: []byte("foo")})
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handleRstStreamFrame(&frames.RstStreamFrame{StreamID: 7, ByteOffset: 10})
+					Expect(err).ToNot(HaveOccurred())
+					stats, err := sess.flowControlManager.GetStats(0)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(stats.BytesReceived).To(Equal(protocol.ByteCount(10)))
+					Expect(stats.BytesRead).To(Equal(protocol.ByteCount(10)))
+				})
+
+				It("errors when the data of rejected streams violates connection level flow control", func() {
+					sess.GoAway(qerr.PeerGoingAway, "")
+					err := sess.handleStreamFrame(&frames.StreamFrame{StreamID: 7, Offset: protocol.ReceiveConnectionFlowControlWindow, Data: []byte("foo")})
+					Expect(err).To(MatchError(qerr.FlowControlReceivedTooMuchData))
+				})
+
+				It("still accepts data for existing streams after sending a GOAWAY", func() {
+					err := sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Data: []byte("foo")})
+					Expect(err).ToNot(HaveOccurred())
+					sess.GoAway(qerr.PeerGoingAway, "")
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Offset: 3, Data: []byte("bar")})
+					Expect(err).ToNot(HaveOccurred())
+					b := make([]byte, 6)
+					_, err = sess.streams[5].Read(b)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(b).To(Equal([]byte("foobar")))
+				})
+
+				It("returns an error when sending a GOAWAY on a closed session", func() {
+					sess.Close(nil)
+					err := sess.GoAway(qerr.PeerGoingAway, "")
+					Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PeerGoingAway))
+				})
+
+				It("doesn't open new streams after receiving a GOAWAY", func() {
+					err := sess.handleFrames([]frames.Frame{&frames.GoawayFrame{ErrorCode: qerr.PeerGoingAway, ReasonPhrase: "bye"}})
+					Expect(err).ToNot(HaveOccurred())
+					_, err = sess.OpenStream()
+					Expect(err).To(MatchError(qerr.Error(qerr.PeerGoingAway, "GOAWAY received: bye")))
+					_, err = sess.OpenStreamSync()
+					Expect(err).To(MatchError(qerr.Error(qerr.PeerGoingAway, "GOAWAY received: bye")))
+				})
+
+				It("unblocks OpenStreamSync when receiving a GOAWAY", func() {
+					for i := 0; i < 100; i++ {
+						_, err := sess.OpenStream()
+						Expect(err).ToNot(HaveOccurred())
+					}
+					errChan := make(chan error, 1)
+					go func() {
+						defer GinkgoRecover()
+						_, err := sess.OpenStreamSync()
+						errChan <- err
+					}()
+					Consistently(errChan).ShouldNot(Receive())
+					sess.handleGoawayFrame(&frames.GoawayFrame{ErrorCode: qerr.PeerGoingAway})
+					Eventually(errChan).Should(Receive(HaveOccurred()))
+				})
+
+				It("closes our streams that the peer won't process", func() {
+					str1, err := sess.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					str2, err := sess.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Data: []byte("foo")})
+					Expect(err).ToNot(HaveOccurred())
+					sess.handleGoawayFrame(&frames.GoawayFrame{ErrorCode: qerr.PeerGoingAway, LastGoodStream: str1.StreamID()})
+					_, err = str2.Write([]byte("foobar"))
+					Expect(err).To(MatchError(qerr.Error(qerr.PeerGoingAway, "GOAWAY received: ")))
+					Expect(str1.(*stream).err).ToNot(HaveOccurred())
+					Expect(sess.streams[5].err).ToNot(HaveOccurred())
+				})
+
+				Context("active streams", func() {
+					It("doesn't count the crypto stream", func() {
+						Expect(sess.streams).To(HaveKey(protocol.StreamID(1)))
+						Expect(sess.hasActiveStreams()).To(BeFalse())
+					})
+
+					It("doesn't count the headers stream", func() {
+						_, err := sess.GetOrOpenStream(3)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(sess.hasActiveStreams()).To(BeFalse())
+					})
+
+					It("has active streams until they are finished", func() {
+						str, err := sess.GetOrOpenStream(5)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(sess.hasActiveStreams()).To(BeTrue())
+						str.(*stream).sentFin()
+						str.(*stream).dataAcked(0, 0, true)
+						str.Close()
+						str.(*stream).CloseRemote(0)
+						_, err = str.Read([]byte{0})
+						Expect(err).To(MatchError(io.EOF))
+						Expect(sess.hasActiveStreams()).To(BeFalse())
+					})
+
+					It("doesn't have active streams after the session was closed", func() {
+						_, err := sess.GetOrOpenStream(5)
+						Expect(err).ToNot(HaveOccurred())
+						sess.Close(nil)
+						Expect(sess.hasActiveStreams()).To(BeFalse())
+					})
+				})
+			})
+
 			Context("handling WINDOW_UPDATE frames", func() {
 				It("updates the Flow Control Window of a stream", func() {
-					_, err := session.OpenStream(5)
+					_, err := sess.GetOrOpenStream(5)
 					Expect(err).ToNot(HaveOccurred())
-					err = session.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
+					err = sess.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
 						StreamID:   5,
 						ByteOffset: 100,
 					})
 					Expect(err).ToNot(HaveOccurred())
-					Expect(session.flowControlManager.SendWindowSize(5)).To(Equal(protocol.ByteCount(100)))
+					Expect(sess.flowControlManager.SendWindowSize(5)).To(Equal(protocol.ByteCount(100)))
 				})
 
 				It("updates the Flow Control Window of the connection", func() {
-					err := session.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
+					err := sess.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
 						StreamID:   0,
 						ByteOffset: 0x800000,
 					})
@@ -339,18 +885,36 @@ var _ = Describe("Session", func() {
 				})
 
 				It("opens a new stream when receiving a WINDOW_UPDATE for an unknown stream", func() {
-					err := session.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
+					err := sess.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
 						StreamID:   5,
 						ByteOffset: 1337,
 					})
 					Expect(err).ToNot(HaveOccurred())
-					Expect(session.streams).To(HaveKey(protocol.StreamID(5)))
-					Expect(session.streams[5]).ToNot(BeNil())
+					Expect(sess.streams).To(HaveKey(protocol.StreamID(5)))
+					Expect(sess.streams[5]).ToNot(BeNil())
+				})
+
+				It("doesn't race with checking for active streams while shutting down", func() {
+					done := make(chan struct{})
+					go func() {
+						defer close(done)
+						for i := 0; i < 100; i++ {
+							sess.hasActiveStreams()
+						}
+					}()
+					for id := protocol.StreamID(5); id < 5+2*20; id += 2 {
+						err := sess.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
+							StreamID:   id,
+							ByteOffset: 1337,
+						})
+						Expect(err).ToNot(HaveOccurred())
+					}
+					Eventually(done).Should(BeClosed())
 				})
 
 				It("errors when receiving a WindowUpdateFrame for a closed stream", func() {
-					session.streams[5] = nil // this is what the garbageCollectStreams() does when a Stream is closed
-					err := session.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
+					sess.streams[5] = nil // this is what the garbageCollectStreams() does when a Stream is closed
+					err := sess.handleWindowUpdateFrame(&frames.WindowUpdateFrame{
 						StreamID:   5,
 						ByteOffset: 1337,
 					})
@@ -358,8 +922,8 @@ var _ = Describe("Session", func() {
 				})
 
 				It("ignores errors when receiving a WindowUpdateFrame for a closed stream", func() {
-					session.streams[5] = nil // this is what the garbageCollectStreams() does when a Stream is closed
-					err := session.handleFrames([]frames.Frame{&frames.WindowUpdateFrame{
+					sess.streams[5] = nil // this is what the garbageCollectStreams() does when a Stream is closed
+					err := sess.handleFrames([]frames.Frame{&frames.WindowUpdateFrame{
 						StreamID:   5,
 						ByteOffset: 1337,
 					}})
@@ -368,28 +932,28 @@ var _ = Describe("Session", func() {
 			})
 
 			It("handles PING frames", func() {
-				err := session.handleFrames([]frames.Frame{&frames.PingFrame{}})
+				err := sess.handleFrames([]frames.Frame{&frames.PingFrame{}})
 				Expect(err).NotTo(HaveOccurred())
 			})
 
 			It("handles BLOCKED frames", func() {
-				err := session.handleFrames([]frames.Frame{&frames.BlockedFrame{}})
+				err := sess.handleFrames([]frames.Frame{&frames.BlockedFrame{}})
 				Expect(err).NotTo(HaveOccurred())
 			})
 
-			It("errors on GOAWAY frames", func() {
-				err := session.handleFrames([]frames.Frame{&frames.GoawayFrame{}})
-				Expect(err).To(MatchError("unimplemented: handling GOAWAY frames"))
+			It("handles GOAWAY frames", func() {
+				err := sess.handleFrames([]frames.Frame{&frames.GoawayFrame{}})
+				Expect(err).NotTo(HaveOccurred())
 			})
 
 			It("handles STOP_WAITING frames", func() {
-				err := session.handleFrames([]frames.Frame{&frames.StopWaitingFrame{LeastUnacked: 10}})
+				err := sess.handleFrames([]frames.Frame{&frames.StopWaitingFrame{LeastUnacked: 10}})
 				Expect(err).NotTo(HaveOccurred())
 			})
 
 			It("handles CONNECTION_CLOSE frames", func() {
-				str, _ := session.OpenStream(5)
-				err := session.handleFrames([]frames.Frame{&frames.ConnectionCloseFrame{ErrorCode: 42, ReasonPhrase: "foobar"}})
+				str, _ := sess.GetOrOpenStream(5)
+				err := sess.handleFrames([]frames.Frame{&frames.ConnectionCloseFrame{ErrorCode: 42, ReasonPhrase: "foobar"}})
 				Expect(err).NotTo(HaveOccurred())
 				_, err = str.Read([]byte{0})
 				Expect(err).To(MatchError(qerr.Error(42, "foobar")))
@@ -403,31 +967,53 @@ var _ = Describe("Session", func() {
 				BeforeEach(func() {
 					time.Sleep(10 * time.Millisecond) // Wait for old goroutines to finish
 					nGoRoutinesBefore = runtime.NumGoroutine()
-					go session.run()
+					go sess.run()
 					Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore + 2))
 				})
 
 				It("shuts down without error", func() {
-					session.Close(nil)
-					Expect(closeCallbackCalled).To(BeTrue())
+					sess.Close(nil)
+					Eventually(closeCallbackChan).Should(Receive())
 					Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
-					Expect(conn.written).To(HaveLen(1))
-					Expect(conn.written[0][len(conn.written[0])-7:]).To(Equal([]byte{0x02, byte(qerr.PeerGoingAway), 0, 0, 0, 0, 0}))
+					Expect(conn.getWritten()).To(HaveLen(1))
+					Expect(conn.getWritten()[0][len(conn.getWritten()[0])-7:]).To(Equal([]byte{0x02, byte(qerr.PeerGoingAway), 0, 0, 0, 0, 0}))
+				})
+
+				It("passes the CONNECTION_CLOSE packet to the close callback", func() {
+					sess.Close(nil)
+					var closePacket []byte
+					Eventually(closeCallbackChan).Should(Receive(&closePacket))
+					Expect(conn.getWritten()).To(HaveLen(1))
+					Expect(closePacket).To(Equal(conn.getWritten()[0]))
+				})
+
+				It("doesn't pass a packet to the close callback if the peer closed the session", func() {
+					sess.closeImpl(qerr.Error(qerr.PeerGoingAway, ""), true)
+					var closePacket []byte
+					Eventually(closeCallbackChan).Should(Receive(&closePacket))
+					Expect(conn.getWritten()).To(BeEmpty())
+					Expect(closePacket).To(BeNil())
+				})
+
+				It("doesn't call the close callback when the session is closed for a new version", func() {
+					sess.Close(errCloseSessionForNewVersion)
+					Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+					Expect(closeCallbackChan).ToNot(Receive())
 				})
 
 				It("only closes once", func() {
-					session.Close(nil)
-					session.Close(nil)
+					sess.Close(nil)
+					sess.Close(nil)
 					Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
-					Expect(conn.written).To(HaveLen(1))
+					Expect(conn.getWritten()).To(HaveLen(1))
 				})
 
 				It("closes streams with proper error", func() {
 					testErr := errors.New("test error")
-					s, err := session.OpenStream(5)
+					s, err := sess.GetOrOpenStream(5)
 					Expect(err).NotTo(HaveOccurred())
-					session.Close(testErr)
-					Expect(closeCallbackCalled).To(BeTrue())
+					sess.Close(testErr)
+					Eventually(closeCallbackChan).Should(Receive())
 					Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
 					n, err := s.Read([]byte{0})
 					Expect(n).To(BeZero())
@@ -442,50 +1028,155 @@ var _ = Describe("Session", func() {
 				var hdr *publicHeader
 
 				BeforeEach(func() {
-					session.unpacker = &mockUnpacker{}
+					sess.unpacker = &mockUnpacker{}
 					hdr = &publicHeader{PacketNumberLen: protocol.PacketNumberLen6}
 				})
 
 				It("sets the lastRcvdPacketNumber", func() {
 					hdr.PacketNumber = 5
-					err := session.handlePacketImpl(nil, hdr, nil)
+					err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
 					Expect(err).ToNot(HaveOccurred())
-					Expect(session.lastRcvdPacketNumber).To(Equal(protocol.PacketNumber(5)))
+					Expect(sess.lastRcvdPacketNumber).To(Equal(protocol.PacketNumber(5)))
 				})
 
 				It("sets the lastRcvdPacketNumber, for an out-of-order packet", func() {
 					hdr.PacketNumber = 5
-					err := session.handlePacketImpl(nil, hdr, nil)
+					err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
 					Expect(err).ToNot(HaveOccurred())
-					Expect(session.lastRcvdPacketNumber).To(Equal(protocol.PacketNumber(5)))
+					Expect(sess.lastRcvdPacketNumber).To(Equal(protocol.PacketNumber(5)))
 					hdr.PacketNumber = 3
-					err = session.handlePacketImpl(nil, hdr, nil)
+					err = sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
 					Expect(err).ToNot(HaveOccurred())
-					Expect(session.lastRcvdPacketNumber).To(Equal(protocol.PacketNumber(3)))
+					Expect(sess.lastRcvdPacketNumber).To(Equal(protocol.PacketNumber(3)))
 				})
 
 				It("ignores duplicate packets", func() {
 					hdr.PacketNumber = 5
-					err := session.handlePacketImpl(nil, hdr, nil)
+					err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("retains the packet buffer for stream frames that are queued", func() {
+					buffer := getPacketBufferWithData([]byte("foobar"))
+					frame := &frames.StreamFrame{StreamID: 5, Data: buffer.Slice}
+					sess.unpacker = &mockUnpacker{frames: []frames.Frame{frame}}
+					hdr.PacketNumber = 5
+					err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr, data: buffer.Slice, buffer: buffer})
 					Expect(err).ToNot(HaveOccurred())
-					err = session.handlePacketImpl(nil, hdr, nil)
+					Expect(frame.Buffer).To(Equal(buffer))
+					Expect(buffer.refCount).To(BeEquivalentTo(2))
+					p := make([]byte, 6)
+					_, err = sess.streams[5].Read(p)
 					Expect(err).ToNot(HaveOccurred())
+					Expect(p).To(Equal([]byte("foobar")))
+					Expect(buffer.refCount).To(BeEquivalentTo(1))
 				})
 
 				It("ignores packets smaller than the highest LeastUnacked of a StopWaiting", func() {
-					err := session.receivedPacketHandler.ReceivedStopWaiting(&frames.StopWaitingFrame{LeastUnacked: 10})
+					err := sess.receivedPacketHandler.ReceivedStopWaiting(&frames.StopWaitingFrame{LeastUnacked: 10})
 					Expect(err).ToNot(HaveOccurred())
 					hdr.PacketNumber = 5
-					err = session.handlePacketImpl(nil, hdr, nil)
+					err = sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
 					Expect(err).ToNot(HaveOccurred())
 				})
+
+				Context("connection migration", func() {
+					var (
+						origAddr *net.UDPAddr
+						sph      *mockSentPacketHandler
+					)
+
+					BeforeEach(func() {
+						origAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234}
+						conn.remoteAddr = origAddr
+						sph = &mockSentPacketHandler{}
+						sess.sentPacketHandler = sph
+					})
+
+					It("switches to the new address after receiving a packet", func() {
+						newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1234}
+						hdr.PacketNumber = 5
+						err := sess.handlePacketImpl(&receivedPacket{remoteAddr: newAddr, publicHeader: hdr})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(conn.remoteAddr).To(Equal(newAddr))
+						Expect(sph.connectionMigrated).To(BeTrue())
+					})
+
+					It("doesn't switch to the new address if the packet can't be decrypted", func() {
+						sess.unpacker = &mockUnpacker{unpackErr: qerr.Error(qerr.DecryptionFailure, "")}
+						hdr.PacketNumber = 5
+						err := sess.handlePacketImpl(&receivedPacket{remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1234}, publicHeader: hdr})
+						Expect(err).To(HaveOccurred())
+						Expect(conn.remoteAddr).To(Equal(origAddr))
+						Expect(sph.connectionMigrated).To(BeFalse())
+					})
+
+					It("doesn't switch back to the old address for reordered packets", func() {
+						newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1234}
+						hdr.PacketNumber = 5
+						err := sess.handlePacketImpl(&receivedPacket{remoteAddr: newAddr, publicHeader: hdr})
+						Expect(err).ToNot(HaveOccurred())
+						hdr.PacketNumber = 4
+						err = sess.handlePacketImpl(&receivedPacket{remoteAddr: origAddr, publicHeader: hdr})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(conn.remoteAddr).To(Equal(newAddr))
+					})
+
+					It("doesn't reset the congestion controller on a NAT rebinding", func() {
+						newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 4321}
+						hdr.PacketNumber = 5
+						err := sess.handlePacketImpl(&receivedPacket{remoteAddr: newAddr, publicHeader: hdr})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(conn.remoteAddr).To(Equal(newAddr))
+						Expect(sph.connectionMigrated).To(BeFalse())
+					})
+
+					It("doesn't do anything if the address didn't change", func() {
+						migrated := false
+						sess.config.ConnectionMigrated = func(Session, ConnectionMigration) { migrated = true }
+						hdr.PacketNumber = 5
+						err := sess.handlePacketImpl(&receivedPacket{remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234}, publicHeader: hdr})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(sph.connectionMigrated).To(BeFalse())
+						Expect(migrated).To(BeFalse())
+					})
+
+					It("notifies the application", func() {
+						var migration ConnectionMigration
+						var migratedSession Session
+						sess.config.ConnectionMigrated = func(s Session, m ConnectionMigration) {
+							migratedSession = s
+							migration = m
+						}
+						newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 4321}
+						hdr.PacketNumber = 5
+						err := sess.handlePacketImpl(&receivedPacket{remoteAddr: newAddr, publicHeader: hdr})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(migratedSession).To(Equal(sess))
+						Expect(migration).To(Equal(ConnectionMigration{
+							OldAddr:      origAddr,
+							NewAddr:      newAddr,
+							NATRebinding: true,
+						}))
+					})
+
+					It("doesn't follow the server's address on the client side", func() {
+						sess.perspective = protocol.PerspectiveClient
+						hdr.PacketNumber = 5
+						err := sess.handlePacketImpl(&receivedPacket{remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 168, 100, 200), Port: 1234}, publicHeader: hdr})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(conn.remoteAddr).To(Equal(origAddr))
+					})
+				})
 			})
 
 			Context("sending packets", func() {
 				It("sends ack frames", func() {
 					packetNumber := protocol.PacketNumber(0x35EA)
-					session.receivedPacketHandler.ReceivedPacket(packetNumber, true)
-					err := session.sendPacket()
+					sess.receivedPacketHandler.ReceivedPacket(packetNumber, true)
+					err := sess.sendPacket()
 					Expect(err).NotTo(HaveOccurred())
 					Expect(conn.written).To(HaveLen(1))
 					// test for the beginning of an ACK frame: Entropy until LargestObserved
@@ -493,14 +1184,14 @@ var _ = Describe("Session", func() {
 				})
 
 				It("sends two WindowUpdate frames", func() {
-					_, err := session.OpenStream(5)
+					_, err := sess.GetOrOpenStream(5)
 					Expect(err).ToNot(HaveOccurred())
-					session.flowControlManager.AddBytesRead(5, protocol.ReceiveStreamFlowControlWindow)
-					err = session.sendPacket()
+					sess.flowControlManager.AddBytesRead(5, protocol.ReceiveStreamFlowControlWindow)
+					err = sess.sendPacket()
 					Expect(err).NotTo(HaveOccurred())
-					err = session.sendPacket()
+					err = sess.sendPacket()
 					Expect(err).NotTo(HaveOccurred())
-					err = session.sendPacket()
+					err = sess.sendPacket()
 					Expect(err).NotTo(HaveOccurred())
 					Expect(conn.written).To(HaveLen(2))
 					Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x04, 0x05, 0, 0, 0})))
@@ -508,28 +1199,64 @@ var _ = Describe("Session", func() {
 				})
 
 				It("sends public reset", func() {
-					err := session.sendPublicReset(1)
+					err := sess.sendPublicReset(1)
 					Expect(err).NotTo(HaveOccurred())
 					Expect(conn.written).To(HaveLen(1))
 					Expect(conn.written[0]).To(ContainSubstring(string([]byte("PRST"))))
 				})
+
+				It("uses the nonce proof derived from the reset secret in public resets", func() {
+					sess.config.ResetSecret = []byte("foobar")
+					err := sess.sendPublicReset(1)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(conn.written).To(HaveLen(1))
+					Expect(conn.written[0]).To(Equal(writePublicReset(sess.connectionID, 1, publicResetNonceProof([]byte("foobar"), sess.connectionID))))
+				})
 			})
 
 			Context("retransmissions", func() {
+				// sent puts the data of the frame into the send buffer of its stream, as if it had been sent before
+				sent := func(f *frames.StreamFrame) {
+					str, err := sess.GetOrOpenStream(f.StreamID)
+					Expect(err).ToNot(HaveOccurred())
+					putSentData(str.(*stream), f)
+				}
+
+				It("stores only the range of the stream data in the sent packet history", func() {
+					sph := newMockSentPacketHandler()
+					sess.sentPacketHandler = sph
+					s, err := sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+					_, err = s.Write([]byte("foobar"))
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.sendPacket()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(conn.written).To(HaveLen(1))
+					sentPackets := sph.(*mockSentPacketHandler).sentPackets
+					Expect(sentPackets).To(HaveLen(1))
+					Expect(sentPackets[0].StreamRanges).To(Equal([]ackhandlerlegacy.StreamRange{{StreamID: 5, Length: 6}}))
+					for _, f := range sentPackets[0].Frames {
+						Expect(f).ToNot(BeAssignableToTypeOf(&frames.StreamFrame{}))
+					}
+				})
+
 				It("sends a StreamFrame from a packet queued for retransmission", func() {
 					f := frames.StreamFrame{
 						StreamID: 0x5,
 						Data:     []byte("foobar1234567"),
 					}
+					sent(&f)
 					p := ackhandlerlegacy.Packet{
 						PacketNumber: 0x1337,
-						Frames:       []frames.Frame{&f},
+						StreamRanges: []ackhandlerlegacy.StreamRange{ackhandlerlegacy.NewStreamRange(&f)},
 					}
 					sph := newMockSentPacketHandler()
 					sph.(*mockSentPacketHandler).retransmissionQueue = []*ackhandlerlegacy.Packet{&p}
-					session.sentPacketHandler = sph
+					sess.sentPacketHandler = sph
+					// the retransmission is sent in a packet with a higher packet number
+					sess.packer.lastPacketNumber = 0x1337
 
-					err := session.sendPacket()
+					err := sess.sendPacket()
 					Expect(err).NotTo(HaveOccurred())
 					Expect(conn.written).To(HaveLen(1))
 					Expect(conn.written[0]).To(ContainSubstring("foobar1234567"))
@@ -544,48 +1271,86 @@ var _ = Describe("Session", func() {
 						StreamID: 0x7,
 						Data:     []byte("loremipsum"),
 					}
+					sent(&f1)
+					sent(&f2)
 					p1 := ackhandlerlegacy.Packet{
 						PacketNumber: 0x1337,
-						Frames:       []frames.Frame{&f1},
+						StreamRanges: []ackhandlerlegacy.StreamRange{ackhandlerlegacy.NewStreamRange(&f1)},
 					}
 					p2 := ackhandlerlegacy.Packet{
 						PacketNumber: 0x1338,
-						Frames:       []frames.Frame{&f2},
+						StreamRanges: []ackhandlerlegacy.StreamRange{ackhandlerlegacy.NewStreamRange(&f2)},
 					}
 					sph := newMockSentPacketHandler()
 					sph.(*mockSentPacketHandler).retransmissionQueue = []*ackhandlerlegacy.Packet{&p1, &p2}
-					session.sentPacketHandler = sph
+					sess.sentPacketHandler = sph
+					sess.packer.lastPacketNumber = 0x1338
 
-					err := session.sendPacket()
+					err := sess.sendPacket()
 					Expect(err).NotTo(HaveOccurred())
 					Expect(conn.written).To(HaveLen(1))
 					Expect(conn.written[0]).To(ContainSubstring("foobar"))
 					Expect(conn.written[0]).To(ContainSubstring("loremipsum"))
 				})
+
+				It("sends multiple packets in batches", func() {
+					for i := 0; i < 2*protocol.PacketBatchSize; i++ {
+						f := &frames.StreamFrame{
+							StreamID: 5,
+							Offset:   protocol.ByteCount(i * 1000),
+							Data:     bytes.Repeat([]byte{'f'}, 1000),
+						}
+						sent(f)
+						sess.streamFramer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(f))
+					}
+					err := sess.sendPacket()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(len(conn.written)).To(BeNumerically(">", protocol.PacketBatchSize))
+					Expect(conn.batches).To(HaveLen(2))
+					Expect(conn.batches[0]).To(Equal(protocol.PacketBatchSize))
+					Expect(conn.batches[1]).To(Equal(len(conn.written) - protocol.PacketBatchSize))
+				})
+
+				It("removes acknowledged stream data from the send buffers", func() {
+					f := frames.StreamFrame{
+						StreamID: 0x5,
+						Data:     []byte("foobar"),
+					}
+					sent(&f)
+					p := ackhandlerlegacy.Packet{
+						PacketNumber: 0x1337,
+						StreamRanges: []ackhandlerlegacy.StreamRange{ackhandlerlegacy.NewStreamRange(&f)},
+					}
+					sph := newMockSentPacketHandler()
+					sph.(*mockSentPacketHandler).ackedPacketQueue = []*ackhandlerlegacy.Packet{&p}
+					sess.sentPacketHandler = sph
+
+					Expect(sess.streams[5].sendBuffer.Len()).To(Equal(protocol.ByteCount(6)))
+					err := sess.handleAckFrame(&frames.AckFrame{})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.streams[5].sendBuffer.Len()).To(BeZero())
+				})
 			})
 
 			Context("scheduling sending", func() {
-				It("sends after writing to a stream", func(done Done) {
-					Expect(session.sendingScheduled).NotTo(Receive())
-					s, err := session.OpenStream(3)
+				It("sends after writing to a stream", func() {
+					Expect(sess.sendingScheduled).NotTo(Receive())
+					s, err := sess.GetOrOpenStream(3)
 					Expect(err).NotTo(HaveOccurred())
-					go func() {
-						s.Write([]byte("foobar"))
-						close(done)
-					}()
-					Eventually(session.sendingScheduled).Should(Receive())
-					s.(*stream).getDataForWriting(1000) // unblock
+					_, err = s.Write([]byte("foobar"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(sess.sendingScheduled).To(Receive())
 				})
 
 				Context("bundling of small packets", func() {
 					It("bundles two small frames of different streams into one packet", func() {
-						s1, err := session.OpenStream(5)
+						s1, err := sess.GetOrOpenStream(5)
 						Expect(err).NotTo(HaveOccurred())
-						s2, err := session.OpenStream(7)
+						s2, err := sess.GetOrOpenStream(7)
 						Expect(err).NotTo(HaveOccurred())
 						go func() {
 							time.Sleep(time.Millisecond)
-							session.run()
+							sess.run()
 						}()
 						go func() {
 							_, err2 := s1.Write([]byte("foobar1"))
@@ -594,15 +1359,15 @@ var _ = Describe("Session", func() {
 						_, err = s2.Write([]byte("foobar2"))
 						Expect(err).NotTo(HaveOccurred())
 						time.Sleep(10 * time.Millisecond)
-						Expect(conn.written).To(HaveLen(1))
+						Expect(conn.getWritten()).To(HaveLen(1))
 					})
 
 					It("sends out two big frames in two packets", func() {
-						s1, err := session.OpenStream(5)
+						s1, err := sess.GetOrOpenStream(5)
 						Expect(err).NotTo(HaveOccurred())
-						s2, err := session.OpenStream(7)
+						s2, err := sess.GetOrOpenStream(7)
 						Expect(err).NotTo(HaveOccurred())
-						go session.run()
+						go sess.run()
 						go func() {
 							defer GinkgoRecover()
 							_, err2 := s1.Write(bytes.Repeat([]byte{'e'}, 1000))
@@ -610,51 +1375,51 @@ var _ = Describe("Session", func() {
 						}()
 						_, err = s2.Write(bytes.Repeat([]byte{'e'}, 1000))
 						Expect(err).ToNot(HaveOccurred())
-						Eventually(func() [][]byte { return conn.written }).Should(HaveLen(2))
+						Eventually(conn.getWritten).Should(HaveLen(2))
 					})
 
 					It("sends out two small frames that are written to long after one another into two packets", func() {
-						s, err := session.OpenStream(5)
+						s, err := sess.GetOrOpenStream(5)
 						Expect(err).NotTo(HaveOccurred())
-						go session.run()
+						go sess.run()
 						_, err = s.Write([]byte("foobar1"))
 						Expect(err).NotTo(HaveOccurred())
-						Eventually(func() [][]byte { return conn.written }).Should(HaveLen(1))
+						Eventually(conn.getWritten).Should(HaveLen(1))
 						_, err = s.Write([]byte("foobar2"))
 						Expect(err).NotTo(HaveOccurred())
-						Eventually(func() [][]byte { return conn.written }).Should(HaveLen(2))
+						Eventually(conn.getWritten).Should(HaveLen(2))
 					})
 
 					It("sends a queued ACK frame only once", func() {
 						packetNumber := protocol.PacketNumber(0x1337)
-						session.receivedPacketHandler.ReceivedPacket(packetNumber, true)
+						sess.receivedPacketHandler.ReceivedPacket(packetNumber, true)
 
-						s, err := session.OpenStream(5)
+						s, err := sess.GetOrOpenStream(5)
 						Expect(err).NotTo(HaveOccurred())
-						go session.run()
+						go sess.run()
 						_, err = s.Write([]byte("foobar1"))
 						Expect(err).NotTo(HaveOccurred())
-						Eventually(func() [][]byte { return conn.written }).Should(HaveLen(1))
+						Eventually(conn.getWritten).Should(HaveLen(1))
 						_, err = s.Write([]byte("foobar2"))
 						Expect(err).NotTo(HaveOccurred())
 
-						Eventually(func() [][]byte { return conn.written }).Should(HaveLen(2))
-						Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x37, 0x13})))
-						Expect(conn.written[1]).ToNot(ContainSubstring(string([]byte{0x37, 0x13})))
+						Eventually(conn.getWritten).Should(HaveLen(2))
+						Expect(conn.getWritten()[0]).To(ContainSubstring(string([]byte{0x37, 0x13})))
+						Expect(conn.getWritten()[1]).ToNot(ContainSubstring(string([]byte{0x37, 0x13})))
 					})
 				})
 			})
 
 			It("closes when crypto stream errors", func() {
-				go session.run()
-				s, err := session.OpenStream(3)
+				go sess.run()
+				s, err := sess.GetOrOpenStream(3)
 				Expect(err).NotTo(HaveOccurred())
-				err = session.handleStreamFrame(&frames.StreamFrame{
+				err = sess.handleStreamFrame(&frames.StreamFrame{
 					StreamID: 1,
 					Data:     []byte("4242\x00\x00\x00\x00"),
 				})
 				Expect(err).NotTo(HaveOccurred())
-				Eventually(func() bool { return atomic.LoadUint32(&session.closed) != 0 }).Should(BeTrue())
+				Eventually(func() bool { return atomic.LoadUint32(&sess.closed) != 0 }).Should(BeTrue())
 				_, err = s.Write([]byte{})
 				Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidCryptoMessageType))
 			})
@@ -665,52 +1430,132 @@ var _ = Describe("Session", func() {
 					hdr := &publicHeader{
 						PacketNumber: protocol.PacketNumber(i + 1),
 					}
-					session.handlePacket(nil, hdr, []byte("foobar"))
+					sess.handlePacket(&receivedPacket{publicHeader: hdr, data: []byte("foobar"), buffer: getPacketBuffer()})
 				}
-				session.run()
+				sess.run()
 
 				Expect(conn.written).To(HaveLen(1))
 				Expect(conn.written[0]).To(ContainSubstring(string([]byte("PRST"))))
 			})
 
 			It("unqueues undecryptable packets for later decryption", func() {
-				session.undecryptablePackets = []receivedPacket{{
-					nil,
-					&publicHeader{PacketNumber: protocol.PacketNumber(42)},
-					nil,
+				sess.undecryptablePackets = []receivedPacket{{
+					publicHeader: &publicHeader{PacketNumber: protocol.PacketNumber(42)},
+					buffer:       getPacketBuffer(),
 				}}
-				Expect(session.receivedPackets).NotTo(Receive())
-				session.tryDecryptingQueuedPackets()
-				Expect(session.undecryptablePackets).To(BeEmpty())
-				Expect(session.receivedPackets).To(Receive())
+				Expect(sess.receivedPackets).NotTo(Receive())
+				sess.tryDecryptingQueuedPackets()
+				Expect(sess.undecryptablePackets).To(BeEmpty())
+				Expect(sess.receivedPackets).To(Receive())
 			})
 
 			It("times out", func(done Done) {
-				session.connectionParametersManager.SetFromMap(map[handshake.Tag][]byte{
+				sess.connectionParametersManager.SetFromMap(map[handshake.Tag][]byte{
 					handshake.TagICSL: {0, 0, 0, 0},
 				})
-				session.packer.connectionParametersManager = session.connectionParametersManager
-				session.run() // Would normally not return
+				sess.packer.connectionParametersManager = sess.connectionParametersManager
+				sess.run() // Would normally not return
 				Expect(conn.written[0]).To(ContainSubstring("No recent network activity."))
 				close(done)
 			}, 3)
 
+			Context("running on an event loop", func() {
+				var loop *eventLoop
+
+				BeforeEach(func() {
+					loop = newEventLoop(2)
+				})
+
+				AfterEach(func() {
+					loop.close()
+				})
+
+				It("doesn't use its own timer and packet queue", func() {
+					sess.runOnEventLoop(loop)
+					Expect(sess.loop).To(Equal(loop.worker(sess.connectionID)))
+					Expect(sess.timer).To(BeNil())
+					Expect(sess.receivedPackets).To(BeNil())
+				})
+
+				It("doesn't allocate a timer and packet queue if the server uses an event loop", func() {
+					pSession, err := newSession(conn, version, 0, nil, func(protocol.ConnectionID, []byte) {}, nil, populateConfig(&Config{EventLoopWorkers: 2}))
+					Expect(err).ToNot(HaveOccurred())
+					sess = pSession.(*session)
+					Expect(sess.timer).To(BeNil())
+					Expect(sess.receivedPackets).To(BeNil())
+				})
+
+				It("handles the crypto stream on the worker", func() {
+					sess.loop = loop.worker(sess.connectionID)
+					b := &bytes.Buffer{}
+					handshake.WriteHandshakeMessage(b, handshake.TagCHLO, map[handshake.Tag][]byte{handshake.TagPAD: []byte("foobar")})
+					data := b.Bytes()
+					err := sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.cryptoDataReceived).To(BeFalse())
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 1, Data: data[:5]})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.cryptoDataReceived).To(BeTrue())
+					Expect(sess.handleCryptoDataOnEventLoop()).To(Succeed())
+					Expect(sess.cryptoDataReceived).To(BeFalse())
+					Expect(sess.cryptoData.Len()).To(Equal(5))
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 1, Offset: 5, Data: data[5:]})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sess.handleCryptoDataOnEventLoop()).To(MatchError("CryptoMessageParameterNotFound: SNI required"))
+				})
+
+				It("sends a CONNECTION_CLOSE when closed", func() {
+					sess.runOnEventLoop(loop)
+					sess.Close(nil)
+					Eventually(closeCallbackChan).Should(Receive())
+					Expect(conn.getWritten()).To(HaveLen(1))
+					Expect(conn.getWritten()[0][len(conn.getWritten()[0])-7:]).To(Equal([]byte{0x02, byte(qerr.PeerGoingAway), 0, 0, 0, 0, 0}))
+				})
+
+				It("sends after writing to a stream", func() {
+					sess.runOnEventLoop(loop)
+					s, err := sess.GetOrOpenStream(3)
+					Expect(err).NotTo(HaveOccurred())
+					go s.Write([]byte("foobar"))
+					Eventually(conn.getWritten).Should(HaveLen(1))
+					Expect(conn.getWritten()[0]).To(ContainSubstring("foobar"))
+				})
+
+				It("times out", func() {
+					sess.connectionParametersManager.SetFromMap(map[handshake.Tag][]byte{
+						handshake.TagICSL: {0, 0, 0, 0},
+					})
+					sess.packer.connectionParametersManager = sess.connectionParametersManager
+					sess.runOnEventLoop(loop)
+					Eventually(closeCallbackChan).Should(Receive())
+					Expect(conn.getWritten()[0]).To(ContainSubstring("No recent network activity."))
+				})
+
+				It("ignores events after it was closed", func() {
+					sess.runOnEventLoop(loop)
+					sess.Close(nil)
+					Eventually(closeCallbackChan).Should(Receive())
+					sess.scheduleSending()
+					Consistently(conn.getWritten).Should(HaveLen(1))
+				})
+			})
+
 			It("errors when the SentPacketHandler has too many packets tracked", func() {
 				streamFrame := frames.StreamFrame{StreamID: 5, Data: []byte("foobar")}
 				for i := uint32(1); i < protocol.MaxTrackedSentPackets+10; i++ {
 					packet := ackhandlerlegacy.Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{&streamFrame}, Length: 1}
-					err := session.sentPacketHandler.SentPacket(&packet)
+					err := sess.sentPacketHandler.SentPacket(&packet)
 					Expect(err).ToNot(HaveOccurred())
 				}
-				// now session.sentPacketHandler.CheckForError will return an error
-				err := session.sendPacket()
+				// now sess.sentPacketHandler.CheckForError will return an error
+				err := sess.sendPacket()
 				Expect(err).To(MatchError(ackhandlerlegacy.ErrTooManyTrackedSentPackets))
 			})
 
 			It("stores up to MaxSessionUnprocessedPackets packets", func(done Done) {
 				// Nothing here should block
 				for i := 0; i < protocol.MaxSessionUnprocessedPackets+10; i++ {
-					session.handlePacket(nil, nil, nil)
+					sess.handlePacket(&receivedPacket{buffer: getPacketBuffer()})
 				}
 				close(done)
 			}, 0.5)
@@ -719,70 +1564,306 @@ var _ = Describe("Session", func() {
 				// We simulate consistently low RTTs, so that the test works faster
 				n := protocol.PacketNumber(10)
 				for p := protocol.PacketNumber(1); p < n; p++ {
-					err := session.sentPacketHandler.SentPacket(&ackhandlerlegacy.Packet{PacketNumber: p, Length: 1})
+					err := sess.sentPacketHandler.SentPacket(&ackhandlerlegacy.Packet{PacketNumber: p, Length: 1})
 					Expect(err).NotTo(HaveOccurred())
 					time.Sleep(time.Microsecond)
 					ack := frames.AckFrameLegacy{LargestObserved: p}
-					err = session.sentPacketHandler.ReceivedAck(&frames.AckFrame{AckFrameLegacy: &ack}, p)
+					err = sess.sentPacketHandler.ReceivedAck(&frames.AckFrame{AckFrameLegacy: &ack}, p)
 					Expect(err).NotTo(HaveOccurred())
 				}
 				// Now, we send a single packet, and expect that it was retransmitted later
-				go session.run()
 				Expect(conn.written).To(BeEmpty())
-				err := session.sentPacketHandler.SentPacket(&ackhandlerlegacy.Packet{
+				f := &frames.StreamFrame{
+					StreamID: 5,
+					Data:     bytes.Repeat([]byte{'a'}, 1000),
+				}
+				str, err := sess.GetOrOpenStream(5)
+				Expect(err).NotTo(HaveOccurred())
+				putSentData(str.(*stream), f)
+				err = sess.sentPacketHandler.SentPacket(&ackhandlerlegacy.Packet{
 					PacketNumber: n,
 					Length:       1,
-					Frames: []frames.Frame{&frames.StreamFrame{
-						Data: bytes.Repeat([]byte{'a'}, 1000),
-					}},
+					StreamRanges: []ackhandlerlegacy.StreamRange{ackhandlerlegacy.NewStreamRange(f)},
 				})
-				session.packer.lastPacketNumber = n
+				sess.packer.lastPacketNumber = n
 				Expect(err).NotTo(HaveOccurred())
-				session.scheduleSending()
-				Eventually(func() bool { return len(conn.written) > 0 }).Should(BeTrue())
+				go sess.run()
+				sess.scheduleSending()
+				Eventually(func() bool { return len(conn.getWritten()) > 0 }).Should(BeTrue())
+			})
+
+			Context("stats", func() {
+				var hdr *publicHeader
+
+				BeforeEach(func() {
+					sess.unpacker = &mockUnpacker{}
+					hdr = &publicHeader{PacketNumberLen: protocol.PacketNumberLen6, PacketNumber: 5, Raw: []byte("header")}
+				})
+
+				It("counts received packets", func() {
+					queued := sess.handleReceivedPacket(receivedPacket{publicHeader: hdr, data: []byte("foobar"), buffer: getPacketBuffer()})
+					Expect(queued).To(BeFalse())
+					sess.publishStats()
+					stats := sess.Stats()
+					Expect(stats.PacketsReceived).To(Equal(uint64(1)))
+					Expect(stats.BytesReceived).To(Equal(protocol.ByteCount(12)))
+				})
+
+				It("counts undecryptable packets only once", func() {
+					sess.unpacker = &mockUnpacker{unpackErr: qerr.Error(qerr.DecryptionFailure, "")}
+					queued := sess.handleReceivedPacket(receivedPacket{publicHeader: hdr, data: []byte("foobar"), buffer: getPacketBuffer()})
+					Expect(queued).To(BeTrue())
+					Expect(sess.undecryptablePackets).To(HaveLen(1))
+					// the packet still can't be decrypted when it is unqueued
+					p := sess.undecryptablePackets[0]
+					sess.undecryptablePackets = nil
+					queued = sess.handleReceivedPacket(p)
+					Expect(queued).To(BeTrue())
+					sess.publishStats()
+					stats := sess.Stats()
+					Expect(stats.PacketsReceived).To(Equal(uint64(1)))
+					Expect(stats.PacketsUndecryptable).To(Equal(uint64(1)))
+				})
+
+				It("counts duplicate packets as dropped", func() {
+					err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handlePacketImpl(&receivedPacket{publicHeader: hdr})
+					Expect(err).ToNot(HaveOccurred())
+					sess.publishStats()
+					Expect(sess.Stats().PacketsDropped).To(Equal(uint64(1)))
+				})
+
+				It("counts packets dropped because the queue is full", func() {
+					for i := 0; i < protocol.MaxSessionUnprocessedPackets+10; i++ {
+						sess.handlePacket(&receivedPacket{buffer: getPacketBuffer()})
+					}
+					Expect(sess.Stats().PacketsDropped).To(Equal(uint64(10)))
+				})
+
+				It("counts sent and retransmitted packets", func() {
+					f := frames.StreamFrame{
+						StreamID: 5,
+						Data:     []byte("foobar"),
+					}
+					str, err := sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+					putSentData(str.(*stream), &f)
+					sph := newMockSentPacketHandler()
+					sph.(*mockSentPacketHandler).retransmissionQueue = []*ackhandlerlegacy.Packet{{PacketNumber: 1, StreamRanges: []ackhandlerlegacy.StreamRange{ackhandlerlegacy.NewStreamRange(&f)}}}
+					sess.sentPacketHandler = sph
+					sess.packer.lastPacketNumber = 1
+					err = sess.sendPacket()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(conn.written).To(HaveLen(1))
+					sess.publishStats()
+					stats := sess.Stats()
+					Expect(stats.PacketsSent).To(Equal(uint64(1)))
+					Expect(stats.BytesSent).To(Equal(protocol.ByteCount(len(conn.written[0]))))
+					Expect(stats.PacketsRetransmitted).To(Equal(uint64(1)))
+				})
+
+				It("returns the stats of the sent packet handler", func() {
+					sph := newMockSentPacketHandler()
+					sph.(*mockSentPacketHandler).stats = ackhandlerlegacy.SentPacketStats{
+						SmoothedRTT:        10 * time.Millisecond,
+						MinRTT:             5 * time.Millisecond,
+						LatestRTT:          15 * time.Millisecond,
+						CongestionWindow:   1000,
+						SlowStartThreshold: 2000,
+						BytesInFlight:      500,
+						PacketsLost:        3,
+						BytesLost:          300,
+					}
+					sess.sentPacketHandler = sph
+					Expect(sess.Stats().SmoothedRTT).To(BeZero())
+					sess.publishStats()
+					stats := sess.Stats()
+					Expect(stats.SmoothedRTT).To(Equal(10 * time.Millisecond))
+					Expect(stats.MinRTT).To(Equal(5 * time.Millisecond))
+					Expect(stats.LatestRTT).To(Equal(15 * time.Millisecond))
+					Expect(stats.CongestionWindow).To(Equal(protocol.ByteCount(1000)))
+					Expect(stats.SlowStartThreshold).To(Equal(protocol.ByteCount(2000)))
+					Expect(stats.BytesInFlight).To(Equal(protocol.ByteCount(500)))
+					Expect(stats.PacketsLost).To(Equal(uint64(3)))
+					Expect(stats.BytesLost).To(Equal(protocol.ByteCount(300)))
+				})
+
+				It("returns the flow control stats of the connection and the streams", func() {
+					_, err := sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					err = sess.flowControlManager.AddBytesSent(5, 100)
+					Expect(err).ToNot(HaveOccurred())
+					stats := sess.Stats()
+					Expect(stats.Streams).To(HaveKey(protocol.StreamID(5)))
+					Expect(stats.Streams[5].BytesReceived).To(Equal(protocol.ByteCount(6)))
+					Expect(stats.Streams[5].BytesSent).To(Equal(protocol.ByteCount(100)))
+					Expect(stats.Streams[5].ReceiveWindow).To(Equal(protocol.ReceiveStreamFlowControlWindow - 6))
+					Expect(stats.ReceiveWindow).To(Equal(protocol.ReceiveConnectionFlowControlWindow - 6))
+					Expect(stats.SendWindow).To(Equal(sess.flowControlManager.RemainingConnectionWindowSize()))
+				})
+
+				It("doesn't return stats for closed streams", func() {
+					sess.streams[5] = nil
+					Expect(sess.Stats().Streams).ToNot(HaveKey(protocol.StreamID(5)))
+				})
+			})
+
+			Context("logging", func() {
+				var buf *bytes.Buffer
+
+				BeforeEach(func() {
+					buf = &bytes.Buffer{}
+					config := populateConfig(&Config{Logger: utils.NewLogger(buf, utils.LogLevelDebug)})
+					pSession, err := newSession(conn, version, 0x1337, nil, func(protocol.ConnectionID, []byte) {}, nil, config)
+					Expect(err).ToNot(HaveOccurred())
+					sess = pSession.(*session)
+				})
+
+				It("attaches the connection ID and the remote address to all messages", func() {
+					conn.remoteAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 443}
+					sess.sendPublicReset(1)
+					Expect(buf.String()).To(Equal("[connection=0000000000001337 remote=192.168.13.37:443] Sending public reset, packet number 1\n"))
+				})
+
+				It("logs the current remote address after a migration", func() {
+					conn.remoteAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 443}
+					sess.logger.Infof("foobar")
+					conn.remoteAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 13, 38), Port: 443}
+					sess.logger.Infof("foobar")
+					Expect(buf.String()).To(Equal("[connection=0000000000001337 remote=192.168.13.37:443] foobar\n[connection=0000000000001337 remote=192.168.13.38:443] foobar\n"))
+				})
+			})
+
+			Context("tracing", func() {
+				var tracer *mockTracer
+
+				BeforeEach(func() {
+					tracer = &mockTracer{tracer: &mockConnectionTracer{}}
+					config := populateConfig(&Config{Tracer: tracer})
+					pSession, err := newSession(conn, version, 0x1337, nil, func(protocol.ConnectionID, []byte) {}, nil, config)
+					Expect(err).ToNot(HaveOccurred())
+					sess = pSession.(*session)
+				})
+
+				It("gets a tracer for the connection", func() {
+					Expect(tracer.perspective).To(Equal(protocol.PerspectiveServer))
+					Expect(tracer.connectionID).To(Equal(protocol.ConnectionID(0x1337)))
+					Expect(sess.tracer).To(Equal(tracer.tracer))
+				})
+
+				It("closes the tracer when the session is discarded", func() {
+					sess.discard()
+					Expect(tracer.tracer.closed).To(BeTrue())
+				})
+
+				It("doesn't trace if the tracer doesn't return a connection tracer", func() {
+					config := populateConfig(&Config{Tracer: &mockTracer{}})
+					pSession, err := newSession(conn, version, 0x1337, nil, func(protocol.ConnectionID, []byte) {}, nil, config)
+					Expect(err).ToNot(HaveOccurred())
+					sess = pSession.(*session)
+					Expect(sess.tracer).To(BeNil())
+					_, err = sess.GetOrOpenStream(5)
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("traces received packets", func() {
+					sess.unpacker = &mockUnpacker{frames: []frames.Frame{&frames.PingFrame{}}}
+					hdr := &publicHeader{PacketNumber: 5, PacketNumberLen: protocol.PacketNumberLen6, Raw: []byte("header")}
+					err := sess.handlePacketImpl(&receivedPacket{publicHeader: hdr, data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(tracer.tracer.getEvents()).To(ContainElement("received packet 5, 12 bytes, 1 frames"))
+				})
+
+				It("traces sent packets", func() {
+					sess.receivedPacketHandler.ReceivedPacket(1, true)
+					err := sess.sendPacket()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(conn.written).To(HaveLen(1))
+					Expect(tracer.tracer.getEvents()).To(ContainElement("sent packet 1"))
+				})
+
+				It("traces opened and closed streams", func() {
+					sess.handleStreamFrame(&frames.StreamFrame{StreamID: 5, FinBit: true})
+					_, err := sess.streams[5].Read([]byte{0})
+					Expect(err).To(MatchError(io.EOF))
+					sess.streams[5].Close()
+					sess.streams[5].sentFin()
+					sess.streams[5].dataAcked(0, 0, true)
+					sess.garbageCollectStreams()
+					Expect(sess.streams[5]).To(BeNil())
+					Expect(tracer.tracer.getEvents()).To(Equal([]string{"opened stream 1", "opened stream 3", "opened stream 5", "closed stream 5"}))
+				})
+
+				It("traces the close reason and closes the tracer", func() {
+					go sess.run()
+					sess.Close(qerr.Error(qerr.InternalError, "foobar"))
+					Eventually(func() bool {
+						tracer.tracer.mutex.Lock()
+						defer tracer.tracer.mutex.Unlock()
+						return tracer.tracer.closed
+					}).Should(BeTrue())
+					Expect(tracer.tracer.getEvents()).To(ContainElement("closed connection: InternalError: foobar, remote: false"))
+					Expect(tracer.tracer.getEvents()).To(ContainElement(HavePrefix("sent packet")))
+				})
 			})
 
 			Context("counting streams", func() {
 				It("errors when too many streams are opened", func(done Done) {
 					// 1.1 * 100
-					for i := 2; i <= 110; i++ {
-						_, err := session.OpenStream(protocol.StreamID(i))
+					for i := 0; i < 110; i++ {
+						_, err := sess.GetOrOpenStream(protocol.StreamID(2*i + 3))
 						Expect(err).NotTo(HaveOccurred())
 					}
-					_, err := session.OpenStream(protocol.StreamID(111))
+					_, err := sess.GetOrOpenStream(protocol.StreamID(223))
 					Expect(err).To(MatchError(qerr.TooManyOpenStreams))
-					Eventually(session.closeChan).Should(Receive())
+					Eventually(sess.closeChan).Should(Receive())
 					close(done)
 				})
 
+				It("doesn't count streams opened by us towards the limit for streams opened by the peer", func() {
+					for i := 0; i < 100; i++ {
+						_, err := sess.OpenStream()
+						Expect(err).NotTo(HaveOccurred())
+					}
+					for i := 0; i < 110; i++ {
+						_, err := sess.GetOrOpenStream(protocol.StreamID(2*i + 3))
+						Expect(err).NotTo(HaveOccurred())
+					}
+					Expect(sess.closeChan).ToNot(Receive())
+				})
+
 				It("does not error when many streams are opened and closed", func() {
-					for i := 2; i <= 1000; i++ {
-						s, err := session.OpenStream(protocol.StreamID(i))
+					for i := 3; i <= 2000; i += 2 {
+						s, err := sess.GetOrOpenStream(protocol.StreamID(i))
 						Expect(err).NotTo(HaveOccurred())
 						err = s.Close()
 						Expect(err).NotTo(HaveOccurred())
 						s.(*stream).sentFin()
+						s.(*stream).dataAcked(0, 0, true)
 						s.CloseRemote(0)
 						_, err = s.Read([]byte("a"))
 						Expect(err).To(MatchError(io.EOF))
-						session.garbageCollectStreams()
+						sess.garbageCollectStreams()
 					}
 				})
 			})
 
 			Context("ignoring errors", func() {
 				It("ignores duplicate acks", func() {
-					session.sentPacketHandler.SentPacket(&ackhandlerlegacy.Packet{
+					sess.sentPacketHandler.SentPacket(&ackhandlerlegacy.Packet{
 						PacketNumber: 1,
 						Length:       1,
 					})
-					err := session.handleFrames([]frames.Frame{&frames.AckFrame{
+					err := sess.handleFrames([]frames.Frame{&frames.AckFrame{
 						AckFrameLegacy: &frames.AckFrameLegacy{
 							LargestObserved: 1,
 						},
 					}})
 					Expect(err).NotTo(HaveOccurred())
-					err = session.handleFrames([]frames.Frame{&frames.AckFrame{
+					err = sess.handleFrames([]frames.Frame{&frames.AckFrame{
 						AckFrameLegacy: &frames.AckFrameLegacy{
 							LargestObserved: 1,
 						},
@@ -793,9 +1874,9 @@ var _ = Describe("Session", func() {
 
 			Context("window updates", func() {
 				It("gets stream level window updates", func() {
-					err := session.flowControlManager.AddBytesRead(1, protocol.ReceiveStreamFlowControlWindow)
+					err := sess.flowControlManager.AddBytesRead(1, protocol.ReceiveStreamFlowControlWindow)
 					Expect(err).NotTo(HaveOccurred())
-					frames, err := session.getWindowUpdateFrames()
+					frames, err := sess.getWindowUpdateFrames()
 					Expect(err).NotTo(HaveOccurred())
 					Expect(frames).To(HaveLen(1))
 					Expect(frames[0].StreamID).To(Equal(protocol.StreamID(1)))
@@ -803,11 +1884,11 @@ var _ = Describe("Session", func() {
 				})
 
 				It("gets connection level window updates", func() {
-					_, err := session.OpenStream(5)
+					_, err := sess.GetOrOpenStream(5)
 					Expect(err).NotTo(HaveOccurred())
-					err = session.flowControlManager.AddBytesRead(5, protocol.ReceiveConnectionFlowControlWindow)
+					err = sess.flowControlManager.AddBytesRead(5, protocol.ReceiveConnectionFlowControlWindow)
 					Expect(err).NotTo(HaveOccurred())
-					frames, err := session.getWindowUpdateFrames()
+					frames, err := sess.getWindowUpdateFrames()
 					Expect(err).NotTo(HaveOccurred())
 					Expect(frames).To(HaveLen(1))
 					Expect(frames[0].StreamID).To(Equal(protocol.StreamID(0)))
@@ -1,9 +1,12 @@
 package quic
 
 import (
+	"errors"
 	"io"
+	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/flowcontrol"
 	"github.com/lucas-clemente/quic-go/frames"
@@ -11,6 +14,7 @@ import (
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
 )
 
 // A Stream assembles the data from StreamFrames and provides a super-convenient Read-Interface
@@ -19,6 +23,8 @@ import (
 type stream struct {
 	streamID protocol.StreamID
 	onData   func()
+	// onPriority is called when the priority of the stream is changed
+	onPriority func(protocol.StreamID, StreamPriority)
 
 	readPosInFrame int
 	writeOffset    protocol.ByteCount
@@ -35,35 +41,68 @@ type stream struct {
 
 	frameQueue        *streamFrameSorter
 	newFrameOrErrCond sync.Cond
-
-	dataForWriting       []byte
-	finSent              bool
-	doneWritingOrErrCond sync.Cond
+	readDeadline      time.Time
+
+	// sendBuffer holds the data that was written, until it is acknowledged by the peer
+	// writeOffset is the offset up to which data was passed to the stream framer
+	sendBuffer          *sendBuffer
+	finSent             bool
+	finAcked            bool
+	sendBufferOrErrCond sync.Cond
+	writeDeadline       time.Time
+
+	// resetLocally is set if Reset was called, rstStreamFrame is the RST_STREAM frame that still needs to be sent
+	resetLocally   bool
+	rstStreamFrame *frames.RstStreamFrame
+	// finalOffset is the offset of the last byte sent by the peer, it is known once we received a FIN or a RST_STREAM
+	// After a Reset, the stream is kept until the final offset is known, since the data that won't be read is credited to the connection flow control window.
+	finalOffset      protocol.ByteCount
+	finalOffsetKnown bool
 
 	flowControlManager flowcontrol.FlowControlManager
 }
 
+type deadlineError struct{}
+
+func (deadlineError) Error() string   { return "deadline exceeded" }
+func (deadlineError) Temporary() bool { return true }
+func (deadlineError) Timeout() bool   { return true }
+
+var errDeadline net.Error = &deadlineError{}
+
+var errResetLocally = errors.New("stream was reset")
+
 // newStream creates a new Stream
-func newStream(onData func(), connectionParameterManager *handshake.ConnectionParametersManager, flowControlManager flowcontrol.FlowControlManager, StreamID protocol.StreamID) (*stream, error) {
+func newStream(onData func(), onPriority func(protocol.StreamID, StreamPriority), connectionParameterManager *handshake.ConnectionParametersManager, flowControlManager flowcontrol.FlowControlManager, sendBufferSize protocol.ByteCount, StreamID protocol.StreamID) (*stream, error) {
 	s := &stream{
 		onData:             onData,
+		onPriority:         onPriority,
 		streamID:           StreamID,
 		flowControlManager: flowControlManager,
 		frameQueue:         newStreamFrameSorter(),
+		sendBuffer:         newSendBuffer(sendBufferSize),
 	}
 
 	s.newFrameOrErrCond.L = &s.mutex
-	s.doneWritingOrErrCond.L = &s.mutex
+	s.sendBufferOrErrCond.L = &s.mutex
 
 	return s, nil
 }
 
 // Read implements io.Reader. It is not thread safe!
 func (s *stream) Read(p []byte) (int, error) {
+	return s.ReadContext(context.Background(), p)
+}
+
+// ReadContext is like Read, but returns ctx.Err() once the context is done
+func (s *stream) ReadContext(ctx context.Context, p []byte) (int, error) {
 	if atomic.LoadInt32(&s.eof) != 0 {
 		return 0, io.EOF
 	}
 
+	stopWaitingForContext := s.signalWhenDone(ctx, &s.newFrameOrErrCond)
+	defer stopWaitingForContext()
+
 	bytesRead := 0
 	for bytesRead < len(p) {
 		s.mutex.Lock()
@@ -83,7 +122,11 @@ func (s *stream) Read(p []byte) (int, error) {
 				s.readPosInFrame = int(s.readOffset - frame.Offset)
 				break
 			}
-			s.newFrameOrErrCond.Wait()
+			if err := s.checkDeadline(ctx, s.readDeadline); err != nil {
+				s.mutex.Unlock()
+				return bytesRead, err
+			}
+			s.waitWithDeadline(&s.newFrameOrErrCond, s.readDeadline)
 			frame = s.frameQueue.Head()
 		}
 		s.mutex.Unlock()
@@ -109,6 +152,9 @@ func (s *stream) Read(p []byte) (int, error) {
 			s.mutex.Lock()
 			s.frameQueue.Pop()
 			s.mutex.Unlock()
+			if frame.Buffer != nil {
+				frame.Buffer.Release()
+			}
 			if fin {
 				atomic.StoreInt32(&s.eof, 1)
 				return bytesRead, io.EOF
@@ -127,49 +173,181 @@ func (s *stream) ReadByte() (byte, error) {
 }
 
 func (s *stream) Write(p []byte) (int, error) {
+	return s.WriteContext(context.Background(), p)
+}
+
+// WriteContext is like Write, but returns ctx.Err() once the context is done
+// It returns as soon as all data was copied into the send buffer, and only blocks while the send buffer is full.
+func (s *stream) WriteContext(ctx context.Context, p []byte) (int, error) {
 	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	s.dataForWriting = make([]byte, len(p))
-	copy(s.dataForWriting, p)
+	if err := s.checkDeadline(ctx, s.writeDeadline); err != nil {
+		return 0, err
+	}
 
-	s.onData()
+	stopWaitingForContext := s.signalWhenDone(ctx, &s.sendBufferOrErrCond)
+	defer stopWaitingForContext()
 
-	for s.dataForWriting != nil && s.err == nil {
-		s.doneWritingOrErrCond.Wait()
+	var n int
+	for s.err == nil {
+		if written := s.sendBuffer.Write(p[n:]); written > 0 {
+			n += written
+			s.onData()
+		}
+		if n == len(p) {
+			return n, nil
+		}
+		// the send buffer is full, wait until the peer acknowledges some data
+		if err := s.checkDeadline(ctx, s.writeDeadline); err != nil {
+			return n, err
+		}
+		s.waitWithDeadline(&s.sendBufferOrErrCond, s.writeDeadline)
 	}
+	return n, s.err
+}
 
+// SetDeadline sets the read and write deadline
+func (s *stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	s.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read calls
+func (s *stream) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.readDeadline = t
+	// wake up a blocked Read, so that it uses the new deadline
+	s.newFrameOrErrCond.Broadcast()
 	s.mutex.Unlock()
-	if s.err != nil {
-		return 0, s.err
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write calls
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.writeDeadline = t
+	// wake up a blocked Write, so that it uses the new deadline
+	s.sendBufferOrErrCond.Broadcast()
+	s.mutex.Unlock()
+	return nil
+}
+
+// SetPriority sets the priority of the stream
+// It returns qerr.InvalidPriority if the priority level or the weight is out of range.
+func (s *stream) SetPriority(priority StreamPriority) error {
+	if priority.Level > protocol.LowestStreamPriorityLevel || priority.Weight == 0 || priority.Weight > protocol.MaxStreamWeight {
+		return qerr.InvalidPriority
+	}
+	s.onPriority(s.streamID, priority)
+	return nil
+}
+
+// checkDeadline returns an error if the context is done or the deadline has passed
+// the mutex has to be held when calling this function
+func (s *stream) checkDeadline(ctx context.Context, deadline time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return errDeadline
 	}
-	return len(p), nil
+	return nil
+}
+
+// waitWithDeadline waits on the cond, but not longer than until the deadline
+// the mutex has to be held when calling this function
+func (s *stream) waitWithDeadline(cond *sync.Cond, deadline time.Time) {
+	if deadline.IsZero() {
+		cond.Wait()
+		return
+	}
+	timer := time.AfterFunc(deadline.Sub(time.Now()), func() {
+		s.mutex.Lock()
+		cond.Broadcast()
+		s.mutex.Unlock()
+	})
+	cond.Wait()
+	timer.Stop()
 }
 
+// signalWhenDone wakes up the goroutine waiting on the cond once the context is done
+// the returned function must be called once the caller stops waiting
+func (s *stream) signalWhenDone(ctx context.Context, cond *sync.Cond) func() {
+	done := ctx.Done()
+	// if the context is already done, checkDeadline returns before waiting
+	if done == nil || ctx.Err() != nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			s.mutex.Lock()
+			cond.Broadcast()
+			s.mutex.Unlock()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// lenOfDataForWriting returns the number of bytes in the send buffer that weren't sent yet
 func (s *stream) lenOfDataForWriting() protocol.ByteCount {
 	s.mutex.Lock()
-	l := protocol.ByteCount(len(s.dataForWriting))
-	s.mutex.Unlock()
-	return l
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return 0
+	}
+	return s.sendBuffer.End() - s.writeOffset
 }
 
+// getDataForWriting returns at most maxBytes bytes of the data that wasn't sent yet
+// The data stays in the send buffer until it is acknowledged.
 func (s *stream) getDataForWriting(maxBytes protocol.ByteCount) []byte {
 	s.mutex.Lock()
-	if s.dataForWriting == nil {
-		s.mutex.Unlock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return nil
+	}
+	data := s.sendBuffer.Get(s.writeOffset, maxBytes)
+	s.writeOffset += protocol.ByteCount(len(data))
+	return data
+}
+
+// unackedRange returns the first range in [start, end) that was sent, but not yet acknowledged
+// If there is no such range, the returned range is empty.
+func (s *stream) unackedRange(start, end protocol.ByteCount) (protocol.ByteCount, protocol.ByteCount) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return end, end
+	}
+	return s.sendBuffer.UnackedRange(start, utils.MinByteCount(end, s.writeOffset))
+}
+
+// getDataForRetransmission returns at most maxBytes bytes of the data starting at offset, that was already sent before
+func (s *stream) getDataForRetransmission(offset, maxBytes protocol.ByteCount) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
 		return nil
 	}
-	var ret []byte
-	if protocol.ByteCount(len(s.dataForWriting)) > maxBytes {
-		ret = s.dataForWriting[:maxBytes]
-		s.dataForWriting = s.dataForWriting[maxBytes:]
-	} else {
-		ret = s.dataForWriting
-		s.dataForWriting = nil
-		s.doneWritingOrErrCond.Signal()
+	return s.sendBuffer.Get(offset, utils.MinByteCount(maxBytes, s.writeOffset-offset))
+}
+
+// dataAcked is called when data of this stream was acknowledged by the peer
+// The acknowledged data is removed from the send buffer, which unblocks a pending Write.
+func (s *stream) dataAcked(offset, length protocol.ByteCount, fin bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if fin {
+		s.finAcked = true
+	}
+	if s.sendBuffer.Ack(offset, offset+length) {
+		s.sendBufferOrErrCond.Signal()
 	}
-	s.writeOffset += protocol.ByteCount(len(ret))
-	s.mutex.Unlock()
-	return ret
 }
 
 // Close implements io.Closer
@@ -181,17 +359,67 @@ func (s *stream) Close() error {
 
 func (s *stream) shouldSendFin() bool {
 	s.mutex.Lock()
-	res := atomic.LoadInt32(&s.closed) != 0 && !s.finSent && s.err == nil && s.dataForWriting == nil
+	res := atomic.LoadInt32(&s.closed) != 0 && !s.finSent && s.err == nil && s.writeOffset == s.sendBuffer.End()
 	s.mutex.Unlock()
 	return res
 }
 
+// shouldRetransmitFin returns if a lost FIN has to be retransmitted
+func (s *stream) shouldRetransmitFin() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err == nil && !s.finAcked
+}
+
 func (s *stream) sentFin() {
 	s.mutex.Lock()
 	s.finSent = true
 	s.mutex.Unlock()
 }
 
+// Reset aborts sending and receiving on the stream, and sends a RST_STREAM frame with the given error code to the peer
+// Data that wasn't sent yet is discarded, and pending and future calls to Read and Write return an error
+func (s *stream) Reset(errorCode uint32) {
+	atomic.StoreInt32(&s.closed, 1)
+	s.mutex.Lock()
+	if s.resetLocally || s.err != nil {
+		s.mutex.Unlock()
+		return
+	}
+	s.resetLocally = true
+	// the writeOffset can't change anymore, since registerError discards all data that wasn't sent yet
+	s.rstStreamFrame = &frames.RstStreamFrame{
+		StreamID:   s.streamID,
+		ByteOffset: s.writeOffset,
+		ErrorCode:  errorCode,
+	}
+	s.registerError(errResetLocally)
+	finalOffsetKnown := s.finalOffsetKnown
+	s.mutex.Unlock()
+	if finalOffsetKnown {
+		s.creditUnreadData()
+	}
+	s.onData()
+}
+
+// creditUnreadData treats all data up to the final offset as read, since it won't be read by the application after a Reset
+func (s *stream) creditUnreadData() {
+	s.mutex.Lock()
+	finalOffset := s.finalOffset
+	s.mutex.Unlock()
+	// the final offset was already passed to the flow controller when it was received, so this can't be a flow control violation
+	s.flowControlManager.ResetStream(s.streamID, finalOffset)
+}
+
+// getRstStreamFrame returns the RST_STREAM frame, if Reset was called and the frame wasn't sent yet
+func (s *stream) getRstStreamFrame() *frames.RstStreamFrame {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f := s.rstStreamFrame
+	s.rstStreamFrame = nil
+	return f
+}
+
 // AddStreamFrame adds a new stream frame
 func (s *stream) AddStreamFrame(frame *frames.StreamFrame) error {
 	maxOffset := frame.Offset + frame.DataLen()
@@ -208,11 +436,29 @@ func (s *stream) AddStreamFrame(frame *frames.StreamFrame) error {
 	}
 
 	s.mutex.Lock()
+	if frame.FinBit && !s.finalOffsetKnown {
+		s.finalOffset = maxOffset
+		s.finalOffsetKnown = true
+	}
+	// after a Reset, the data won't be read anymore
+	if s.resetLocally {
+		s.mutex.Unlock()
+		if frame.FinBit {
+			s.creditUnreadData()
+		}
+		return nil
+	}
 	defer s.mutex.Unlock()
 	err = s.frameQueue.Push(frame)
 	if err != nil && err != errDuplicateStreamData {
 		return err
 	}
+	// the frame references the packet data until it was read
+	// Frames that are still queued when the stream is closed are left to the garbage collector,
+	// since a concurrent Read might still be accessing them.
+	if err == nil && frame.Buffer != nil {
+		frame.Buffer.Retain()
+	}
 	s.newFrameOrErrCond.Signal()
 	return nil
 }
@@ -222,6 +468,16 @@ func (s *stream) CloseRemote(offset protocol.ByteCount) {
 	s.AddStreamFrame(&frames.StreamFrame{FinBit: true, Offset: offset})
 }
 
+// receivedRstStream is called when a RST_STREAM frame was received, after its final offset was passed to the flow controller
+func (s *stream) receivedRstStream(finalOffset protocol.ByteCount) {
+	s.mutex.Lock()
+	if !s.finalOffsetKnown {
+		s.finalOffset = finalOffset
+		s.finalOffsetKnown = true
+	}
+	s.mutex.Unlock()
+}
+
 // RegisterError is called by session to indicate that an error occurred and the
 // stream should be closed.
 func (s *stream) RegisterError(err error) {
@@ -231,19 +487,37 @@ func (s *stream) RegisterError(err error) {
 	if s.err != nil { // s.err must not be changed!
 		return
 	}
+	s.registerError(err)
+}
+
+// registerError sets the error, the mutex has to be held when calling this function
+func (s *stream) registerError(err error) {
 	s.err = err
-	s.doneWritingOrErrCond.Signal()
+	// data that wasn't sent or acknowledged yet won't be sent or retransmitted anymore
+	s.sendBuffer.Discard()
+	s.sendBufferOrErrCond.Signal()
 	s.newFrameOrErrCond.Signal()
 }
 
 func (s *stream) finishedReading() bool {
-	return atomic.LoadInt32(&s.eof) != 0
+	if atomic.LoadInt32(&s.eof) != 0 {
+		return true
+	}
+	// after a Reset, we don't expect the application to read the remaining data
+	// but we need to know the final offset, to credit the unread data to the connection flow control window
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.resetLocally && s.finalOffsetKnown
 }
 
 func (s *stream) finishedWriting() bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	return s.err != nil || (atomic.LoadInt32(&s.closed) != 0 && s.finSent)
+	if s.rstStreamFrame != nil {
+		return false
+	}
+	// data that was sent has to be kept until it is acknowledged, since it might have to be retransmitted
+	return s.err != nil || (atomic.LoadInt32(&s.closed) != 0 && s.finSent && s.finAcked && s.sendBuffer.Len() == 0)
 }
 
 func (s *stream) finished() bool {
@@ -3,6 +3,7 @@ package quic
 import (
 	"sync"
 
+	"github.com/lucas-clemente/quic-go/ackhandlerlegacy"
 	"github.com/lucas-clemente/quic-go/flowcontrol"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -15,26 +16,114 @@ type streamFramer struct {
 	streamsMutex *sync.RWMutex
 
 	flowControlManager flowcontrol.FlowControlManager
+	scheduler          streamScheduler
 
-	retransmissionQueue []*frames.StreamFrame
+	retransmissionQueue []*retransmissionRange
 	blockedFrameQueue   []*frames.BlockedFrame
+	// resetStreams contains the IDs of streams reset by us, their data is not retransmitted anymore
+	resetStreams map[protocol.StreamID]bool
+
+	// The frames returned by PopStreamFrames are reused, they are only valid until the next call.
+	// This is possible since the sent packet history only stores the ranges of the stream data.
+	streamFrames    []*frames.StreamFrame
+	numStreamFrames int
+	res             []*frames.StreamFrame
+
+	// popNormalFrame is passed to the scheduler's Iterate, it is created once so that no closure is allocated for every packet
+	popNormalFrame func(protocol.StreamID) bool
+	// currentFrame, currentLen and maxLen are the state of popNormalFrame
+	currentFrame *frames.StreamFrame
+	currentLen   protocol.ByteCount
+	maxLen       protocol.ByteCount
 }
 
-func newStreamFramer(streams *map[protocol.StreamID]*stream, streamsMutex *sync.RWMutex, flowControlManager flowcontrol.FlowControlManager) *streamFramer {
-	return &streamFramer{
+// A retransmissionRange is a range of stream data that was lost
+// The data is taken from the stream's send buffer when it is retransmitted, skipping data that was acknowledged in the meantime.
+type retransmissionRange struct {
+	streamID   protocol.StreamID
+	start, end protocol.ByteCount
+	fin        bool
+}
+
+func newStreamFramer(streams *map[protocol.StreamID]*stream, streamsMutex *sync.RWMutex, flowControlManager flowcontrol.FlowControlManager, scheduler streamScheduler) *streamFramer {
+	f := &streamFramer{
 		streams:            streams,
 		streamsMutex:       streamsMutex,
 		flowControlManager: flowControlManager,
+		scheduler:          scheduler,
+		resetStreams:       make(map[protocol.StreamID]bool),
 	}
+	f.popNormalFrame = f.maybePopNormalFrame
+	return f
 }
 
-func (f *streamFramer) AddFrameForRetransmission(frame *frames.StreamFrame) {
-	f.retransmissionQueue = append(f.retransmissionQueue, frame)
+// AddRangeForRetransmission queues a range of stream data that was lost
+func (f *streamFramer) AddRangeForRetransmission(r ackhandlerlegacy.StreamRange) {
+	if f.resetStreams[r.StreamID] {
+		return
+	}
+	f.retransmissionQueue = append(f.retransmissionQueue, &retransmissionRange{
+		streamID: r.StreamID,
+		start:    r.Offset,
+		end:      r.Offset + r.Length,
+		fin:      r.FinBit,
+	})
 }
 
+// PopRstStreamFrames returns the RST_STREAM frames for streams that were reset
+// it removes all data of these streams from the retransmission queue
+func (f *streamFramer) PopRstStreamFrames() []*frames.RstStreamFrame {
+	f.streamsMutex.RLock()
+	var res []*frames.RstStreamFrame
+	for _, s := range *f.streams {
+		if s == nil {
+			continue
+		}
+		if frame := s.getRstStreamFrame(); frame != nil {
+			f.resetStreams[frame.StreamID] = true
+			res = append(res, frame)
+		}
+	}
+	f.streamsMutex.RUnlock()
+
+	if len(res) == 0 {
+		return nil
+	}
+	retransmissionQueue := f.retransmissionQueue[:0]
+	for _, r := range f.retransmissionQueue {
+		if !f.resetStreams[r.streamID] {
+			retransmissionQueue = append(retransmissionQueue, r)
+		}
+	}
+	f.retransmissionQueue = retransmissionQueue
+	return res
+}
+
+// RemoveStream is called when a stream is garbage collected
+// Lost data of this stream isn't retransmitted anyway, since the stream doesn't exist anymore.
+func (f *streamFramer) RemoveStream(id protocol.StreamID) {
+	delete(f.resetStreams, id)
+}
+
+// PopStreamFrames returns the STREAM frames for the next packet
+// The frames are only valid until the next call.
 func (f *streamFramer) PopStreamFrames(maxLen protocol.ByteCount) []*frames.StreamFrame {
-	fs, currentLen := f.maybePopFramesForRetransmission(maxLen)
-	return append(fs, f.maybePopNormalFrames(maxLen-currentLen)...)
+	f.numStreamFrames = 0
+	f.res = f.res[:0]
+	currentLen := f.maybePopFramesForRetransmission(maxLen)
+	f.maybePopNormalFrames(maxLen - currentLen)
+	return f.res
+}
+
+// getStreamFrame returns a STREAM frame that is not used in the current packet yet
+func (f *streamFramer) getStreamFrame() *frames.StreamFrame {
+	if f.numStreamFrames == len(f.streamFrames) {
+		f.streamFrames = append(f.streamFrames, &frames.StreamFrame{})
+	}
+	frame := f.streamFrames[f.numStreamFrames]
+	f.numStreamFrames++
+	*frame = frames.StreamFrame{DataLenPresent: true}
+	return frame
 }
 
 func (f *streamFramer) PopBlockedFrame() *frames.BlockedFrame {
@@ -46,109 +135,127 @@ func (f *streamFramer) PopBlockedFrame() *frames.BlockedFrame {
 	return frame
 }
 
-func (f *streamFramer) maybePopFramesForRetransmission(maxLen protocol.ByteCount) (res []*frames.StreamFrame, currentLen protocol.ByteCount) {
+func (f *streamFramer) maybePopFramesForRetransmission(maxLen protocol.ByteCount) (currentLen protocol.ByteCount) {
+	f.streamsMutex.RLock()
+	defer f.streamsMutex.RUnlock()
+
 	for len(f.retransmissionQueue) > 0 {
-		frame := f.retransmissionQueue[0]
-		frame.DataLenPresent = true
+		r := f.retransmissionQueue[0]
+		s := (*f.streams)[r.streamID]
+		// the stream was already garbage collected, so all its data was acknowledged
+		if s == nil {
+			f.retransmissionQueue = f.retransmissionQueue[1:]
+			continue
+		}
 
-		frameHeaderLen, _ := frame.MinLength(protocol.VersionWhatever) // can never error
-		if currentLen+frameHeaderLen > maxLen {
-			break
+		start, end := s.unackedRange(r.start, r.end)
+		// all data was acknowledged, and the FIN doesn't need to be retransmitted
+		if start == end && (!r.fin || !s.shouldRetransmitFin()) {
+			f.retransmissionQueue = f.retransmissionQueue[1:]
+			continue
 		}
+		frame := f.getStreamFrame()
+		frame.StreamID = r.streamID
+		frame.Offset = start
 
-		currentLen += frameHeaderLen
+		if start == end {
+			// all data was acknowledged, but the FIN needs to be retransmitted
+			frame.Offset = r.end
+			frame.FinBit = true
+			frameHeaderLen, _ := frame.MinLength(protocol.VersionWhatever) // can never error
+			if currentLen+frameHeaderLen > maxLen {
+				break
+			}
+			f.retransmissionQueue = f.retransmissionQueue[1:]
+			f.res = append(f.res, frame)
+			currentLen += frameHeaderLen
+			continue
+		}
 
-		splitFrame := maybeSplitOffFrame(frame, maxLen-currentLen)
-		if splitFrame != nil { // StreamFrame was split
-			res = append(res, splitFrame)
-			currentLen += splitFrame.DataLen()
+		frameHeaderLen, _ := frame.MinLength(protocol.VersionWhatever) // can never error
+		if currentLen+frameHeaderLen >= maxLen {
 			break
 		}
+		currentLen += frameHeaderLen
 
-		f.retransmissionQueue = f.retransmissionQueue[1:]
-		res = append(res, frame)
+		frame.Data = s.getDataForRetransmission(start, utils.MinByteCount(end-start, maxLen-currentLen))
 		currentLen += frame.DataLen()
+		f.res = append(f.res, frame)
+
+		// if the range was not retransmitted completely, the next call continues where this one stopped
+		r.start = start + frame.DataLen()
+		if r.start == r.end {
+			frame.FinBit = r.fin
+			f.retransmissionQueue = f.retransmissionQueue[1:]
+		}
 	}
 	return
 }
 
-func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []*frames.StreamFrame) {
+func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) {
 	f.streamsMutex.RLock()
 	defer f.streamsMutex.RUnlock()
 
-	frame := &frames.StreamFrame{DataLenPresent: true}
-	var currentLen protocol.ByteCount
-
-	for _, s := range *f.streams {
-		if s == nil {
-			continue
-		}
-
-		frame.StreamID = s.streamID
-		// not perfect, but thread-safe since writeOffset is only written when getting data
-		frame.Offset = s.writeOffset
-		frameHeaderBytes, _ := frame.MinLength(protocol.VersionWhatever) // can never error
-		if currentLen+frameHeaderBytes > maxBytes {
-			return // theoretically, we could find another stream that fits, but this is quite unlikely, so we stop here
-		}
-		maxLen := maxBytes - currentLen - frameHeaderBytes
+	f.currentFrame = f.getStreamFrame()
+	f.currentLen = 0
+	f.maxLen = maxBytes
+	f.scheduler.Iterate(f.popNormalFrame)
+	f.currentFrame = nil
+}
 
-		if s.lenOfDataForWriting() != 0 {
-			sendWindowSize, _ := f.flowControlManager.SendWindowSize(s.streamID)
-			maxLen = utils.MinByteCount(maxLen, sendWindowSize)
-		}
+// maybePopNormalFrame pops new data of a stream, it is called by the scheduler's Iterate
+func (f *streamFramer) maybePopNormalFrame(id protocol.StreamID) bool {
+	s := (*f.streams)[id]
+	if s == nil {
+		return true
+	}
 
-		if maxLen == 0 {
-			continue
-		}
+	frame := f.currentFrame
+	frame.StreamID = s.streamID
+	// not perfect, but thread-safe since writeOffset is only written when getting data
+	frame.Offset = s.writeOffset
+	frameHeaderBytes, _ := frame.MinLength(protocol.VersionWhatever) // can never error
+	if f.currentLen+frameHeaderBytes > f.maxLen {
+		return false // theoretically, we could find another stream that fits, but this is quite unlikely, so we stop here
+	}
+	maxLen := f.maxLen - f.currentLen - frameHeaderBytes
 
-		data := s.getDataForWriting(maxLen)
-		if data == nil {
-			if s.shouldSendFin() {
-				frame.FinBit = true
-				s.sentFin()
-				res = append(res, frame)
-				currentLen += frameHeaderBytes + frame.DataLen()
-				frame = &frames.StreamFrame{DataLenPresent: true}
-			}
-			continue
-		}
+	if s.lenOfDataForWriting() != 0 {
+		sendWindowSize, _ := f.flowControlManager.SendWindowSize(s.streamID)
+		maxLen = utils.MinByteCount(maxLen, sendWindowSize)
+	}
 
-		frame.Data = data
-		f.flowControlManager.AddBytesSent(s.streamID, protocol.ByteCount(len(data)))
+	if maxLen == 0 {
+		return true
+	}
 
-		// Finally, check if we are now FC blocked and should queue a BLOCKED frame
-		if f.flowControlManager.RemainingConnectionWindowSize() == 0 {
-			// We are now connection-level FC blocked
-			f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: 0})
-		} else if individualWindowSize, _ := f.flowControlManager.SendWindowSize(s.streamID); individualWindowSize == 0 {
-			// We are now stream-level FC blocked
-			f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: s.StreamID()})
+	data := s.getDataForWriting(maxLen)
+	if data == nil {
+		if s.shouldSendFin() {
+			frame.FinBit = true
+			s.sentFin()
+			f.res = append(f.res, frame)
+			f.currentLen += frameHeaderBytes + frame.DataLen()
+			f.currentFrame = f.getStreamFrame()
 		}
-
-		res = append(res, frame)
-		currentLen += frameHeaderBytes + frame.DataLen()
-		frame = &frames.StreamFrame{DataLenPresent: true}
+		return true
 	}
-	return
-}
 
-// maybeSplitOffFrame removes the first n bytes and returns them as a separate frame. If n >= len(frame), nil is returned and nothing is modified.
-func maybeSplitOffFrame(frame *frames.StreamFrame, n protocol.ByteCount) *frames.StreamFrame {
-	if n >= frame.DataLen() {
-		return nil
-	}
+	frame.Data = data
+	f.flowControlManager.AddBytesSent(s.streamID, protocol.ByteCount(len(data)))
+	f.scheduler.BytesSent(s.streamID, protocol.ByteCount(len(data)))
 
-	defer func() {
-		frame.Data = frame.Data[n:]
-		frame.Offset += n
-	}()
-
-	return &frames.StreamFrame{
-		FinBit:         false,
-		StreamID:       frame.StreamID,
-		Offset:         frame.Offset,
-		Data:           frame.Data[:n],
-		DataLenPresent: frame.DataLenPresent,
+	// Finally, check if we are now FC blocked and should queue a BLOCKED frame
+	if f.flowControlManager.RemainingConnectionWindowSize() == 0 {
+		// We are now connection-level FC blocked
+		f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: 0})
+	} else if individualWindowSize, _ := f.flowControlManager.SendWindowSize(s.streamID); individualWindowSize == 0 {
+		// We are now stream-level FC blocked
+		f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: s.StreamID()})
 	}
+
+	f.res = append(f.res, frame)
+	f.currentLen += frameHeaderBytes + frame.DataLen()
+	f.currentFrame = f.getStreamFrame()
+	return true
 }
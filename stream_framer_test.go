@@ -4,16 +4,29 @@ import (
 	"bytes"
 	"sync"
 
+	"github.com/lucas-clemente/quic-go/ackhandlerlegacy"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+// putSentData puts the data of the frame into the send buffer of the stream, as if it had been sent before
+func putSentData(str *stream, f *frames.StreamFrame) {
+	if end := str.sendBuffer.End(); end < f.Offset {
+		str.sendBuffer.Write(make([]byte, f.Offset-end))
+	}
+	if end := str.sendBuffer.End(); end < f.Offset+f.DataLen() {
+		str.sendBuffer.Write(f.Data[end-f.Offset:])
+	}
+	str.writeOffset = str.sendBuffer.End()
+}
+
 var _ = Describe("Stream Framer", func() {
 	var (
 		retransmittedFrame1, retransmittedFrame2 *frames.StreamFrame
 		framer                                   *streamFramer
+		scheduler                                *priorityScheduler
 		streamsMap                               map[protocol.StreamID]*stream
 		stream1, stream2                         *stream
 		fcm                                      *mockFlowControlHandler
@@ -29,31 +42,40 @@ var _ = Describe("Stream Framer", func() {
 			Data:     []byte{0xDE, 0xCA, 0xFB, 0xAD},
 		}
 
-		stream1 = &stream{streamID: 10}
-		stream2 = &stream{streamID: 11}
+		stream1 = &stream{streamID: 10, sendBuffer: newSendBuffer(protocol.MaxByteCount)}
+		stream2 = &stream{streamID: 11, sendBuffer: newSendBuffer(protocol.MaxByteCount)}
 		streamsMap = map[protocol.StreamID]*stream{
 			1: nil, 2: nil, 3: nil, 4: nil, // we have to be able to deal with nil frames
 			10: stream1,
 			11: stream2,
 		}
+		// the streams of the retransmitted frames have already sent all their data
+		for _, f := range []*frames.StreamFrame{retransmittedFrame1, retransmittedFrame2} {
+			str := &stream{streamID: f.StreamID, sendBuffer: newSendBuffer(protocol.MaxByteCount)}
+			putSentData(str, f)
+			streamsMap[f.StreamID] = str
+		}
 
 		fcm = newMockFlowControlHandler()
 		fcm.sendWindowSizes[stream1.streamID] = protocol.MaxByteCount
 		fcm.sendWindowSizes[stream2.streamID] = protocol.MaxByteCount
 		fcm.sendWindowSizes[retransmittedFrame1.StreamID] = protocol.MaxByteCount
 		fcm.sendWindowSizes[retransmittedFrame2.StreamID] = protocol.MaxByteCount
-		framer = newStreamFramer(&streamsMap, &sync.RWMutex{}, fcm)
+		scheduler = newPriorityScheduler()
+		scheduler.AddStream(stream1.streamID)
+		scheduler.AddStream(stream2.streamID)
+		framer = newStreamFramer(&streamsMap, &sync.RWMutex{}, fcm, scheduler)
 	})
 
 	It("sets the DataLenPresent for dequeued retransmitted frames", func() {
-		framer.AddFrameForRetransmission(retransmittedFrame1)
+		framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame1))
 		fs := framer.PopStreamFrames(protocol.MaxByteCount)
 		Expect(fs).To(HaveLen(1))
 		Expect(fs[0].DataLenPresent).To(BeTrue())
 	})
 
 	It("sets the DataLenPresent for dequeued normal frames", func() {
-		stream1.dataForWriting = []byte("foobar")
+		stream1.sendBuffer.Write([]byte("foobar"))
 		fs := framer.PopStreamFrames(protocol.MaxByteCount)
 		Expect(fs).To(HaveLen(1))
 		Expect(fs[0].DataLenPresent).To(BeTrue())
@@ -65,17 +87,19 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("pops frames for retransmission", func() {
-			framer.AddFrameForRetransmission(retransmittedFrame1)
-			framer.AddFrameForRetransmission(retransmittedFrame2)
+			framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame1))
+			framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame2))
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(2))
-			Expect(fs[0]).To(Equal(retransmittedFrame1))
-			Expect(fs[1]).To(Equal(retransmittedFrame2))
+			Expect(fs[0].StreamID).To(Equal(retransmittedFrame1.StreamID))
+			Expect(fs[0].Data).To(Equal(retransmittedFrame1.Data))
+			Expect(fs[1].StreamID).To(Equal(retransmittedFrame2.StreamID))
+			Expect(fs[1].Data).To(Equal(retransmittedFrame2.Data))
 			Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
 		})
 
 		It("returns normal frames", func() {
-			stream1.dataForWriting = []byte("foobar")
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(1))
 			Expect(fs[0].StreamID).To(Equal(stream1.streamID))
@@ -84,14 +108,10 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("returns multiple normal frames", func() {
-			stream1.dataForWriting = []byte("foobar")
-			stream2.dataForWriting = []byte("foobaz")
+			stream1.sendBuffer.Write([]byte("foobar"))
+			stream2.sendBuffer.Write([]byte("foobaz"))
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(2))
-			// Swap if we dequeued in other order
-			if fs[0].StreamID != stream1.streamID {
-				fs[0], fs[1] = fs[1], fs[0]
-			}
 			Expect(fs[0].StreamID).To(Equal(stream1.streamID))
 			Expect(fs[0].Data).To(Equal([]byte("foobar")))
 			Expect(fs[1].StreamID).To(Equal(stream2.streamID))
@@ -100,17 +120,21 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("returns retransmission frames before normal frames", func() {
-			framer.AddFrameForRetransmission(retransmittedFrame1)
-			stream1.dataForWriting = []byte("foobar")
+			framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame1))
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(2))
-			Expect(fs[0]).To(Equal(retransmittedFrame1))
+			Expect(fs[0].StreamID).To(Equal(retransmittedFrame1.StreamID))
+			Expect(fs[0].Data).To(Equal(retransmittedFrame1.Data))
 			Expect(fs[1].StreamID).To(Equal(stream1.streamID))
 			Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
 		})
 
 		It("does not pop empty frames", func() {
-			stream1.dataForWriting = []byte("foobar")
+			// the frame header for the streams of the retransmitted frames is longer, since they have a non-zero write offset
+			delete(streamsMap, retransmittedFrame1.StreamID)
+			delete(streamsMap, retransmittedFrame2.StreamID)
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fs := framer.PopStreamFrames(4)
 			Expect(fs).To(HaveLen(0))
 			fs = framer.PopStreamFrames(5)
@@ -120,52 +144,19 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		Context("splitting of frames", func() {
-			It("splits off nothing", func() {
-				f := &frames.StreamFrame{
-					StreamID: 1,
-					Data:     []byte("bar"),
-					Offset:   3,
-				}
-				Expect(maybeSplitOffFrame(f, 1000)).To(BeNil())
-				Expect(f.Offset).To(Equal(protocol.ByteCount(3)))
-				Expect(f.Data).To(Equal([]byte("bar")))
-			})
-
-			It("splits off initial frame", func() {
-				f := &frames.StreamFrame{
-					StreamID:       1,
-					Data:           []byte("foobar"),
-					DataLenPresent: true,
-					Offset:         3,
-					FinBit:         true,
-				}
-				previous := maybeSplitOffFrame(f, 3)
-				Expect(previous).ToNot(BeNil())
-				Expect(previous.StreamID).To(Equal(protocol.StreamID(1)))
-				Expect(previous.Data).To(Equal([]byte("foo")))
-				Expect(previous.DataLenPresent).To(BeTrue())
-				Expect(previous.Offset).To(Equal(protocol.ByteCount(3)))
-				Expect(previous.FinBit).To(BeFalse())
-				Expect(f.StreamID).To(Equal(protocol.StreamID(1)))
-				Expect(f.Data).To(Equal([]byte("bar")))
-				Expect(f.DataLenPresent).To(BeTrue())
-				Expect(f.Offset).To(Equal(protocol.ByteCount(6)))
-				Expect(f.FinBit).To(BeTrue())
-			})
-
 			It("splits a frame", func() {
-				framer.AddFrameForRetransmission(retransmittedFrame2)
+				framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame2))
 				origlen := retransmittedFrame2.DataLen()
 				fs := framer.PopStreamFrames(6)
 				Expect(fs).To(HaveLen(1))
 				minLength, _ := fs[0].MinLength(0)
 				Expect(minLength + fs[0].DataLen()).To(Equal(protocol.ByteCount(6)))
-				Expect(framer.retransmissionQueue[0].Data).To(HaveLen(int(origlen - fs[0].DataLen())))
-				Expect(framer.retransmissionQueue[0].Offset).To(Equal(fs[0].DataLen()))
+				Expect(framer.retransmissionQueue[0].end - framer.retransmissionQueue[0].start).To(Equal(origlen - fs[0].DataLen()))
+				Expect(framer.retransmissionQueue[0].start).To(Equal(fs[0].DataLen()))
 			})
 
 			It("only removes a frame from the framer after returning all split parts", func() {
-				framer.AddFrameForRetransmission(retransmittedFrame2)
+				framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame2))
 				fs := framer.PopStreamFrames(6)
 				Expect(fs).To(HaveLen(1))
 				Expect(framer.retransmissionQueue).ToNot(BeEmpty())
@@ -176,7 +167,7 @@ var _ = Describe("Stream Framer", func() {
 
 			It("gets the whole data of a frame if it was split", func() {
 				origdata := []byte("foobar")
-				stream1.dataForWriting = origdata
+				stream1.sendBuffer.Write(origdata)
 				fs := framer.PopStreamFrames(7)
 				Expect(fs).To(HaveLen(1))
 				Expect(fs[0].Data).To(Equal([]byte("foo")))
@@ -189,9 +180,76 @@ var _ = Describe("Stream Framer", func() {
 			})
 		})
 
+		Context("retransmitting from the send buffer", func() {
+			var f *frames.StreamFrame
+
+			BeforeEach(func() {
+				f = &frames.StreamFrame{
+					StreamID: stream1.streamID,
+					Data:     []byte("foobar"),
+				}
+				putSentData(stream1, f)
+			})
+
+			It("doesn't retransmit data that was acknowledged in the meantime", func() {
+				framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(f))
+				stream1.dataAcked(2, 2, false)
+				fs := framer.PopStreamFrames(1000)
+				Expect(fs).To(HaveLen(2))
+				Expect(fs[0].Offset).To(BeZero())
+				Expect(fs[0].Data).To(Equal([]byte("fo")))
+				Expect(fs[1].Offset).To(Equal(protocol.ByteCount(4)))
+				Expect(fs[1].Data).To(Equal([]byte("ar")))
+				Expect(framer.retransmissionQueue).To(BeEmpty())
+			})
+
+			It("drops the retransmission if all data was acknowledged", func() {
+				framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(f))
+				stream1.dataAcked(f.Offset, f.DataLen(), f.FinBit)
+				Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
+				Expect(framer.retransmissionQueue).To(BeEmpty())
+			})
+
+			It("drops the retransmission if the stream was garbage collected", func() {
+				framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(f))
+				streamsMap[stream1.streamID] = nil
+				Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
+				Expect(framer.retransmissionQueue).To(BeEmpty())
+			})
+
+			It("sets the FIN bit on the last part of a split frame", func() {
+				f.FinBit = true
+				framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(f))
+				fs := framer.PopStreamFrames(6)
+				Expect(fs).To(HaveLen(1))
+				Expect(fs[0].FinBit).To(BeFalse())
+				fs = framer.PopStreamFrames(1000)
+				Expect(fs).To(HaveLen(1))
+				Expect(fs[0].FinBit).To(BeTrue())
+			})
+
+			It("retransmits the FIN if only the data was acknowledged", func() {
+				f.FinBit = true
+				framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(f))
+				stream1.dataAcked(0, 6, false)
+				fs := framer.PopStreamFrames(1000)
+				Expect(fs).To(HaveLen(1))
+				Expect(fs[0].Offset).To(Equal(protocol.ByteCount(6)))
+				Expect(fs[0].Data).To(BeEmpty())
+				Expect(fs[0].FinBit).To(BeTrue())
+			})
+
+			It("doesn't retransmit a FIN that was acknowledged", func() {
+				f.FinBit = true
+				framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(f))
+				stream1.dataAcked(f.Offset, f.DataLen(), f.FinBit)
+				Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
+			})
+		})
+
 		Context("sending FINs", func() {
 			It("sends FINs when streams are closed", func() {
-				stream1.writeOffset = 42
+				putSentData(stream1, &frames.StreamFrame{Offset: 42})
 				stream1.closed = 1
 				fs := framer.PopStreamFrames(1000)
 				Expect(fs).To(HaveLen(1))
@@ -201,24 +259,88 @@ var _ = Describe("Stream Framer", func() {
 				Expect(fs[0].Data).To(BeEmpty())
 			})
 		})
+
+		Context("scheduling", func() {
+			// popStreamIDs pops n packets worth of stream frames, and returns the stream ID of every frame
+			popStreamIDs := func(n int) []protocol.StreamID {
+				var ids []protocol.StreamID
+				for i := 0; i < n; i++ {
+					for _, f := range framer.PopStreamFrames(protocol.MaxPacketSize) {
+						ids = append(ids, f.StreamID)
+					}
+				}
+				return ids
+			}
+
+			It("always sends the crypto and the headers stream first", func() {
+				for _, id := range []protocol.StreamID{1, 3} {
+					str := &stream{streamID: id, sendBuffer: newSendBuffer(protocol.MaxByteCount)}
+					str.sendBuffer.Write([]byte("foobar"))
+					streamsMap[id] = str
+					scheduler.AddStream(id)
+					fcm.sendWindowSizes[id] = protocol.MaxByteCount
+				}
+				stream1.sendBuffer.Write([]byte("foobar"))
+				scheduler.SetPriority(3, StreamPriority{Level: protocol.LowestStreamPriorityLevel, Weight: 1})
+				fs := framer.PopStreamFrames(1000)
+				Expect(fs).To(HaveLen(3))
+				Expect(fs[0].StreamID).To(Equal(protocol.StreamID(1)))
+				Expect(fs[1].StreamID).To(Equal(protocol.StreamID(3)))
+				Expect(fs[2].StreamID).To(Equal(stream1.streamID))
+			})
+
+			It("sends streams with a higher priority first", func() {
+				scheduler.SetPriority(stream2.streamID, StreamPriority{Level: 0, Weight: protocol.DefaultStreamWeight})
+				stream1.sendBuffer.Write(bytes.Repeat([]byte{'f'}, 5000))
+				stream2.sendBuffer.Write(bytes.Repeat([]byte{'b'}, 2000))
+				Expect(popStreamIDs(3)).To(Equal([]protocol.StreamID{stream2.streamID, stream2.streamID, stream1.streamID, stream1.streamID}))
+			})
+
+			It("takes turns between streams of the same priority", func() {
+				stream1.sendBuffer.Write(bytes.Repeat([]byte{'f'}, 5000))
+				stream2.sendBuffer.Write(bytes.Repeat([]byte{'b'}, 5000))
+				Expect(popStreamIDs(4)).To(Equal([]protocol.StreamID{stream1.streamID, stream2.streamID, stream1.streamID, stream2.streamID}))
+			})
+
+			It("shares the bandwidth according to the weights", func() {
+				scheduler.SetPriority(stream2.streamID, StreamPriority{Level: protocol.DefaultStreamPriorityLevel, Weight: 3 * protocol.DefaultStreamWeight})
+				stream1.sendBuffer.Write(bytes.Repeat([]byte{'f'}, 10000))
+				stream2.sendBuffer.Write(bytes.Repeat([]byte{'b'}, 10000))
+				Expect(popStreamIDs(8)).To(Equal([]protocol.StreamID{
+					stream1.streamID, stream2.streamID, stream2.streamID, stream2.streamID,
+					stream1.streamID, stream2.streamID, stream2.streamID, stream2.streamID,
+				}))
+			})
+
+			It("doesn't let an idle stream block the round robin", func() {
+				stream3 := &stream{streamID: 12, sendBuffer: newSendBuffer(protocol.MaxByteCount)}
+				streamsMap[stream3.streamID] = stream3
+				scheduler.AddStream(stream3.streamID)
+				fcm.sendWindowSizes[stream3.streamID] = protocol.MaxByteCount
+				// stream1 doesn't have any data
+				stream2.sendBuffer.Write(bytes.Repeat([]byte{'b'}, 5000))
+				stream3.sendBuffer.Write(bytes.Repeat([]byte{'c'}, 5000))
+				Expect(popStreamIDs(4)).To(Equal([]protocol.StreamID{stream2.streamID, stream3.streamID, stream2.streamID, stream3.streamID}))
+			})
+		})
 	})
 
 	Context("flow control", func() {
 		It("tells the FlowControlManager how many bytes it sent", func() {
-			stream1.dataForWriting = []byte("foobar")
+			stream1.sendBuffer.Write([]byte("foobar"))
 			framer.PopStreamFrames(1000)
 			Expect(fcm.bytesSent).To(Equal(protocol.ByteCount(6)))
 		})
 
 		It("does not count retransmitted frames as sent bytes", func() {
-			framer.AddFrameForRetransmission(retransmittedFrame1)
+			framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame1))
 			framer.PopStreamFrames(1000)
 			Expect(fcm.bytesSent).To(BeZero())
 		})
 
 		It("returns the whole frame if it fits", func() {
-			stream1.writeOffset = 10
-			stream1.dataForWriting = []byte("foobar")
+			putSentData(stream1, &frames.StreamFrame{Offset: 10})
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fcm.sendWindowSizes[stream1.streamID] = 10 + 6
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(1))
@@ -226,7 +348,7 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("returns a smaller frame if the whole frame doesn't fit", func() {
-			stream1.dataForWriting = []byte("foobar")
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fcm.sendWindowSizes[stream1.streamID] = 3
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(1))
@@ -234,8 +356,8 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("returns a smaller frame if the whole frame doesn't fit in the stream flow control window, for non-zero StreamFrame offset", func() {
-			stream1.writeOffset = 1
-			stream1.dataForWriting = []byte("foobar")
+			putSentData(stream1, &frames.StreamFrame{Offset: 1})
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fcm.sendWindowSizes[stream1.StreamID()] = 3
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(1))
@@ -243,7 +365,7 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("returns a smaller frame if the whole frame doesn't fit in the connection flow control window", func() {
-			stream1.dataForWriting = []byte("foobar")
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fcm.streamsContributing = []protocol.StreamID{stream1.StreamID()}
 			fcm.remainingConnectionWindowSize = 3
 			fs := framer.PopStreamFrames(1000)
@@ -252,7 +374,7 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("ignores the connection flow control window for non-contributing streams", func() {
-			stream1.dataForWriting = []byte("foobar")
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fcm.remainingConnectionWindowSize = 0
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(1))
@@ -260,7 +382,7 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("respects the connection flow control window for contributing streams", func() {
-			stream1.dataForWriting = []byte("foobar")
+			stream1.sendBuffer.Write([]byte("foobar"))
 			fcm.remainingConnectionWindowSize = 0
 			fcm.streamsContributing = []protocol.StreamID{stream1.StreamID()}
 			fs := framer.PopStreamFrames(1000)
@@ -269,8 +391,8 @@ var _ = Describe("Stream Framer", func() {
 
 		It("selects a stream that is not flow control blocked", func() {
 			fcm.sendWindowSizes[stream1.StreamID()] = 0
-			stream1.dataForWriting = []byte("foobar")
-			stream2.dataForWriting = []byte("foobaz")
+			stream1.sendBuffer.Write([]byte("foobar"))
+			stream2.sendBuffer.Write([]byte("foobaz"))
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(HaveLen(1))
 			Expect(fs[0].StreamID).To(Equal(stream2.StreamID()))
@@ -278,8 +400,8 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("chooses a non-contributing stream if the connection is flow control blocked", func() {
-			stream1.dataForWriting = []byte("foobar")
-			stream2.dataForWriting = []byte("foobaz")
+			stream1.sendBuffer.Write([]byte("foobar"))
+			stream2.sendBuffer.Write([]byte("foobaz"))
 			fcm.streamsContributing = []protocol.StreamID{stream1.StreamID()}
 			fcm.remainingConnectionWindowSize = 0
 			fs := framer.PopStreamFrames(1000)
@@ -290,16 +412,16 @@ var _ = Describe("Stream Framer", func() {
 		It("returns nil if every stream is individually flow control blocked", func() {
 			fcm.sendWindowSizes[stream1.StreamID()] = 0
 			fcm.sendWindowSizes[stream2.StreamID()] = 0
-			stream1.dataForWriting = []byte("foobar")
-			stream2.dataForWriting = []byte("foobaz")
+			stream1.sendBuffer.Write([]byte("foobar"))
+			stream2.sendBuffer.Write([]byte("foobaz"))
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(BeEmpty())
 		})
 
 		It("returns nil if every stream is connection flow control blocked", func() {
 			fcm.remainingConnectionWindowSize = 0
-			stream1.dataForWriting = []byte("foobar")
-			stream2.dataForWriting = []byte("foobaz")
+			stream1.sendBuffer.Write([]byte("foobar"))
+			stream2.sendBuffer.Write([]byte("foobaz"))
 			fcm.streamsContributing = []protocol.StreamID{stream1.StreamID(), stream2.StreamID()}
 			fs := framer.PopStreamFrames(1000)
 			Expect(fs).To(BeEmpty())
@@ -317,7 +439,7 @@ var _ = Describe("Stream Framer", func() {
 
 		It("queues and pops BLOCKED frames for individually blocked streams", func() {
 			fcm.sendWindowSizes[stream1.StreamID()] = 3
-			stream1.dataForWriting = []byte("foo")
+			stream1.sendBuffer.Write([]byte("foo"))
 			framer.PopStreamFrames(1000)
 			blockedFrame := framer.PopBlockedFrame()
 			Expect(blockedFrame).ToNot(BeNil())
@@ -328,7 +450,7 @@ var _ = Describe("Stream Framer", func() {
 		It("queues and pops BLOCKED frames for connection blocked streams", func() {
 			fcm.remainingConnectionWindowSize = 3
 			fcm.streamsContributing = []protocol.StreamID{stream1.StreamID()}
-			stream1.dataForWriting = []byte("foo")
+			stream1.sendBuffer.Write([]byte("foo"))
 			framer.PopStreamFrames(1000)
 			blockedFrame := framer.PopBlockedFrame()
 			Expect(blockedFrame).ToNot(BeNil())
@@ -338,14 +460,14 @@ var _ = Describe("Stream Framer", func() {
 
 		It("does not queue BLOCKED frames for non-contributing streams", func() {
 			fcm.remainingConnectionWindowSize = 3
-			stream1.dataForWriting = []byte("foo")
+			stream1.sendBuffer.Write([]byte("foo"))
 			framer.PopStreamFrames(1000)
 			Expect(framer.PopBlockedFrame()).To(BeNil())
 		})
 
 		It("does not queue BLOCKED frames twice", func() {
 			fcm.sendWindowSizes[stream1.StreamID()] = 3
-			stream1.dataForWriting = []byte("foobar")
+			stream1.sendBuffer.Write([]byte("foobar"))
 			framer.PopStreamFrames(1000)
 			blockedFrame := framer.PopBlockedFrame()
 			Expect(blockedFrame).ToNot(BeNil())
@@ -353,4 +475,36 @@ var _ = Describe("Stream Framer", func() {
 			Expect(framer.PopBlockedFrame()).To(BeNil())
 		})
 	})
+
+	Context("RST_STREAM frames", func() {
+		It("returns nil if no stream was reset", func() {
+			Expect(framer.PopRstStreamFrames()).To(BeNil())
+		})
+
+		It("pops RST_STREAM frames only once", func() {
+			rst := &frames.RstStreamFrame{StreamID: stream1.StreamID(), ByteOffset: 0x42, ErrorCode: 0x1337}
+			stream1.rstStreamFrame = rst
+			Expect(framer.PopRstStreamFrames()).To(Equal([]*frames.RstStreamFrame{rst}))
+			Expect(framer.PopRstStreamFrames()).To(BeNil())
+		})
+
+		It("removes queued retransmissions for reset streams", func() {
+			retransmittedFrame1.StreamID = stream1.StreamID()
+			putSentData(stream1, retransmittedFrame1)
+			framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame1))
+			framer.AddRangeForRetransmission(ackhandlerlegacy.NewStreamRange(retransmittedFrame2))
+			stream1.rstStreamFrame = &frames.RstStreamFrame{StreamID: stream1.StreamID()}
+			framer.PopRstStreamFrames()
+			fs := framer.PopStreamFrames(1000)
+			Expect(fs).To(HaveLen(1))
+			Expect(fs[0].StreamID).To(Equal(retransmittedFrame2.StreamID))
+		})
+
+		It("doesn't queue retransmissions for reset streams", func() {
+			stream1.rstStreamFrame = &frames.RstStreamFrame{StreamID: stream1.StreamID()}
+			framer.PopRstStreamFrames()
+			framer.AddRangeForRetransmission(ackhandlerlegacy.StreamRange{StreamID: stream1.StreamID(), Length: 6})
+			Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
+		})
+	})
 })
@@ -0,0 +1,189 @@
+package quic
+
+import (
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// A streamScheduler decides in which order the streams get to send data
+type streamScheduler interface {
+	// AddStream adds a stream with the default priority
+	AddStream(protocol.StreamID)
+	// RemoveStream removes a stream, it is called when the stream is garbage collected
+	RemoveStream(protocol.StreamID)
+	// SetPriority changes the priority of a stream
+	SetPriority(protocol.StreamID, StreamPriority)
+	// Iterate calls f for every stream in the order in which they should send data, until f returns false
+	// It is only called from the session's run loop.
+	Iterate(f func(protocol.StreamID) bool)
+	// BytesSent is called when a stream sent new data
+	BytesSent(protocol.StreamID, protocol.ByteCount)
+}
+
+// quantumPerWeight is the number of bytes a stream may send per unit of weight, before the next stream of the same priority level gets its turn
+// A stream with the default weight may send one full packet.
+const quantumPerWeight = protocol.MaxPacketSize / protocol.DefaultStreamWeight
+
+type scheduledStream struct {
+	id       protocol.StreamID
+	priority StreamPriority
+	// index is the position in the priority level
+	index int
+	// deficit is the number of bytes the stream may still send in the current round
+	// It becomes negative if the stream sent more than that, since a stream can always fill the whole packet.
+	deficit int64
+}
+
+func (s *scheduledStream) quantum() int64 {
+	return int64(s.priority.Weight) * int64(quantumPerWeight)
+}
+
+type priorityLevel struct {
+	streams []*scheduledStream
+	// next is the index of the stream that gets the first chance to send data
+	next int
+}
+
+// The priorityScheduler always sends the crypto and the headers stream first.
+// Data streams are sent in the order of their priority level, and streams of the same level are scheduled using a deficit round robin according to their weights.
+type priorityScheduler struct {
+	mutex sync.Mutex
+
+	// alwaysFirst contains the crypto and the headers stream, if they are open
+	alwaysFirst []protocol.StreamID
+	streams     map[protocol.StreamID]*scheduledStream
+	levels      [protocol.LowestStreamPriorityLevel + 1]priorityLevel
+
+	// order is reused by Iterate, to avoid allocating a new slice for every packet
+	order []protocol.StreamID
+}
+
+var _ streamScheduler = &priorityScheduler{}
+
+func newPriorityScheduler() *priorityScheduler {
+	return &priorityScheduler{
+		streams: make(map[protocol.StreamID]*scheduledStream),
+	}
+}
+
+func (s *priorityScheduler) AddStream(id protocol.StreamID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// the crypto stream is always opened before the headers stream
+	if id == 1 || id == 3 {
+		s.alwaysFirst = append(s.alwaysFirst, id)
+		return
+	}
+	str := &scheduledStream{
+		id:       id,
+		priority: StreamPriority{Level: protocol.DefaultStreamPriorityLevel, Weight: protocol.DefaultStreamWeight},
+	}
+	s.streams[id] = str
+	s.addToLevel(str)
+}
+
+func (s *priorityScheduler) RemoveStream(id protocol.StreamID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, sid := range s.alwaysFirst {
+		if sid == id {
+			s.alwaysFirst = append(s.alwaysFirst[:i], s.alwaysFirst[i+1:]...)
+			return
+		}
+	}
+	str, ok := s.streams[id]
+	if !ok {
+		return
+	}
+	delete(s.streams, id)
+	s.removeFromLevel(str)
+}
+
+func (s *priorityScheduler) SetPriority(id protocol.StreamID, priority StreamPriority) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// unknown streams are either the crypto or the headers stream, or were already garbage collected
+	str, ok := s.streams[id]
+	if !ok {
+		return
+	}
+	if str.priority.Level == priority.Level {
+		// the stream keeps its position, but the new weight already applies to the current round
+		oldQuantum := str.quantum()
+		str.priority.Weight = priority.Weight
+		str.deficit += str.quantum() - oldQuantum
+		return
+	}
+	s.removeFromLevel(str)
+	str.priority = priority
+	s.addToLevel(str)
+}
+
+func (s *priorityScheduler) Iterate(f func(protocol.StreamID) bool) {
+	s.mutex.Lock()
+	order := s.order[:0]
+	order = append(order, s.alwaysFirst...)
+	for i := range s.levels {
+		level := &s.levels[i]
+		n := len(level.streams)
+		if n == 0 {
+			continue
+		}
+		// streams that used up their quantum pass the first chance to the next stream
+		for level.streams[level.next].deficit <= 0 {
+			level.streams[level.next].deficit += level.streams[level.next].quantum()
+			level.next = (level.next + 1) % n
+		}
+		for j := 0; j < n; j++ {
+			order = append(order, level.streams[(level.next+j)%n].id)
+		}
+	}
+	s.order = order
+	s.mutex.Unlock()
+
+	for _, id := range order {
+		if !f(id) {
+			return
+		}
+	}
+}
+
+func (s *priorityScheduler) BytesSent(id protocol.StreamID, n protocol.ByteCount) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	str, ok := s.streams[id]
+	if !ok {
+		return
+	}
+	str.deficit -= int64(n)
+	// all streams before this one in the same level didn't have any data to send, so this stream takes over the first chance
+	s.levels[str.priority.Level].next = str.index
+}
+
+// the mutex has to be held when calling this function
+func (s *priorityScheduler) addToLevel(str *scheduledStream) {
+	level := &s.levels[str.priority.Level]
+	str.index = len(level.streams)
+	str.deficit = str.quantum()
+	level.streams = append(level.streams, str)
+}
+
+// the mutex has to be held when calling this function
+func (s *priorityScheduler) removeFromLevel(str *scheduledStream) {
+	level := &s.levels[str.priority.Level]
+	level.streams = append(level.streams[:str.index], level.streams[str.index+1:]...)
+	for _, other := range level.streams[str.index:] {
+		other.index--
+	}
+	if level.next > str.index {
+		level.next--
+	}
+	if level.next >= len(level.streams) {
+		level.next = 0
+	}
+}
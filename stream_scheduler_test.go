@@ -0,0 +1,163 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Priority Scheduler", func() {
+	var scheduler *priorityScheduler
+
+	getOrder := func() []protocol.StreamID {
+		var order []protocol.StreamID
+		scheduler.Iterate(func(id protocol.StreamID) bool {
+			order = append(order, id)
+			return true
+		})
+		return order
+	}
+
+	BeforeEach(func() {
+		scheduler = newPriorityScheduler()
+	})
+
+	It("doesn't iterate if there are no streams", func() {
+		Expect(getOrder()).To(BeEmpty())
+	})
+
+	It("iterates over streams in the order they were added", func() {
+		scheduler.AddStream(5)
+		scheduler.AddStream(7)
+		scheduler.AddStream(9)
+		Expect(getOrder()).To(Equal([]protocol.StreamID{5, 7, 9}))
+	})
+
+	It("stops iterating when the callback returns false", func() {
+		scheduler.AddStream(5)
+		scheduler.AddStream(7)
+		var order []protocol.StreamID
+		scheduler.Iterate(func(id protocol.StreamID) bool {
+			order = append(order, id)
+			return false
+		})
+		Expect(order).To(Equal([]protocol.StreamID{5}))
+	})
+
+	It("puts the crypto and the headers stream first", func() {
+		scheduler.AddStream(1)
+		scheduler.AddStream(5)
+		scheduler.AddStream(3)
+		Expect(getOrder()).To(Equal([]protocol.StreamID{1, 3, 5}))
+	})
+
+	It("ignores priorities for the crypto and the headers stream", func() {
+		scheduler.AddStream(1)
+		scheduler.AddStream(3)
+		scheduler.AddStream(5)
+		scheduler.SetPriority(3, StreamPriority{Level: protocol.LowestStreamPriorityLevel, Weight: 1})
+		scheduler.SetPriority(5, StreamPriority{Level: 0, Weight: protocol.MaxStreamWeight})
+		Expect(getOrder()).To(Equal([]protocol.StreamID{1, 3, 5}))
+	})
+
+	It("removes streams", func() {
+		scheduler.AddStream(1)
+		scheduler.AddStream(3)
+		scheduler.AddStream(5)
+		scheduler.AddStream(7)
+		scheduler.RemoveStream(3)
+		scheduler.RemoveStream(5)
+		Expect(getOrder()).To(Equal([]protocol.StreamID{1, 7}))
+		Expect(scheduler.streams).ToNot(HaveKey(protocol.StreamID(5)))
+	})
+
+	It("ignores unknown streams", func() {
+		scheduler.AddStream(5)
+		scheduler.RemoveStream(7)
+		scheduler.SetPriority(7, StreamPriority{Level: 0, Weight: 1})
+		scheduler.BytesSent(7, 1000)
+		Expect(getOrder()).To(Equal([]protocol.StreamID{5}))
+	})
+
+	Context("priorities", func() {
+		It("orders streams by their priority level", func() {
+			scheduler.AddStream(5)
+			scheduler.AddStream(7)
+			scheduler.AddStream(9)
+			scheduler.SetPriority(5, StreamPriority{Level: protocol.LowestStreamPriorityLevel, Weight: protocol.DefaultStreamWeight})
+			scheduler.SetPriority(9, StreamPriority{Level: 0, Weight: protocol.DefaultStreamWeight})
+			Expect(getOrder()).To(Equal([]protocol.StreamID{9, 7, 5}))
+		})
+
+		It("keeps the position when only the weight changes", func() {
+			scheduler.AddStream(5)
+			scheduler.AddStream(7)
+			scheduler.SetPriority(5, StreamPriority{Level: protocol.DefaultStreamPriorityLevel, Weight: 100})
+			Expect(getOrder()).To(Equal([]protocol.StreamID{5, 7}))
+			Expect(scheduler.streams[5].priority.Weight).To(Equal(uint16(100)))
+		})
+	})
+
+	Context("round robin", func() {
+		BeforeEach(func() {
+			scheduler.AddStream(5)
+			scheduler.AddStream(7)
+			scheduler.AddStream(9)
+		})
+
+		It("keeps the first position while the stream didn't use up its quantum", func() {
+			scheduler.BytesSent(5, protocol.ByteCount(scheduler.streams[5].quantum()-1))
+			Expect(getOrder()).To(Equal([]protocol.StreamID{5, 7, 9}))
+		})
+
+		It("moves on to the next stream once a stream used up its quantum", func() {
+			scheduler.BytesSent(5, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{7, 9, 5}))
+			scheduler.BytesSent(7, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{9, 5, 7}))
+		})
+
+		It("gives the first position to the stream that sent data", func() {
+			scheduler.BytesSent(7, 100)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{7, 9, 5}))
+		})
+
+		It("skips a stream until it made up for sending more than its quantum", func() {
+			scheduler.SetPriority(5, StreamPriority{Level: protocol.DefaultStreamPriorityLevel, Weight: 1})
+			str := scheduler.streams[5]
+			scheduler.BytesSent(5, protocol.ByteCount(str.deficit+3*str.quantum()))
+			Expect(getOrder()).To(Equal([]protocol.StreamID{7, 9, 5}))
+			scheduler.BytesSent(7, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{9, 5, 7}))
+			scheduler.BytesSent(9, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{7, 9, 5}))
+		})
+
+		It("uses the weight as the quantum", func() {
+			scheduler.SetPriority(5, StreamPriority{Level: protocol.DefaultStreamPriorityLevel, Weight: 2 * protocol.DefaultStreamWeight})
+			scheduler.BytesSent(5, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{5, 7, 9}))
+			scheduler.BytesSent(5, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{7, 9, 5}))
+			scheduler.BytesSent(7, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{9, 5, 7}))
+			scheduler.BytesSent(9, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{5, 7, 9}))
+			scheduler.BytesSent(5, protocol.MaxPacketSize)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{5, 7, 9}))
+		})
+
+		It("moves the first position when the first stream is removed", func() {
+			scheduler.BytesSent(9, 100)
+			scheduler.RemoveStream(9)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{5, 7}))
+		})
+
+		It("keeps the first position when a stream before it is removed", func() {
+			scheduler.BytesSent(7, 100)
+			scheduler.RemoveStream(5)
+			Expect(getOrder()).To(Equal([]protocol.StreamID{7, 9}))
+		})
+	})
+})
@@ -3,13 +3,18 @@ package quic
 import (
 	"errors"
 	"io"
+	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/flowcontrol"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -52,6 +57,16 @@ func (m *mockFlowControlHandler) MaybeTriggerConnectionWindowUpdate() (bool, pro
 	return m.triggerConnectionWindowUpdate, 0x1337
 }
 
+func (m *mockFlowControlHandler) ResetStream(streamID protocol.StreamID, byteOffset protocol.ByteCount) error {
+	m.highestReceivedForStream = streamID
+	m.highestReceived = byteOffset
+	return nil
+}
+
+func (m *mockFlowControlHandler) DiscardConnectionData(n protocol.ByteCount) error {
+	panic("not implemented")
+}
+
 func (m *mockFlowControlHandler) AddBytesRead(streamID protocol.StreamID, n protocol.ByteCount) error {
 	m.bytesReadForStream = streamID
 	m.bytesRead = n
@@ -94,23 +109,34 @@ func (m *mockFlowControlHandler) UpdateWindow(streamID protocol.StreamID, offset
 	panic("not implemented")
 }
 
+func (m *mockFlowControlHandler) GetStats(streamID protocol.StreamID) (flowcontrol.Stats, error) {
+	panic("not implemented")
+}
+
 var _ = Describe("Stream", func() {
 	var (
 		str          *stream
 		onDataCalled bool
+		priority     *StreamPriority
 	)
 
 	onData := func() {
 		onDataCalled = true
 	}
 
+	onPriority := func(id protocol.StreamID, p StreamPriority) {
+		Expect(id).To(Equal(protocol.StreamID(1337)))
+		priority = &p
+	}
+
 	BeforeEach(func() {
 		onDataCalled = false
+		priority = nil
 		var streamID protocol.StreamID = 1337
-		cpm := handshake.NewConnectionParamatersManager()
+		cpm := handshake.NewConnectionParamatersManager(protocol.ReceiveStreamFlowControlWindow, protocol.ReceiveConnectionFlowControlWindow, protocol.MaxStreamsPerConnection, protocol.MaxIdleConnectionStateLifetime)
 		flowControlManager := flowcontrol.NewFlowControlManager(cpm)
 		flowControlManager.NewStream(streamID, true)
-		str, _ = newStream(onData, cpm, flowControlManager, streamID)
+		str, _ = newStream(onData, onPriority, cpm, flowControlManager, protocol.DefaultStreamSendBufferSize, streamID)
 	})
 
 	It("gets stream id", func() {
@@ -299,52 +325,115 @@ var _ = Describe("Stream", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(onDataCalled).To(BeTrue())
 		})
+
+		Context("packet buffers", func() {
+			It("retains the buffer of a queued frame until the frame was read", func() {
+				buffer := getPacketBufferWithData([]byte{0xDE, 0xAD, 0xBE, 0xEF})
+				frame := frames.StreamFrame{
+					Offset: 0,
+					Data:   buffer.Slice,
+					Buffer: buffer,
+				}
+				err := str.AddStreamFrame(&frame)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(buffer.refCount).To(BeEquivalentTo(2))
+				b := make([]byte, 2)
+				_, err = str.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(buffer.refCount).To(BeEquivalentTo(2))
+				_, err = str.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b).To(Equal([]byte{0xBE, 0xEF}))
+				Expect(buffer.refCount).To(BeEquivalentTo(1))
+			})
+
+			It("doesn't retain the buffer of duplicate frames", func() {
+				frame1 := frames.StreamFrame{
+					Offset: 0,
+					Data:   []byte{0xDE, 0xAD},
+				}
+				err := str.AddStreamFrame(&frame1)
+				Expect(err).ToNot(HaveOccurred())
+				buffer := getPacketBufferWithData([]byte{0xDE, 0xAD})
+				frame2 := frames.StreamFrame{
+					Offset: 0,
+					Data:   buffer.Slice,
+					Buffer: buffer,
+				}
+				err = str.AddStreamFrame(&frame2)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(buffer.refCount).To(BeEquivalentTo(1))
+			})
+		})
 	})
 
 	Context("writing", func() {
-		It("writes and gets all data at once", func(done Done) {
-			go func() {
-				n, err := str.Write([]byte("foobar"))
-				Expect(err).ToNot(HaveOccurred())
-				Expect(n).To(Equal(6))
-				close(done)
-			}()
-			Eventually(func() []byte {
-				str.mutex.Lock()
-				defer str.mutex.Unlock()
-				return str.dataForWriting
-			}).Should(Equal([]byte("foobar")))
+		It("returns as soon as the data was buffered", func() {
+			n, err := str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
 			Expect(onDataCalled).To(BeTrue())
 			Expect(str.lenOfDataForWriting()).To(Equal(protocol.ByteCount(6)))
 			data := str.getDataForWriting(1000)
 			Expect(data).To(Equal([]byte("foobar")))
 			Expect(str.writeOffset).To(Equal(protocol.ByteCount(6)))
-			Expect(str.dataForWriting).To(BeNil())
+			Expect(str.lenOfDataForWriting()).To(BeZero())
 		})
 
-		It("writes and gets data in two turns", func(done Done) {
-			go func() {
-				n, err := str.Write([]byte("foobar"))
-				Expect(err).ToNot(HaveOccurred())
-				Expect(n).To(Equal(6))
-				close(done)
-			}()
-			Eventually(func() []byte {
-				str.mutex.Lock()
-				defer str.mutex.Unlock()
-				return str.dataForWriting
-			}).Should(Equal([]byte("foobar")))
-			Expect(str.lenOfDataForWriting()).To(Equal(protocol.ByteCount(6)))
+		It("gets data in two turns", func() {
+			n, err := str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
 			data := str.getDataForWriting(3)
 			Expect(data).To(Equal([]byte("foo")))
 			Expect(str.writeOffset).To(Equal(protocol.ByteCount(3)))
-			Expect(str.dataForWriting).ToNot(BeNil())
 			Expect(str.lenOfDataForWriting()).To(Equal(protocol.ByteCount(3)))
 			data = str.getDataForWriting(3)
 			Expect(data).To(Equal([]byte("bar")))
 			Expect(str.writeOffset).To(Equal(protocol.ByteCount(6)))
-			Expect(str.dataForWriting).To(BeNil())
-			Expect(str.lenOfDataForWriting()).To(Equal(protocol.ByteCount(0)))
+			Expect(str.lenOfDataForWriting()).To(BeZero())
+		})
+
+		It("keeps the data in the send buffer until it is acknowledged", func() {
+			str.Write([]byte("foobar"))
+			str.getDataForWriting(1000)
+			Expect(str.sendBuffer.Len()).To(Equal(protocol.ByteCount(6)))
+			str.dataAcked(3, 3, false)
+			Expect(str.sendBuffer.Len()).To(Equal(protocol.ByteCount(6)))
+			str.dataAcked(0, 3, false)
+			Expect(str.sendBuffer.Len()).To(BeZero())
+		})
+
+		It("blocks Write while the send buffer is full", func() {
+			str.sendBuffer = newSendBuffer(4)
+			var writeReturned int32
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(6))
+				atomic.StoreInt32(&writeReturned, 1)
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(4)))
+			Expect(str.getDataForWriting(1000)).To(Equal([]byte("foob")))
+			Consistently(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(BeZero())
+			str.dataAcked(0, 2, false)
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(2)))
+			Expect(str.getDataForWriting(1000)).To(Equal([]byte("ar")))
+			Eventually(func() int32 { return atomic.LoadInt32(&writeReturned) }).Should(BeEquivalentTo(1))
+		})
+
+		It("returns the unacknowledged ranges of the data that was sent", func() {
+			str.Write([]byte("foobar"))
+			str.getDataForWriting(4)
+			str.dataAcked(1, 1, false)
+			start, end := str.unackedRange(0, 6)
+			Expect(start).To(BeZero())
+			Expect(end).To(Equal(protocol.ByteCount(1)))
+			start, end = str.unackedRange(1, 6)
+			Expect(start).To(Equal(protocol.ByteCount(2)))
+			Expect(end).To(Equal(protocol.ByteCount(4)))
+			Expect(str.getDataForRetransmission(2, 1000)).To(Equal([]byte("ob")))
 		})
 
 		It("returns remote errors", func(done Done) {
@@ -362,14 +451,285 @@ var _ = Describe("Stream", func() {
 
 		It("copies the slice while writing", func() {
 			s := []byte("foo")
+			n, err := str.Write(s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			s[0] = 'v'
+			Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
+		})
+	})
+
+	Context("deadlines", func() {
+		It("returns a timeout error when the read deadline has passed", func() {
+			str.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			n, err := str.Read(make([]byte, 6))
+			Expect(n).To(BeZero())
+			Expect(err).To(MatchError(errDeadline))
+			Expect(err.(net.Error).Timeout()).To(BeTrue())
+		})
+
+		It("unblocks a Read when the read deadline is changed", func() {
+			errChan := make(chan error, 1)
 			go func() {
-				n, err := str.Write(s)
-				Expect(err).ToNot(HaveOccurred())
+				defer GinkgoRecover()
+				_, err := str.Read(make([]byte, 6))
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			str.SetReadDeadline(time.Now().Add(-time.Second))
+			Eventually(errChan).Should(Receive(MatchError(errDeadline)))
+		})
+
+		It("reads data that arrives before the read deadline", func() {
+			str.SetReadDeadline(time.Now().Add(time.Hour))
+			err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+			Expect(err).ToNot(HaveOccurred())
+			b := make([]byte, 6)
+			n, err := str.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			Expect(b).To(Equal([]byte("foobar")))
+		})
+
+		It("doesn't time out after the read deadline was reset", func() {
+			str.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			str.SetReadDeadline(time.Time{})
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+			}()
+			n, err := str.Read(make([]byte, 6))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+		})
+
+		It("returns a timeout error when the write deadline has passed while the send buffer is full", func() {
+			str.sendBuffer = newSendBuffer(3)
+			str.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+			n, err := str.Write([]byte("foobar"))
+			Expect(n).To(Equal(3))
+			Expect(err).To(MatchError(errDeadline))
+			Expect(err.(net.Error).Timeout()).To(BeTrue())
+			Expect(str.getDataForWriting(1000)).To(Equal([]byte("foo")))
+		})
+
+		It("returns the number of bytes buffered when a Write times out", func() {
+			str.sendBuffer = newSendBuffer(4)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.Write([]byte("foobar"))
+				Expect(err).To(MatchError(errDeadline))
+				Expect(n).To(Equal(4))
+				close(done)
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+			str.SetWriteDeadline(time.Now().Add(-time.Second))
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("doesn't accept data when the write deadline is in the past", func() {
+			str.SetWriteDeadline(time.Now().Add(-time.Second))
+			n, err := str.Write([]byte("foobar"))
+			Expect(n).To(BeZero())
+			Expect(err).To(MatchError(errDeadline))
+			Expect(onDataCalled).To(BeFalse())
+		})
+
+		It("sets the read and the write deadline", func() {
+			t := time.Now().Add(time.Hour)
+			str.SetDeadline(t)
+			Expect(str.readDeadline).To(Equal(t))
+			Expect(str.writeDeadline).To(Equal(t))
+		})
+	})
+
+	Context("contexts", func() {
+		It("unblocks ReadContext when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.ReadContext(ctx, make([]byte, 6))
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			cancel()
+			Eventually(errChan).Should(Receive(MatchError(context.Canceled)))
+		})
+
+		It("returns an error from ReadContext when the context's deadline has passed", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := str.ReadContext(ctx, make([]byte, 6))
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("unblocks WriteContext when the context is canceled", func() {
+			str.sendBuffer = newSendBuffer(3)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.WriteContext(ctx, []byte("foobar"))
+				Expect(err).To(MatchError(context.Canceled))
 				Expect(n).To(Equal(3))
+				close(done)
 			}()
 			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
-			s[0] = 'v'
-			Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("doesn't write when the context is already canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			n, err := str.WriteContext(ctx, []byte("foobar"))
+			Expect(n).To(BeZero())
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(str.lenOfDataForWriting()).To(BeZero())
+		})
+	})
+
+	Context("priorities", func() {
+		It("sets the priority", func() {
+			err := str.SetPriority(StreamPriority{Level: 1, Weight: 42})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(priority).To(Equal(&StreamPriority{Level: 1, Weight: 42}))
+		})
+
+		It("accepts the lowest level and the maximum weight", func() {
+			err := str.SetPriority(StreamPriority{Level: protocol.LowestStreamPriorityLevel, Weight: protocol.MaxStreamWeight})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(priority).ToNot(BeNil())
+		})
+
+		It("rejects invalid levels", func() {
+			err := str.SetPriority(StreamPriority{Level: protocol.LowestStreamPriorityLevel + 1, Weight: 16})
+			Expect(err).To(MatchError(qerr.InvalidPriority))
+			Expect(priority).To(BeNil())
+		})
+
+		It("rejects invalid weights", func() {
+			err := str.SetPriority(StreamPriority{Level: 3, Weight: 0})
+			Expect(err).To(MatchError(qerr.InvalidPriority))
+			err = str.SetPriority(StreamPriority{Level: 3, Weight: protocol.MaxStreamWeight + 1})
+			Expect(err).To(MatchError(qerr.InvalidPriority))
+			Expect(priority).To(BeNil())
+		})
+	})
+
+	Context("resetting", func() {
+		It("queues a RST_STREAM frame with the offset of the data sent", func() {
+			str.Write([]byte("foobar"))
+			str.getDataForWriting(4)
+			str.Reset(0x1337)
+			Expect(onDataCalled).To(BeTrue())
+			Expect(str.getRstStreamFrame()).To(Equal(&frames.RstStreamFrame{
+				StreamID:   1337,
+				ByteOffset: 4,
+				ErrorCode:  0x1337,
+			}))
+			Expect(str.getRstStreamFrame()).To(BeNil())
+		})
+
+		It("discards data that wasn't sent or acknowledged yet", func() {
+			str.Write([]byte("foobar"))
+			str.getDataForWriting(4)
+			str.Reset(0x1337)
+			Expect(str.getDataForWriting(1000)).To(BeNil())
+			Expect(str.sendBuffer.Len()).To(BeZero())
+			start, end := str.unackedRange(0, 4)
+			Expect(start).To(Equal(end))
+			Expect(str.shouldSendFin()).To(BeFalse())
+		})
+
+		It("unblocks Write", func() {
+			str.sendBuffer = newSendBuffer(3)
+			errChan := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Write([]byte("foobar"))
+				errChan <- err
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+			str.Reset(0x1337)
+			Eventually(errChan).Should(Receive(MatchError(errResetLocally)))
+		})
+
+		It("unblocks Read", func() {
+			errChan := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Read(make([]byte, 4))
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			str.Reset(0x1337)
+			Eventually(errChan).Should(Receive(MatchError(errResetLocally)))
+		})
+
+		It("doesn't queue a RST_STREAM frame after the stream was closed with an error", func() {
+			str.RegisterError(errors.New("test"))
+			str.Reset(0x1337)
+			Expect(str.getRstStreamFrame()).To(BeNil())
+		})
+
+		It("only queues a single RST_STREAM frame", func() {
+			str.Reset(0x1337)
+			str.Reset(0x42)
+			Expect(str.getRstStreamFrame().ErrorCode).To(Equal(uint32(0x1337)))
+			Expect(str.getRstStreamFrame()).To(BeNil())
+		})
+
+		It("is finished once the RST_STREAM frame was sent and the final offset is known", func() {
+			str.Reset(0x1337)
+			Expect(str.finished()).To(BeFalse())
+			str.getRstStreamFrame()
+			Expect(str.finished()).To(BeFalse())
+			err := str.AddStreamFrame(&frames.StreamFrame{Offset: 6, FinBit: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.finished()).To(BeTrue())
+		})
+
+		It("is finished once a RST_STREAM was received", func() {
+			str.Reset(0x1337)
+			str.getRstStreamFrame()
+			str.receivedRstStream(10)
+			Expect(str.finished()).To(BeTrue())
+		})
+
+		It("credits the unread data to the connection when the FIN is received after a Reset", func() {
+			err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+			Expect(err).ToNot(HaveOccurred())
+			n, err := str.Read(make([]byte, 2))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(2))
+			str.Reset(0x1337)
+			stats, err := str.flowControlManager.GetStats(0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.BytesRead).To(Equal(protocol.ByteCount(2)))
+			err = str.AddStreamFrame(&frames.StreamFrame{Offset: 6, Data: []byte("foo"), FinBit: true})
+			Expect(err).ToNot(HaveOccurred())
+			stats, err = str.flowControlManager.GetStats(0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.BytesRead).To(Equal(protocol.ByteCount(9)))
+		})
+
+		It("credits the unread data to the connection when the FIN was received before the Reset", func() {
+			err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar"), FinBit: true})
+			Expect(err).ToNot(HaveOccurred())
+			str.Reset(0x1337)
+			stats, err := str.flowControlManager.GetStats(0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.BytesRead).To(Equal(protocol.ByteCount(6)))
+		})
+
+		It("doesn't queue data received after a Reset", func() {
+			str.Reset(0x1337)
+			err := str.AddStreamFrame(&frames.StreamFrame{Data: []byte("foobar")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.frameQueue.Head()).To(BeNil())
 		})
 	})
 
@@ -385,7 +745,7 @@ var _ = Describe("Stream", func() {
 		})
 
 		It("does not allow FIN when there's still data", func() {
-			str.dataForWriting = []byte("foobar")
+			str.Write([]byte("foobar"))
 			str.Close()
 			Expect(str.shouldSendFin()).To(BeFalse())
 		})
@@ -405,6 +765,21 @@ var _ = Describe("Stream", func() {
 			str.sentFin()
 			Expect(str.shouldSendFin()).To(BeFalse())
 		})
+
+		It("is finished writing once all data and the FIN were acknowledged", func() {
+			str.Write([]byte("foobar"))
+			str.Close()
+			str.getDataForWriting(1000)
+			Expect(str.shouldSendFin()).To(BeTrue())
+			str.sentFin()
+			Expect(str.finishedWriting()).To(BeFalse())
+			str.dataAcked(0, 6, false)
+			Expect(str.finishedWriting()).To(BeFalse())
+			Expect(str.shouldRetransmitFin()).To(BeTrue())
+			str.dataAcked(6, 0, true)
+			Expect(str.shouldRetransmitFin()).To(BeFalse())
+			Expect(str.finishedWriting()).To(BeTrue())
+		})
 	})
 
 	Context("flow control, for receiving", func() {
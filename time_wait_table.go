@@ -0,0 +1,79 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+type timeWaitEntry struct {
+	closedAt time.Time
+	// connectionClosePacket is the CONNECTION_CLOSE packet sent when the session was closed, it may be nil
+	connectionClosePacket []byte
+	// receivedPackets counts the packets received after the session was closed
+	receivedPackets uint64
+}
+
+// The timeWaitTable stores connection IDs of recently closed sessions
+// Entries are evicted after protocol.TimeWaitDuration, or when the table holds more than protocol.MaxTimeWaitEntries entries.
+// It is not safe for concurrent use.
+type timeWaitTable struct {
+	entries map[protocol.ConnectionID]*timeWaitEntry
+	// queue contains the connection IDs in the order they were added, and thus in the order they expire
+	queue []protocol.ConnectionID
+}
+
+func newTimeWaitTable() *timeWaitTable {
+	return &timeWaitTable{
+		entries: make(map[protocol.ConnectionID]*timeWaitEntry),
+	}
+}
+
+// add adds a closed connection
+func (t *timeWaitTable) add(id protocol.ConnectionID, connectionClosePacket []byte, now time.Time) {
+	t.evict(now)
+	if _, ok := t.entries[id]; ok {
+		return
+	}
+	if len(t.queue) >= protocol.MaxTimeWaitEntries {
+		t.removeOldest()
+	}
+	t.entries[id] = &timeWaitEntry{
+		closedAt:              now,
+		connectionClosePacket: connectionClosePacket,
+	}
+	t.queue = append(t.queue, id)
+}
+
+// receivedPacket is called for packets with connection IDs that don't belong to an active session
+// It returns false if the connection ID is unknown. Otherwise, it returns the packet that should be sent in response, which may be nil.
+// To avoid amplification, the CONNECTION_CLOSE is only retransmitted with an exponential backoff.
+func (t *timeWaitTable) receivedPacket(id protocol.ConnectionID, now time.Time) ([]byte, bool) {
+	t.evict(now)
+	entry, ok := t.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry.receivedPackets++
+	// retransmit for the 1st, 2nd, 4th, 8th, ... packet
+	if entry.receivedPackets&(entry.receivedPackets-1) != 0 {
+		return nil, true
+	}
+	return entry.connectionClosePacket, true
+}
+
+func (t *timeWaitTable) len() int {
+	return len(t.entries)
+}
+
+// evict removes all entries that are older than protocol.TimeWaitDuration
+func (t *timeWaitTable) evict(now time.Time) {
+	for len(t.queue) > 0 && now.Sub(t.entries[t.queue[0]].closedAt) >= protocol.TimeWaitDuration {
+		t.removeOldest()
+	}
+}
+
+func (t *timeWaitTable) removeOldest() {
+	delete(t.entries, t.queue[0])
+	t.queue = t.queue[1:]
+}
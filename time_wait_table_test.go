@@ -0,0 +1,90 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Time-wait table", func() {
+	var (
+		table *timeWaitTable
+		now   time.Time
+	)
+
+	BeforeEach(func() {
+		table = newTimeWaitTable()
+		now = time.Now()
+	})
+
+	It("doesn't know connections that weren't added", func() {
+		_, ok := table.receivedPacket(1, now)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("returns the CONNECTION_CLOSE packet", func() {
+		table.add(1, []byte("foobar"), now)
+		p, ok := table.receivedPacket(1, now)
+		Expect(ok).To(BeTrue())
+		Expect(p).To(Equal([]byte("foobar")))
+	})
+
+	It("handles connections that didn't send a CONNECTION_CLOSE", func() {
+		table.add(1, nil, now)
+		p, ok := table.receivedPacket(1, now)
+		Expect(ok).To(BeTrue())
+		Expect(p).To(BeNil())
+	})
+
+	It("retransmits the CONNECTION_CLOSE with an exponential backoff", func() {
+		table.add(1, []byte("foobar"), now)
+		var retransmitted []int
+		for i := 1; i <= 20; i++ {
+			p, ok := table.receivedPacket(1, now)
+			Expect(ok).To(BeTrue())
+			if p != nil {
+				retransmitted = append(retransmitted, i)
+			}
+		}
+		Expect(retransmitted).To(Equal([]int{1, 2, 4, 8, 16}))
+	})
+
+	It("doesn't add a connection twice", func() {
+		table.add(1, []byte("foo"), now)
+		table.add(1, []byte("bar"), now)
+		Expect(table.len()).To(Equal(1))
+		Expect(table.queue).To(HaveLen(1))
+		p, _ := table.receivedPacket(1, now)
+		Expect(p).To(Equal([]byte("foo")))
+	})
+
+	It("evicts entries after the time-wait duration", func() {
+		table.add(1, []byte("foo"), now)
+		table.add(2, []byte("bar"), now.Add(protocol.TimeWaitDuration/2))
+		_, ok := table.receivedPacket(1, now.Add(protocol.TimeWaitDuration-time.Nanosecond))
+		Expect(ok).To(BeTrue())
+		_, ok = table.receivedPacket(1, now.Add(protocol.TimeWaitDuration))
+		Expect(ok).To(BeFalse())
+		Expect(table.len()).To(Equal(1))
+		_, ok = table.receivedPacket(2, now.Add(protocol.TimeWaitDuration))
+		Expect(ok).To(BeTrue())
+	})
+
+	It("evicts the oldest entry when the table is full", func() {
+		for i := 1; i <= protocol.MaxTimeWaitEntries; i++ {
+			table.add(protocol.ConnectionID(i), nil, now)
+		}
+		Expect(table.len()).To(Equal(protocol.MaxTimeWaitEntries))
+		table.add(protocol.MaxTimeWaitEntries+1, nil, now)
+		Expect(table.len()).To(Equal(protocol.MaxTimeWaitEntries))
+		_, ok := table.receivedPacket(1, now)
+		Expect(ok).To(BeFalse())
+		_, ok = table.receivedPacket(2, now)
+		Expect(ok).To(BeTrue())
+		_, ok = table.receivedPacket(protocol.MaxTimeWaitEntries+1, now)
+		Expect(ok).To(BeTrue())
+	})
+})
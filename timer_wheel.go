@@ -0,0 +1,115 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// The timerWheel keeps track of the deadlines of many sessions, and fires them with a granularity of protocol.EventLoopTimerGranularity
+// Every session has at most one deadline, setting a new deadline replaces the old one.
+// It is not safe for concurrent use.
+type timerWheel struct {
+	// slots contain the deadlines, indexed by the tick they expire in modulo the number of slots
+	slots []map[*session]time.Time
+	// slotOf is the slot of every session that has a deadline
+	slotOf map[*session]int
+
+	start    time.Time
+	lastTick int64
+	// nextTick is the earliest tick that might contain a deadline, it is 0 if it has to be looked up
+	nextTick int64
+}
+
+func newTimerWheel(now time.Time) *timerWheel {
+	slots := make([]map[*session]time.Time, protocol.EventLoopTimerWheelSlots)
+	for i := range slots {
+		slots[i] = make(map[*session]time.Time)
+	}
+	return &timerWheel{
+		slots:  slots,
+		slotOf: make(map[*session]int),
+		start:  now,
+	}
+}
+
+func (w *timerWheel) tick(t time.Time) int64 {
+	return int64(t.Sub(w.start) / protocol.EventLoopTimerGranularity)
+}
+
+// set sets the deadline of a session
+func (w *timerWheel) set(s *session, deadline time.Time) {
+	w.remove(s)
+	// the deadline has certainly passed once the tick after the deadline's tick is reached
+	tick := w.tick(deadline) + 1
+	// deadlines in the past fire with the next tick
+	if tick <= w.lastTick {
+		tick = w.lastTick + 1
+	}
+	slot := int(tick % int64(len(w.slots)))
+	w.slots[slot][s] = deadline
+	w.slotOf[s] = slot
+	if w.nextTick != 0 && tick < w.nextTick {
+		w.nextTick = tick
+	}
+}
+
+// remove removes the deadline of a session
+func (w *timerWheel) remove(s *session) {
+	if slot, ok := w.slotOf[s]; ok {
+		delete(w.slots[slot], s)
+		delete(w.slotOf, s)
+	}
+}
+
+// len returns the number of sessions that have a deadline
+func (w *timerWheel) len() int {
+	return len(w.slotOf)
+}
+
+// next returns the time when the wheel has to be advanced next
+// It returns false if no session has a deadline.
+// The wheel might not return any expired session at that time, e.g. if a deadline was removed.
+func (w *timerWheel) next() (time.Time, bool) {
+	if len(w.slotOf) == 0 {
+		return time.Time{}, false
+	}
+	if w.nextTick == 0 {
+		for tick := w.lastTick + 1; ; tick++ {
+			if len(w.slots[tick%int64(len(w.slots))]) > 0 {
+				w.nextTick = tick
+				break
+			}
+		}
+	}
+	return w.start.Add(time.Duration(w.nextTick) * protocol.EventLoopTimerGranularity), true
+}
+
+// advance moves the wheel forward, and returns all sessions whose deadline expired
+// The deadlines of these sessions are removed.
+func (w *timerWheel) advance(now time.Time) []*session {
+	nowTick := w.tick(now)
+	if nowTick <= w.lastTick {
+		return nil
+	}
+	var expired []*session
+	ticks := nowTick - w.lastTick
+	if ticks > int64(len(w.slots)) {
+		ticks = int64(len(w.slots))
+	}
+	for i := int64(1); i <= ticks; i++ {
+		slot := int((w.lastTick + i) % int64(len(w.slots)))
+		for s, deadline := range w.slots[slot] {
+			// deadlines that wrapped around the wheel only expire in a later round
+			if deadline.After(now) {
+				continue
+			}
+			expired = append(expired, s)
+			delete(w.slots[slot], s)
+			delete(w.slotOf, s)
+		}
+	}
+	w.lastTick = nowTick
+	w.nextTick = 0
+	return expired
+}
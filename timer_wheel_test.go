@@ -0,0 +1,98 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Timer wheel", func() {
+	var (
+		wheel  *timerWheel
+		start  time.Time
+		s1, s2 *session
+	)
+
+	BeforeEach(func() {
+		start = time.Now()
+		wheel = newTimerWheel(start)
+		s1 = &session{}
+		s2 = &session{}
+	})
+
+	It("fires deadlines", func() {
+		wheel.set(s1, start.Add(10*time.Millisecond))
+		wheel.set(s2, start.Add(20*time.Millisecond))
+		Expect(wheel.len()).To(Equal(2))
+		Expect(wheel.advance(start.Add(5 * time.Millisecond))).To(BeEmpty())
+		Expect(wheel.advance(start.Add(15 * time.Millisecond))).To(Equal([]*session{s1}))
+		Expect(wheel.advance(start.Add(25 * time.Millisecond))).To(Equal([]*session{s2}))
+		Expect(wheel.len()).To(BeZero())
+	})
+
+	It("returns when it has to be advanced next", func() {
+		_, ok := wheel.next()
+		Expect(ok).To(BeFalse())
+		wheel.set(s1, start.Add(22*time.Millisecond))
+		next, ok := wheel.next()
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(start.Add(25 * time.Millisecond)))
+		wheel.set(s2, start.Add(7*time.Millisecond))
+		next, _ = wheel.next()
+		Expect(next).To(Equal(start.Add(10 * time.Millisecond)))
+		Expect(wheel.advance(next)).To(Equal([]*session{s2}))
+		next, _ = wheel.next()
+		Expect(next).To(Equal(start.Add(25 * time.Millisecond)))
+		Expect(wheel.advance(next)).To(Equal([]*session{s1}))
+		_, ok = wheel.next()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("doesn't fire deadlines before they expire", func() {
+		deadline := start.Add(12 * time.Millisecond)
+		wheel.set(s1, deadline)
+		Expect(wheel.advance(deadline.Add(-time.Millisecond))).To(BeEmpty())
+		Expect(wheel.advance(deadline.Add(protocol.EventLoopTimerGranularity))).To(Equal([]*session{s1}))
+	})
+
+	It("fires deadlines in the past with the next tick", func() {
+		wheel.advance(start.Add(time.Second))
+		wheel.set(s1, start)
+		Expect(wheel.advance(start.Add(time.Second + protocol.EventLoopTimerGranularity))).To(Equal([]*session{s1}))
+	})
+
+	It("replaces deadlines", func() {
+		wheel.set(s1, start.Add(10*time.Millisecond))
+		wheel.set(s1, start.Add(time.Second))
+		Expect(wheel.len()).To(Equal(1))
+		Expect(wheel.advance(start.Add(100 * time.Millisecond))).To(BeEmpty())
+		Expect(wheel.advance(start.Add(2 * time.Second))).To(Equal([]*session{s1}))
+	})
+
+	It("removes deadlines", func() {
+		wheel.set(s1, start.Add(10*time.Millisecond))
+		wheel.remove(s1)
+		Expect(wheel.len()).To(BeZero())
+		Expect(wheel.advance(start.Add(time.Second))).To(BeEmpty())
+	})
+
+	It("fires deadlines that wrap around the wheel in a later round", func() {
+		wheelSpan := protocol.EventLoopTimerWheelSlots * protocol.EventLoopTimerGranularity
+		deadline := start.Add(wheelSpan + 10*time.Millisecond)
+		wheel.set(s1, deadline)
+		for t := start; t.Before(deadline); t = t.Add(protocol.EventLoopTimerGranularity) {
+			Expect(wheel.advance(t)).To(BeEmpty())
+		}
+		Expect(wheel.advance(deadline.Add(protocol.EventLoopTimerGranularity))).To(Equal([]*session{s1}))
+	})
+
+	It("fires all expired deadlines when advancing more than one round", func() {
+		wheel.set(s1, start.Add(10*time.Millisecond))
+		wheel.set(s2, start.Add(3*time.Second))
+		wheelSpan := protocol.EventLoopTimerWheelSlots * protocol.EventLoopTimerGranularity
+		Expect(wheel.advance(start.Add(2 * wheelSpan))).To(ConsistOf(s1, s2))
+	})
+})
@@ -52,6 +52,15 @@ func ReadUfloat16(b io.ByteReader) (uint64, error) {
 
 // WriteUfloat16 writes a float in the QUIC-float16 format from its uint64 representation
 func WriteUfloat16(b *bytes.Buffer, value uint64) {
+	WriteUint16(b, encodeUfloat16(value))
+}
+
+// AppendUfloat16 appends a float in the QUIC-float16 format from its uint64 representation
+func AppendUfloat16(b []byte, value uint64) []byte {
+	return AppendUint16(b, encodeUfloat16(value))
+}
+
+func encodeUfloat16(value uint64) uint16 {
 	var result uint16
 	if value < (uint64(1) << uFloat16MantissaEffectiveBits) {
 		// Fast path: either the value is denormalized, or has exponent zero.
@@ -81,6 +90,5 @@ func WriteUfloat16(b *bytes.Buffer, value uint64) {
 		// This hides the bit.
 		result = (uint16(value) + (exponent << uFloat16MantissaBits))
 	}
-
-	WriteUint16(b, result)
+	return result
 }
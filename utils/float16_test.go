@@ -142,6 +142,7 @@ var _ = Describe("float16", func() {
 		for _, testcase := range testcases {
 			b := &bytes.Buffer{}
 			WriteUfloat16(b, testcase.decoded)
+			Expect(AppendUfloat16(nil, testcase.decoded)).To(Equal(b.Bytes()))
 			val, err := ReadUint16(b)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(val).To(Equal(testcase.encoded))
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 var out io.Writer = os.Stdout
@@ -23,48 +24,50 @@ const (
 	LogLevelNothing
 )
 
-var logLevel = LogLevelNothing
+// logLevel is accessed atomically
+var logLevel = uint32(LogLevelNothing)
 
+// mutex protects out
 var mutex sync.Mutex
 
 // SetLogWriter sets the log writer.
 func SetLogWriter(w io.Writer) {
+	mutex.Lock()
 	out = w
+	mutex.Unlock()
 }
 
 // SetLogLevel sets the log level
 func SetLogLevel(level LogLevel) {
-	logLevel = level
+	atomic.StoreUint32(&logLevel, uint32(level))
+}
+
+func getLogLevel() LogLevel {
+	return LogLevel(atomic.LoadUint32(&logLevel))
+}
+
+func writeLog(msg string) {
+	mutex.Lock()
+	fmt.Fprintln(out, msg)
+	mutex.Unlock()
 }
 
 // Debugf logs something
 func Debugf(format string, args ...interface{}) {
-	if logLevel == LogLevelDebug {
-		mutex.Lock()
-		fmt.Fprintf(out, format+"\n", args...)
-		mutex.Unlock()
-	}
+	DefaultLogger.Debugf(format, args...)
 }
 
 // Infof logs something
 func Infof(format string, args ...interface{}) {
-	if logLevel <= LogLevelInfo {
-		mutex.Lock()
-		fmt.Fprintf(out, format+"\n", args...)
-		mutex.Unlock()
-	}
+	DefaultLogger.Infof(format, args...)
 }
 
 // Errorf logs something
 func Errorf(format string, args ...interface{}) {
-	if logLevel <= LogLevelError {
-		mutex.Lock()
-		fmt.Fprintf(out, format+"\n", args...)
-		mutex.Unlock()
-	}
+	DefaultLogger.Errorf(format, args...)
 }
 
 // Debug returns true if the log level is LogLevelDebug
 func Debug() bool {
-	return logLevel == LogLevelDebug
+	return DefaultLogger.Debug()
 }
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A Logger logs messages at different log levels.
+// Loggers are shared by many sessions, so all methods must be safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	// Debug returns true if debug messages are logged.
+	// It is used to avoid expensive formatting of messages that would be discarded anyway.
+	Debug() bool
+	// With returns a Logger that attaches a field to all messages, e.g. the connection ID of a session.
+	With(key string, value interface{}) Logger
+}
+
+// DefaultLogger writes to the writer set by SetLogWriter, at the log level set by SetLogLevel
+var DefaultLogger Logger = &leveledLogger{
+	level:  getLogLevel,
+	output: writeLog,
+}
+
+// NewLogger creates a Logger that writes all messages at or above the log level to w, one message per line.
+// Fields are written in front of the message, e.g. [connection=0123456789abcdef remote=127.0.0.1:443] message
+func NewLogger(w io.Writer, level LogLevel) Logger {
+	var mutex sync.Mutex
+	return &leveledLogger{
+		level: func() LogLevel { return level },
+		output: func(msg string) {
+			mutex.Lock()
+			fmt.Fprintln(w, msg)
+			mutex.Unlock()
+		},
+	}
+}
+
+// NewStdLogger creates a Logger that writes all messages at or above the log level to a logger of the standard log package
+// If the log.Logger reports file names, the file of the caller of the Logger is reported, also if it is wrapped by NewRateLimitedLogger.
+func NewStdLogger(l *log.Logger, level LogLevel) Logger {
+	return &leveledLogger{
+		level: func() LogLevel { return level },
+		output: func(msg string) {
+			if l.Flags()&(log.Lshortfile|log.Llongfile) == 0 {
+				l.Output(1, msg)
+				return
+			}
+			l.Output(callDepth(), msg)
+		},
+	}
+}
+
+// loggerFiles are the files implementing the loggers of this package
+var loggerFiles = func() map[string]bool {
+	_, file, _, _ := runtime.Caller(0)
+	dir := filepath.Dir(file)
+	return map[string]bool{
+		file:                         true,
+		filepath.Join(dir, "log.go"): true,
+	}
+}()
+
+// callDepth returns the call depth of the first caller outside of the loggers, as counted by log.Logger.Output
+// It must be called directly by the function calling Output.
+// The depth depends on the wrapping of the loggers, e.g. a rateLimitedLogger adds a frame to Errorf.
+func callDepth() int {
+	pcs := make([]uintptr, 32)
+	// skip runtime.Callers and callDepth
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	depth := 1
+	for {
+		frame, more := frames.Next()
+		if !loggerFiles[frame.File] || !more {
+			return depth
+		}
+		depth++
+	}
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// leveledLogger is the Logger used by all loggers in this package
+type leveledLogger struct {
+	level  func() LogLevel
+	output func(msg string)
+	fields []logField
+}
+
+var _ Logger = &leveledLogger{}
+
+func (l *leveledLogger) Debugf(format string, args ...interface{}) {
+	if l.level() == LogLevelDebug {
+		l.log(format, args...)
+	}
+}
+
+func (l *leveledLogger) Infof(format string, args ...interface{}) {
+	if l.level() <= LogLevelInfo {
+		l.log(format, args...)
+	}
+}
+
+func (l *leveledLogger) Errorf(format string, args ...interface{}) {
+	if l.level() <= LogLevelError {
+		l.log(format, args...)
+	}
+}
+
+func (l *leveledLogger) Debug() bool {
+	return l.level() == LogLevelDebug
+}
+
+func (l *leveledLogger) With(key string, value interface{}) Logger {
+	fields := make([]logField, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &leveledLogger{
+		level:  l.level,
+		output: l.output,
+		fields: append(fields, logField{key: key, value: value}),
+	}
+}
+
+func (l *leveledLogger) log(format string, args ...interface{}) {
+	if len(l.fields) == 0 {
+		l.output(fmt.Sprintf(format, args...))
+		return
+	}
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, f := range l.fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%v", f.key, f.value)
+	}
+	b.WriteString("] ")
+	fmt.Fprintf(&b, format, args...)
+	l.output(b.String())
+}
+
+// NewRateLimitedLogger wraps a Logger, and limits the number of error messages to maxErrors per interval.
+// Errors exceeding the limit are dropped, and the number of dropped errors is logged before the next error that is logged.
+// Debug and info messages are not limited. All loggers derived using With share the same limit.
+func NewRateLimitedLogger(l Logger, maxErrors int, interval time.Duration) Logger {
+	return &rateLimitedLogger{
+		Logger: l,
+		limiter: &errorRateLimiter{
+			maxErrors: maxErrors,
+			interval:  interval,
+		},
+	}
+}
+
+type rateLimitedLogger struct {
+	Logger
+	limiter *errorRateLimiter
+}
+
+var _ Logger = &rateLimitedLogger{}
+
+func (l *rateLimitedLogger) Errorf(format string, args ...interface{}) {
+	allowed, dropped := l.limiter.allow()
+	if !allowed {
+		return
+	}
+	if dropped > 0 {
+		l.Logger.Errorf("Dropped %d error messages, since the rate limit was reached", dropped)
+	}
+	l.Logger.Errorf(format, args...)
+}
+
+func (l *rateLimitedLogger) With(key string, value interface{}) Logger {
+	return &rateLimitedLogger{
+		Logger:  l.Logger.With(key, value),
+		limiter: l.limiter,
+	}
+}
+
+type errorRateLimiter struct {
+	mutex sync.Mutex
+
+	maxErrors     int
+	interval      time.Duration
+	intervalStart time.Time
+	count         int
+	dropped       int
+}
+
+// allow returns if an error may be logged, and the number of errors dropped since the last error that was allowed
+func (r *errorRateLimiter) allow() (bool, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.intervalStart) >= r.interval {
+		r.intervalStart = now
+		r.count = 0
+	}
+	if r.count >= r.maxErrors {
+		r.dropped++
+		return false, 0
+	}
+	r.count++
+	dropped := r.dropped
+	r.dropped = 0
+	return true, dropped
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logger", func() {
+	var b *bytes.Buffer
+
+	BeforeEach(func() {
+		b = &bytes.Buffer{}
+	})
+
+	Context("writing to an io.Writer", func() {
+		It("only logs messages at or above the log level", func() {
+			logger := NewLogger(b, LogLevelInfo)
+			Expect(logger.Debug()).To(BeFalse())
+			logger.Debugf("debug")
+			logger.Infof("info %d", 1)
+			logger.Errorf("err %d", 2)
+			Expect(b.String()).To(Equal("info 1\nerr 2\n"))
+		})
+
+		It("logs debug messages", func() {
+			logger := NewLogger(b, LogLevelDebug)
+			Expect(logger.Debug()).To(BeTrue())
+			logger.Debugf("debug")
+			Expect(b.String()).To(Equal("debug\n"))
+		})
+
+		It("logs nothing", func() {
+			logger := NewLogger(b, LogLevelNothing)
+			logger.Errorf("err")
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("writes the fields in front of the message", func() {
+			logger := NewLogger(b, LogLevelInfo).With("connection", "deadbeef").With("remote", "127.0.0.1:443")
+			logger.Infof("%d%%", 100)
+			Expect(b.String()).To(Equal("[connection=deadbeef remote=127.0.0.1:443] 100%\n"))
+		})
+
+		It("doesn't add fields to the parent logger", func() {
+			logger := NewLogger(b, LogLevelInfo).With("foo", 1)
+			logger.With("bar", 2)
+			logger.With("baz", 3).Infof("child")
+			logger.Infof("parent")
+			Expect(b.String()).To(Equal("[foo=1 baz=3] child\n[foo=1] parent\n"))
+		})
+	})
+
+	Context("the default logger", func() {
+		AfterEach(func() {
+			SetLogWriter(os.Stdout)
+			SetLogLevel(LogLevelNothing)
+		})
+
+		It("uses the log level and the writer that were set", func() {
+			logger := DefaultLogger.With("connection", 42)
+			SetLogWriter(b)
+			logger.Infof("info")
+			SetLogLevel(LogLevelInfo)
+			logger.Infof("info")
+			Expect(b.String()).To(Equal("[connection=42] info\n"))
+		})
+	})
+
+	It("logs to a logger of the standard library", func() {
+		logger := NewStdLogger(log.New(b, "quic: ", 0), LogLevelError).With("connection", 42)
+		logger.Infof("info")
+		logger.Errorf("err")
+		Expect(b.String()).To(Equal("quic: [connection=42] err\n"))
+	})
+
+	It("reports the file of the caller to a logger of the standard library", func() {
+		logger := NewStdLogger(log.New(b, "", log.Lshortfile), LogLevelDebug)
+		logger.Infof("info")
+		logger.With("connection", 42).Errorf("err")
+		Expect(b.String()).To(MatchRegexp(`^logger_test.go:\d+: info\nlogger_test.go:\d+: \[connection=42\] err\n$`))
+	})
+
+	It("reports the file of the caller when wrapped by the rate limiting logger", func() {
+		logger := NewRateLimitedLogger(NewStdLogger(log.New(b, "", log.Lshortfile), LogLevelDebug), 10, time.Hour)
+		logger.Infof("info")
+		logger.With("connection", 42).Errorf("err")
+		Expect(b.String()).To(MatchRegexp(`^logger_test.go:\d+: info\nlogger_test.go:\d+: \[connection=42\] err\n$`))
+	})
+
+	Context("rate limiting", func() {
+		It("drops errors exceeding the rate limit", func() {
+			logger := NewRateLimitedLogger(NewLogger(b, LogLevelInfo), 2, time.Hour)
+			for i := 1; i <= 4; i++ {
+				logger.Errorf("err %d", i)
+			}
+			Expect(b.String()).To(Equal("err 1\nerr 2\n"))
+		})
+
+		It("doesn't limit info messages", func() {
+			logger := NewRateLimitedLogger(NewLogger(b, LogLevelInfo), 1, time.Hour)
+			logger.Infof("info 1")
+			logger.Infof("info 2")
+			Expect(b.String()).To(Equal("info 1\ninfo 2\n"))
+		})
+
+		It("shares the limit with derived loggers", func() {
+			logger := NewRateLimitedLogger(NewLogger(b, LogLevelInfo), 1, time.Hour)
+			logger.Errorf("err 1")
+			logger.With("foo", "bar").Errorf("err 2")
+			Expect(b.String()).To(Equal("err 1\n"))
+		})
+
+		It("logs the number of dropped errors in the next interval", func() {
+			logger := NewRateLimitedLogger(NewLogger(b, LogLevelInfo), 1, 20*time.Millisecond)
+			logger.Errorf("err 1")
+			logger.Errorf("err 2")
+			logger.Errorf("err 3")
+			time.Sleep(25 * time.Millisecond)
+			logger.Errorf("err 4")
+			Expect(b.String()).To(Equal("err 1\nDropped 2 error messages, since the rate limit was reached\nerr 4\n"))
+		})
+	})
+})
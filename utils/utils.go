@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"crypto/rand"
 	"io"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/protocol"
+	"golang.org/x/net/context"
 )
 
 // ReadStream is the read part of a QUIC stream
@@ -22,6 +24,21 @@ type Stream interface {
 	io.Closer
 	StreamID() protocol.StreamID
 	CloseRemote(offset protocol.ByteCount)
+	// ReadContext is like Read, but returns ctx.Err() once the context is done
+	ReadContext(ctx context.Context, p []byte) (int, error)
+	// WriteContext is like Write, but returns ctx.Err() once the context is done
+	WriteContext(ctx context.Context, p []byte) (int, error)
+	// SetDeadline sets the read and write deadlines, see SetReadDeadline and SetWriteDeadline
+	SetDeadline(t time.Time) error
+	// SetReadDeadline sets the deadline for future and currently blocked Read calls
+	// A Read blocked after the deadline returns an error implementing net.Error, with Timeout() == true
+	// A zero value for t means Read will not time out
+	SetReadDeadline(t time.Time) error
+	// SetWriteDeadline sets the deadline for future and currently blocked Write calls
+	// A Write blocked after the deadline returns an error implementing net.Error, with Timeout() == true
+	// Even if Write times out, it may return n > 0, indicating that some of the data was sent
+	// A zero value for t means Write will not time out
+	SetWriteDeadline(t time.Time) error
 }
 
 // ReadUintN reads N bytes
@@ -163,6 +180,41 @@ func WriteUint16(b *bytes.Buffer, i uint16) {
 	b.WriteByte(uint8(i >> 8))
 }
 
+// AppendUint64 appends a uint64
+func AppendUint64(b []byte, i uint64) []byte {
+	return append(b, uint8(i), uint8(i>>8), uint8(i>>16), uint8(i>>24), uint8(i>>32), uint8(i>>40), uint8(i>>48), uint8(i>>56))
+}
+
+// AppendUint56 appends 56 bit of a uint64
+func AppendUint56(b []byte, i uint64) []byte {
+	return append(b, uint8(i), uint8(i>>8), uint8(i>>16), uint8(i>>24), uint8(i>>32), uint8(i>>40), uint8(i>>48))
+}
+
+// AppendUint48 appends 48 bit of a uint64
+func AppendUint48(b []byte, i uint64) []byte {
+	return append(b, uint8(i), uint8(i>>8), uint8(i>>16), uint8(i>>24), uint8(i>>32), uint8(i>>40))
+}
+
+// AppendUint40 appends 40 bit of a uint64
+func AppendUint40(b []byte, i uint64) []byte {
+	return append(b, uint8(i), uint8(i>>8), uint8(i>>16), uint8(i>>24), uint8(i>>32))
+}
+
+// AppendUint32 appends a uint32
+func AppendUint32(b []byte, i uint32) []byte {
+	return append(b, uint8(i), uint8(i>>8), uint8(i>>16), uint8(i>>24))
+}
+
+// AppendUint24 appends 24 bit of a uint32
+func AppendUint24(b []byte, i uint32) []byte {
+	return append(b, uint8(i), uint8(i>>8), uint8(i>>16))
+}
+
+// AppendUint16 appends a uint16
+func AppendUint16(b []byte, i uint16) []byte {
+	return append(b, uint8(i), uint8(i>>8))
+}
+
 // RandomBit returns a cryptographically secure random bit (encoded as true / false)
 func RandomBit() (bool, error) {
 	b := make([]byte, 1)
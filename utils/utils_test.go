@@ -174,6 +174,31 @@ var _ = Describe("Utils", func() {
 		})
 	})
 
+	Context("Append", func() {
+		It("appends little endian integers", func() {
+			b := []byte{0x42}
+			b = AppendUint16(b, 0xFF11)
+			b = AppendUint24(b, 0xFFEE11)
+			b = AppendUint32(b, 0xFFEEDD11)
+			Expect(b).To(Equal([]byte{0x42, 0x11, 0xFF, 0x11, 0xEE, 0xFF, 0x11, 0xDD, 0xEE, 0xFF}))
+		})
+
+		It("appends the same bytes as the writers", func() {
+			num := uint64(0xFFEEDDCCBBAA9988)
+			b := &bytes.Buffer{}
+			WriteUint40(b, num)
+			WriteUint48(b, num)
+			WriteUint56(b, num)
+			WriteUint64(b, num)
+			var a []byte
+			a = AppendUint40(a, num)
+			a = AppendUint48(a, num)
+			a = AppendUint56(a, num)
+			a = AppendUint64(a, num)
+			Expect(a).To(Equal(b.Bytes()))
+		})
+	})
+
 	Context("Rand", func() {
 		It("returns either true or false", func() {
 			countTrue := 0